@@ -29,6 +29,8 @@ import (
 	"github.com/aoscloud/aos_common/journalalerts"
 	"github.com/aoscloud/aos_common/resourcemonitor"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/utils/idmap"
 )
 
 /***********************************************************************************************************************
@@ -58,6 +60,48 @@ type Migration struct {
 	MergedMigrationPath string `json:"mergedMigrationPath"`
 }
 
+// TrafficCounter traffic counter configuration.
+type TrafficCounter struct {
+	Name      string            `json:"name"`
+	Period    string            `json:"period"`
+	ResetDay  int               `json:"resetDay,omitempty"`
+	ResetHour int               `json:"resetHour,omitempty"`
+	Window    aostypes.Duration `json:"window,omitempty"`
+	Limited   bool              `json:"limited"`
+}
+
+// TrafficMonitoring traffic monitoring configuration.
+type TrafficMonitoring struct {
+	Counters        []TrafficCounter  `json:"counters"`
+	AlertThresholds []uint64          `json:"alertThresholds"`
+	HistoryBucket   aostypes.Duration `json:"historyBucket"`
+	HistoryDepth    aostypes.Duration `json:"historyDepth"`
+}
+
+// Scrubber integrity scrubber configuration.
+type Scrubber struct {
+	Period   aostypes.Duration `json:"period"`
+	IOBudget uint64            `json:"ioBudget"`
+}
+
+// SpacePolicy node space policy configuration.
+type SpacePolicy struct {
+	EvictStorages    bool   `json:"evictStorages"`
+	MaxEvictPriority uint64 `json:"maxEvictPriority"`
+}
+
+// FSImage packed filesystem image configuration. Empty format means services and layers are kept unpacked.
+type FSImage struct {
+	Format string `json:"format"`
+	Verity string `json:"verity"`
+}
+
+// UserNamespace subordinate IDs pool used to map instances running in user namespace.
+type UserNamespace struct {
+	SubIDStart uint32 `json:"subIdStart"`
+	SubIDCount uint32 `json:"subIdCount"`
+}
+
 // Config instance.
 type Config struct {
 	CACert                    string                 `json:"caCert"`
@@ -79,13 +123,22 @@ type Config struct {
 	UnitConfigFile            string                 `json:"unitConfigFile"`
 	ServiceTTLDays            uint64                 `json:"serviceTtlDays"`
 	LayerTTLDays              uint64                 `json:"layerTtlDays"`
+	InstallConcurrency        int                    `json:"installConcurrency"`
+	InstallTimeout            aostypes.Duration      `json:"installTimeout"`
+	ImageSignaturePolicy      string                 `json:"imageSignaturePolicy"`
+	InsecureRegistries        []string               `json:"insecureRegistries"`
 	ServiceHealthCheckTimeout aostypes.Duration      `json:"serviceHealthCheckTimeout"`
 	Monitoring                resourcemonitor.Config `json:"monitoring"`
+	TrafficMonitoring         TrafficMonitoring      `json:"trafficMonitoring"`
 	Logging                   Logging                `json:"logging"`
 	JournalAlerts             journalalerts.Config   `json:"journalAlerts,omitempty"`
 	HostBinds                 []string               `json:"hostBinds"`
 	Hosts                     []aostypes.Host        `json:"hosts,omitempty"`
 	Migration                 Migration              `json:"migration"`
+	Scrubber                  Scrubber               `json:"scrubber"`
+	SpacePolicy               SpacePolicy            `json:"spacePolicy"`
+	FSImage                   FSImage                `json:"fsImage"`
+	UserNamespace             UserNamespace          `json:"userNamespace"`
 }
 
 /***********************************************************************************************************************
@@ -102,11 +155,16 @@ func New(fileName string) (config *Config, err error) {
 	config = &Config{
 		ServiceTTLDays:            30,                                            // nolint:gomnd
 		LayerTTLDays:              30,                                            // nolint:gomnd
+		InstallConcurrency:        4,                                             // nolint:gomnd
 		ServiceHealthCheckTimeout: aostypes.Duration{Duration: 35 * time.Second}, // nolint:gomnd
 		Monitoring: resourcemonitor.Config{
 			SendPeriod: aostypes.Duration{Duration: 1 * time.Minute},
 			PollPeriod: aostypes.Duration{Duration: 10 * time.Second},
 		},
+		TrafficMonitoring: TrafficMonitoring{
+			HistoryBucket: aostypes.Duration{Duration: 1 * time.Hour},
+			HistoryDepth:  aostypes.Duration{Duration: 30 * 24 * time.Hour}, // nolint:gomnd
+		},
 		Logging: Logging{
 			MaxPartSize:  524288, // nolint:gomnd
 			MaxPartCount: 20,     // nolint:gomnd
@@ -115,6 +173,10 @@ func New(fileName string) (config *Config, err error) {
 			SystemAlertPriority:  defaultSystemAlertPriority,
 			ServiceAlertPriority: defaultServiceAlertPriority,
 		},
+		Scrubber: Scrubber{
+			Period:   aostypes.Duration{Duration: 24 * time.Hour}, // nolint:gomnd
+			IOBudget: 4 * 1024 * 1024,                             // nolint:gomnd
+		},
 	}
 
 	if err = json.Unmarshal(raw, &config); err != nil {
@@ -157,6 +219,14 @@ func New(fileName string) (config *Config, err error) {
 		config.Migration.MergedMigrationPath = path.Join(config.WorkingDir, "mergedMigration")
 	}
 
+	// Sub IDs overlapping host users and groups give instances access to their files
+	if config.UserNamespace.SubIDCount != 0 {
+		if err = idmap.ValidateHostRange(
+			config.UserNamespace.SubIDStart, config.UserNamespace.SubIDCount); err != nil {
+			return config, aoserrors.Errorf("invalid user namespace sub IDs: %v", err)
+		}
+	}
+
 	if config.JournalAlerts.ServiceAlertPriority > maxAlertPriorityLevel ||
 		config.JournalAlerts.ServiceAlertPriority < minAlertPriorityLevel {
 		log.Warnf("Default value %d for service alert priority is assigned", defaultServiceAlertPriority)
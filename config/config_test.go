@@ -27,8 +27,10 @@ import (
 	"time"
 
 	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
 
 	"github.com/aoscloud/aos_servicemanager/config"
+	"github.com/aoscloud/aos_servicemanager/utils/idmap"
 )
 
 /***********************************************************************************************************************
@@ -70,6 +72,14 @@ func createConfigFile() (err error) {
 			"maxThreshold": 150
 		}
 	},
+	"trafficMonitoring": {
+		"counters": [
+			{"name": "daily", "period": "day", "limited": true},
+			{"name": "monthly", "period": "month", "resetDay": 15, "resetHour": 3},
+			{"name": "rolling", "period": "rolling", "window": "24h"}
+		],
+		"alertThresholds": [80, 90]
+	},
 	"logging": {
 		"maxPartSize": 1024,
 		"maxPartCount": 10
@@ -80,6 +90,7 @@ func createConfigFile() (err error) {
 		"systemAlertPriority": 5
 	},
 	"hostBinds": ["dir0", "dir1", "dir2"],
+	"insecureRegistries": ["registry.local:5000"],
 	"hosts": [{
 			"ip": "127.0.0.1",
 			"hostName" : "wwwivi"
@@ -92,6 +103,21 @@ func createConfigFile() (err error) {
 	"migration": {
 		"migrationPath" : "/usr/share/aos_servicemnager/migration",
 		"mergedMigrationPath" : "/var/aos/servicemanager/mergedMigration"
+	},
+	"scrubber": {
+		"period": "12h"
+	},
+	"spacePolicy": {
+		"evictStorages": true,
+		"maxEvictPriority": 10
+	},
+	"fsImage": {
+		"format": "squashfs",
+		"verity": "dm-verity"
+	},
+	"userNamespace": {
+		"subIdStart": 100000,
+		"subIdCount": 655360
 	}
 }`
 
@@ -111,6 +137,17 @@ func setup() (err error) {
 		return aoserrors.Wrap(err)
 	}
 
+	idmap.PasswdFile = path.Join("tmp", "passwd")
+	idmap.GroupFile = path.Join("tmp", "group")
+
+	if err = ioutil.WriteFile(idmap.PasswdFile, []byte("root:x:0:0:root:/root:/bin/sh\n"), 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(idmap.GroupFile, []byte("root:x:0:\n"), 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	return nil
 }
 
@@ -314,6 +351,35 @@ func TestGetMonitoringConfig(t *testing.T) {
 	}
 }
 
+func TestGetTrafficMonitoringConfig(t *testing.T) {
+	expectedCounters := []config.TrafficCounter{
+		{Name: "daily", Period: "day", Limited: true},
+		{Name: "monthly", Period: "month", ResetDay: 15, ResetHour: 3},
+		{Name: "rolling", Period: "rolling", Window: aostypes.Duration{Duration: 24 * time.Hour}},
+	}
+
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if !reflect.DeepEqual(config.TrafficMonitoring.Counters, expectedCounters) {
+		t.Errorf("Wrong traffic counters: %v", config.TrafficMonitoring.Counters)
+	}
+
+	if !reflect.DeepEqual(config.TrafficMonitoring.AlertThresholds, []uint64{80, 90}) {
+		t.Errorf("Wrong traffic alert thresholds: %v", config.TrafficMonitoring.AlertThresholds)
+	}
+
+	if config.TrafficMonitoring.HistoryBucket.Duration != time.Hour {
+		t.Errorf("Wrong traffic history bucket: %v", config.TrafficMonitoring.HistoryBucket)
+	}
+
+	if config.TrafficMonitoring.HistoryDepth.Duration != 30*24*time.Hour {
+		t.Errorf("Wrong traffic history depth: %v", config.TrafficMonitoring.HistoryDepth)
+	}
+}
+
 func TestGetLoggingConfig(t *testing.T) {
 	config, err := config.New("tmp/aos_servicemanager.cfg")
 	if err != nil {
@@ -363,6 +429,17 @@ func TestHostBinds(t *testing.T) {
 	}
 }
 
+func TestInsecureRegistries(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %s", err)
+	}
+
+	if !reflect.DeepEqual(config.InsecureRegistries, []string{"registry.local:5000"}) {
+		t.Errorf("Wrong insecure registries value: %v", config.InsecureRegistries)
+	}
+}
+
 func TestHosts(t *testing.T) {
 	config, err := config.New("tmp/aos_servicemanager.cfg")
 	if err != nil {
@@ -445,6 +522,17 @@ func TestRemoteNodeFalg(t *testing.T) {
 	}
 }
 
+func TestDefaultInstallConcurrency(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %v", err)
+	}
+
+	if config.InstallConcurrency != 4 {
+		t.Errorf("Wrong default install concurrency value: %d", config.InstallConcurrency)
+	}
+}
+
 func TestRunnerFeatures(t *testing.T) {
 	config, err := config.New("tmp/aos_servicemanager.cfg")
 	if err != nil {
@@ -455,3 +543,78 @@ func TestRunnerFeatures(t *testing.T) {
 		t.Errorf("Wrong runnerFeatures value: %v", config.RunnerFeatures)
 	}
 }
+
+func TestScrubberConfig(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %v", err)
+	}
+
+	if config.Scrubber.Period.Duration != 12*time.Hour {
+		t.Errorf("Wrong scrubber period value: %v", config.Scrubber.Period)
+	}
+
+	if config.Scrubber.IOBudget != 4*1024*1024 {
+		t.Errorf("Wrong default scrubber IO budget value: %d", config.Scrubber.IOBudget)
+	}
+}
+
+func TestSpacePolicyConfig(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %v", err)
+	}
+
+	if !config.SpacePolicy.EvictStorages {
+		t.Error("Storages eviction should be enabled")
+	}
+
+	if config.SpacePolicy.MaxEvictPriority != 10 {
+		t.Errorf("Wrong max evict priority value: %d", config.SpacePolicy.MaxEvictPriority)
+	}
+}
+
+func TestFSImageConfig(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %v", err)
+	}
+
+	if config.FSImage.Format != "squashfs" {
+		t.Errorf("Wrong image format: %s", config.FSImage.Format)
+	}
+
+	if config.FSImage.Verity != "dm-verity" {
+		t.Errorf("Wrong verity type: %s", config.FSImage.Verity)
+	}
+}
+
+func TestUserNamespaceConfig(t *testing.T) {
+	config, err := config.New("tmp/aos_servicemanager.cfg")
+	if err != nil {
+		t.Fatalf("Error opening config file: %v", err)
+	}
+
+	if config.UserNamespace.SubIDStart != 100000 {
+		t.Errorf("Wrong sub ID start: %d", config.UserNamespace.SubIDStart)
+	}
+
+	if config.UserNamespace.SubIDCount != 655360 {
+		t.Errorf("Wrong sub ID count: %d", config.UserNamespace.SubIDCount)
+	}
+}
+
+func TestUserNamespaceOverlap(t *testing.T) {
+	groupFile := idmap.GroupFile
+	defer func() { idmap.GroupFile = groupFile }()
+
+	idmap.GroupFile = path.Join("tmp", "overlapgroup")
+
+	if err := ioutil.WriteFile(idmap.GroupFile, []byte("root:x:0:\naos:x:100001:\n"), 0o600); err != nil {
+		t.Fatalf("Can't write group file: %v", err)
+	}
+
+	if _, err := config.New("tmp/aos_servicemanager.cfg"); err == nil {
+		t.Error("Sub IDs overlapping host groups should be rejected")
+	}
+}
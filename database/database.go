@@ -37,6 +37,7 @@ import (
 	"github.com/aoscloud/aos_servicemanager/layermanager"
 	"github.com/aoscloud/aos_servicemanager/networkmanager"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
+	"github.com/aoscloud/aos_servicemanager/utils/blobstore"
 )
 
 /***********************************************************************************************************************
@@ -49,7 +50,7 @@ const (
 	syncMode    = "NORMAL"
 )
 
-const dbVersion = 6
+const dbVersion = 13
 
 /***********************************************************************************************************************
  * Vars
@@ -99,9 +100,10 @@ func (db *Database) SetOperationVersion(version uint64) error {
 
 // AddService adds new service.
 func (db *Database) AddService(service servicemanager.ServiceInfo) (err error) {
-	return db.executeQuery("INSERT INTO services values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	return db.executeQuery("INSERT INTO services values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		service.ServiceID, service.AosVersion, service.ServiceProvider, service.Description, service.ImagePath,
-		service.ManifestDigest, service.Cached, service.Timestamp, service.Size, service.GID)
+		service.ManifestDigest, service.Cached, service.Timestamp, service.Size, service.GID, service.Quarantined,
+		service.Prefetched, service.FSImage.Format, service.FSImage.Verity, service.FSImage.RootHash)
 }
 
 // RemoveService removes existing service.
@@ -123,7 +125,8 @@ func (db *Database) GetServices() (services []servicemanager.ServiceInfo, err er
 			return []any{
 				&service.ServiceID, &service.AosVersion, &service.ServiceProvider, &service.Description,
 				&service.ImagePath, &service.ManifestDigest, &service.Cached, &service.Timestamp,
-				&service.Size, &service.GID,
+				&service.Size, &service.GID, &service.Quarantined, &service.Prefetched,
+				&service.FSImage.Format, &service.FSImage.Verity, &service.FSImage.RootHash,
 			}
 		})
 }
@@ -137,7 +140,8 @@ func (db *Database) GetAllServiceVersions(id string) (services []servicemanager.
 			return []any{
 				&service.ServiceID, &service.AosVersion, &service.ServiceProvider, &service.Description,
 				&service.ImagePath, &service.ManifestDigest, &service.Cached, &service.Timestamp,
-				&service.Size, &service.GID,
+				&service.Size, &service.GID, &service.Quarantined, &service.Prefetched,
+				&service.FSImage.Format, &service.FSImage.Verity, &service.FSImage.RootHash,
 			}
 		}, id); err != nil {
 		return nil, err
@@ -160,11 +164,34 @@ func (db *Database) SetServiceCached(serviceID string, aosVersion uint64, cached
 	return err
 }
 
+// SetServiceQuarantined sets quarantined status for the service.
+func (db *Database) SetServiceQuarantined(serviceID string, aosVersion uint64, quarantined bool) (err error) {
+	if err = db.executeQuery("UPDATE services SET quarantined = ? WHERE id = ? AND aosVersion = ?",
+		quarantined, serviceID, aosVersion); errors.Is(err, errNotExist) {
+		return servicemanager.ErrNotExist
+	}
+
+	return err
+}
+
+// SetServicePrefetched sets prefetched status for the service.
+func (db *Database) SetServicePrefetched(serviceID string, aosVersion uint64, prefetched bool) (err error) {
+	if err = db.executeQuery("UPDATE services SET prefetched = ? WHERE id = ? AND aosVersion = ?",
+		prefetched, serviceID, aosVersion); errors.Is(err, errNotExist) {
+		return servicemanager.ErrNotExist
+	}
+
+	return err
+}
+
 // SetTrafficMonitorData stores traffic monitor data.
-func (db *Database) SetTrafficMonitorData(chain string, timestamp time.Time, value uint64) (err error) {
-	if err = db.executeQuery("UPDATE trafficmonitor SET time = ?, value = ? where chain = ?",
-		timestamp, value, chain); errors.Is(err, errNotExist) {
-		if _, err := db.sql.Exec("INSERT INTO trafficmonitor VALUES(?, ?, ?)", chain, timestamp, value); err != nil {
+func (db *Database) SetTrafficMonitorData(
+	chain string, timestamp time.Time, value uint64, alertLevel int,
+) (err error) {
+	if err = db.executeQuery("UPDATE trafficmonitor SET time = ?, value = ?, alertLevel = ? where chain = ?",
+		timestamp, value, alertLevel, chain); errors.Is(err, errNotExist) {
+		if _, err := db.sql.Exec("INSERT INTO trafficmonitor VALUES(?, ?, ?, ?)",
+			chain, timestamp, value, alertLevel); err != nil {
 			return aoserrors.Wrap(err)
 		}
 
@@ -175,17 +202,20 @@ func (db *Database) SetTrafficMonitorData(chain string, timestamp time.Time, val
 }
 
 // GetTrafficMonitorData stores traffic monitor data.
-func (db *Database) GetTrafficMonitorData(chain string) (timestamp time.Time, value uint64, err error) {
-	if err = db.getDataFromQuery(fmt.Sprintf("SELECT time, value FROM trafficmonitor WHERE chain = \"%s\"", chain),
-		&timestamp, &value); err != nil {
+func (db *Database) GetTrafficMonitorData(
+	chain string,
+) (timestamp time.Time, value uint64, alertLevel int, err error) {
+	if err = db.getDataFromQuery(
+		fmt.Sprintf("SELECT time, value, alertLevel FROM trafficmonitor WHERE chain = \"%s\"", chain),
+		&timestamp, &value, &alertLevel); err != nil {
 		if errors.Is(err, errNotExist) {
-			return timestamp, value, networkmanager.ErrEntryNotExist
+			return timestamp, value, alertLevel, networkmanager.ErrEntryNotExist
 		}
 
-		return timestamp, value, err
+		return timestamp, value, alertLevel, err
 	}
 
-	return timestamp, value, nil
+	return timestamp, value, alertLevel, nil
 }
 
 // RemoveTrafficMonitorData removes existing traffic monitor entry.
@@ -197,6 +227,42 @@ func (db *Database) RemoveTrafficMonitorData(chain string) (err error) {
 	return err
 }
 
+// SetTrafficHistoryData stores traffic history data.
+func (db *Database) SetTrafficHistoryData(instanceID string, timestamp time.Time, inValue, outValue uint64) (err error) {
+	if err = db.executeQuery("UPDATE traffichistory SET inValue = ?, outValue = ? WHERE instanceID = ? AND time = ?",
+		inValue, outValue, instanceID, timestamp.UTC()); errors.Is(err, errNotExist) {
+		if _, err := db.sql.Exec("INSERT INTO traffichistory VALUES(?, ?, ?, ?)",
+			instanceID, timestamp.UTC(), inValue, outValue); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return nil
+	}
+
+	return err
+}
+
+// GetTrafficHistoryData returns traffic history data for specified time range.
+func (db *Database) GetTrafficHistoryData(
+	instanceID string, from, till time.Time,
+) (history []networkmanager.TrafficHistoryItem, err error) {
+	return getFromQuery(
+		db,
+		"SELECT time, inValue, outValue FROM traffichistory WHERE instanceID = ? AND time >= ? AND time <= ? ORDER BY time",
+		func(item *networkmanager.TrafficHistoryItem) []any {
+			return []any{&item.Timestamp, &item.InputTraffic, &item.OutputTraffic}
+		}, instanceID, from.UTC(), till.UTC())
+}
+
+// RemoveTrafficHistoryData removes traffic history data older than specified time.
+func (db *Database) RemoveTrafficHistoryData(till time.Time) (err error) {
+	if err = db.executeQuery("DELETE FROM traffichistory WHERE time < ?", till.UTC()); errors.Is(err, errNotExist) {
+		return nil
+	}
+
+	return err
+}
+
 // SetJournalCursor stores system logger cursor.
 func (db *Database) SetJournalCursor(cursor string) error {
 	return db.executeQuery("UPDATE config SET cursor = ?", cursor)
@@ -256,9 +322,10 @@ func (db *Database) SetOnlineTime(onlineTime time.Time) error {
 
 // AddLayer add layer to layers table.
 func (db *Database) AddLayer(layer layermanager.LayerInfo) (err error) {
-	return db.executeQuery("INSERT INTO layers values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	return db.executeQuery("INSERT INTO layers values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		layer.Digest, layer.LayerID, layer.Path, layer.OSVersion, layer.VendorVersion,
-		layer.Description, layer.AosVersion, layer.Timestamp, layer.Cached, layer.Size)
+		layer.Description, layer.AosVersion, layer.Timestamp, layer.Cached, layer.Size,
+		layer.ContentDigest, layer.Quarantined, layer.FSImage.Format, layer.FSImage.Verity, layer.FSImage.RootHash)
 }
 
 // DeleteLayerByDigest remove layer from DB by digest.
@@ -279,7 +346,8 @@ func (db *Database) GetLayersInfo() (layersList []layermanager.LayerInfo, err er
 			return []any{
 				&layer.Digest, &layer.LayerID, &layer.Path, &layer.OSVersion,
 				&layer.VendorVersion, &layer.Description, &layer.AosVersion, &layer.Timestamp,
-				&layer.Cached, &layer.Size,
+				&layer.Cached, &layer.Size, &layer.ContentDigest, &layer.Quarantined,
+				&layer.FSImage.Format, &layer.FSImage.Verity, &layer.FSImage.RootHash,
 			}
 		})
 }
@@ -289,7 +357,9 @@ func (db *Database) GetLayerInfoByDigest(digest string) (layer layermanager.Laye
 	if err = db.getDataFromQuery(fmt.Sprintf("SELECT * FROM layers WHERE digest = \"%s\"", digest),
 		&layer.Digest, &layer.LayerID, &layer.Path, &layer.OSVersion,
 		&layer.VendorVersion, &layer.Description,
-		&layer.AosVersion, &layer.Timestamp, &layer.Cached, &layer.Size); err != nil {
+		&layer.AosVersion, &layer.Timestamp, &layer.Cached, &layer.Size,
+		&layer.ContentDigest, &layer.Quarantined,
+		&layer.FSImage.Format, &layer.FSImage.Verity, &layer.FSImage.RootHash); err != nil {
 		if errors.Is(err, errNotExist) {
 			return layer, layermanager.ErrNotExist
 		}
@@ -320,6 +390,100 @@ func (db *Database) SetLayerCached(digest string, cached bool) (err error) {
 	return err
 }
 
+// SetLayerContentDigest sets digest of unpacked layer content.
+func (db *Database) SetLayerContentDigest(digest string, contentDigest string) (err error) {
+	if err = db.executeQuery("UPDATE layers SET contentDigest = ? WHERE digest = ?",
+		contentDigest, digest); errors.Is(err, errNotExist) {
+		return layermanager.ErrNotExist
+	}
+
+	return err
+}
+
+// SetLayerQuarantined sets quarantined status for the layer.
+func (db *Database) SetLayerQuarantined(digest string, quarantined bool) (err error) {
+	if err = db.executeQuery("UPDATE layers SET quarantined = ? WHERE digest = ?",
+		quarantined, digest); errors.Is(err, errNotExist) {
+		return layermanager.ErrNotExist
+	}
+
+	return err
+}
+
+// AddBlob adds blob to blobs table.
+func (db *Database) AddBlob(blob blobstore.BlobInfo) (err error) {
+	return db.executeQuery("INSERT INTO blobs values(?, ?, ?, ?)", blob.Path, blob.Digest, blob.Size, blob.RefCount)
+}
+
+// GetBlob returns blob information by path.
+func (db *Database) GetBlob(path string) (blob blobstore.BlobInfo, err error) {
+	if err = db.getDataFromQuery(fmt.Sprintf("SELECT * FROM blobs WHERE path = \"%s\"", path),
+		&blob.Path, &blob.Digest, &blob.Size, &blob.RefCount); err != nil {
+		if errors.Is(err, errNotExist) {
+			return blob, blobstore.ErrNotExist
+		}
+
+		return blob, err
+	}
+
+	return blob, nil
+}
+
+// GetBlobs returns all stored blobs.
+func (db *Database) GetBlobs() (blobs []blobstore.BlobInfo, err error) {
+	return getFromQuery(
+		db,
+		"SELECT * FROM blobs",
+		func(blob *blobstore.BlobInfo) []any {
+			return []any{&blob.Path, &blob.Digest, &blob.Size, &blob.RefCount}
+		})
+}
+
+// SetBlobRefCount sets blob reference counter.
+func (db *Database) SetBlobRefCount(path string, refCount uint64) (err error) {
+	if err = db.executeQuery("UPDATE blobs SET refCount = ? WHERE path = ?",
+		refCount, path); errors.Is(err, errNotExist) {
+		return blobstore.ErrNotExist
+	}
+
+	return err
+}
+
+// RemoveBlob removes blob from blobs table.
+func (db *Database) RemoveBlob(path string) (err error) {
+	if err = db.executeQuery("DELETE FROM blobs WHERE path = ?", path); errors.Is(err, errNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// GetStoppedItems returns items which are not used by current instances.
+func (db *Database) GetStoppedItems() (items []launcher.StoppedItem, err error) {
+	return getFromQuery(
+		db,
+		"SELECT * FROM stoppeditems",
+		func(item *launcher.StoppedItem) []any {
+			return []any{&item.Type, &item.ID, &item.Priority}
+		})
+}
+
+// SetStoppedItem adds or updates item which is not used by current instances.
+func (db *Database) SetStoppedItem(item launcher.StoppedItem) error {
+	return db.executeQuery("INSERT INTO stoppeditems values(?, ?, ?) ON CONFLICT(type, id) DO UPDATE SET priority = ?",
+		item.Type, item.ID, item.Priority, item.Priority)
+}
+
+// RemoveStoppedItem removes item from stopped items table.
+func (db *Database) RemoveStoppedItem(itemType, id string) (err error) {
+	if err = db.executeQuery("DELETE FROM stoppeditems WHERE type = ? AND id = ?",
+		itemType, id); errors.Is(err, errNotExist) {
+		return nil
+	}
+
+	return err
+}
+
 // AddInstance adds instance information to db.
 func (db *Database) AddInstance(instance launcher.InstanceInfo) error {
 	return db.executeQuery("INSERT INTO instances values(?, ?, ?, ?, ?, ?, ?, ?)",
@@ -499,6 +663,10 @@ func newDatabase(name string, migrationPath string, mergedMigrationPath string,
 		return db, aoserrors.Wrap(err)
 	}
 
+	if err := db.createTrafficHistoryTable(); err != nil {
+		return db, aoserrors.Wrap(err)
+	}
+
 	if err := db.createLayersTable(); err != nil {
 		return db, aoserrors.Wrap(err)
 	}
@@ -507,6 +675,14 @@ func newDatabase(name string, migrationPath string, mergedMigrationPath string,
 		return db, err
 	}
 
+	if err := db.createBlobsTable(); err != nil {
+		return db, err
+	}
+
+	if err := db.createStoppedItemsTable(); err != nil {
+		return db, err
+	}
+
 	return db, nil
 }
 
@@ -568,6 +744,11 @@ func (db *Database) createServiceTable() (err error) {
 															   timestamp TIMESTAMP,
 															   size INTEGER,
 															   GID INTEGER,
+															   quarantined INTEGER,
+															   prefetched INTEGER,
+															   fsImageFormat TEXT,
+															   fsImageVerity TEXT,
+															   fsImageRootHash TEXT,
 															   PRIMARY KEY(id, aosVersion))`)
 
 	return aoserrors.Wrap(err)
@@ -578,7 +759,20 @@ func (db *Database) createTrafficMonitorTable() (err error) {
 
 	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS trafficmonitor (chain TEXT NOT NULL PRIMARY KEY,
 																	 time TIMESTAMP,
-																	 value INTEGER)`)
+																	 value INTEGER,
+																	 alertLevel INTEGER)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createTrafficHistoryTable() (err error) {
+	log.Info("Create traffic history table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS traffichistory (instanceID TEXT NOT NULL,
+																	 time TIMESTAMP NOT NULL,
+																	 inValue INTEGER,
+																	 outValue INTEGER,
+																	 PRIMARY KEY(instanceID, time))`)
 
 	return aoserrors.Wrap(err)
 }
@@ -595,7 +789,12 @@ func (db *Database) createLayersTable() (err error) {
 															 aosVersion INTEGER,
 															 timestamp TIMESTAMP,
 															 cached INTEGER,
-															 size INTEGER)`)
+															 size INTEGER,
+															 contentDigest TEXT,
+															 quarantined INTEGER,
+															 fsImageFormat TEXT,
+															 fsImageVerity TEXT,
+															 fsImageRootHash TEXT)`)
 
 	return aoserrors.Wrap(err)
 }
@@ -615,6 +814,28 @@ func (db *Database) createInstancesTable() (err error) {
 	return aoserrors.Wrap(err)
 }
 
+func (db *Database) createBlobsTable() (err error) {
+	log.Info("Create blobs table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS blobs (path TEXT NOT NULL PRIMARY KEY,
+															digest TEXT,
+															size INTEGER,
+															refCount INTEGER)`)
+
+	return aoserrors.Wrap(err)
+}
+
+func (db *Database) createStoppedItemsTable() (err error) {
+	log.Info("Create stopped items table")
+
+	_, err = db.sql.Exec(`CREATE TABLE IF NOT EXISTS stoppeditems (type TEXT NOT NULL,
+																   id TEXT NOT NULL,
+																   priority INTEGER,
+																   PRIMARY KEY(type, id))`)
+
+	return aoserrors.Wrap(err)
+}
+
 func (db *Database) removeAllServices() (err error) {
 	_, err = db.sql.Exec("DELETE FROM services")
 
@@ -627,6 +848,12 @@ func (db *Database) removeAllTrafficMonitor() (err error) {
 	return aoserrors.Wrap(err)
 }
 
+func (db *Database) removeAllTrafficHistory() (err error) {
+	_, err = db.sql.Exec("DELETE FROM traffichistory")
+
+	return aoserrors.Wrap(err)
+}
+
 func (db *Database) executeQuery(query string, args ...interface{}) error {
 	stmt, err := db.sql.Prepare(query)
 	if err != nil {
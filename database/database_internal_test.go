@@ -38,7 +38,10 @@ import (
 
 	"github.com/aoscloud/aos_servicemanager/launcher"
 	"github.com/aoscloud/aos_servicemanager/layermanager"
+	"github.com/aoscloud/aos_servicemanager/networkmanager"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
+	"github.com/aoscloud/aos_servicemanager/utils/blobstore"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
 )
 
 /***********************************************************************************************************************
@@ -310,6 +313,154 @@ func TestCachedService(t *testing.T) {
 	}
 }
 
+func TestQuarantined(t *testing.T) {
+	service := servicemanager.ServiceInfo{
+		ServiceID: "serviceQuarantined",
+		VersionInfo: aostypes.VersionInfo{
+			AosVersion: 1,
+		},
+		ServiceProvider: "sp1",
+		ImagePath:       "to/service1",
+	}
+
+	if err := db.AddService(service); err != nil {
+		t.Errorf("Can't add service: %v", err)
+	}
+
+	if err := db.SetServiceQuarantined(service.ServiceID, service.AosVersion, true); err != nil {
+		t.Errorf("Can't set service quarantined: %v", err)
+	}
+
+	services, err := db.GetAllServiceVersions(service.ServiceID)
+	if err != nil {
+		t.Errorf("Can't get service: %v", err)
+	}
+
+	service.Quarantined = true
+
+	if !reflect.DeepEqual([]servicemanager.ServiceInfo{service}, services) {
+		t.Error("Unexpected services")
+	}
+
+	layer := layermanager.LayerInfo{
+		Digest: "sha256:quarantined", LayerID: "quarantined", Path: "path", OSVersion: "1",
+		VersionInfo: aostypes.VersionInfo{AosVersion: 1},
+	}
+
+	if err := db.AddLayer(layer); err != nil {
+		t.Errorf("Can't add layer: %v", err)
+	}
+
+	if err := db.SetLayerContentDigest(layer.Digest, "sha256:content"); err != nil {
+		t.Errorf("Can't set layer content digest: %v", err)
+	}
+
+	if err := db.SetLayerQuarantined(layer.Digest, true); err != nil {
+		t.Errorf("Can't set layer quarantined: %v", err)
+	}
+
+	savedLayer, err := db.GetLayerInfoByDigest(layer.Digest)
+	if err != nil {
+		t.Errorf("Can't get layer: %v", err)
+	}
+
+	layer.ContentDigest = "sha256:content"
+	layer.Quarantined = true
+
+	if !reflect.DeepEqual(savedLayer, layer) {
+		t.Error("Unexpected layer")
+	}
+
+	if err := db.DeleteLayerByDigest(layer.Digest); err != nil {
+		t.Errorf("Can't remove layer: %v", err)
+	}
+}
+
+func TestPrefetchedService(t *testing.T) {
+	service := servicemanager.ServiceInfo{
+		ServiceID: "servicePrefetched",
+		VersionInfo: aostypes.VersionInfo{
+			AosVersion: 1,
+		},
+		ServiceProvider: "sp1",
+		ImagePath:       "to/service1",
+		Prefetched:      true,
+	}
+
+	if err := db.AddService(service); err != nil {
+		t.Errorf("Can't add service: %v", err)
+	}
+
+	services, err := db.GetAllServiceVersions(service.ServiceID)
+	if err != nil {
+		t.Errorf("Can't get service: %v", err)
+	}
+
+	if !reflect.DeepEqual([]servicemanager.ServiceInfo{service}, services) {
+		t.Error("Unexpected services")
+	}
+
+	if err := db.SetServicePrefetched(service.ServiceID, service.AosVersion, false); err != nil {
+		t.Errorf("Can't set service prefetched: %v", err)
+	}
+
+	if services, err = db.GetAllServiceVersions(service.ServiceID); err != nil {
+		t.Errorf("Can't get service: %v", err)
+	}
+
+	service.Prefetched = false
+
+	if !reflect.DeepEqual([]servicemanager.ServiceInfo{service}, services) {
+		t.Error("Unexpected services")
+	}
+}
+
+func TestFSImage(t *testing.T) {
+	service := servicemanager.ServiceInfo{
+		ServiceID:       "servicePacked",
+		VersionInfo:     aostypes.VersionInfo{AosVersion: 1},
+		ServiceProvider: "sp1",
+		ImagePath:       "to/service1",
+		FSImage:         fsimage.Info{Format: fsimage.FormatSquashfs, Verity: fsimage.VerityDM, RootHash: "1111"},
+	}
+
+	if err := db.AddService(service); err != nil {
+		t.Errorf("Can't add service: %v", err)
+	}
+
+	services, err := db.GetAllServiceVersions(service.ServiceID)
+	if err != nil {
+		t.Errorf("Can't get service: %v", err)
+	}
+
+	if !reflect.DeepEqual([]servicemanager.ServiceInfo{service}, services) {
+		t.Error("Unexpected services")
+	}
+
+	layer := layermanager.LayerInfo{
+		Digest: "sha256:packed", LayerID: "packed", Path: "path", OSVersion: "1",
+		VersionInfo: aostypes.VersionInfo{AosVersion: 1},
+		FSImage:     fsimage.Info{Format: fsimage.FormatErofs, Verity: fsimage.VerityFS, RootHash: "sha256:2222"},
+	}
+
+	if err := db.AddLayer(layer); err != nil {
+		t.Errorf("Can't add layer: %v", err)
+	}
+
+	savedLayer, err := db.GetLayerInfoByDigest(layer.Digest)
+	if err != nil {
+		t.Errorf("Can't get layer: %v", err)
+	}
+
+	if !reflect.DeepEqual(savedLayer, layer) {
+		t.Error("Unexpected layer")
+	}
+
+	if err := db.DeleteLayerByDigest(layer.Digest); err != nil {
+		t.Errorf("Can't remove layer: %v", err)
+	}
+}
+
 func TestSetTimestampService(t *testing.T) {
 	service := servicemanager.ServiceInfo{
 		ServiceID: "serviceTimestamp",
@@ -338,25 +489,26 @@ func TestSetTimestampService(t *testing.T) {
 func TestTrafficMonitor(t *testing.T) {
 	setTime := time.Now()
 	setValue := uint64(100)
+	setAlertLevel := 2
 
-	if err := db.SetTrafficMonitorData("chain1", setTime, setValue); err != nil {
+	if err := db.SetTrafficMonitorData("chain1", setTime, setValue, setAlertLevel); err != nil {
 		t.Fatalf("Can't set traffic monitor: %s", err)
 	}
 
-	getTime, getValue, err := db.GetTrafficMonitorData("chain1")
+	getTime, getValue, getAlertLevel, err := db.GetTrafficMonitorData("chain1")
 	if err != nil {
 		t.Fatalf("Can't get traffic monitor: %s", err)
 	}
 
-	if !getTime.Equal(setTime) || getValue != setValue {
-		t.Fatalf("Wrong value time: %s, value %d", getTime, getValue)
+	if !getTime.Equal(setTime) || getValue != setValue || getAlertLevel != setAlertLevel {
+		t.Fatalf("Wrong value time: %s, value %d, alert level %d", getTime, getValue, getAlertLevel)
 	}
 
 	if err := db.RemoveTrafficMonitorData("chain1"); err != nil {
 		t.Fatalf("Can't remove traffic monitor: %s", err)
 	}
 
-	if _, _, err := db.GetTrafficMonitorData("chain1"); err == nil {
+	if _, _, _, err := db.GetTrafficMonitorData("chain1"); err == nil {
 		t.Fatal("Entry should be removed")
 	}
 
@@ -366,6 +518,77 @@ func TestTrafficMonitor(t *testing.T) {
 	}
 }
 
+func TestTrafficHistory(t *testing.T) {
+	historyTime := time.Now().UTC().Truncate(time.Hour)
+
+	testData := []networkmanager.TrafficHistoryItem{
+		{Timestamp: historyTime.Add(-2 * time.Hour), InputTraffic: 100, OutputTraffic: 200},
+		{Timestamp: historyTime.Add(-1 * time.Hour), InputTraffic: 300, OutputTraffic: 400},
+		{Timestamp: historyTime, InputTraffic: 500, OutputTraffic: 600},
+	}
+
+	for _, item := range testData {
+		if err := db.SetTrafficHistoryData(
+			"instance0", item.Timestamp, item.InputTraffic, item.OutputTraffic); err != nil {
+			t.Fatalf("Can't set traffic history: %v", err)
+		}
+	}
+
+	// Update existing bucket
+	testData[2].InputTraffic, testData[2].OutputTraffic = 700, 800
+
+	if err := db.SetTrafficHistoryData(
+		"instance0", testData[2].Timestamp, testData[2].InputTraffic, testData[2].OutputTraffic); err != nil {
+		t.Fatalf("Can't set traffic history: %v", err)
+	}
+
+	if err := db.SetTrafficHistoryData("instance1", historyTime, 1, 1); err != nil {
+		t.Fatalf("Can't set traffic history: %v", err)
+	}
+
+	history, err := db.GetTrafficHistoryData("instance0", historyTime.Add(-24*time.Hour), historyTime)
+	if err != nil {
+		t.Fatalf("Can't get traffic history: %v", err)
+	}
+
+	if len(history) != len(testData) {
+		t.Fatalf("Wrong traffic history count: %d", len(history))
+	}
+
+	for i, item := range history {
+		if !item.Timestamp.Equal(testData[i].Timestamp) || item.InputTraffic != testData[i].InputTraffic ||
+			item.OutputTraffic != testData[i].OutputTraffic {
+			t.Errorf("Wrong traffic history item: %v", item)
+		}
+	}
+
+	if history, err = db.GetTrafficHistoryData(
+		"instance0", historyTime.Add(-time.Hour), historyTime.Add(-time.Hour)); err != nil {
+		t.Fatalf("Can't get traffic history: %v", err)
+	}
+
+	if len(history) != 1 || history[0].InputTraffic != testData[1].InputTraffic {
+		t.Errorf("Wrong traffic history: %v", history)
+	}
+
+	if err = db.RemoveTrafficHistoryData(historyTime.Add(-time.Hour)); err != nil {
+		t.Fatalf("Can't remove traffic history: %v", err)
+	}
+
+	if history, err = db.GetTrafficHistoryData("instance0", historyTime.Add(-24*time.Hour), historyTime); err != nil {
+		t.Fatalf("Can't get traffic history: %v", err)
+	}
+
+	if len(history) != 2 {
+		t.Errorf("Wrong traffic history count: %d", len(history))
+	}
+
+	// Clear DB
+	if err := db.removeAllTrafficHistory(); err != nil {
+		t.Errorf("Can't remove all traffic history: %v", err)
+	}
+}
+
 func TestOperationVersion(t *testing.T) {
 	var setOperationVersion uint64 = 123
 
@@ -598,6 +821,102 @@ func TestLayers(t *testing.T) {
 	}
 }
 
+func TestBlobs(t *testing.T) {
+	blobs := []blobstore.BlobInfo{
+		{Path: "/blobs/sha256/1", Digest: "sha256:1", Size: 1024, RefCount: 1},
+		{Path: "/blobs/sha256/2", Digest: "sha256:2", Size: 2048, RefCount: 3},
+	}
+
+	for _, blob := range blobs {
+		if err := db.AddBlob(blob); err != nil {
+			t.Fatalf("Can't add blob: %v", err)
+		}
+	}
+
+	storedBlobs, err := db.GetBlobs()
+	if err != nil {
+		t.Fatalf("Can't get blobs: %v", err)
+	}
+
+	if !reflect.DeepEqual(storedBlobs, blobs) {
+		t.Errorf("Wrong blobs: %v", storedBlobs)
+	}
+
+	if err = db.SetBlobRefCount(blobs[0].Path, 2); err != nil {
+		t.Fatalf("Can't set blob ref count: %v", err)
+	}
+
+	blob, err := db.GetBlob(blobs[0].Path)
+	if err != nil {
+		t.Fatalf("Can't get blob: %v", err)
+	}
+
+	if blob.RefCount != 2 {
+		t.Errorf("Wrong blob ref count: %d", blob.RefCount)
+	}
+
+	if err = db.SetBlobRefCount("/blobs/sha256/3", 1); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("Wrong set ref count error: %v", err)
+	}
+
+	for _, blob := range blobs {
+		if err = db.RemoveBlob(blob.Path); err != nil {
+			t.Errorf("Can't remove blob: %v", err)
+		}
+	}
+
+	if _, err = db.GetBlob(blobs[0].Path); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("Wrong get blob error: %v", err)
+	}
+}
+
+func TestStoppedItems(t *testing.T) {
+	items := []launcher.StoppedItem{
+		{Type: "storage", ID: "storage1", Priority: 10},
+		{Type: "service", ID: "service1", Priority: 20},
+		{Type: "layer", ID: "sha256:1", Priority: 30},
+	}
+
+	for _, item := range items {
+		if err := db.SetStoppedItem(item); err != nil {
+			t.Fatalf("Can't set stopped item: %v", err)
+		}
+	}
+
+	items[1].Priority = 40
+
+	if err := db.SetStoppedItem(items[1]); err != nil {
+		t.Fatalf("Can't set stopped item: %v", err)
+	}
+
+	storedItems, err := db.GetStoppedItems()
+	if err != nil {
+		t.Fatalf("Can't get stopped items: %v", err)
+	}
+
+	if !reflect.DeepEqual(storedItems, items) {
+		t.Errorf("Wrong stopped items: %v", storedItems)
+	}
+
+	for _, item := range items {
+		if err = db.RemoveStoppedItem(item.Type, item.ID); err != nil {
+			t.Errorf("Can't remove stopped item: %v", err)
+		}
+	}
+
+	if err = db.RemoveStoppedItem("layer", "sha256:2"); err != nil {
+		t.Errorf("Can't remove not existing stopped item: %v", err)
+	}
+
+	if storedItems, err = db.GetStoppedItems(); err != nil {
+		t.Fatalf("Can't get stopped items: %v", err)
+	}
+
+	if len(storedItems) != 0 {
+		t.Errorf("Wrong stopped items: %v", storedItems)
+	}
+}
+
 func TestInstances(t *testing.T) {
 	const (
 		testServiceID = "testService"
@@ -0,0 +1,22 @@
+CREATE TABLE services_new (id TEXT NOT NULL,
+                           aosVersion INTEGER,
+                           providerID TEXT,
+                           description TEXT,
+                           imagePath TEXT,
+                           manifestDigest BLOB,
+                           cached INTEGER,
+                           timestamp TIMESTAMP,
+                           size INTEGER,
+                           GID INTEGER,
+                           quarantined INTEGER,
+                           PRIMARY KEY(id, aosVersion));
+
+INSERT INTO services_new (id, aosVersion, providerID, description, imagePath, manifestDigest, cached,
+        timestamp, size, GID, quarantined)
+SELECT id, aosVersion, providerID, description, imagePath, manifestDigest, cached,
+        timestamp, size, GID, quarantined
+FROM services;
+
+DROP TABLE services;
+
+ALTER TABLE services_new RENAME TO services;
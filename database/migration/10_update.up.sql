@@ -0,0 +1,2 @@
+ALTER TABLE services ADD prefetched INTEGER;
+UPDATE services SET prefetched = 0;
@@ -0,0 +1,9 @@
+ALTER TABLE services ADD fsImageFormat TEXT;
+ALTER TABLE services ADD fsImageVerity TEXT;
+ALTER TABLE services ADD fsImageRootHash TEXT;
+UPDATE services SET fsImageFormat = "", fsImageVerity = "", fsImageRootHash = "";
+
+ALTER TABLE layers ADD fsImageFormat TEXT;
+ALTER TABLE layers ADD fsImageVerity TEXT;
+ALTER TABLE layers ADD fsImageRootHash TEXT;
+UPDATE layers SET fsImageFormat = "", fsImageVerity = "", fsImageRootHash = "";
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS stoppeditems (type TEXT NOT NULL,
+                                         id TEXT NOT NULL,
+                                         priority INTEGER,
+                                         PRIMARY KEY(type, id));
@@ -0,0 +1,11 @@
+CREATE TABLE trafficmonitor_new (chain TEXT NOT NULL PRIMARY KEY,
+                                 time TIMESTAMP,
+                                 value INTEGER);
+
+INSERT INTO trafficmonitor_new (chain, time, value)
+SELECT chain, time, value
+FROM trafficmonitor;
+
+DROP TABLE trafficmonitor;
+
+ALTER TABLE trafficmonitor_new RENAME TO trafficmonitor;
@@ -0,0 +1,2 @@
+ALTER TABLE trafficmonitor ADD alertLevel INTEGER;
+UPDATE trafficmonitor SET alertLevel = 0;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS traffichistory (instanceID TEXT NOT NULL,
+										   time TIMESTAMP NOT NULL,
+										   inValue INTEGER,
+										   outValue INTEGER,
+										   PRIMARY KEY(instanceID, time));
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS blobs (path TEXT NOT NULL PRIMARY KEY,
+								  digest TEXT,
+								  size INTEGER,
+								  refCount INTEGER);
+INSERT OR IGNORE INTO blobs SELECT path, digest, size, 1 FROM layers;
@@ -0,0 +1,42 @@
+CREATE TABLE services_new (id TEXT NOT NULL,
+                           aosVersion INTEGER,
+                           providerID TEXT,
+                           description TEXT,
+                           imagePath TEXT,
+                           manifestDigest BLOB,
+                           cached INTEGER,
+                           timestamp TIMESTAMP,
+                           size INTEGER,
+                           GID INTEGER,
+                           PRIMARY KEY(id, aosVersion));
+
+INSERT INTO services_new (id, aosVersion, providerID, description, imagePath, manifestDigest, cached,
+        timestamp, size, GID)
+SELECT id, aosVersion, providerID, description, imagePath, manifestDigest, cached,
+        timestamp, size, GID
+FROM services;
+
+DROP TABLE services;
+
+ALTER TABLE services_new RENAME TO services;
+
+CREATE TABLE layers_new (digest TEXT NOT NULL PRIMARY KEY,
+                         layerId TEXT,
+                         path TEXT,
+                         osVersion TEXT,
+                         vendorVersion TEXT,
+                         description TEXT,
+                         aosVersion INTEGER,
+                         timestamp TIMESTAMP,
+                         cached INTEGER,
+                         size INTEGER);
+
+INSERT INTO layers_new (digest, layerId, path, osVersion, vendorVersion, description, aosVersion,
+        timestamp, cached, size)
+SELECT digest, layerId, path, osVersion, vendorVersion, description, aosVersion,
+        timestamp, cached, size
+FROM layers;
+
+DROP TABLE layers;
+
+ALTER TABLE layers_new RENAME TO layers;
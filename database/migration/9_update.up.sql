@@ -0,0 +1,8 @@
+ALTER TABLE services ADD quarantined INTEGER;
+UPDATE services SET quarantined = 0;
+
+ALTER TABLE layers ADD contentDigest TEXT;
+UPDATE layers SET contentDigest = "";
+
+ALTER TABLE layers ADD quarantined INTEGER;
+UPDATE layers SET quarantined = 0;
@@ -9,11 +9,13 @@ replace github.com/coreos/go-iptables => github.com/aoscloud/go-iptables v0.0.0-
 require (
 	github.com/aoscloud/aos_common v0.0.0-20230127084043-ebcc151487e0
 	github.com/apparentlymart/go-cidr v1.1.0
+	github.com/cavaliergopher/grab/v3 v3.0.1
 	github.com/containernetworking/cni v1.1.1
 	github.com/containernetworking/plugins v1.1.1
 	github.com/coreos/go-iptables v0.6.0
 	github.com/coreos/go-systemd v0.0.0-20190719114852-fd7a80b32e1f
 	github.com/coreos/go-systemd/v22 v22.3.2
+	github.com/cyphar/filepath-securejoin v0.2.3
 	github.com/golang/protobuf v1.5.2
 	github.com/google/uuid v1.3.0
 	github.com/mattn/go-sqlite3 v1.14.13
@@ -35,8 +37,6 @@ require (
 require (
 	github.com/ThalesIgnite/crypto11 v0.0.0-00010101000000-000000000000 // indirect
 	github.com/anexia-it/fsquota v0.1.3 // indirect
-	github.com/cavaliergopher/grab/v3 v3.0.1 // indirect
-	github.com/cyphar/filepath-securejoin v0.2.3 // indirect
 	github.com/docker/docker v17.12.1-ce+incompatible // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
 	github.com/godbus/dbus/v5 v5.0.6 // indirect
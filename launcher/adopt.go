@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/runner"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	instanceRuntimeInfoFile = "instance.json"
+	runcStateRunning        = "running"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Instance info the container is started with. Used to check if running instance can be adopted after restart.
+type instanceRuntimeInfo struct {
+	InstanceInfo InstanceInfo `json:"instanceInfo"`
+	AosVersion   uint64       `json:"aosVersion"`
+	Secret       string       `json:"secret,omitempty"`
+	UserNS       bool         `json:"userNs,omitempty"`
+	UserNSHostID uint32       `json:"userNsHostId,omitempty"`
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// RuncStateFunc returns runc container status.
+//
+//nolint:gochecknoglobals // used to be overridden in unit tests
+var RuncStateFunc = getRuncState
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func getRuncState(containerID string) (status string, err error) {
+	output, err := exec.Command("runc", "state", containerID).Output()
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	var state struct {
+		Status string `json:"status"`
+	}
+
+	if err = json.Unmarshal(output, &state); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return state.Status, nil
+}
+
+func saveInstanceRuntimeInfo(instance *runtimeInstanceInfo) error {
+	data, err := json.Marshal(instanceRuntimeInfo{
+		InstanceInfo: instance.InstanceInfo,
+		AosVersion:   instance.service.AosVersion,
+		Secret:       instance.secret,
+		UserNS:       instance.userNS,
+		UserNSHostID: instance.userNSHostID,
+	})
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(instance.runtimeDir, instanceRuntimeInfoFile), data, 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func loadInstanceRuntimeInfo(instance *runtimeInstanceInfo) (runtimeInfo instanceRuntimeInfo, err error) {
+	data, err := os.ReadFile(filepath.Join(instance.runtimeDir, instanceRuntimeInfoFile))
+	if err != nil {
+		return runtimeInfo, aoserrors.Wrap(err)
+	}
+
+	if err = json.Unmarshal(data, &runtimeInfo); err != nil {
+		return runtimeInfo, aoserrors.Wrap(err)
+	}
+
+	return runtimeInfo, nil
+}
+
+// Instances which are still running and not changed since previous launcher run are adopted. Other instances are
+// restarted by run instances.
+func (launcher *Launcher) adoptInstances(instances []*runtimeInstanceInfo) {
+	for _, instance := range instances {
+		instance := instance
+
+		launcher.actionHandler.Execute(instance.InstanceID, func(instanceID string) error {
+			if err := launcher.adoptInstance(instance); err != nil {
+				log.WithFields(instanceLogFields(instance, nil)).Debugf("Instance will be restarted: %v", err)
+
+				return nil
+			}
+
+			log.WithFields(instanceLogFields(instance, nil)).Info("Running instance adopted")
+
+			return nil
+		})
+	}
+
+	launcher.actionHandler.Wait()
+}
+
+func (launcher *Launcher) adoptInstance(instance *runtimeInstanceInfo) error {
+	if instance.service == nil {
+		return aoserrors.New("instance service is not available")
+	}
+
+	runtimeInfo, err := loadInstanceRuntimeInfo(instance)
+	if err != nil {
+		return err
+	}
+
+	if runtimeInfo.InstanceInfo != instance.InstanceInfo || runtimeInfo.AosVersion != instance.service.AosVersion {
+		return aoserrors.New("instance is changed")
+	}
+
+	state, err := RuncStateFunc(instance.InstanceID)
+	if err != nil {
+		return err
+	}
+
+	if state != runcStateRunning {
+		return aoserrors.Errorf("instance is %s", state)
+	}
+
+	if err = launcher.restoreRuntime(instance, runtimeInfo); err != nil {
+		return err
+	}
+
+	// Starting of already running unit doesn't restart it but resumes its state monitoring by runner
+	runStatus := launcher.instanceRunner.StartInstance(instance.InstanceID, instance.runtimeDir, runner.RunParameters{
+		StartInterval:   instance.service.serviceConfig.RunParameters.StartInterval.Duration,
+		StartBurst:      instance.service.serviceConfig.RunParameters.StartBurst,
+		RestartInterval: instance.service.serviceConfig.RunParameters.RestartInterval.Duration,
+	})
+	if runStatus.State != cloudprotocol.InstanceStateActive {
+		return aoserrors.Errorf("instance state is %s", runStatus.State)
+	}
+
+	launcher.runMutex.Lock()
+	instance.setRunStatus(runStatus)
+	// Network is kept only while instance is restarted
+	instance.keepNetwork = false
+	launcher.runMutex.Unlock()
+
+	launcher.startInstanceMonitor(instance)
+
+	return nil
+}
+
+// Restores resources allocated for running instance.
+func (launcher *Launcher) restoreRuntime(instance *runtimeInstanceInfo, runtimeInfo instanceRuntimeInfo) error {
+	if err := launcher.getInstanceResources(instance); err != nil {
+		return err
+	}
+
+	if runtimeInfo.UserNS {
+		launcher.runMutex.Lock()
+		launcher.userNSRanges[instance.InstanceID] = runtimeInfo.UserNSHostID
+		launcher.runMutex.Unlock()
+
+		instance.userNSHostID, instance.userNS = runtimeInfo.UserNSHostID, true
+	}
+
+	if err := launcher.allocateDevices(instance); err != nil {
+		return err
+	}
+
+	if instance.service.serviceConfig.Permissions != nil {
+		secret, err := launcher.instanceRegistrar.RegisterInstance(
+			instance.InstanceIdent, instance.service.serviceConfig.Permissions)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		// Running instance uses secret passed on its start
+		if secret != runtimeInfo.Secret {
+			return aoserrors.New("instance secret is changed")
+		}
+
+		instance.secret = secret
+	}
+
+	return launcher.setupNetwork(instance)
+}
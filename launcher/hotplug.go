@@ -0,0 +1,435 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	instancePIDFile  = ".pid"
+	devicesAllowFile = "devices.allow"
+	devicesDenyFile  = "devices.deny"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// InjectDeviceFunc, EjectDeviceFunc add and remove device of running instance.
+//
+//nolint:gochecknoglobals // used to be overridden in unit tests
+var (
+	InjectDeviceFunc = injectDevice
+	EjectDeviceFunc  = ejectDevice
+)
+
+// DevicesCgroupsDir specifies cgroup v1 devices controller directory of instances.
+//
+//nolint:gochecknoglobals // used to be overridden in unit tests
+var DevicesCgroupsDir = "/sys/fs/cgroup/devices/system.slice/system-aos\\x2dservice.slice"
+
+// ErrDeviceRulesNotSupported device cgroup rules can't be changed for running instance. Cgroup v2 device rules
+// are eBPF program attached by runc on start.
+var ErrDeviceRulesNotSupported = errors.New("device cgroup rules can't be changed")
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// Device is added to the runtime spec on start. Running instance gets newly available device by updating its device
+// cgroup rules and creating device node in its root. The runtime spec is updated as well to keep the device if the
+// instance is restarted by the runner.
+func (launcher *Launcher) injectInstanceDevice(instance *runtimeInstanceInfo, device aostypes.ServiceDevice) error {
+	specFile := filepath.Join(instance.runtimeDir, runtimeConfigFile)
+
+	spec, err := loadRuntimeSpec(specFile, launcher.resourceManager)
+	if err != nil {
+		return err
+	}
+
+	numDevices := len(spec.ociSpec.Linux.Devices)
+	numRules := len(spec.ociSpec.Linux.Resources.Devices)
+	numGroups := len(spec.ociSpec.Process.User.AdditionalGids)
+
+	if err = spec.setDevices([]aostypes.ServiceDevice{device}); err != nil {
+		return err
+	}
+
+	if len(spec.ociSpec.Process.User.AdditionalGids) != numGroups {
+		return aoserrors.New("device groups can't be added to running instance")
+	}
+
+	for i, specDevice := range spec.ociSpec.Linux.Devices[numDevices:] {
+		rule := spec.ociSpec.Linux.Resources.Devices[numRules+i]
+
+		var newRule *runtimespec.LinuxDeviceCgroup
+
+		if !hasDeviceRule(spec.ociSpec.Linux.Resources.Devices[:numRules], rule) {
+			newRule = &rule
+		}
+
+		if err = InjectDeviceFunc(instance.InstanceID, specDevice, newRule); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return spec.save(specFile)
+}
+
+// Device nodes are removed from running instance. Device cgroup rules are revoked if supported, otherwise they are
+// kept in the runtime spec as they remain active till the instance is restarted.
+func (launcher *Launcher) ejectInstanceDevice(instance *runtimeInstanceInfo, device string) error {
+	specFile := filepath.Join(instance.runtimeDir, runtimeConfigFile)
+
+	spec, err := loadRuntimeSpec(specFile, launcher.resourceManager)
+	if err != nil {
+		return err
+	}
+
+	deviceInfo, err := launcher.resourceManager.GetDeviceInfo(device)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	containerPaths := make([]string, 0, len(deviceInfo.HostDevices))
+
+	for _, hostDevice := range deviceInfo.HostDevices {
+		var containerPath string
+
+		if _, containerPath, err = parseHostDevice(hostDevice); err != nil {
+			return err
+		}
+
+		containerPaths = append(containerPaths, containerPath)
+	}
+
+	specDevices := make([]runtimespec.LinuxDevice, 0, len(spec.ociSpec.Linux.Devices))
+
+	for _, specDevice := range spec.ociSpec.Linux.Devices {
+		if !isDevicePath(specDevice.Path, containerPaths) {
+			specDevices = append(specDevices, specDevice)
+
+			continue
+		}
+
+		rule := runtimespec.LinuxDeviceCgroup{
+			Allow: false, Type: specDevice.Type, Major: &specDevice.Major, Minor: &specDevice.Minor, Access: "rwm",
+		}
+
+		err = EjectDeviceFunc(instance.InstanceID, specDevice, &rule)
+		if err != nil && !errors.Is(err, ErrDeviceRulesNotSupported) {
+			return aoserrors.Wrap(err)
+		}
+
+		if err == nil {
+			spec.removeDeviceRule(specDevice)
+		}
+	}
+
+	spec.ociSpec.Linux.Devices = specDevices
+
+	return spec.save(specFile)
+}
+
+func (launcher *Launcher) isInstanceActive(instance *runtimeInstanceInfo) bool {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	return instance.runStatus.State == cloudprotocol.InstanceStateActive
+}
+
+func getServiceDevice(instance *runtimeInstanceInfo, name string) aostypes.ServiceDevice {
+	for _, serviceDevice := range instance.service.serviceConfig.Devices {
+		if serviceDevice.Name == name {
+			return serviceDevice
+		}
+	}
+
+	return aostypes.ServiceDevice{Name: name}
+}
+
+func (spec *runtimeSpec) removeDeviceRule(device runtimespec.LinuxDevice) {
+	for i, rule := range spec.ociSpec.Linux.Resources.Devices {
+		if rule.Allow && rule.Type == device.Type && rule.Major != nil && *rule.Major == device.Major &&
+			rule.Minor != nil && *rule.Minor == device.Minor {
+			spec.ociSpec.Linux.Resources.Devices = append(
+				spec.ociSpec.Linux.Resources.Devices[:i], spec.ociSpec.Linux.Resources.Devices[i+1:]...)
+
+			return
+		}
+	}
+}
+
+func hasDeviceRule(rules []runtimespec.LinuxDeviceCgroup, rule runtimespec.LinuxDeviceCgroup) bool {
+	for _, existingRule := range rules {
+		if existingRule.Allow && existingRule.Type == rule.Type && existingRule.Access == rule.Access &&
+			existingRule.Major != nil && rule.Major != nil && *existingRule.Major == *rule.Major &&
+			existingRule.Minor != nil && rule.Minor != nil && *existingRule.Minor == *rule.Minor {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDevicePath(devicePath string, containerPaths []string) bool {
+	for _, containerPath := range containerPaths {
+		if devicePath == containerPath || strings.HasPrefix(devicePath, containerPath+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func injectDevice(instanceID string, device runtimespec.LinuxDevice, rule *runtimespec.LinuxDeviceCgroup) error {
+	if rule != nil {
+		if err := writeDeviceRule(instanceID, devicesAllowFile, *rule); err != nil {
+			return err
+		}
+	}
+
+	return createDeviceNode(instanceID, device)
+}
+
+func ejectDevice(instanceID string, device runtimespec.LinuxDevice, rule *runtimespec.LinuxDeviceCgroup) error {
+	if err := removeDeviceNode(instanceID, device); err != nil {
+		return err
+	}
+
+	if rule != nil {
+		return writeDeviceRule(instanceID, devicesDenyFile, *rule)
+	}
+
+	return nil
+}
+
+func writeDeviceRule(instanceID, ruleFile string, rule runtimespec.LinuxDeviceCgroup) error {
+	fileName := filepath.Join(DevicesCgroupsDir, instanceID, ruleFile)
+
+	if _, err := os.Stat(fileName); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrDeviceRulesNotSupported
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	deviceType, major, minor := "a", "*", "*"
+
+	if rule.Type != "" {
+		deviceType = rule.Type
+	}
+
+	if rule.Major != nil {
+		major = strconv.FormatInt(*rule.Major, 10)
+	}
+
+	if rule.Minor != nil {
+		minor = strconv.FormatInt(*rule.Minor, 10)
+	}
+
+	if err := os.WriteFile(
+		fileName, []byte(fmt.Sprintf("%s %s:%s %s", deviceType, major, minor, rule.Access)), 0o600); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func openInstanceRoot(instanceID string) (rootFd int, err error) {
+	data, err := os.ReadFile(filepath.Join(RuntimeDir, instanceID, instancePIDFile))
+	if err != nil {
+		return -1, aoserrors.Wrap(err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1, aoserrors.Wrap(err)
+	}
+
+	if rootFd, err = unix.Open(
+		fmt.Sprintf("/proc/%d/root", pid), unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0); err != nil {
+		return -1, aoserrors.Wrap(err)
+	}
+
+	return rootFd, nil
+}
+
+// Paths are resolved inside the instance root to not follow instance symlinks pointing to host files.
+func openInstanceDir(rootFd int, dirPath string, create bool) (dirFd int, err error) {
+	how := &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_DIRECTORY | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	}
+
+	if dirFd, err = unix.Openat2(rootFd, dirPath, how); err == nil || !create || !errors.Is(err, unix.ENOENT) {
+		return dirFd, aoserrors.Wrap(err)
+	}
+
+	parentFd, err := openInstanceDir(rootFd, path.Dir(dirPath), create)
+	if err != nil {
+		return -1, err
+	}
+	defer unix.Close(parentFd)
+
+	if err = unix.Mkdirat(parentFd, path.Base(dirPath), 0o755); err != nil && !errors.Is(err, unix.EEXIST) {
+		return -1, aoserrors.Wrap(err)
+	}
+
+	if dirFd, err = unix.Openat2(rootFd, dirPath, how); err != nil {
+		return -1, aoserrors.Wrap(err)
+	}
+
+	return dirFd, nil
+}
+
+func createDeviceNode(instanceID string, device runtimespec.LinuxDevice) error {
+	var mode uint32
+
+	switch device.Type {
+	case "c", "u":
+		mode = unix.S_IFCHR
+
+	case "b":
+		mode = unix.S_IFBLK
+
+	case "p":
+		mode = unix.S_IFIFO
+
+	default:
+		return aoserrors.Errorf("unsupported device type: %s", device.Type)
+	}
+
+	if device.FileMode != nil {
+		mode |= uint32(device.FileMode.Perm())
+	}
+
+	rootFd, err := openInstanceRoot(instanceID)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	dirFd, err := openInstanceDir(rootFd, path.Dir(path.Clean("/"+device.Path)), true)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirFd)
+
+	name := path.Base(device.Path)
+
+	if err = unix.Unlinkat(dirFd, name, 0); err != nil && !errors.Is(err, unix.ENOENT) {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = unix.Mknodat(
+		dirFd, name, mode, int(unix.Mkdev(uint32(device.Major), uint32(device.Minor)))); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return setDeviceNodeOwner(dirFd, name, mode, device)
+}
+
+// Node owner and mode are changed through the opened node to not follow symlink if the node is replaced in between.
+func setDeviceNodeOwner(dirFd int, name string, mode uint32, device runtimespec.LinuxDevice) error {
+	nodeFd, err := unix.Openat(dirFd, name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer unix.Close(nodeFd)
+
+	var stat unix.Stat_t
+
+	if err = unix.Fstat(nodeFd, &stat); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if stat.Mode&unix.S_IFMT != mode&unix.S_IFMT {
+		return aoserrors.Errorf("device node %s is replaced", device.Path)
+	}
+
+	uid, gid := -1, -1
+
+	if device.UID != nil {
+		uid = int(*device.UID)
+	}
+
+	if device.GID != nil {
+		gid = int(*device.GID)
+	}
+
+	nodePath := fmt.Sprintf("/proc/self/fd/%d", nodeFd)
+
+	if err = unix.Fchownat(unix.AT_FDCWD, nodePath, uid, gid, 0); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Node is created with umask applied
+	if err = unix.Chmod(nodePath, mode&^unix.S_IFMT); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	log.WithField("device", device.Path).Debug("Device node created")
+
+	return nil
+}
+
+func removeDeviceNode(instanceID string, device runtimespec.LinuxDevice) error {
+	rootFd, err := openInstanceRoot(instanceID)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(rootFd)
+
+	dirFd, err := openInstanceDir(rootFd, path.Dir(path.Clean("/"+device.Path)), false)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+
+		return err
+	}
+	defer unix.Close(dirFd)
+
+	if err = unix.Unlinkat(dirFd, path.Base(device.Path), 0); err != nil && !errors.Is(err, unix.ENOENT) {
+		return aoserrors.Wrap(err)
+	}
+
+	log.WithFields(log.Fields{"instanceID": instanceID, "device": device.Path}).Debug("Device node removed")
+
+	return nil
+}
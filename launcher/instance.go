@@ -23,6 +23,7 @@ import (
 	"github.com/aoscloud/aos_common/api/cloudprotocol"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/aoscloud/aos_servicemanager/resourcemanager"
 	"github.com/aoscloud/aos_servicemanager/runner"
 )
 
@@ -37,6 +38,11 @@ type runtimeInstanceInfo struct {
 	runtimeDir      string
 	secret          string
 	overrideEnvVars []string
+	resources       []resourcemanager.ResourceInfo
+	userNS          bool
+	userNSHostID    uint32
+	limitsUsage     limitsUsage
+	keepNetwork     bool
 }
 
 /***********************************************************************************************************************
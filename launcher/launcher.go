@@ -20,6 +20,7 @@ package launcher
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
@@ -45,8 +46,10 @@ import (
 	"github.com/aoscloud/aos_servicemanager/config"
 	"github.com/aoscloud/aos_servicemanager/layermanager"
 	"github.com/aoscloud/aos_servicemanager/networkmanager"
+	"github.com/aoscloud/aos_servicemanager/resourcemanager"
 	"github.com/aoscloud/aos_servicemanager/runner"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
 )
 
 /***********************************************************************************************************************
@@ -60,6 +63,7 @@ const (
 	runtimeConfigFile      = "config.json"
 	instanceRootFS         = "rootfs"
 	instanceMountPointsDir = "mounts"
+	instanceImagesDir      = "images"
 	instanceStateFile      = "/state.dat"
 	instanceStorageDir     = "/storage"
 	runxRunner             = "runx"
@@ -79,6 +83,9 @@ type Storage interface {
 	SetOverrideEnvVars(envVarsInfo []cloudprotocol.EnvVarsInstanceInfo) error
 	GetOnlineTime() (time.Time, error)
 	SetOnlineTime(t time.Time) error
+	GetStoppedItems() ([]StoppedItem, error)
+	SetStoppedItem(item StoppedItem) error
+	RemoveStoppedItem(itemType, id string) error
 }
 
 // ServiceProvider service provider.
@@ -91,6 +98,7 @@ type ServiceProvider interface {
 // LayerProvider layer provider.
 type LayerProvider interface {
 	GetLayerInfoByDigest(digest string) (layermanager.LayerInfo, error)
+	QuarantineLayer(digest string) error
 }
 
 // InstanceRunner interface to start/stop service instances.
@@ -103,11 +111,15 @@ type InstanceRunner interface {
 // ResourceManager provides API to validate, request and release resources.
 type ResourceManager interface {
 	GetDeviceInfo(device string) (aostypes.DeviceInfo, error)
-	GetResourceInfo(resource string) (aostypes.ResourceInfo, error)
+	GetResourceInfo(resource string) (resourcemanager.ResourceInfo, error)
 	AllocateDevice(device, instanceID string) error
 	ReleaseDevice(device, instanceID string) error
 	ReleaseDevices(instanceID string) error
 	GetDeviceInstances(name string) (instanceIDs []string, err error)
+	GetHostFSInfo() (resourcemanager.HostFSInfo, error)
+	GetSecurityProfiles() (resourcemanager.SecurityProfilesInfo, error)
+	GetResourceLimits() (resourcemanager.ResourceLimitsInfo, error)
+	DeviceEventChannel() <-chan resourcemanager.DeviceEvent
 }
 
 // NetworkManager provides network access.
@@ -115,6 +127,7 @@ type NetworkManager interface {
 	GetNetnsPath(instanceID string) string
 	AddInstanceToNetwork(instanceID, networkID string, params networkmanager.NetworkParams) error
 	RemoveInstanceFromNetwork(instanceID, networkID string) error
+	GetServiceEndpoints(serviceID string) []networkmanager.ServiceEndpoint
 }
 
 // InstanceRegistrar provides API to register/unregister instance.
@@ -176,6 +189,10 @@ type Launcher struct {
 	currentInstances       map[string]*runtimeInstanceInfo
 	currentServices        map[string]*serviceInfo
 	currentEnvVars         []cloudprotocol.EnvVarsInstanceInfo
+	stoppedItems           map[stoppedItemKey]uint64
+	userNSRanges           map[string]uint32
+	usageMutex             sync.Mutex
+	instancesUsage         map[aostypes.InstanceIdent]LimitsUsage
 	onlineTime             time.Time
 	isCloudOnline          bool
 }
@@ -194,12 +211,18 @@ const OperationVersion = 9
 //
 //nolint:gochecknoglobals
 var (
-	MountFunc   = fs.OverlayMount
-	UnmountFunc = fs.Umount
+	MountFunc        = fs.OverlayMount
+	UnmountFunc      = fs.Umount
+	MountImageFunc   = fsimage.Mount
+	UnmountImageFunc = fsimage.Umount
 )
 
-// ErrNotExist not exist instance error.
-var ErrNotExist = errors.New("instance not exist")
+var (
+	// ErrNotExist not exist instance error.
+	ErrNotExist = errors.New("instance not exist")
+	// ErrMissingLayer service layer is not installed or damaged and should be fetched again.
+	ErrMissingLayer = errors.New("missing layer")
+)
 
 //nolint:gochecknoglobals // used to be overridden in unit tests
 var (
@@ -207,6 +230,10 @@ var (
 	RuntimeDir = "/run/aos/runtime"
 	// CheckTTLsPeriod specifies period different TTL timers are checked with.
 	CheckTTLsPeriod = 1 * time.Hour
+	// CheckLimitsPeriod specifies period instances cgroup usage is checked against resource limits.
+	CheckLimitsPeriod = 10 * time.Second
+	// CgroupsDir specifies cgroup v2 directory of instances.
+	CgroupsDir = "/sys/fs/cgroup/system.slice/system-aos\\x2dservice.slice"
 )
 
 var defaultHostFSBinds = []string{"bin", "sbin", "lib", "lib64", "usr"} //nolint:gochecknoglobals // const
@@ -222,6 +249,10 @@ func New(config *config.Config, storage Storage, serviceProvider ServiceProvider
 ) (launcher *Launcher, err error) {
 	log.Debug("New launcher")
 
+	if err = validateUserNSConfig(config.UserNamespace); err != nil {
+		return nil, err
+	}
+
 	launcher = &Launcher{
 		storage: storage, serviceProvider: serviceProvider, layerProvider: layerProvider,
 		instanceRunner: instanceRunner, resourceManager: resourceManager, networkManager: networkManager,
@@ -231,6 +262,8 @@ func New(config *config.Config, storage Storage, serviceProvider ServiceProvider
 		actionHandler:        action.New(maxParallelInstanceActions),
 		runtimeStatusChannel: make(chan RuntimeStatus, 1),
 		currentInstances:     make(map[string]*runtimeInstanceInfo),
+		stoppedItems:         make(map[stoppedItemKey]uint64),
+		userNSRanges:         make(map[string]uint32),
 	}
 
 	ctx, cancelFunction := context.WithCancel(context.Background())
@@ -255,6 +288,10 @@ func New(config *config.Config, storage Storage, serviceProvider ServiceProvider
 		log.Errorf("Can't get current env vars: %v", err)
 	}
 
+	if err = launcher.loadStoppedItems(); err != nil {
+		log.Errorf("Can't load stopped items: %v", err)
+	}
+
 	// Restart previously started instances
 	if err = launcher.restartStoredInstances(); err != nil {
 		log.Errorf("Restart instances error: %v", err)
@@ -344,11 +381,22 @@ func (launcher *Launcher) CloudConnection(connected bool) error {
  **********************************************************************************************************************/
 
 func (launcher *Launcher) handleChannels(ctx context.Context) {
+	limitsTicker := time.NewTicker(CheckLimitsPeriod)
+	defer limitsTicker.Stop()
+
 	for {
 		select {
 		case instances := <-launcher.instanceRunner.InstanceStatusChannel():
 			launcher.updateInstancesStatuses(instances)
 
+		case <-limitsTicker.C:
+			launcher.checkInstancesLimits()
+
+		case event := <-launcher.resourceManager.DeviceEventChannel():
+			launcher.Lock()
+			launcher.handleDeviceEvent(event)
+			launcher.Unlock()
+
 		case <-time.After(CheckTTLsPeriod):
 			launcher.Lock()
 			launcher.updateInstancesEnvVars()
@@ -503,7 +551,7 @@ func (launcher *Launcher) releaseRuntime(instance *runtimeInstanceInfo) (err err
 		}
 	}
 
-	if !slices.Contains(launcher.config.RunnerFeatures, runxRunner) {
+	if !slices.Contains(launcher.config.RunnerFeatures, runxRunner) && !instance.keepNetwork {
 		if networkErr := launcher.networkManager.RemoveInstanceFromNetwork(
 			instance.InstanceID, instance.service.ServiceProvider); networkErr != nil && err == nil {
 			err = aoserrors.Wrap(networkErr)
@@ -514,6 +562,8 @@ func (launcher *Launcher) releaseRuntime(instance *runtimeInstanceInfo) (err err
 		err = aoserrors.Wrap(deviceErr)
 	}
 
+	launcher.releaseUserNS(instance)
+
 	return err
 }
 
@@ -567,6 +617,18 @@ func (launcher *Launcher) stopInstance(instance *runtimeInstanceInfo) (err error
 		err = aoserrors.Wrap(errStat)
 	}
 
+	if unmountErr := umountIDMapped(instance); unmountErr != nil && err == nil {
+		err = unmountErr
+	}
+
+	if unmountErr := umountImages(instance); unmountErr != nil && err == nil {
+		err = unmountErr
+	}
+
+	if unmountErr := umountWritableLayer(instance); unmountErr != nil && err == nil {
+		err = unmountErr
+	}
+
 	if removeErr := os.RemoveAll(instance.runtimeDir); removeErr != nil && err == nil {
 		err = aoserrors.Wrap(removeErr)
 	}
@@ -617,17 +679,29 @@ func (launcher *Launcher) doStartAction(instance *runtimeInstanceInfo) {
 	})
 }
 
-func (launcher *Launcher) getHostsFromResources(resources []string) (hosts []aostypes.Host, err error) {
-	for _, resource := range resources {
-		unitResource, err := launcher.resourceManager.GetResourceInfo(resource)
+func (launcher *Launcher) getInstanceResources(instance *runtimeInstanceInfo) error {
+	instance.resources = make([]resourcemanager.ResourceInfo, 0, len(instance.service.serviceConfig.Resources))
+
+	for _, resource := range instance.service.serviceConfig.Resources {
+		resourceInfo, err := launcher.resourceManager.GetResourceInfo(resource)
 		if err != nil {
-			return hosts, aoserrors.Wrap(err)
+			launcher.alertSender.SendAlert(resourceValidateAlert(instance, resource, err))
+
+			return aoserrors.Wrap(err)
 		}
 
-		hosts = append(hosts, unitResource.Hosts...)
+		instance.resources = append(instance.resources, resourceInfo)
 	}
 
-	return hosts, nil
+	return nil
+}
+
+func getHostsFromResources(resources []resourcemanager.ResourceInfo) (hosts []aostypes.Host) {
+	for _, resource := range resources {
+		hosts = append(hosts, resource.Hosts...)
+	}
+
+	return hosts
 }
 
 func (launcher *Launcher) setupNetwork(instance *runtimeInstanceInfo) (err error) {
@@ -644,12 +718,7 @@ func (launcher *Launcher) setupNetwork(instance *runtimeInstanceInfo) (err error
 		Hosts:              launcher.config.Hosts,
 	}
 
-	resourceHosts, err := launcher.getHostsFromResources(instance.service.serviceConfig.Resources)
-	if err != nil {
-		return err
-	}
-
-	params.Hosts = append(params.Hosts, resourceHosts...)
+	params.Hosts = append(params.Hosts, getHostsFromResources(instance.resources)...)
 
 	if instance.service.serviceConfig.Quotas.DownloadSpeed != nil {
 		params.IngressKbit = *instance.service.serviceConfig.Quotas.DownloadSpeed
@@ -683,6 +752,8 @@ func (launcher *Launcher) setupNetwork(instance *runtimeInstanceInfo) (err error
 		params.AllowedConnections = append(params.AllowedConnections, key)
 	}
 
+	params.PortMappings = instance.service.serviceConfig.PortMappings
+
 	if !slices.Contains(launcher.config.RunnerFeatures, runxRunner) {
 		if err := launcher.networkManager.AddInstanceToNetwork(
 			instance.InstanceID, instance.service.ServiceProvider, params); err != nil {
@@ -714,6 +785,14 @@ func (launcher *Launcher) allocateDevices(instance *runtimeInstanceInfo) (err er
 }
 
 func (launcher *Launcher) setupRuntime(instance *runtimeInstanceInfo) error {
+	if err := launcher.getInstanceResources(instance); err != nil {
+		return err
+	}
+
+	if err := launcher.allocateUserNS(instance); err != nil {
+		return err
+	}
+
 	if err := launcher.allocateDevices(instance); err != nil {
 		return err
 	}
@@ -778,6 +857,10 @@ func (launcher *Launcher) startInstance(instance *runtimeInstanceInfo) error {
 		return err
 	}
 
+	if err := saveInstanceRuntimeInfo(instance); err != nil {
+		return err
+	}
+
 	runStatus := launcher.instanceRunner.StartInstance(
 		instance.InstanceID, instance.runtimeDir, runner.RunParameters{
 			StartInterval:   instance.service.serviceConfig.RunParameters.StartInterval.Duration,
@@ -796,6 +879,12 @@ func (launcher *Launcher) startInstance(instance *runtimeInstanceInfo) error {
 
 	launcher.runMutex.Unlock()
 
+	launcher.startInstanceMonitor(instance)
+
+	return nil
+}
+
+func (launcher *Launcher) startInstanceMonitor(instance *runtimeInstanceInfo) {
 	monitorParams := resourcemonitor.ResourceMonitorParams{
 		InstanceIdent: instance.InstanceIdent,
 		UID:           int(instance.UID),
@@ -820,8 +909,6 @@ func (launcher *Launcher) startInstance(instance *runtimeInstanceInfo) error {
 	if err := launcher.instanceMonitor.StartInstanceMonitor(instance.InstanceID, monitorParams); err != nil {
 		log.WithFields(instanceLogFields(instance, nil)).Errorf("Can't start instance monitoring: %v", err)
 	}
-
-	return nil
 }
 
 func (launcher *Launcher) sendRunInstancesStatuses() {
@@ -960,32 +1047,135 @@ func (launcher *Launcher) prepareRootFS(instance *runtimeInstanceInfo, runtimeCo
 		return aoserrors.Wrap(err)
 	}
 
-	layersDir := []string{mountPointsDir, imageParts.ServiceFSPath}
+	serviceFSPath, err := mountImage(instance, imageParts.ServiceFSPath, instance.service.FSImage, 0)
+	if err != nil {
+		return err
+	}
+
+	layersDir := []string{mountPointsDir, serviceFSPath}
 
-	for _, digest := range imageParts.LayersDigest {
+	for i, digest := range imageParts.LayersDigest {
 		layer, err := launcher.layerProvider.GetLayerInfoByDigest(digest)
 		if err != nil {
+			if errors.Is(err, layermanager.ErrNotExist) || errors.Is(err, layermanager.ErrQuarantined) {
+				return launcher.missingLayerError(instance, digest, err)
+			}
+
 			return aoserrors.Wrap(err)
 		}
 
-		layersDir = append(layersDir, layer.Path)
+		if _, err = os.Stat(layer.Path); err != nil {
+			if quarantineErr := launcher.layerProvider.QuarantineLayer(digest); quarantineErr != nil {
+				log.WithField("digest", digest).Errorf("Can't quarantine layer: %v", quarantineErr)
+			}
+
+			return launcher.missingLayerError(instance, digest, err)
+		}
+
+		layerPath, err := mountImage(instance, layer.Path, layer.FSImage, i+1)
+		if err != nil {
+			return err
+		}
+
+		layersDir = append(layersDir, layerPath)
 	}
 
-	layersDir = append(layersDir, path.Join(launcher.config.WorkingDir, hostFSWiteoutsDir), "/")
+	// Fully self-contained images don't use host rootfs as the lowest layer
+	if hostFS := instance.service.serviceConfig.HostFS; hostFS == nil || !hostFS.NoHostRootFS {
+		layersDir = append(layersDir, path.Join(launcher.config.WorkingDir, hostFSWiteoutsDir), "/")
+	}
 
 	rootfsDir := filepath.Join(instance.runtimeDir, instanceRootFS)
+	overlayDir := rootfsDir
+
+	// Instance in user namespace uses idmapped clone of overlay as rootfs
+	if instance.userNS {
+		overlayDir = filepath.Join(instance.runtimeDir, instanceMergedFSDir)
+	}
 
-	if err = os.MkdirAll(rootfsDir, 0o755); err != nil {
+	if err = os.MkdirAll(overlayDir, 0o755); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if err = MountFunc(rootfsDir, layersDir, "", ""); err != nil {
+	upperDir, workDir, err := launcher.prepareWritableLayer(instance)
+	if err != nil {
+		return err
+	}
+
+	if err = MountFunc(overlayDir, layersDir, workDir, upperDir); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
+	if overlayDir != rootfsDir {
+		if err = os.MkdirAll(rootfsDir, 0o755); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = IDMapMountFunc(
+			overlayDir, rootfsDir, instance.userNSMapping(), instance.userNSMapping()); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
 	return nil
 }
 
+// Missing layer is reported by alert. Damaged layer is quarantined, so it is installed again on next desired layers
+// processing and the failed instance is restarted by next run instances request.
+func (launcher *Launcher) missingLayerError(instance *runtimeInstanceInfo, digest string, err error) error {
+	err = aoserrors.Errorf("%w %s: %v", ErrMissingLayer, digest, err)
+
+	launcher.alertSender.SendAlert(cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagServiceInstance,
+		Payload: cloudprotocol.ServiceInstanceAlert{
+			InstanceIdent: instance.InstanceIdent,
+			AosVersion:    instance.service.AosVersion,
+			Message:       err.Error(),
+		},
+	})
+
+	return err
+}
+
+// Packed service rootfs and layers are mounted to instance runtime dir and used as overlay lower dirs.
+func mountImage(instance *runtimeInstanceInfo, imagePath string, fsImage fsimage.Info, index int) (string, error) {
+	if fsImage.Format == "" {
+		return imagePath, nil
+	}
+
+	mountPoint := filepath.Join(instance.runtimeDir, instanceImagesDir, strconv.Itoa(index))
+
+	if err := MountImageFunc(imagePath, mountPoint, fsImage); err != nil {
+		os.RemoveAll(mountPoint)
+
+		return "", aoserrors.Wrap(err)
+	}
+
+	return mountPoint, nil
+}
+
+func umountImages(instance *runtimeInstanceInfo) (err error) {
+	imagesDir := filepath.Join(instance.runtimeDir, instanceImagesDir)
+
+	entries, err := os.ReadDir(imagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	for _, entry := range entries {
+		if unmountErr := UnmountImageFunc(filepath.Join(imagesDir, entry.Name())); unmountErr != nil && err == nil {
+			err = aoserrors.Wrap(unmountErr)
+		}
+	}
+
+	return err
+}
+
 func getMountPermissions(mount runtimespec.Mount) (permissions uint64, err error) {
 	for _, option := range mount.Options {
 		nameValue := strings.Split(strings.TrimSpace(option), "=")
@@ -1066,10 +1256,16 @@ func (launcher *Launcher) restartStoredInstances() error {
 
 	launcher.cacheCurrentServices(currentInstances)
 
+	restoreInstances := make([]*runtimeInstanceInfo, 0, len(currentInstances))
+
 	launcher.runMutex.Lock()
 
 	for _, currentInstance := range currentInstances {
 		instance := newRuntimeInstanceInfo(currentInstance)
+		restoreInstances = append(restoreInstances, instance)
+
+		// Keep instance network on restart: it is reattached by network manager when the instance is started again
+		instance.keepNetwork = true
 
 		launcher.currentInstances[currentInstance.InstanceID] = instance
 
@@ -1088,7 +1284,7 @@ func (launcher *Launcher) restartStoredInstances() error {
 
 	launcher.runMutex.Unlock()
 
-	launcher.stopCurrentInstances()
+	launcher.adoptInstances(restoreInstances)
 	launcher.runInstances(currentInstances)
 
 	return nil
@@ -1144,6 +1340,8 @@ newInstancesLoop:
 		}
 	}
 
+	launcher.setItemsStopped(curInstances)
+
 	return runningInstances
 }
 
@@ -1201,6 +1399,128 @@ func (launcher *Launcher) updateOfflineTimeouts() {
 	launcher.setOfflineInstancesStatus(instances)
 }
 
+func (launcher *Launcher) handleDeviceEvent(event resourcemanager.DeviceEvent) {
+	instances := launcher.getDeviceRequestedInstances(event.Name)
+
+	if !event.Available {
+		launcher.sendDeviceRemovedAlerts(event.Name)
+
+		for _, instance := range instances {
+			if !launcher.isInstanceActive(instance) {
+				continue
+			}
+
+			if err := launcher.ejectInstanceDevice(instance, event.Name); err != nil {
+				log.WithFields(instanceLogFields(instance, log.Fields{"device": event.Name})).Errorf(
+					"Can't remove device: %v", err)
+			}
+		}
+
+		return
+	}
+
+	// Running instances get the device in place, other instances and instances the device can't be injected into
+	// are restarted
+	restartInstances := make([]*runtimeInstanceInfo, 0, len(instances))
+
+	for _, instance := range instances {
+		if launcher.isInstanceActive(instance) {
+			err := launcher.injectInstanceDevice(instance, getServiceDevice(instance, event.Name))
+			if err == nil {
+				continue
+			}
+
+			log.WithFields(instanceLogFields(instance, log.Fields{"device": event.Name})).Warnf(
+				"Can't inject device, restart instance: %v", err)
+		}
+
+		restartInstances = append(restartInstances, instance)
+	}
+
+	if len(restartInstances) == 0 {
+		return
+	}
+
+	log.WithField("device", event.Name).Debug("Restart instances requested available device")
+
+	launcher.stopInstances(restartInstances)
+
+	startInstances := make([]*runtimeInstanceInfo, 0, len(restartInstances))
+
+	for _, instance := range restartInstances {
+		startInstances = append(startInstances, newRuntimeInstanceInfo(instance.InstanceInfo))
+	}
+
+	launcher.startInstances(startInstances)
+
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	updateInstancesStatus := &InstancesStatus{Instances: make([]cloudprotocol.InstanceStatus, 0, len(startInstances))}
+
+	for _, instance := range startInstances {
+		updateInstancesStatus.Instances = append(updateInstancesStatus.Instances, instance.getCloudStatus())
+	}
+
+	launcher.runtimeStatusChannel <- RuntimeStatus{UpdateStatus: updateInstancesStatus}
+}
+
+func (launcher *Launcher) getDeviceRequestedInstances(device string) []*runtimeInstanceInfo {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	instances := make([]*runtimeInstanceInfo, 0)
+
+	for _, instance := range launcher.currentInstances {
+		if instance.service == nil || instance.service.serviceConfig == nil {
+			continue
+		}
+
+		for _, serviceDevice := range instance.service.serviceConfig.Devices {
+			if serviceDevice.Name == device {
+				instances = append(instances, instance)
+
+				break
+			}
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Priority > instances[j].Priority })
+
+	return instances
+}
+
+func (launcher *Launcher) sendDeviceRemovedAlerts(device string) {
+	instanceIDs, err := launcher.resourceManager.GetDeviceInstances(device)
+	if err != nil {
+		log.WithField("device", device).Errorf("Can't get device instances: %v", err)
+
+		return
+	}
+
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	for _, instanceID := range instanceIDs {
+		if instance, ok := launcher.currentInstances[instanceID]; ok {
+			launcher.alertSender.SendAlert(deviceAllocateAlert(instance, device, errDeviceRemoved))
+		}
+	}
+}
+
+func resourceValidateAlert(instance *runtimeInstanceInfo, resource string, err error) cloudprotocol.AlertItem {
+	return cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagResourceValidate,
+		Payload: cloudprotocol.ResourceValidateAlert{
+			ResourcesErrors: []cloudprotocol.ResourceValidateError{{
+				Name:   resource,
+				Errors: []string{fmt.Sprintf("requested by service %s: %v", instance.ServiceID, err)},
+			}},
+		},
+	}
+}
+
 func deviceAllocateAlert(instance *runtimeInstanceInfo, device string, err error) cloudprotocol.AlertItem {
 	return cloudprotocol.AlertItem{
 		Timestamp: time.Now(),
@@ -28,6 +28,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -42,6 +43,7 @@ import (
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 
 	"github.com/aoscloud/aos_servicemanager/config"
 	"github.com/aoscloud/aos_servicemanager/launcher"
@@ -50,6 +52,9 @@ import (
 	"github.com/aoscloud/aos_servicemanager/resourcemanager"
 	"github.com/aoscloud/aos_servicemanager/runner"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
+	"github.com/aoscloud/aos_servicemanager/spacepolicy"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
+	"github.com/aoscloud/aos_servicemanager/utils/idmap"
 )
 
 /***********************************************************************************************************************
@@ -80,6 +85,7 @@ type testStorage struct {
 	instances  map[string]launcher.InstanceInfo
 	envVars    []cloudprotocol.EnvVarsInstanceInfo
 	onlineTime time.Time
+	stopped    []launcher.StoppedItem
 }
 
 type testServiceProvider struct {
@@ -88,7 +94,9 @@ type testServiceProvider struct {
 }
 
 type testLayerProvider struct {
-	layers map[string]layermanager.LayerInfo
+	sync.Mutex
+	layers      map[string]layermanager.LayerInfo
+	quarantined map[string]bool
 }
 
 type testRunner struct {
@@ -102,7 +110,11 @@ type testResourceManager struct {
 	sync.RWMutex
 	allocatedDevices map[string][]string
 	devices          map[string]aostypes.DeviceInfo
-	resources        map[string]aostypes.ResourceInfo
+	resources        map[string]resourcemanager.ResourceInfo
+	hostFSInfo       resourcemanager.HostFSInfo
+	securityProfiles resourcemanager.SecurityProfilesInfo
+	resourceLimits   resourcemanager.ResourceLimitsInfo
+	deviceEvents     chan resourcemanager.DeviceEvent
 }
 
 type testNetworkManager struct {
@@ -123,14 +135,16 @@ type testInstanceMonitor struct {
 type testMounter struct {
 	sync.Mutex
 	mounts map[string]mountInfo
+	images map[string]fsimage.Info
 }
 
 type serviceInfo struct {
 	aostypes.ServiceInfo
 	gid           uint32
 	imageConfig   *imagespec.Image
-	serviceConfig *aostypes.ServiceConfig
+	serviceConfig *launcher.ServiceConfig
 	layerDigests  []string
+	fsImage       fsimage.Info
 }
 
 type mountInfo struct {
@@ -159,7 +173,11 @@ type testDevice struct {
 }
 
 type testAlertSender struct {
-	alerts []cloudprotocol.DeviceAllocateAlert
+	sync.Mutex
+	alerts         []cloudprotocol.DeviceAllocateAlert
+	instanceAlerts []cloudprotocol.ServiceInstanceAlert
+	resourceAlerts []cloudprotocol.ResourceValidateAlert
+	quotaAlerts    []cloudprotocol.InstanceQuotaAlert
 }
 
 /***********************************************************************************************************************
@@ -607,7 +625,7 @@ func TestRuntimeSpec(t *testing.T) {
 						Env:        []string{"env1=val1", "env2=val2", "env3=val3"},
 					},
 				},
-				serviceConfig: &aostypes.ServiceConfig{
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
 					Hostname: newString("testHostName"),
 					Sysctl:   map[string]string{"key1": "val1", "key2": "val2", "key3": "val3"},
 					Quotas: aostypes.ServiceQuotas{
@@ -624,7 +642,7 @@ func TestRuntimeSpec(t *testing.T) {
 					},
 					Resources:   []string{"resource1", "resource2", "resource3"},
 					Permissions: map[string]map[string]string{"perm1": {"key1": "val1"}},
-				},
+				}},
 			},
 		},
 		instances: []aostypes.InstanceInfo{
@@ -731,15 +749,15 @@ func TestRuntimeSpec(t *testing.T) {
 
 	envVars := []string{"var0=0", "var1=1", "var2=2", "var3=3", "var4=4", "var5=5", "var6=6", "var7=7"}
 
-	resourceManager.addResource(aostypes.ResourceInfo{
+	resourceManager.addResource(resourcemanager.ResourceInfo{ResourceInfo: aostypes.ResourceInfo{
 		Name: "resource1", Mounts: hostMounts[:2], Env: envVars[:2], Groups: hostGroups[6:7],
-	})
-	resourceManager.addResource(aostypes.ResourceInfo{
+	}})
+	resourceManager.addResource(resourcemanager.ResourceInfo{ResourceInfo: aostypes.ResourceInfo{
 		Name: "resource2", Mounts: hostMounts[2:4], Env: envVars[2:5], Groups: hostGroups[7:8],
-	})
-	resourceManager.addResource(aostypes.ResourceInfo{
+	}})
+	resourceManager.addResource(resourcemanager.ResourceInfo{ResourceInfo: aostypes.ResourceInfo{
 		Name: "resource3", Mounts: hostMounts[4:], Env: envVars[5:], Groups: hostGroups[8:],
-	})
+	}})
 
 	if err = serviceProvider.installServices(runItem.services); err != nil {
 		t.Fatalf("Can't install services: %v", err)
@@ -999,9 +1017,15 @@ func TestRuntimeEnvironment(t *testing.T) {
 		{IP: "10.0.0.3", Hostname: "host3"},
 	}
 
-	resourceManager.addResource(aostypes.ResourceInfo{Name: "resource0", Hosts: resourceHosts[:1]})
-	resourceManager.addResource(aostypes.ResourceInfo{Name: "resource1", Hosts: resourceHosts[1:2]})
-	resourceManager.addResource(aostypes.ResourceInfo{Name: "resource2", Hosts: resourceHosts[2:]})
+	resourceManager.addResource(resourcemanager.ResourceInfo{
+		ResourceInfo: aostypes.ResourceInfo{Name: "resource0", Hosts: resourceHosts[:1]},
+	})
+	resourceManager.addResource(resourcemanager.ResourceInfo{
+		ResourceInfo: aostypes.ResourceInfo{Name: "resource1", Hosts: resourceHosts[1:2]},
+	})
+	resourceManager.addResource(resourcemanager.ResourceInfo{
+		ResourceInfo: aostypes.ResourceInfo{Name: "resource2", Hosts: resourceHosts[2:]},
+	})
 	resourceManager.addDevice(aostypes.DeviceInfo{Name: "device0"})
 	resourceManager.addDevice(aostypes.DeviceInfo{Name: "device1"})
 	resourceManager.addDevice(aostypes.DeviceInfo{Name: "device2"})
@@ -1018,46 +1042,52 @@ func TestRuntimeEnvironment(t *testing.T) {
 						ExposedPorts: map[string]struct{}{"port0": {}, "port1": {}, "port2": {}},
 					},
 				},
-				serviceConfig: &aostypes.ServiceConfig{
-					Hostname:           newString("host1"),
-					Permissions:        map[string]map[string]string{"perm1": {"key1": "val1"}},
-					AllowedConnections: map[string]struct{}{"connection0": {}, "connection1": {}, "connection2": {}},
-					Quotas: aostypes.ServiceQuotas{
-						DownloadSpeed: newUint64(4096),
-						UploadSpeed:   newUint64(8192),
-						DownloadLimit: newUint64(16384),
-						UploadLimit:   newUint64(32768),
-						StorageLimit:  newUint64(2048),
-						StateLimit:    newUint64(1024),
-					},
-					Resources: []string{"resource0", "resource1", "resource2"},
-					Devices:   []aostypes.ServiceDevice{{Name: "device0"}, {Name: "device1"}, {Name: "device2"}},
-					AlertRules: &aostypes.AlertRules{
-						RAM: &aostypes.AlertRuleParam{
-							MinTimeout:   aostypes.Duration{Duration: 1 * time.Second},
-							MinThreshold: 10, MaxThreshold: 100,
+				serviceConfig: &launcher.ServiceConfig{
+					ServiceConfig: aostypes.ServiceConfig{
+						Hostname:           newString("host1"),
+						Permissions:        map[string]map[string]string{"perm1": {"key1": "val1"}},
+						AllowedConnections: map[string]struct{}{"connection0": {}, "connection1": {}, "connection2": {}},
+						Quotas: aostypes.ServiceQuotas{
+							DownloadSpeed: newUint64(4096),
+							UploadSpeed:   newUint64(8192),
+							DownloadLimit: newUint64(16384),
+							UploadLimit:   newUint64(32768),
+							StorageLimit:  newUint64(2048),
+							StateLimit:    newUint64(1024),
 						},
-						CPU: &aostypes.AlertRuleParam{
-							MinTimeout:   aostypes.Duration{Duration: 2 * time.Second},
-							MinThreshold: 20, MaxThreshold: 200,
-						},
-						UsedDisks: []aostypes.PartitionAlertRuleParam{
-							{
-								Name: "storage",
-								AlertRuleParam: aostypes.AlertRuleParam{
-									MinTimeout:   aostypes.Duration{Duration: 3 * time.Second},
-									MinThreshold: 30, MaxThreshold: 300,
+						Resources: []string{"resource0", "resource1", "resource2"},
+						Devices:   []aostypes.ServiceDevice{{Name: "device0"}, {Name: "device1"}, {Name: "device2"}},
+						AlertRules: &aostypes.AlertRules{
+							RAM: &aostypes.AlertRuleParam{
+								MinTimeout:   aostypes.Duration{Duration: 1 * time.Second},
+								MinThreshold: 10, MaxThreshold: 100,
+							},
+							CPU: &aostypes.AlertRuleParam{
+								MinTimeout:   aostypes.Duration{Duration: 2 * time.Second},
+								MinThreshold: 20, MaxThreshold: 200,
+							},
+							UsedDisks: []aostypes.PartitionAlertRuleParam{
+								{
+									Name: "storage",
+									AlertRuleParam: aostypes.AlertRuleParam{
+										MinTimeout:   aostypes.Duration{Duration: 3 * time.Second},
+										MinThreshold: 30, MaxThreshold: 300,
+									},
 								},
 							},
+							InTraffic: &aostypes.AlertRuleParam{
+								MinTimeout:   aostypes.Duration{Duration: 4 * time.Second},
+								MinThreshold: 40, MaxThreshold: 400,
+							},
+							OutTraffic: &aostypes.AlertRuleParam{
+								MinTimeout:   aostypes.Duration{Duration: 5 * time.Second},
+								MinThreshold: 50, MaxThreshold: 500,
+							},
 						},
-						InTraffic: &aostypes.AlertRuleParam{
-							MinTimeout:   aostypes.Duration{Duration: 4 * time.Second},
-							MinThreshold: 40, MaxThreshold: 400,
-						},
-						OutTraffic: &aostypes.AlertRuleParam{
-							MinTimeout:   aostypes.Duration{Duration: 5 * time.Second},
-							MinThreshold: 50, MaxThreshold: 500,
-						},
+					},
+					PortMappings: []networkmanager.PortMapping{
+						{HostPort: 8080, ContainerPort: 80},
+						{HostIP: "127.0.0.1", HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
 					},
 				},
 			},
@@ -1142,6 +1172,7 @@ func TestRuntimeEnvironment(t *testing.T) {
 		EgressKbit:         *serviceConfig.Quotas.UploadSpeed,
 		DownloadLimit:      *serviceConfig.Quotas.DownloadLimit,
 		UploadLimit:        *serviceConfig.Quotas.UploadLimit,
+		PortMappings:       serviceConfig.PortMappings,
 	}) {
 		t.Errorf("Wrong network params: %v", netParams)
 	}
@@ -1268,6 +1299,101 @@ func TestRuntimeEnvironment(t *testing.T) {
 	}
 }
 
+func TestServiceDiscoveryEnvVars(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	layerProvider := newTestLayerProvider()
+	storage := newTestStorage()
+
+	runItem := testItem{
+		services: []serviceInfo{
+			{
+				ServiceInfo: aostypes.ServiceInfo{ID: "service0"},
+				imageConfig: &imagespec.Image{
+					OS: "linux",
+					Config: imagespec.ImageConfig{
+						ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+					},
+				},
+			},
+			{
+				ServiceInfo: aostypes.ServiceInfo{ID: "service1"},
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
+					AllowedConnections: map[string]struct{}{"service0/8080/tcp": {}, "service2/80": {}},
+				}},
+			},
+		},
+		instances: []aostypes.InstanceInfo{
+			{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+				Priority:      100,
+			},
+			{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject1", Instance: 0},
+				Priority:      100,
+			},
+			{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject1", Instance: 0},
+				Priority:      0,
+			},
+		},
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider, layerProvider,
+		newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(), newTestRegistrar(),
+		newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = serviceProvider.installServices(runItem.services); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)}},
+		defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instance, err := storage.getInstanceByIdent(runItem.instances[2].InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get instance info: %v", err)
+	}
+
+	runtimeSpec, err := getInstanceRuntimeSpec(instance.InstanceID)
+	if err != nil {
+		t.Fatalf("Can't get instance runtime spec: %v", err)
+	}
+
+	// Endpoint of the same subject should be selected, service2 is not running
+
+	for _, envVar := range []string{
+		"AOS_SERVICE_SERVICE0_HOST=0.subject1.service0",
+		"AOS_SERVICE_SERVICE0_IP=172.17.0.1",
+		"AOS_SERVICE_SERVICE0_PORTS=8080/tcp",
+	} {
+		if !slices.Contains(runtimeSpec.Process.Env, envVar) {
+			t.Errorf("Env var %s not found: %v", envVar, runtimeSpec.Process.Env)
+		}
+	}
+
+	for _, envVar := range runtimeSpec.Process.Env {
+		if strings.HasPrefix(envVar, "AOS_SERVICE_SERVICE2_") {
+			t.Errorf("Unexpected env var: %s", envVar)
+		}
+	}
+}
+
 func TestOverrideEnvVars(t *testing.T) {
 	defaultTTLPeriod := launcher.CheckTTLsPeriod
 
@@ -1702,6 +1828,98 @@ func TestRestartStoredInstancesOnStart(t *testing.T) {
 	}
 }
 
+func TestAdoptRunningInstances(t *testing.T) {
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+
+	runItem := testItem{
+		services: []serviceInfo{{ServiceInfo: aostypes.ServiceInfo{ID: "service0"}}},
+		instances: []aostypes.InstanceInfo{
+			{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+			{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 1}},
+		},
+	}
+
+	storage.fromTestItem(runItem)
+
+	if err := serviceProvider.installServices(runItem.services); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	expectedStatus := launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), expectedStatus,
+		defaultStatusTimeout); err != nil {
+		t.Fatalf("Check runtime status error: %v", err)
+	}
+
+	// Keep runtime dir to emulate launcher crash: instances are not stopped
+	runtimeBackup := launcher.RuntimeDir + ".backup"
+
+	if err = os.Rename(launcher.RuntimeDir, runtimeBackup); err != nil {
+		t.Fatalf("Can't backup runtime dir: %v", err)
+	}
+
+	testLauncher.Close()
+
+	if err = os.Rename(runtimeBackup, launcher.RuntimeDir); err != nil {
+		t.Fatalf("Can't restore runtime dir: %v", err)
+	}
+
+	instances, err := storage.GetAllInstances()
+	if err != nil {
+		t.Fatalf("Can't get instances: %v", err)
+	}
+
+	runningInstanceID := instances[0].InstanceID
+	runcStateFunc := launcher.RuncStateFunc
+
+	defer func() {
+		launcher.RuncStateFunc = runcStateFunc
+	}()
+
+	launcher.RuncStateFunc = func(containerID string) (string, error) {
+		if containerID == runningInstanceID {
+			return "running", nil
+		}
+
+		return "stopped", nil
+	}
+
+	var stoppedInstances []string
+
+	testLauncher, err = launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunner(nil, func(instanceID string) error {
+			stoppedInstances = append(stoppedInstances, instanceID)
+
+			return nil
+		}), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), expectedStatus,
+		defaultStatusTimeout); err != nil {
+		t.Fatalf("Check runtime status error: %v", err)
+	}
+
+	// Only not running instance should be restarted
+	if !reflect.DeepEqual(stoppedInstances, []string{instances[1].InstanceID}) {
+		t.Errorf("Wrong stopped instances: %v", stoppedInstances)
+	}
+}
+
 func TestInstancePriorities(t *testing.T) {
 	const (
 		service = "service0"
@@ -2193,7 +2411,9 @@ func TestResourceAlerts(t *testing.T) {
 		alerts []cloudprotocol.DeviceAllocateAlert
 	}
 
-	serviceConfig := &aostypes.ServiceConfig{Devices: []aostypes.ServiceDevice{{Name: "device0", Permissions: "rw"}}}
+	serviceConfig := &launcher.ServiceConfig{
+		ServiceConfig: aostypes.ServiceConfig{Devices: []aostypes.ServiceDevice{{Name: "device0", Permissions: "rw"}}},
+	}
 
 	data := []testAlertItem{
 		// Try to allocate device3 (shared count 1) by 3 instances. Instance with index 0 should allocate the device as
@@ -2365,18 +2585,18 @@ func TestOfflineTimeout(t *testing.T) {
 			},
 			{
 				ServiceInfo: aostypes.ServiceInfo{ID: "service1"},
-				serviceConfig: &aostypes.ServiceConfig{
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
 					OfflineTTL: aostypes.Duration{Duration: 5 * time.Second},
-				},
+				}},
 			},
 			{
 				ServiceInfo: aostypes.ServiceInfo{ID: "service2"},
 			},
 			{
 				ServiceInfo: aostypes.ServiceInfo{ID: "service3"},
-				serviceConfig: &aostypes.ServiceConfig{
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
 					OfflineTTL: aostypes.Duration{Duration: 10 * time.Second},
-				},
+				}},
 			},
 		},
 		instances: []aostypes.InstanceInfo{
@@ -2496,76 +2716,1694 @@ func TestOfflineTimeout(t *testing.T) {
 	}
 }
 
-/***********************************************************************************************************************
- * testStorage
- **********************************************************************************************************************/
+func TestMissingLayer(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	layerProvider := newTestLayerProvider()
+	alertSender := newTestAlertSender()
 
-func newTestStorage() *testStorage {
-	return &testStorage{
-		instances: make(map[string]launcher.InstanceInfo),
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, newTestStorage(), serviceProvider,
+		layerProvider, newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), alertSender)
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
 	}
-}
+	defer testLauncher.Close()
 
-func (storage *testStorage) AddInstance(instance launcher.InstanceInfo) error {
-	storage.Lock()
-	defer storage.Unlock()
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
 
-	if _, ok := storage.instances[instance.InstanceID]; ok {
-		return aoserrors.New("instance exists")
+	item := testItem{
+		services: []serviceInfo{
+			{ServiceInfo: aostypes.ServiceInfo{ID: "service0"}, layerDigests: []string{"layer0"}},
+			{ServiceInfo: aostypes.ServiceInfo{ID: "service1"}, layerDigests: []string{"layer1"}},
+		},
+		layers: []aostypes.LayerInfo{{Digest: "layer1"}},
+		instances: []aostypes.InstanceInfo{
+			{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+			{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject0", Instance: 0}},
+		},
+		err: []error{errors.New("missing layer layer0")}, //nolint:goerr113
 	}
 
-	storage.instances[instance.InstanceID] = instance
+	if err = serviceProvider.installServices(item.services); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
 
-	return nil
-}
+	if err = layerProvider.installLayers(item.layers); err != nil {
+		t.Fatalf("Can't install layers: %v", err)
+	}
 
-func (storage *testStorage) UpdateInstance(instance launcher.InstanceInfo) error {
-	storage.Lock()
-	defer storage.Unlock()
+	if err = testLauncher.RunInstances(item.instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
 
-	if _, ok := storage.instances[instance.InstanceID]; !ok {
-		return launcher.ErrNotExist
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(item)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
 	}
 
-	storage.instances[instance.InstanceID] = instance
+	// Missing layer is reported to request its fetch
+	if len(alertSender.instanceAlerts) != 1 ||
+		alertSender.instanceAlerts[0].InstanceIdent != item.instances[0].InstanceIdent ||
+		!strings.HasPrefix(alertSender.instanceAlerts[0].Message, "missing layer layer0") {
+		t.Errorf("Wrong instance alerts: %v", alertSender.instanceAlerts)
+	}
 
-	return nil
-}
+	// Damaged layer is quarantined to be installed again
+	if err = os.RemoveAll(filepath.Join(tmpDir, layersDir, "layer1")); err != nil {
+		t.Fatalf("Can't remove layer: %v", err)
+	}
 
-func (storage *testStorage) RemoveInstance(instanceID string) error {
-	storage.Lock()
-	defer storage.Unlock()
+	item.err = []error{
+		errors.New("missing layer layer0"), errors.New("missing layer layer1"), //nolint:goerr113
+	}
 
-	if _, ok := storage.instances[instanceID]; !ok {
-		return launcher.ErrNotExist
+	if err = testLauncher.RunInstances(item.instances, true); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
 	}
 
-	delete(storage.instances, instanceID)
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(item)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
 
-	return nil
-}
+	if !layerProvider.isQuarantined("layer1") {
+		t.Error("Damaged layer should be quarantined")
+	}
 
-func (storage *testStorage) GetAllInstances() (instances []launcher.InstanceInfo, err error) {
-	storage.RLock()
-	defer storage.RUnlock()
+	// Failed instances are restarted when layers are installed again
+	item.layers = []aostypes.LayerInfo{{Digest: "layer0"}, {Digest: "layer1"}}
+	item.err = nil
 
-	for _, instance := range storage.instances {
-		instances = append(instances, instance)
+	if err = layerProvider.installLayers(item.layers); err != nil {
+		t.Fatalf("Can't install layers: %v", err)
 	}
 
-	return instances, nil
-}
-
-func (storage *testStorage) GetOverrideEnvVars() ([]cloudprotocol.EnvVarsInstanceInfo, error) {
-	storage.RLock()
-	defer storage.RUnlock()
+	if err = testLauncher.RunInstances(item.instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
 
-	return storage.envVars, nil
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(item)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
 }
 
-func (storage *testStorage) SetOverrideEnvVars(envVarsInfo []cloudprotocol.EnvVarsInstanceInfo) error {
-	storage.Lock()
-	defer storage.Unlock()
+func TestStoppedItemPriorities(t *testing.T) {
+	storage := newTestStorage()
+	serviceProvider := newTestServiceProvider()
+	layerProvider := newTestLayerProvider()
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		layerProvider, newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	item := testItem{
+		services: []serviceInfo{
+			{ServiceInfo: aostypes.ServiceInfo{ID: "service0"}, layerDigests: []string{"layer0", "layer1"}},
+		},
+		layers: []aostypes.LayerInfo{{Digest: "layer0"}, {Digest: "layer1"}},
+		instances: []aostypes.InstanceInfo{
+			{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+				Priority:      10,
+			},
+			{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 1},
+				Priority:      20,
+			},
+		},
+	}
+
+	if err = serviceProvider.installServices(item.services); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	if err = layerProvider.installLayers(item.layers); err != nil {
+		t.Fatalf("Can't install layers: %v", err)
+	}
+
+	if err = testLauncher.RunInstances(item.instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(item)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	testLauncher.Close()
+
+	// Stopped item priorities are restored on start and removed layers are dropped
+
+	if err = layerProvider.installLayers([]aostypes.LayerInfo{{Digest: "layer0"}}); err != nil {
+		t.Fatalf("Can't install layers: %v", err)
+	}
+
+	if testLauncher, err = launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		layerProvider, newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	for _, priorityItem := range []struct {
+		item     spacepolicy.Item
+		priority uint64
+	}{
+		{spacepolicy.Item{Type: spacepolicy.ItemTypeService, ID: "service0"}, 20},
+		{spacepolicy.Item{Type: spacepolicy.ItemTypeLayer, ID: "layer0"}, 20},
+		{spacepolicy.Item{Type: spacepolicy.ItemTypeLayer, ID: "layer1"}, 0},
+		{spacepolicy.Item{Type: spacepolicy.ItemTypeService, ID: "service1"}, 0},
+	} {
+		if priority := testLauncher.GetItemPriority(priorityItem.item); priority != priorityItem.priority {
+			t.Errorf("Wrong %s %s priority: %d", priorityItem.item.Type, priorityItem.item.ID, priority)
+		}
+	}
+
+	storedItems, err := storage.GetStoppedItems()
+	if err != nil {
+		t.Fatalf("Can't get stopped items: %v", err)
+	}
+
+	for _, storedItem := range storedItems {
+		if storedItem.ID == "layer1" {
+			t.Error("Removed layer should not be stored")
+		}
+	}
+}
+
+func TestPackedImages(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	layerProvider := newTestLayerProvider()
+	storage := newTestStorage()
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		layerProvider, newTestRunner(nil, nil), newTestResourceManager(), newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	serviceImage := fsimage.Info{Format: fsimage.FormatSquashfs, Verity: fsimage.VerityDM, RootHash: "1111"}
+	layerImage := fsimage.Info{Format: fsimage.FormatErofs, Verity: fsimage.VerityFS, RootHash: "sha256:2222"}
+
+	item := testItem{
+		services: []serviceInfo{
+			{
+				ServiceInfo: aostypes.ServiceInfo{ID: "service0"}, layerDigests: []string{"layer0", "layer1"},
+				fsImage: serviceImage,
+			},
+		},
+		layers: []aostypes.LayerInfo{{Digest: "layer0"}, {Digest: "layer1"}},
+		instances: []aostypes.InstanceInfo{
+			{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+		},
+	}
+
+	if err = serviceProvider.installServices(item.services); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	if err = layerProvider.installLayers(item.layers); err != nil {
+		t.Fatalf("Can't install layers: %v", err)
+	}
+
+	// Only layer1 is packed
+	packedLayer := layerProvider.layers["layer1"]
+	packedLayer.FSImage = layerImage
+	layerProvider.layers["layer1"] = packedLayer
+
+	if err = testLauncher.RunInstances(item.instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(item)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	instance, err := storage.getInstanceByIdent(item.instances[0].InstanceIdent)
+	if err != nil {
+		t.Fatalf("Can't get instance: %v", err)
+	}
+
+	instanceID := instance.InstanceID
+
+	imagesDir := filepath.Join(launcher.RuntimeDir, instanceID, "images")
+
+	if !reflect.DeepEqual(mounter.images, map[string]fsimage.Info{
+		filepath.Join(imagesDir, "0"): serviceImage,
+		filepath.Join(imagesDir, "2"): layerImage,
+	}) {
+		t.Errorf("Wrong mounted images: %v", mounter.images)
+	}
+
+	mountInfo := mounter.mounts[filepath.Join(launcher.RuntimeDir, instanceID, instanceRootFS)]
+
+	expectedLowerDirs := []string{
+		filepath.Join(launcher.RuntimeDir, instanceID, "mounts"), filepath.Join(imagesDir, "0"),
+		layerProvider.layers["layer0"].Path, filepath.Join(imagesDir, "2"),
+		filepath.Join(tmpDir, "hostfs", "whiteouts"), "/",
+	}
+
+	if !reflect.DeepEqual(mountInfo.lowerDirs, expectedLowerDirs) {
+		t.Errorf("Wrong lower dirs value: %v", mountInfo.lowerDirs)
+	}
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't stop instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	if len(mounter.images) != 0 {
+		t.Errorf("Images should be unmounted: %v", mounter.images)
+	}
+}
+
+func TestWritableLayer(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	layerProvider := newTestLayerProvider()
+	storage := newTestStorage()
+
+	var createdLayers int
+
+	createLayerImage, mountLayerImage := launcher.CreateLayerImageFunc, launcher.MountLayerImageFunc
+
+	launcher.CreateLayerImageFunc = func(imagePath string, size uint64) error {
+		createdLayers++
+
+		if err := ioutil.WriteFile(imagePath, []byte(strconv.Itoa(createdLayers)), 0o600); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if size != 1024 {
+			return aoserrors.Errorf("wrong layer size: %d", size)
+		}
+
+		return nil
+	}
+	launcher.MountLayerImageFunc = func(imagePath, mountPoint string) error {
+		return aoserrors.Wrap(os.MkdirAll(mountPoint, 0o755))
+	}
+
+	defer func() {
+		launcher.CreateLayerImageFunc = createLayerImage
+		launcher.MountLayerImageFunc = mountLayerImage
+	}()
+
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir,
+		StorageDir: filepath.Join(tmpDir, storagesDir),
+		StateDir:   filepath.Join(tmpDir, statesDir),
+	}, storage, serviceProvider, layerProvider, newTestRunner(nil, nil), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	serviceConfig := &launcher.ServiceConfig{
+		WritableLayer: &launcher.WritableLayer{Size: 1024, ResetOnUpdate: true},
+	}
+	layerImage := filepath.Join(tmpDir, "writablelayers", "writable", "layer.img")
+	oldLayerDir := filepath.Join(tmpDir, storagesDir, "writable", ".writablelayer")
+
+	// Layer created inside the storage by previous versions is moved out of it
+	if err = os.MkdirAll(oldLayerDir, 0o700); err != nil {
+		t.Fatalf("Can't create layer dir: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(oldLayerDir, "layer.img"), []byte("0"), 0o600); err != nil {
+		t.Fatalf("Can't create layer image: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(oldLayerDir, "version"), []byte("0"), 0o600); err != nil {
+		t.Fatalf("Can't create layer version: %v", err)
+	}
+
+	type testData struct {
+		aosVersion    uint64
+		expectedLayer string
+	}
+
+	data := []testData{
+		{aosVersion: 0, expectedLayer: "0"},
+		{aosVersion: 1, expectedLayer: "1"},
+		// Layer is kept for the same version
+		{aosVersion: 1, expectedLayer: "1"},
+		// Layer is reset on update
+		{aosVersion: 2, expectedLayer: "2"},
+	}
+
+	for i, item := range data {
+		runItem := testItem{
+			services: []serviceInfo{
+				{
+					ServiceInfo: aostypes.ServiceInfo{
+						ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: item.aosVersion},
+					},
+					serviceConfig: serviceConfig,
+				},
+			},
+			instances: []aostypes.InstanceInfo{
+				{
+					InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+					StoragePath:   "writable",
+					UID:           9483,
+				},
+			},
+		}
+
+		if err = serviceProvider.installServices(runItem.services); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
+
+		if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+			t.Fatalf("Can't run instances: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+			RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+		}, defaultStatusTimeout); err != nil {
+			t.Errorf("Case %d: check runtime status error: %v", i, err)
+		}
+
+		instance, err := storage.getInstanceByIdent(runItem.instances[0].InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get instance: %v", err)
+		}
+
+		mountInfo := mounter.mounts[filepath.Join(launcher.RuntimeDir, instance.InstanceID, instanceRootFS)]
+		layerDir := filepath.Join(launcher.RuntimeDir, instance.InstanceID, "writablelayer")
+
+		if mountInfo.upperDir != filepath.Join(layerDir, "upper") ||
+			mountInfo.workDir != filepath.Join(layerDir, "work") {
+			t.Errorf("Case %d: wrong upper and work dirs: %s, %s", i, mountInfo.upperDir, mountInfo.workDir)
+		}
+
+		layer, err := ioutil.ReadFile(layerImage)
+		if err != nil {
+			t.Fatalf("Can't read layer image: %v", err)
+		}
+
+		if string(layer) != item.expectedLayer {
+			t.Errorf("Case %d: wrong writable layer: %s", i, layer)
+		}
+
+		if _, err = os.Stat(oldLayerDir); !os.IsNotExist(err) {
+			t.Errorf("Case %d: writable layer should not be in storage", i)
+		}
+
+		if err = testLauncher.RunInstances(nil, false); err != nil {
+			t.Fatalf("Can't stop instances: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+			launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+			t.Errorf("Case %d: check runtime status error: %v", i, err)
+		}
+	}
+}
+
+func TestHostFS(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	storage := newTestStorage()
+	resourceManager := newTestResourceManager()
+	logDir := filepath.Join(tmpDir, "host", "log")
+	dataDir := filepath.Join(tmpDir, "host", "data")
+	secretDir := filepath.Join(tmpDir, "host", "secret")
+
+	for _, dir := range []string{filepath.Join(logDir, "journal"), logDir + "s", dataDir, secretDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("Can't create host dir: %v", err)
+		}
+	}
+
+	for link, target := range map[string]string{
+		filepath.Join(logDir, "current"): filepath.Join(logDir, "journal"),
+		filepath.Join(dataDir, "secret"): secretDir,
+	} {
+		if err := os.Symlink(target, link); err != nil && !os.IsExist(err) {
+			t.Fatalf("Can't create host symlink: %v", err)
+		}
+	}
+
+	resourceManager.hostFSInfo = resourcemanager.HostFSInfo{
+		AllowedPaths: []resourcemanager.HostPathInfo{
+			{Path: logDir},
+			{Path: dataDir, ReadWrite: true},
+		},
+	}
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunner(nil, nil), resourceManager, newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	type testData struct {
+		hostFS         launcher.HostFS
+		allowNoRootFS  bool
+		expectedMounts []runtimespec.Mount
+		err            error
+	}
+
+	data := []testData{
+		{
+			hostFS: launcher.HostFS{Paths: []launcher.HostPath{
+				{Path: filepath.Join(logDir, "journal")},
+				{Path: dataDir, ReadWrite: true},
+			}},
+			expectedMounts: []runtimespec.Mount{
+				{
+					Destination: filepath.Join(logDir, "journal"), Type: "bind",
+					Source: filepath.Join(logDir, "journal"), Options: []string{"bind", "ro"},
+				},
+				{
+					Destination: dataDir, Type: "bind", Source: dataDir, Options: []string{"bind", "rw"},
+				},
+			},
+		},
+		{
+			hostFS: launcher.HostFS{Paths: []launcher.HostPath{{Path: logDir, ReadWrite: true}}},
+			err:    fmt.Errorf("host path %s is not allowed", logDir), //nolint:goerr113
+		},
+		{
+			hostFS: launcher.HostFS{Paths: []launcher.HostPath{{Path: logDir + "s"}}},
+			err:    fmt.Errorf("host path %ss is not allowed", logDir), //nolint:goerr113
+		},
+		// Symlinks are checked and mounted by their targets
+		{
+			hostFS: launcher.HostFS{Paths: []launcher.HostPath{{Path: filepath.Join(logDir, "current")}}},
+			expectedMounts: []runtimespec.Mount{
+				{
+					Destination: filepath.Join(logDir, "current"), Type: "bind",
+					Source: filepath.Join(logDir, "journal"), Options: []string{"bind", "ro"},
+				},
+			},
+		},
+		{
+			hostFS: launcher.HostFS{Paths: []launcher.HostPath{{Path: filepath.Join(dataDir, "secret")}}},
+			err: fmt.Errorf("host path %s is not allowed", //nolint:goerr113
+				filepath.Join(dataDir, "secret")),
+		},
+		{
+			hostFS: launcher.HostFS{NoHostRootFS: true},
+			err:    errors.New("disabling host rootfs is not allowed"), //nolint:goerr113
+		},
+		{
+			hostFS:        launcher.HostFS{NoHostRootFS: true},
+			allowNoRootFS: true,
+		},
+	}
+
+	for i, item := range data {
+		resourceManager.Lock()
+		resourceManager.hostFSInfo.AllowNoHostRootFS = item.allowNoRootFS
+		resourceManager.Unlock()
+
+		hostFS := item.hostFS
+
+		runItem := testItem{
+			services: []serviceInfo{
+				{
+					ServiceInfo: aostypes.ServiceInfo{
+						ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: uint64(i)},
+					},
+					serviceConfig: &launcher.ServiceConfig{HostFS: &hostFS},
+				},
+			},
+			instances: []aostypes.InstanceInfo{
+				{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+			},
+		}
+
+		if item.err != nil {
+			runItem.err = []error{item.err}
+		}
+
+		if err = serviceProvider.installServices(runItem.services); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
+
+		if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+			t.Fatalf("Can't run instances: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+			RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+		}, defaultStatusTimeout); err != nil {
+			t.Errorf("Case %d: check runtime status error: %v", i, err)
+		}
+
+		if item.err != nil {
+			continue
+		}
+
+		instance, err := storage.getInstanceByIdent(runItem.instances[0].InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get instance: %v", err)
+		}
+
+		runtimeSpec, err := getInstanceRuntimeSpec(instance.InstanceID)
+		if err != nil {
+			t.Fatalf("Can't get runtime spec: %v", err)
+		}
+
+		for _, expectedMount := range item.expectedMounts {
+			if !slices.ContainsFunc(runtimeSpec.Mounts, func(mount runtimespec.Mount) bool {
+				return reflect.DeepEqual(mount, expectedMount)
+			}) {
+				t.Errorf("Case %d: mount %s not found", i, expectedMount.Destination)
+			}
+		}
+
+		lowerDirs := mounter.mounts[filepath.Join(launcher.RuntimeDir, instance.InstanceID, instanceRootFS)].lowerDirs
+		hasHostRootFS := len(lowerDirs) != 0 && lowerDirs[len(lowerDirs)-1] == "/"
+
+		if hasHostRootFS == item.hostFS.NoHostRootFS {
+			t.Errorf("Case %d: wrong host rootfs usage: %v", i, lowerDirs)
+		}
+	}
+}
+
+func TestResourceGrants(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	storage := newTestStorage()
+	resourceManager := newTestResourceManager()
+	alertSender := newTestAlertSender()
+
+	resourceManager.addResource(resourcemanager.ResourceInfo{
+		ResourceInfo: aostypes.ResourceInfo{Name: "realtime", Env: []string{"RT_ENABLED=1"}},
+		Sysctl:       map[string]string{"kernel.sched_rt_runtime_us": "-1"},
+		Tmpfs:        []resourcemanager.TmpfsInfo{{Destination: "/run/rt", Size: 1024}},
+		Capabilities: []string{"CAP_SYS_NICE"},
+	})
+	resourceManager.addResource(resourcemanager.ResourceInfo{
+		ResourceInfo: aostypes.ResourceInfo{Name: "debug"},
+		SeccompAllow: []string{"ptrace"},
+	})
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunner(nil, nil), resourceManager, newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), alertSender)
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	type testData struct {
+		resources     []string
+		sysctl        map[string]string
+		security      *launcher.SecurityConfig
+		err           error
+		resourceAlert bool
+	}
+
+	denyPtrace := &runtimespec.LinuxSeccomp{
+		DefaultAction: runtimespec.ActAllow,
+		Syscalls:      []runtimespec.LinuxSyscall{{Names: []string{"ptrace"}, Action: runtimespec.ActErrno}},
+	}
+
+	data := []testData{
+		{resources: []string{"realtime"}, sysctl: map[string]string{"net.core.somaxconn": "1024"}},
+		{
+			resources: []string{"realtime"},
+			sysctl:    map[string]string{"kernel.sched_rt_runtime_us": "950000"},
+			err: errors.New( //nolint:goerr113
+				"resource realtime sysctl kernel.sched_rt_runtime_us=-1 conflicts with declared value 950000"),
+		},
+		{
+			resources:     []string{"realtime", "unknown"},
+			err:           resourcemanager.ErrNoAvailableResource,
+			resourceAlert: true,
+		},
+		// Resource grants can't override restrictions declared by service
+		{
+			resources: []string{"realtime"},
+			sysctl:    map[string]string{"net.core.somaxconn": "1024"},
+			security:  &launcher.SecurityConfig{CapDrop: []string{"sys_nice"}},
+			err: errors.New( //nolint:goerr113
+				"resource realtime capability CAP_SYS_NICE conflicts with dropped capability"),
+		},
+		{
+			resources: []string{"realtime"},
+			sysctl:    map[string]string{"net.core.somaxconn": "1024"},
+			security:  &launcher.SecurityConfig{CapDrop: []string{"ALL"}},
+			err: errors.New( //nolint:goerr113
+				"resource realtime capability CAP_SYS_NICE conflicts with dropped capability"),
+		},
+		{
+			resources: []string{"debug"},
+			security:  &launcher.SecurityConfig{Seccomp: denyPtrace},
+			err: errors.New( //nolint:goerr113
+				"resource debug syscall ptrace conflicts with declared seccomp rule"),
+		},
+	}
+
+	for i, item := range data {
+		alertSender.resourceAlerts = nil
+
+		serviceConfig := &launcher.ServiceConfig{}
+
+		serviceConfig.Resources = item.resources
+		serviceConfig.Sysctl = item.sysctl
+		serviceConfig.Security = item.security
+
+		runItem := testItem{
+			services: []serviceInfo{
+				{
+					ServiceInfo: aostypes.ServiceInfo{
+						ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: uint64(i)},
+					},
+					serviceConfig: serviceConfig,
+				},
+			},
+			instances: []aostypes.InstanceInfo{
+				{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+			},
+		}
+
+		if item.err != nil {
+			runItem.err = []error{item.err}
+		}
+
+		if err = serviceProvider.installServices(runItem.services); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
+
+		if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+			t.Fatalf("Can't run instances: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+			RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+		}, defaultStatusTimeout); err != nil {
+			t.Errorf("Case %d: check runtime status error: %v", i, err)
+		}
+
+		if item.resourceAlert != (len(alertSender.resourceAlerts) == 1 &&
+			alertSender.resourceAlerts[0].ResourcesErrors[0].Name == "unknown") {
+			t.Errorf("Case %d: wrong resource alerts: %v", i, alertSender.resourceAlerts)
+		}
+
+		if item.err != nil {
+			continue
+		}
+
+		instance, err := storage.getInstanceByIdent(runItem.instances[0].InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get instance: %v", err)
+		}
+
+		runtimeSpec, err := getInstanceRuntimeSpec(instance.InstanceID)
+		if err != nil {
+			t.Fatalf("Can't get runtime spec: %v", err)
+		}
+
+		if !reflect.DeepEqual(runtimeSpec.Linux.Sysctl, map[string]string{
+			"net.core.somaxconn": "1024", "kernel.sched_rt_runtime_us": "-1",
+		}) {
+			t.Errorf("Case %d: wrong sysctl: %v", i, runtimeSpec.Linux.Sysctl)
+		}
+
+		if !slices.Contains(runtimeSpec.Process.Env, "RT_ENABLED=1") {
+			t.Errorf("Case %d: resource env not found", i)
+		}
+
+		if !slices.ContainsFunc(runtimeSpec.Mounts, func(mount runtimespec.Mount) bool {
+			return mount.Destination == "/run/rt" && mount.Type == "tmpfs" && slices.Contains(mount.Options, "size=1024")
+		}) {
+			t.Errorf("Case %d: resource tmpfs not found", i)
+		}
+
+		if !slices.Contains(runtimeSpec.Process.Capabilities.Ambient, "CAP_SYS_NICE") ||
+			!slices.Contains(runtimeSpec.Process.Capabilities.Bounding, "CAP_SYS_NICE") {
+			t.Errorf("Case %d: resource capability not found: %v", i, runtimeSpec.Process.Capabilities)
+		}
+	}
+}
+
+func TestSecurityProfiles(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	storage := newTestStorage()
+	resourceManager := newTestResourceManager()
+
+	minimalProfile := runtimespec.LinuxSeccomp{
+		DefaultAction: runtimespec.ActErrno,
+		Syscalls: []runtimespec.LinuxSyscall{
+			{Names: []string{"read", "write", "exit_group"}, Action: runtimespec.ActAllow},
+		},
+	}
+
+	resourceManager.securityProfiles = resourcemanager.SecurityProfilesInfo{
+		Seccomp:      map[string]runtimespec.LinuxSeccomp{"minimal": minimalProfile},
+		AppArmor:     []string{"aos-service"},
+		SELinux:      []string{"system_u:system_r:aos_service_t:s0"},
+		Capabilities: []string{"CAP_SYS_TIME"},
+	}
+
+	resourceManager.addResource(resourcemanager.ResourceInfo{
+		ResourceInfo: aostypes.ResourceInfo{Name: "realtime"},
+		SeccompAllow: []string{"sched_setattr"},
+	})
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunner(nil, nil), resourceManager, newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	type testData struct {
+		security     launcher.SecurityConfig
+		resources    []string
+		capabilities []string
+		seccomp      *runtimespec.LinuxSeccomp
+		err          error
+	}
+
+	data := []testData{
+		{
+			security: launcher.SecurityConfig{
+				CapAdd: []string{"sys_time"}, CapDrop: []string{"CAP_KILL"},
+				SeccompProfile: "minimal", AppArmorProfile: "aos-service",
+				SELinuxLabel: "system_u:system_r:aos_service_t:s0",
+			},
+			resources:    []string{"realtime"},
+			capabilities: []string{"CAP_AUDIT_WRITE", "CAP_NET_BIND_SERVICE", "CAP_SYS_TIME"},
+			seccomp: &runtimespec.LinuxSeccomp{
+				DefaultAction: runtimespec.ActErrno,
+				Syscalls: append(minimalProfile.Syscalls, runtimespec.LinuxSyscall{
+					Names: []string{"sched_setattr"}, Action: runtimespec.ActAllow,
+				}),
+			},
+		},
+		{
+			security: launcher.SecurityConfig{CapDrop: []string{"ALL"}, Seccomp: &minimalProfile},
+			seccomp:  &minimalProfile,
+		},
+		{
+			security: launcher.SecurityConfig{CapAdd: []string{"CAP_UNKNOWN"}},
+			err:      errors.New("unknown capability CAP_UNKNOWN"), //nolint:goerr113
+		},
+		{
+			security: launcher.SecurityConfig{CapAdd: []string{"sys_admin"}},
+			err:      errors.New("capability CAP_SYS_ADMIN is not allowed"), //nolint:goerr113
+		},
+		{
+			security: launcher.SecurityConfig{SeccompProfile: "unknown"},
+			err:      errors.New("unknown seccomp profile unknown"), //nolint:goerr113
+		},
+		{
+			security: launcher.SecurityConfig{SeccompProfile: "minimal", Seccomp: &minimalProfile},
+			err:      errors.New("seccomp profile should be either embedded or referenced"), //nolint:goerr113
+		},
+		{
+			security: launcher.SecurityConfig{AppArmorProfile: "unconfined"},
+			err:      errors.New("unknown AppArmor profile unconfined"), //nolint:goerr113
+		},
+		{
+			security: launcher.SecurityConfig{SELinuxLabel: "unconfined_t"},
+			err:      errors.New("unknown SELinux label unconfined_t"), //nolint:goerr113
+		},
+	}
+
+	for i, item := range data {
+		security := item.security
+		serviceConfig := &launcher.ServiceConfig{Security: &security}
+
+		serviceConfig.Resources = item.resources
+
+		runItem := testItem{
+			services: []serviceInfo{
+				{
+					ServiceInfo: aostypes.ServiceInfo{
+						ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: uint64(i)},
+					},
+					serviceConfig: serviceConfig,
+				},
+			},
+			instances: []aostypes.InstanceInfo{
+				{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+			},
+		}
+
+		if item.err != nil {
+			runItem.err = []error{item.err}
+		}
+
+		if err = serviceProvider.installServices(runItem.services); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
+
+		if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+			t.Fatalf("Can't run instances: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+			RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+		}, defaultStatusTimeout); err != nil {
+			t.Errorf("Case %d: check runtime status error: %v", i, err)
+		}
+
+		if item.err != nil {
+			continue
+		}
+
+		instance, err := storage.getInstanceByIdent(runItem.instances[0].InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get instance: %v", err)
+		}
+
+		runtimeSpec, err := getInstanceRuntimeSpec(instance.InstanceID)
+		if err != nil {
+			t.Fatalf("Can't get runtime spec: %v", err)
+		}
+
+		if !reflect.DeepEqual(runtimeSpec.Process.Capabilities.Bounding, item.capabilities) ||
+			!reflect.DeepEqual(runtimeSpec.Process.Capabilities.Ambient, item.capabilities) {
+			t.Errorf("Case %d: wrong capabilities: %v", i, runtimeSpec.Process.Capabilities)
+		}
+
+		if !reflect.DeepEqual(runtimeSpec.Linux.Seccomp, item.seccomp) {
+			t.Errorf("Case %d: wrong seccomp profile: %v", i, runtimeSpec.Linux.Seccomp)
+		}
+
+		if runtimeSpec.Process.ApparmorProfile != item.security.AppArmorProfile ||
+			runtimeSpec.Process.SelinuxLabel != item.security.SELinuxLabel {
+			t.Errorf("Case %d: wrong LSM profiles: %s, %s", i,
+				runtimeSpec.Process.ApparmorProfile, runtimeSpec.Process.SelinuxLabel)
+		}
+	}
+
+	if len(resourceManager.securityProfiles.Seccomp["minimal"].Syscalls) != 1 {
+		t.Error("Unit config seccomp profile should not be modified")
+	}
+}
+
+func TestUserNamespace(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	storage := newTestStorage()
+
+	var idmapMutex sync.Mutex
+
+	idmappedMounts := make(map[string]idmap.Mapping)
+
+	idmapMount := launcher.IDMapMountFunc
+
+	launcher.IDMapMountFunc = func(source, target string, uidMapping, gidMapping idmap.Mapping) error {
+		idmapMutex.Lock()
+		defer idmapMutex.Unlock()
+
+		if _, err := os.Stat(target); err != nil {
+			return aoserrors.Errorf("idmap target err: %v", err)
+		}
+
+		if uidMapping != gidMapping {
+			return aoserrors.New("UID and GID mappings mismatch")
+		}
+
+		idmappedMounts[target] = uidMapping
+
+		return nil
+	}
+
+	defer func() {
+		launcher.IDMapMountFunc = idmapMount
+	}()
+
+	if _, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir, UserNamespace: config.UserNamespace{SubIDStart: 0, SubIDCount: 65536},
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunner(nil, nil), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err == nil {
+		t.Error("Launcher should not map container root to host root")
+	}
+
+	if _, err := launcher.New(&config.Config{
+		WorkingDir: tmpDir, UserNamespace: config.UserNamespace{SubIDStart: 0xFFFF0000, SubIDCount: 2 * 65536},
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunner(nil, nil), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender()); err == nil {
+		t.Error("Launcher should reject sub IDs exceeding ID space")
+	}
+
+	testLauncher, err := launcher.New(&config.Config{
+		WorkingDir:    tmpDir,
+		StorageDir:    filepath.Join(tmpDir, storagesDir),
+		StateDir:      filepath.Join(tmpDir, statesDir),
+		UserNamespace: config.UserNamespace{SubIDStart: 100000, SubIDCount: 2 * 65536},
+	}, storage, serviceProvider, newTestLayerProvider(), newTestRunner(nil, nil), newTestResourceManager(),
+		newTestNetworkManager(), newTestRegistrar(), newTestInstanceMonitor(), newTestAlertSender())
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	serviceConfig := &launcher.ServiceConfig{UserNamespace: true}
+
+	type testData struct {
+		instances []aostypes.InstanceInfo
+		err       []error
+	}
+
+	data := []testData{
+		{
+			instances: []aostypes.InstanceInfo{
+				{
+					InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+					UID:           5000, StoragePath: "userns0", StatePath: "userns0.dat",
+				},
+				{
+					InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 1},
+					UID:           5001, StoragePath: "userns1", StatePath: "userns1.dat",
+				},
+			},
+		},
+		{
+			instances: []aostypes.InstanceInfo{
+				{
+					InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+					UID:           5000, StoragePath: "userns0", StatePath: "userns0.dat",
+				},
+				{
+					InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject1", Instance: 0},
+					UID:           70000,
+				},
+			},
+			err: []error{nil, errors.New("instance UID 70000 or GID 0 can't be mapped into user namespace")}, //nolint:goerr113
+		},
+	}
+
+	for i, item := range data {
+		runItem := testItem{
+			services: []serviceInfo{
+				{
+					ServiceInfo: aostypes.ServiceInfo{
+						ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: uint64(i)},
+					},
+					serviceConfig: serviceConfig,
+				},
+			},
+			instances: item.instances,
+			err:       item.err,
+		}
+
+		idmapMutex.Lock()
+		idmappedMounts = make(map[string]idmap.Mapping)
+		idmapMutex.Unlock()
+
+		if err = serviceProvider.installServices(runItem.services); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
+
+		if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+			t.Fatalf("Can't run instances: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+			RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+		}, defaultStatusTimeout); err != nil {
+			t.Errorf("Case %d: check runtime status error: %v", i, err)
+		}
+
+		hostIDs := make(map[uint32]struct{})
+		startedInstances := 0
+
+		for j, instanceInfo := range item.instances {
+			if j < len(item.err) && item.err[j] != nil {
+				continue
+			}
+
+			startedInstances++
+
+			instance, err := storage.getInstanceByIdent(instanceInfo.InstanceIdent)
+			if err != nil {
+				t.Fatalf("Can't get instance: %v", err)
+			}
+
+			instanceDir := filepath.Join(launcher.RuntimeDir, instance.InstanceID)
+
+			runtimeSpec, err := getInstanceRuntimeSpec(instance.InstanceID)
+			if err != nil {
+				t.Fatalf("Can't get runtime spec: %v", err)
+			}
+
+			if !slices.Contains(runtimeSpec.Linux.Namespaces, runtimespec.LinuxNamespace{
+				Type: runtimespec.UserNamespace,
+			}) {
+				t.Errorf("Case %d: user namespace not found", i)
+			}
+
+			if len(runtimeSpec.Linux.UIDMappings) != 1 || runtimeSpec.Linux.UIDMappings[0].HostID == 0 ||
+				runtimeSpec.Linux.UIDMappings[0].Size != 65536 ||
+				!reflect.DeepEqual(runtimeSpec.Linux.UIDMappings, runtimeSpec.Linux.GIDMappings) {
+				t.Fatalf("Case %d: wrong ID mappings: %v", i, runtimeSpec.Linux.UIDMappings)
+			}
+
+			hostID := runtimeSpec.Linux.UIDMappings[0].HostID
+			hostIDs[hostID] = struct{}{}
+
+			if !slices.ContainsFunc(runtimeSpec.Mounts, func(mount runtimespec.Mount) bool {
+				return mount.Destination == "/storage" &&
+					mount.Source == filepath.Join(instanceDir, "idmapped", "storage")
+			}) {
+				t.Errorf("Case %d: idmapped storage not found", i)
+			}
+
+			if !slices.Contains(runtimeSpec.Linux.MaskedPaths, "/sys/fs") ||
+				slices.Contains(runtimeSpec.Linux.MaskedPaths, "/sys/devices") ||
+				slices.Contains(runtimeSpec.Linux.MaskedPaths, "/sys/devices/system") ||
+				slices.Contains(runtimeSpec.Linux.MaskedPaths, "/sys/devices/system/cpu") {
+				t.Errorf("Case %d: wrong masked sysfs paths: %v", i, runtimeSpec.Linux.MaskedPaths)
+			}
+
+			if _, ok := mounter.mounts[filepath.Join(instanceDir, "mergedfs")]; !ok {
+				t.Errorf("Case %d: overlay should be mounted to merged dir", i)
+			}
+
+			idmapMutex.Lock()
+
+			for _, target := range []string{
+				filepath.Join(instanceDir, instanceRootFS),
+				filepath.Join(instanceDir, "idmapped", "storage"),
+				filepath.Join(instanceDir, "idmapped", "state"),
+			} {
+				if mapping, ok := idmappedMounts[target]; !ok || mapping.HostID != hostID {
+					t.Errorf("Case %d: wrong idmapped mount %s: %v", i, target, mapping)
+				}
+			}
+
+			idmapMutex.Unlock()
+		}
+
+		if len(hostIDs) != startedInstances {
+			t.Errorf("Case %d: instances should have different ID ranges: %v", i, hostIDs)
+		}
+	}
+
+	if err = testLauncher.RunInstances(nil, false); err != nil {
+		t.Fatalf("Can't stop instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	storage := newTestStorage()
+	resourceManager := newTestResourceManager()
+	alertSender := newTestAlertSender()
+
+	resourceManager.resourceLimits = resourcemanager.ResourceLimitsInfo{
+		CPUs: "1-2", IODevices: []string{"/dev/null"}, MaxSwap: 1024, MaxCPUWeight: 500, MaxIOWeight: 300,
+	}
+
+	defaultCgroupsDir, defaultLimitsPeriod := launcher.CgroupsDir, launcher.CheckLimitsPeriod
+
+	launcher.CgroupsDir = filepath.Join(tmpDir, "cgroup")
+	launcher.CheckLimitsPeriod = 100 * time.Millisecond
+
+	t.Cleanup(func() { launcher.CgroupsDir, launcher.CheckLimitsPeriod = defaultCgroupsDir, defaultLimitsPeriod })
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, storage, serviceProvider,
+		newTestLayerProvider(), newTestRunner(nil, nil), resourceManager, newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), alertSender)
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	weight, memoryHigh, ramLimit, swapLimit := uint64(200), uint64(512), uint64(1024), uint64(1024)
+	invalidValue, exceededWeight := uint64(20000), uint64(600)
+
+	type testData struct {
+		limits    launcher.ResourceLimits
+		resources runtimespec.LinuxResources
+		err       error
+	}
+
+	data := []testData{
+		{
+			limits: launcher.ResourceLimits{
+				CPUWeight: &weight, CPUs: "2", MemoryHigh: &memoryHigh, SwapLimit: &swapLimit, IOWeight: &weight,
+				IOLimits: []launcher.IOLimit{{Device: "/dev/null", ReadBPS: 1000, WriteIOPS: 10}},
+			},
+			resources: runtimespec.LinuxResources{
+				CPU: &runtimespec.LinuxCPU{Cpus: "2"},
+				BlockIO: &runtimespec.LinuxBlockIO{
+					ThrottleReadBpsDevice:   []runtimespec.LinuxThrottleDevice{newThrottleDevice(1, 3, 1000)},
+					ThrottleWriteIOPSDevice: []runtimespec.LinuxThrottleDevice{newThrottleDevice(1, 3, 10)},
+				},
+				Unified: map[string]string{
+					"cpu.weight": "200", "io.weight": "200", "memory.high": "512", "memory.swap.max": "1024",
+				},
+			},
+		},
+		{
+			limits: launcher.ResourceLimits{CPUWeight: &invalidValue},
+			err:    errors.New("weight 20000 is out of range [1, 10000]"), //nolint:goerr113
+		},
+		{
+			limits: launcher.ResourceLimits{CPUWeight: &exceededWeight, IOWeight: &weight},
+			err:    errors.New("CPU weight 600 exceeds allowed 500"), //nolint:goerr113
+		},
+		{
+			limits: launcher.ResourceLimits{CPUWeight: &weight, IOWeight: &exceededWeight},
+			err:    errors.New("IO weight 600 exceeds allowed 300"), //nolint:goerr113
+		},
+		{
+			limits: launcher.ResourceLimits{CPUs: "0-1"},
+			err:    errors.New("CPUs 0-1 are not allowed"), //nolint:goerr113
+		},
+		{
+			limits: launcher.ResourceLimits{SwapLimit: &invalidValue},
+			err:    errors.New("swap limit 20000 exceeds allowed 1024"), //nolint:goerr113
+		},
+		{
+			limits: launcher.ResourceLimits{IOLimits: []launcher.IOLimit{{Device: "/dev/zero", ReadBPS: 1}}},
+			err:    errors.New("IO limits of device /dev/zero are not allowed"), //nolint:goerr113
+		},
+		{
+			limits: launcher.ResourceLimits{MemoryHigh: &ramLimit, SwapLimit: &swapLimit},
+			resources: runtimespec.LinuxResources{
+				Unified: map[string]string{"memory.high": "1024", "memory.swap.max": "1024"},
+			},
+		},
+	}
+
+	for i, item := range data {
+		limits := item.limits
+		serviceConfig := &launcher.ServiceConfig{ResourceLimits: &limits}
+
+		serviceConfig.Quotas.RAMLimit = &ramLimit
+
+		runItem := testItem{
+			services: []serviceInfo{
+				{
+					ServiceInfo: aostypes.ServiceInfo{
+						ID: "service0", VersionInfo: aostypes.VersionInfo{AosVersion: uint64(i)},
+					},
+					serviceConfig: serviceConfig,
+				},
+			},
+			instances: []aostypes.InstanceInfo{
+				{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+			},
+		}
+
+		if item.err != nil {
+			runItem.err = []error{item.err}
+		}
+
+		if err = serviceProvider.installServices(runItem.services); err != nil {
+			t.Fatalf("Can't install services: %v", err)
+		}
+
+		if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+			t.Fatalf("Can't run instances: %v", err)
+		}
+
+		if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+			RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+		}, defaultStatusTimeout); err != nil {
+			t.Errorf("Case %d: check runtime status error: %v", i, err)
+		}
+
+		if item.err != nil {
+			continue
+		}
+
+		instance, err := storage.getInstanceByIdent(runItem.instances[0].InstanceIdent)
+		if err != nil {
+			t.Fatalf("Can't get instance: %v", err)
+		}
+
+		runtimeSpec, err := getInstanceRuntimeSpec(instance.InstanceID)
+		if err != nil {
+			t.Fatalf("Can't get runtime spec: %v", err)
+		}
+
+		resources := runtimeSpec.Linux.Resources
+
+		if !reflect.DeepEqual(resources.Unified, item.resources.Unified) {
+			t.Errorf("Case %d: wrong unified resources: %v", i, resources.Unified)
+		}
+
+		if item.resources.CPU != nil && (resources.CPU == nil || resources.CPU.Cpus != item.resources.CPU.Cpus) {
+			t.Errorf("Case %d: wrong CPUs: %v", i, resources.CPU)
+		}
+
+		if !reflect.DeepEqual(resources.BlockIO, item.resources.BlockIO) {
+			t.Errorf("Case %d: wrong block IO: %v", i, resources.BlockIO)
+		}
+	}
+
+	// Check limits usage alerts
+
+	instance, err := storage.getInstanceByIdent(
+		aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0})
+	if err != nil {
+		t.Fatalf("Can't get instance: %v", err)
+	}
+
+	cgroupDir := filepath.Join(launcher.CgroupsDir, instance.InstanceID)
+
+	if err = writeCgroupFiles(cgroupDir, map[string]string{
+		"memory.events": "low 0\nhigh 1\nmax 0\n", "memory.current": "1000", "memory.swap.current": "0",
+	}); err != nil {
+		t.Fatalf("Can't write cgroup files: %v", err)
+	}
+
+	time.Sleep(3 * launcher.CheckLimitsPeriod)
+
+	if alerts := alertSender.getQuotaAlerts(); len(alerts) != 0 {
+		t.Errorf("Unexpected quota alerts: %v", alerts)
+	}
+
+	if err = writeCgroupFiles(cgroupDir, map[string]string{
+		"memory.events": "low 0\nhigh 3\nmax 0\n", "memory.swap.current": "1000",
+	}); err != nil {
+		t.Fatalf("Can't write cgroup files: %v", err)
+	}
+
+	time.Sleep(3 * launcher.CheckLimitsPeriod)
+
+	expectedAlerts := []cloudprotocol.InstanceQuotaAlert{
+		{InstanceIdent: instance.InstanceIdent, Parameter: "memory.high", Value: 1000},
+		{InstanceIdent: instance.InstanceIdent, Parameter: "memory.swap.max", Value: 1000},
+	}
+
+	if alerts := alertSender.getQuotaAlerts(); !reflect.DeepEqual(alerts, expectedAlerts) {
+		t.Errorf("Wrong quota alerts: %v", alerts)
+	}
+
+	// Check limits usage metrics
+
+	if err = writeCgroupFiles(cgroupDir, map[string]string{
+		"cpu.weight": "200", "cpuset.cpus.effective": "1-2", "io.stat": "1:3 rbytes=100 wbytes=0 rios=1 wios=0",
+	}); err != nil {
+		t.Fatalf("Can't write cgroup files: %v", err)
+	}
+
+	time.Sleep(3 * launcher.CheckLimitsPeriod)
+
+	expectedUsage := launcher.LimitsUsage{
+		CPUWeight: 200, CPUs: 2, MemoryHighEvents: 3, Swap: 1000, IO: []launcher.DeviceIOUsage{{Device: "1:3"}},
+	}
+
+	if usage, ok := testLauncher.GetInstanceLimitsUsage(instance.InstanceIdent); !ok || !reflect.DeepEqual(
+		usage, expectedUsage) {
+		t.Errorf("Wrong limits usage: %v", usage)
+	}
+}
+
+func TestDeviceHotplug(t *testing.T) {
+	serviceProvider := newTestServiceProvider()
+	resourceManager := newTestResourceManager()
+	alertSender := newTestAlertSender()
+
+	var (
+		injectMutex sync.Mutex
+		injectErr   error
+	)
+
+	deviceChannel := make(chan string, 1)
+	injectDevice, ejectDevice := launcher.InjectDeviceFunc, launcher.EjectDeviceFunc
+
+	launcher.InjectDeviceFunc = func(
+		instanceID string, device runtimespec.LinuxDevice, rule *runtimespec.LinuxDeviceCgroup,
+	) error {
+		injectMutex.Lock()
+		defer injectMutex.Unlock()
+
+		if injectErr != nil {
+			return injectErr
+		}
+
+		if rule == nil || !rule.Allow || rule.Major == nil || *rule.Major != device.Major {
+			return aoserrors.New("wrong device rule")
+		}
+
+		deviceChannel <- "inject " + device.Path
+
+		return nil
+	}
+
+	launcher.EjectDeviceFunc = func(
+		instanceID string, device runtimespec.LinuxDevice, rule *runtimespec.LinuxDeviceCgroup,
+	) error {
+		if rule == nil || rule.Allow {
+			return aoserrors.New("wrong device rule")
+		}
+
+		deviceChannel <- "eject " + device.Path
+
+		return nil
+	}
+
+	t.Cleanup(func() { launcher.InjectDeviceFunc, launcher.EjectDeviceFunc = injectDevice, ejectDevice })
+
+	testLauncher, err := launcher.New(&config.Config{WorkingDir: tmpDir}, newTestStorage(), serviceProvider,
+		newTestLayerProvider(), newTestRunner(nil, nil), resourceManager, newTestNetworkManager(),
+		newTestRegistrar(), newTestInstanceMonitor(), alertSender)
+	if err != nil {
+		t.Fatalf("Can't create launcher: %v", err)
+	}
+	defer testLauncher.Close()
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(),
+		launcher.RuntimeStatus{RunStatus: &launcher.InstancesStatus{}}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	runItem := testItem{
+		services: []serviceInfo{
+			{
+				ServiceInfo: aostypes.ServiceInfo{ID: "service0"},
+				serviceConfig: &launcher.ServiceConfig{ServiceConfig: aostypes.ServiceConfig{
+					Devices: []aostypes.ServiceDevice{{Name: "modem", Permissions: "rw"}},
+				}},
+			},
+			{ServiceInfo: aostypes.ServiceInfo{ID: "service1"}},
+		},
+		instances: []aostypes.InstanceInfo{
+			{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}},
+			{InstanceIdent: aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject0", Instance: 0}},
+		},
+		err: []error{errors.New("device info not found")}, //nolint:goerr113
+	}
+
+	if err = serviceProvider.installServices(runItem.services); err != nil {
+		t.Fatalf("Can't install services: %v", err)
+	}
+
+	if err = testLauncher.RunInstances(runItem.instances, false); err != nil {
+		t.Fatalf("Can't run instances: %v", err)
+	}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		RunStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	// Instance requested device should be restarted when device becomes available
+
+	resourceManager.addDevice(aostypes.DeviceInfo{Name: "modem"})
+	resourceManager.deviceEvents <- resourcemanager.DeviceEvent{Name: "modem", Available: true}
+
+	runItem.instances, runItem.err = runItem.instances[:1], nil
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	// Instances which allocate removed device should be alerted
+
+	resourceManager.deviceEvents <- resourcemanager.DeviceEvent{Name: "modem", Available: false}
+
+	expectedAlerts := []cloudprotocol.DeviceAllocateAlert{
+		{InstanceIdent: runItem.instances[0].InstanceIdent, Device: "modem", Message: "device info not found"},
+		{InstanceIdent: runItem.instances[0].InstanceIdent, Device: "modem", Message: "device is removed from system"},
+	}
+
+	for timeout := time.After(defaultStatusTimeout); ; {
+		if err = compareDeviceAllocateAlerts(expectedAlerts, alertSender.getDeviceAlerts()); err == nil {
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("Compare device allocation alerts error: %v", err)
+
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// Device should be injected into running instance without restart
+
+	resourceManager.addDevice(aostypes.DeviceInfo{Name: "modem", HostDevices: []string{"/dev/null:/dev/modem"}})
+	resourceManager.deviceEvents <- resourcemanager.DeviceEvent{Name: "modem", Available: true}
+
+	if err = checkDeviceAction(deviceChannel, "inject /dev/modem"); err != nil {
+		t.Errorf("Check device action error: %v", err)
+	}
+
+	// Device should be removed from running instance
+
+	resourceManager.deviceEvents <- resourcemanager.DeviceEvent{Name: "modem", Available: false}
+
+	if err = checkDeviceAction(deviceChannel, "eject /dev/modem"); err != nil {
+		t.Errorf("Check device action error: %v", err)
+	}
+
+	// Instance should be restarted if device can't be injected
+
+	injectMutex.Lock()
+	injectErr = aoserrors.New("can't inject device")
+	injectMutex.Unlock()
+
+	resourceManager.deviceEvents <- resourcemanager.DeviceEvent{Name: "modem", Available: true}
+
+	if err = checkRuntimeStatus(testLauncher.RuntimeStatusChannel(), launcher.RuntimeStatus{
+		UpdateStatus: &launcher.InstancesStatus{Instances: createInstancesStatuses(runItem)},
+	}, defaultStatusTimeout); err != nil {
+		t.Errorf("Check runtime status error: %v", err)
+	}
+
+	select {
+	case action := <-deviceChannel:
+		t.Errorf("Unexpected device action: %s", action)
+
+	default:
+	}
+}
+
+func TestInjectDevice(t *testing.T) {
+	const instanceID = "instance0"
+
+	defaultDevicesCgroupsDir := launcher.DevicesCgroupsDir
+
+	launcher.DevicesCgroupsDir = filepath.Join(tmpDir, "devices")
+
+	t.Cleanup(func() { launcher.DevicesCgroupsDir = defaultDevicesCgroupsDir })
+
+	cgroupDir := filepath.Join(launcher.DevicesCgroupsDir, instanceID)
+
+	if err := os.MkdirAll(cgroupDir, 0o755); err != nil {
+		t.Fatalf("Can't create cgroup dir: %v", err)
+	}
+
+	for _, fileName := range []string{"devices.allow", "devices.deny"} {
+		if err := os.WriteFile(filepath.Join(cgroupDir, fileName), nil, 0o600); err != nil {
+			t.Fatalf("Can't create cgroup file: %v", err)
+		}
+	}
+
+	// Instance root is the test process root
+	if err := os.MkdirAll(filepath.Join(launcher.RuntimeDir, instanceID), 0o755); err != nil {
+		t.Fatalf("Can't create runtime dir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(launcher.RuntimeDir, instanceID, ".pid"),
+		[]byte(strconv.Itoa(os.Getpid())), 0o600); err != nil {
+		t.Fatalf("Can't write pid file: %v", err)
+	}
+
+	major, minor, fileMode, uid, gid := int64(1), int64(3), os.FileMode(0o660), uint32(0), uint32(0)
+	device := runtimespec.LinuxDevice{
+		Path: filepath.Join(tmpDir, "dev", "modem"), Type: "c", Major: major, Minor: minor,
+		FileMode: &fileMode, UID: &uid, GID: &gid,
+	}
+
+	if err := launcher.InjectDeviceFunc(instanceID, device, &runtimespec.LinuxDeviceCgroup{
+		Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "rw",
+	}); err != nil {
+		t.Fatalf("Can't inject device: %v", err)
+	}
+
+	info, err := os.Stat(device.Path)
+	if err != nil {
+		t.Fatalf("Can't stat device node: %v", err)
+	}
+
+	if info.Mode()&os.ModeCharDevice == 0 || info.Mode().Perm() != fileMode {
+		t.Errorf("Wrong device node mode: %v", info.Mode())
+	}
+
+	if rule, _ := os.ReadFile(filepath.Join(cgroupDir, "devices.allow")); string(rule) != "c 1:3 rw" {
+		t.Errorf("Wrong allow rule: %s", rule)
+	}
+
+	if err = launcher.EjectDeviceFunc(instanceID, device, &runtimespec.LinuxDeviceCgroup{
+		Allow: false, Type: "c", Major: &major, Minor: &minor, Access: "rwm",
+	}); err != nil {
+		t.Fatalf("Can't eject device: %v", err)
+	}
+
+	if _, err = os.Stat(device.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Device node should be removed: %v", err)
+	}
+
+	if rule, _ := os.ReadFile(filepath.Join(cgroupDir, "devices.deny")); string(rule) != "c 1:3 rwm" {
+		t.Errorf("Wrong deny rule: %s", rule)
+	}
+
+	// Cgroup v2 rules can't be changed
+	if err = os.RemoveAll(cgroupDir); err != nil {
+		t.Fatalf("Can't remove cgroup dir: %v", err)
+	}
+
+	if err = launcher.EjectDeviceFunc(instanceID, device, &runtimespec.LinuxDeviceCgroup{
+		Allow: false, Type: "c", Major: &major, Minor: &minor, Access: "rwm",
+	}); !errors.Is(err, launcher.ErrDeviceRulesNotSupported) {
+		t.Errorf("Unexpected eject error: %v", err)
+	}
+}
+
+/***********************************************************************************************************************
+ * testStorage
+ **********************************************************************************************************************/
+
+func newTestStorage() *testStorage {
+	return &testStorage{
+		instances: make(map[string]launcher.InstanceInfo),
+	}
+}
+
+func (storage *testStorage) AddInstance(instance launcher.InstanceInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.instances[instance.InstanceID]; ok {
+		return aoserrors.New("instance exists")
+	}
+
+	storage.instances[instance.InstanceID] = instance
+
+	return nil
+}
+
+func (storage *testStorage) UpdateInstance(instance launcher.InstanceInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.instances[instance.InstanceID]; !ok {
+		return launcher.ErrNotExist
+	}
+
+	storage.instances[instance.InstanceID] = instance
+
+	return nil
+}
+
+func (storage *testStorage) RemoveInstance(instanceID string) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if _, ok := storage.instances[instanceID]; !ok {
+		return launcher.ErrNotExist
+	}
+
+	delete(storage.instances, instanceID)
+
+	return nil
+}
+
+func (storage *testStorage) GetAllInstances() (instances []launcher.InstanceInfo, err error) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	for _, instance := range storage.instances {
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+func (storage *testStorage) GetOverrideEnvVars() ([]cloudprotocol.EnvVarsInstanceInfo, error) {
+	storage.RLock()
+	defer storage.RUnlock()
+
+	return storage.envVars, nil
+}
+
+func (storage *testStorage) SetOverrideEnvVars(envVarsInfo []cloudprotocol.EnvVarsInstanceInfo) error {
+	storage.Lock()
+	defer storage.Unlock()
 
 	storage.envVars = envVarsInfo
 
@@ -2588,6 +4426,45 @@ func (storage *testStorage) SetOnlineTime(onlineTime time.Time) error {
 	return nil
 }
 
+func (storage *testStorage) GetStoppedItems() ([]launcher.StoppedItem, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	return append([]launcher.StoppedItem{}, storage.stopped...), nil
+}
+
+func (storage *testStorage) SetStoppedItem(item launcher.StoppedItem) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	for i, stoppedItem := range storage.stopped {
+		if stoppedItem.Type == item.Type && stoppedItem.ID == item.ID {
+			storage.stopped[i] = item
+
+			return nil
+		}
+	}
+
+	storage.stopped = append(storage.stopped, item)
+
+	return nil
+}
+
+func (storage *testStorage) RemoveStoppedItem(itemType, id string) error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	for i, stoppedItem := range storage.stopped {
+		if stoppedItem.Type == itemType && stoppedItem.ID == id {
+			storage.stopped = append(storage.stopped[:i], storage.stopped[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return nil
+}
+
 func (storage *testStorage) fromTestItem(item testItem) {
 	storage.Lock()
 	defer storage.Unlock()
@@ -2657,6 +4534,7 @@ func (provider *testServiceProvider) installServices(services []serviceInfo) err
 			ServiceProvider: service.ProviderID,
 			ImagePath:       servicePath,
 			GID:             service.gid,
+			FSImage:         service.fsImage,
 		}
 
 		provider.layerDigests[service.ID] = service.layerDigests
@@ -2703,21 +4581,53 @@ func (storage *testStorage) getInstanceByIdent(instanceIdent aostypes.InstanceId
 
 func newTestLayerProvider() *testLayerProvider {
 	return &testLayerProvider{
-		layers: make(map[string]layermanager.LayerInfo),
+		layers:      make(map[string]layermanager.LayerInfo),
+		quarantined: make(map[string]bool),
 	}
 }
 
 func (provider *testLayerProvider) GetLayerInfoByDigest(digest string) (layermanager.LayerInfo, error) {
+	provider.Lock()
+	defer provider.Unlock()
+
 	layer, ok := provider.layers[digest]
 	if !ok {
 		return layermanager.LayerInfo{}, layermanager.ErrNotExist
 	}
 
+	if provider.quarantined[digest] {
+		return layermanager.LayerInfo{}, layermanager.ErrQuarantined
+	}
+
 	return layer, nil
 }
 
+func (provider *testLayerProvider) QuarantineLayer(digest string) error {
+	provider.Lock()
+	defer provider.Unlock()
+
+	if _, ok := provider.layers[digest]; !ok {
+		return layermanager.ErrNotExist
+	}
+
+	provider.quarantined[digest] = true
+
+	return nil
+}
+
+func (provider *testLayerProvider) isQuarantined(digest string) bool {
+	provider.Lock()
+	defer provider.Unlock()
+
+	return provider.quarantined[digest]
+}
+
 func (provider *testLayerProvider) installLayers(layers []aostypes.LayerInfo) error {
+	provider.Lock()
+	defer provider.Unlock()
+
 	provider.layers = make(map[string]layermanager.LayerInfo)
+	provider.quarantined = make(map[string]bool)
 
 	if err := os.RemoveAll(filepath.Join(tmpDir, layersDir)); err != nil {
 		return aoserrors.Wrap(err)
@@ -2793,7 +4703,8 @@ func newTestResourceManager() *testResourceManager {
 	return &testResourceManager{
 		allocatedDevices: map[string][]string{},
 		devices:          make(map[string]aostypes.DeviceInfo),
-		resources:        make(map[string]aostypes.ResourceInfo),
+		resources:        make(map[string]resourcemanager.ResourceInfo),
+		deviceEvents:     make(chan resourcemanager.DeviceEvent, 1),
 	}
 }
 
@@ -2809,13 +4720,13 @@ func (manager *testResourceManager) GetDeviceInfo(device string) (aostypes.Devic
 	return deviceInfo, nil
 }
 
-func (manager *testResourceManager) GetResourceInfo(resource string) (aostypes.ResourceInfo, error) {
+func (manager *testResourceManager) GetResourceInfo(resource string) (resourcemanager.ResourceInfo, error) {
 	manager.RLock()
 	defer manager.RUnlock()
 
 	resourceInfo, ok := manager.resources[resource]
 	if !ok {
-		return aostypes.ResourceInfo{}, aoserrors.New("resource info not found")
+		return resourcemanager.ResourceInfo{}, aoserrors.Wrap(resourcemanager.ErrNoAvailableResource)
 	}
 
 	return resourceInfo, nil
@@ -2896,6 +4807,31 @@ func (manager *testResourceManager) GetDeviceInstances(name string) (instanceIDs
 	return manager.allocatedDevices[name], nil
 }
 
+func (manager *testResourceManager) GetHostFSInfo() (resourcemanager.HostFSInfo, error) {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	return manager.hostFSInfo, nil
+}
+
+func (manager *testResourceManager) GetSecurityProfiles() (resourcemanager.SecurityProfilesInfo, error) {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	return manager.securityProfiles, nil
+}
+
+func (manager *testResourceManager) GetResourceLimits() (resourcemanager.ResourceLimitsInfo, error) {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	return manager.resourceLimits, nil
+}
+
+func (manager *testResourceManager) DeviceEventChannel() <-chan resourcemanager.DeviceEvent {
+	return manager.deviceEvents
+}
+
 func (manager *testResourceManager) addDevice(device aostypes.DeviceInfo) {
 	manager.Lock()
 	defer manager.Unlock()
@@ -2903,7 +4839,7 @@ func (manager *testResourceManager) addDevice(device aostypes.DeviceInfo) {
 	manager.devices[device.Name] = device
 }
 
-func (manager *testResourceManager) addResource(resource aostypes.ResourceInfo) {
+func (manager *testResourceManager) addResource(resource resourcemanager.ResourceInfo) {
 	manager.Lock()
 	defer manager.Unlock()
 
@@ -2954,6 +4890,35 @@ func (manager *testNetworkManager) RemoveInstanceFromNetwork(instanceID, network
 	return nil
 }
 
+func (manager *testNetworkManager) GetServiceEndpoints(serviceID string) (endpoints []networkmanager.ServiceEndpoint) {
+	manager.Lock()
+	defer manager.Unlock()
+
+	for instanceID, params := range manager.instances {
+		if params.ServiceID != serviceID || len(params.ExposedPorts) == 0 {
+			continue
+		}
+
+		endpoints = append(endpoints, networkmanager.ServiceEndpoint{
+			InstanceIdent: params.InstanceIdent,
+			InstanceID:    instanceID,
+			IP:            "172.17.0.1",
+			Hostname:      fmt.Sprintf("%d.%s.%s", params.Instance, params.SubjectID, params.ServiceID),
+			Ports:         params.ExposedPorts,
+		})
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].SubjectID != endpoints[j].SubjectID {
+			return endpoints[i].SubjectID < endpoints[j].SubjectID
+		}
+
+		return endpoints[i].Instance < endpoints[j].Instance
+	})
+
+	return endpoints
+}
+
 /***********************************************************************************************************************
  * testRegistrar
  **********************************************************************************************************************/
@@ -3029,7 +4994,7 @@ func (monitor *testInstanceMonitor) StopInstanceMonitor(instanceID string) error
  **********************************************************************************************************************/
 
 func newTestMounter() *testMounter {
-	return &testMounter{mounts: make(map[string]mountInfo)}
+	return &testMounter{mounts: make(map[string]mountInfo), images: make(map[string]fsimage.Info)}
 }
 
 func (mounter *testMounter) Mount(mountPoint string, lowerDirs []string, workDir, upperDir string) error {
@@ -3080,6 +5045,36 @@ func (mounter *testMounter) Unmount(mountPoint string) error {
 	return nil
 }
 
+func (mounter *testMounter) MountImage(dir, mountPoint string, info fsimage.Info) error {
+	mounter.Lock()
+	defer mounter.Unlock()
+
+	if _, ok := mounter.images[mountPoint]; ok {
+		return aoserrors.Errorf("image %s already mounted", mountPoint)
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return aoserrors.Errorf("image dir err: %v", err)
+	}
+
+	if err := os.MkdirAll(mountPoint, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	mounter.images[mountPoint] = info
+
+	return nil
+}
+
+func (mounter *testMounter) UnmountImage(mountPoint string) error {
+	mounter.Lock()
+	defer mounter.Unlock()
+
+	delete(mounter.images, mountPoint)
+
+	return nil
+}
+
 /***********************************************************************************************************************
  * testAlertSender
  **********************************************************************************************************************/
@@ -3089,11 +5084,38 @@ func newTestAlertSender() *testAlertSender {
 }
 
 func (sender *testAlertSender) SendAlert(alertItem cloudprotocol.AlertItem) {
-	if alert, ok := alertItem.Payload.(cloudprotocol.DeviceAllocateAlert); ok {
+	sender.Lock()
+	defer sender.Unlock()
+
+	switch alert := alertItem.Payload.(type) {
+	case cloudprotocol.DeviceAllocateAlert:
 		sender.alerts = append(sender.alerts, alert)
+
+	case cloudprotocol.ServiceInstanceAlert:
+		sender.instanceAlerts = append(sender.instanceAlerts, alert)
+
+	case cloudprotocol.ResourceValidateAlert:
+		sender.resourceAlerts = append(sender.resourceAlerts, alert)
+
+	case cloudprotocol.InstanceQuotaAlert:
+		sender.quotaAlerts = append(sender.quotaAlerts, alert)
 	}
 }
 
+func (sender *testAlertSender) getDeviceAlerts() []cloudprotocol.DeviceAllocateAlert {
+	sender.Lock()
+	defer sender.Unlock()
+
+	return sender.alerts
+}
+
+func (sender *testAlertSender) getQuotaAlerts() []cloudprotocol.InstanceQuotaAlert {
+	sender.Lock()
+	defer sender.Unlock()
+
+	return sender.quotaAlerts
+}
+
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
@@ -3107,6 +5129,8 @@ func setup() (err error) {
 	launcher.RuntimeDir = filepath.Join(tmpDir, "runtime")
 	launcher.MountFunc = mounter.Mount
 	launcher.UnmountFunc = mounter.Unmount
+	launcher.MountImageFunc = mounter.MountImage
+	launcher.UnmountImageFunc = mounter.UnmountImage
 
 	return nil
 }
@@ -3228,6 +5252,20 @@ func checkRuntimeStatus(statusChannel <-chan launcher.RuntimeStatus,
 	return nil
 }
 
+func checkDeviceAction(deviceChannel <-chan string, refAction string) error {
+	select {
+	case action := <-deviceChannel:
+		if action != refAction {
+			return aoserrors.Errorf("wrong device action: %s", action)
+		}
+
+	case <-time.After(defaultStatusTimeout):
+		return aoserrors.New("wait for device action timeout")
+	}
+
+	return nil
+}
+
 func checkInstancesByPriority(compInstances, refInstances []aostypes.InstanceInfo) error {
 	if len(compInstances) != len(refInstances) {
 		return aoserrors.New("wrong instances len")
@@ -3583,6 +5621,28 @@ func getInstanceRuntimeSpec(instanceID string) (runtimespec.Spec, error) {
 	return runtimeSpec, nil
 }
 
+func newThrottleDevice(major, minor int64, rate uint64) runtimespec.LinuxThrottleDevice {
+	throttleDevice := runtimespec.LinuxThrottleDevice{Rate: rate}
+
+	throttleDevice.Major, throttleDevice.Minor = major, minor
+
+	return throttleDevice
+}
+
+func writeCgroupFiles(cgroupDir string, files map[string]string) error {
+	if err := os.MkdirAll(cgroupDir, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(cgroupDir, name), []byte(content), 0o600); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
 func getAosEnvVars(instance launcher.InstanceInfo) (aosEnvVars []string) {
 	aosEnvVars = append(aosEnvVars, fmt.Sprintf("AOS_SERVICE_ID=%s", instance.ServiceID))
 	aosEnvVars = append(aosEnvVars, fmt.Sprintf("AOS_SUBJECT_ID=%s", instance.SubjectID))
@@ -3657,6 +5717,12 @@ func compareNetParams(p1, p2 networkmanager.NetworkParams) bool {
 		return false
 	}
 
+	if !compareArrays(len(p1.PortMappings), len(p2.PortMappings), func(index1, index2 int) bool {
+		return p1.PortMappings[index1] == p2.PortMappings[index2]
+	}) {
+		return false
+	}
+
 	return true
 }
 
@@ -0,0 +1,595 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sys/unix"
+
+	"github.com/aoscloud/aos_servicemanager/utils/cpuset"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	minCgroupWeight = 1
+	maxCgroupWeight = 10000
+	// Percents of limit usage which triggers alert.
+	limitsAlertThreshold = 95
+)
+
+const (
+	memoryHighParameter = "memory.high"
+	swapParameter       = "memory.swap.max"
+	ioParameter         = "io.max"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type limitsUsage struct {
+	checkTime        time.Time
+	memoryHighEvents uint64
+	swapAlerted      bool
+	ioStats          map[string]map[string]uint64
+	ioAlerted        map[string]bool
+	metricsTime      time.Time
+	metricsIOStats   map[string]map[string]uint64
+}
+
+// LimitsUsage cgroup limits usage of the instance.
+type LimitsUsage struct {
+	CPUWeight        uint64
+	CPUs             uint64
+	MemoryHighEvents uint64
+	Swap             uint64
+	IO               []DeviceIOUsage
+}
+
+// DeviceIOUsage IO rates of the instance on the block device.
+type DeviceIOUsage struct {
+	Device    string
+	ReadBPS   uint64
+	WriteBPS  uint64
+	ReadIOPS  uint64
+	WriteIOPS uint64
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// GetInstanceLimitsUsage returns cgroup limits usage of the instance collected by the last limits check.
+func (launcher *Launcher) GetInstanceLimitsUsage(instanceIdent aostypes.InstanceIdent) (LimitsUsage, bool) {
+	launcher.usageMutex.Lock()
+	defer launcher.usageMutex.Unlock()
+
+	usage, ok := launcher.instancesUsage[instanceIdent]
+
+	return usage, ok
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (spec *runtimeSpec) setResourceLimits(limits *ResourceLimits, ramLimit *uint64) error {
+	if limits == nil {
+		return nil
+	}
+
+	allowedLimits, err := spec.resourceManager.GetResourceLimits()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if limits.CPUWeight != nil {
+		if err = validateCgroupWeight("CPU", *limits.CPUWeight, allowedLimits.MaxCPUWeight); err != nil {
+			return err
+		}
+
+		spec.setUnified("cpu.weight", strconv.FormatUint(*limits.CPUWeight, 10))
+	}
+
+	if err = spec.setCPUs(limits.CPUs, allowedLimits.CPUs); err != nil {
+		return err
+	}
+
+	if limits.MemoryHigh != nil {
+		if ramLimit != nil && *limits.MemoryHigh > *ramLimit {
+			return aoserrors.Errorf("memory high %d exceeds RAM limit %d", *limits.MemoryHigh, *ramLimit)
+		}
+
+		spec.setUnified("memory.high", strconv.FormatUint(*limits.MemoryHigh, 10))
+	}
+
+	if limits.SwapLimit != nil {
+		if *limits.SwapLimit > allowedLimits.MaxSwap {
+			return aoserrors.Errorf("swap limit %d exceeds allowed %d", *limits.SwapLimit, allowedLimits.MaxSwap)
+		}
+
+		spec.setUnified("memory.swap.max", strconv.FormatUint(*limits.SwapLimit, 10))
+	}
+
+	if limits.IOWeight != nil {
+		if err = validateCgroupWeight("IO", *limits.IOWeight, allowedLimits.MaxIOWeight); err != nil {
+			return err
+		}
+
+		spec.setUnified("io.weight", strconv.FormatUint(*limits.IOWeight, 10))
+	}
+
+	for _, ioLimit := range limits.IOLimits {
+		if !slices.Contains(allowedLimits.IODevices, ioLimit.Device) {
+			return aoserrors.Errorf("IO limits of device %s are not allowed", ioLimit.Device)
+		}
+
+		if err = spec.addIOLimit(ioLimit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (spec *runtimeSpec) setUnified(key, value string) {
+	if spec.ociSpec.Linux.Resources.Unified == nil {
+		spec.ociSpec.Linux.Resources.Unified = make(map[string]string)
+	}
+
+	spec.ociSpec.Linux.Resources.Unified[key] = value
+}
+
+func (spec *runtimeSpec) setCPUs(cpus, allowedCPUs string) error {
+	if cpus == "" {
+		return nil
+	}
+
+	if allowedCPUs == "" {
+		return aoserrors.New("CPU pinning is not allowed")
+	}
+
+	isSubset, err := cpuset.IsSubset(cpus, allowedCPUs)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if !isSubset {
+		return aoserrors.Errorf("CPUs %s are not allowed", cpus)
+	}
+
+	if spec.ociSpec.Linux.Resources.CPU == nil {
+		spec.ociSpec.Linux.Resources.CPU = &runtimespec.LinuxCPU{}
+	}
+
+	spec.ociSpec.Linux.Resources.CPU.Cpus = cpus
+
+	return nil
+}
+
+func (spec *runtimeSpec) addIOLimit(ioLimit IOLimit) error {
+	major, minor, err := getDeviceNumber(ioLimit.Device)
+	if err != nil {
+		return err
+	}
+
+	if spec.ociSpec.Linux.Resources.BlockIO == nil {
+		spec.ociSpec.Linux.Resources.BlockIO = &runtimespec.LinuxBlockIO{}
+	}
+
+	blockIO := spec.ociSpec.Linux.Resources.BlockIO
+
+	for _, throttle := range []struct {
+		rate   uint64
+		device *[]runtimespec.LinuxThrottleDevice
+	}{
+		{ioLimit.ReadBPS, &blockIO.ThrottleReadBpsDevice},
+		{ioLimit.WriteBPS, &blockIO.ThrottleWriteBpsDevice},
+		{ioLimit.ReadIOPS, &blockIO.ThrottleReadIOPSDevice},
+		{ioLimit.WriteIOPS, &blockIO.ThrottleWriteIOPSDevice},
+	} {
+		if throttle.rate == 0 {
+			continue
+		}
+
+		throttleDevice := runtimespec.LinuxThrottleDevice{Rate: throttle.rate}
+		throttleDevice.Major, throttleDevice.Minor = major, minor
+
+		*throttle.device = append(*throttle.device, throttleDevice)
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) checkInstancesLimits() {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	now := time.Now()
+	instancesUsage := make(map[aostypes.InstanceIdent]LimitsUsage)
+
+	for _, instance := range launcher.currentInstances {
+		if instance.runStatus.State != cloudprotocol.InstanceStateActive || instance.service == nil {
+			continue
+		}
+
+		cgroupDir := filepath.Join(CgroupsDir, instance.InstanceID)
+
+		instancesUsage[instance.InstanceIdent] = instance.getLimitsMetrics(cgroupDir, now)
+
+		if instance.service.serviceConfig == nil || instance.service.serviceConfig.ResourceLimits == nil {
+			continue
+		}
+
+		for _, alert := range instance.checkLimitsUsage(cgroupDir, now) {
+			launcher.alertSender.SendAlert(alert)
+		}
+	}
+
+	// Usage is requested by monitoring, so it is guarded by own mutex to not wait for instances run
+	launcher.usageMutex.Lock()
+	launcher.instancesUsage = instancesUsage
+	launcher.usageMutex.Unlock()
+}
+
+// IO rates are calculated since previous check.
+func (instance *runtimeInstanceInfo) getLimitsMetrics(cgroupDir string, now time.Time) (metrics LimitsUsage) {
+	usage := &instance.limitsUsage
+
+	if weight, err := readCgroupValue(filepath.Join(cgroupDir, "cpu.weight")); err == nil {
+		metrics.CPUWeight = weight
+	}
+
+	if cpus, err := os.ReadFile(filepath.Join(cgroupDir, "cpuset.cpus.effective")); err == nil {
+		if cpuSet, err := cpuset.Parse(strings.TrimSpace(string(cpus))); err == nil {
+			metrics.CPUs = uint64(len(cpuSet))
+		}
+	}
+
+	if events, err := readCgroupKeyValues(filepath.Join(cgroupDir, "memory.events")); err == nil {
+		metrics.MemoryHighEvents = events["high"]
+	}
+
+	if swap, err := readCgroupValue(filepath.Join(cgroupDir, "memory.swap.current")); err == nil {
+		metrics.Swap = swap
+	}
+
+	if ioStats, err := readIOStat(filepath.Join(cgroupDir, "io.stat")); err == nil {
+		elapsed := now.Sub(usage.metricsTime).Seconds()
+
+		if usage.metricsIOStats != nil && elapsed > 0 {
+			devices := make([]string, 0, len(ioStats))
+
+			for device := range ioStats {
+				devices = append(devices, device)
+			}
+
+			sort.Strings(devices)
+
+			rate := func(device, key string) uint64 {
+				current, prev := ioStats[device][key], usage.metricsIOStats[device][key]
+				if current < prev {
+					return 0
+				}
+
+				return uint64(float64(current-prev) / elapsed)
+			}
+
+			for _, device := range devices {
+				metrics.IO = append(metrics.IO, DeviceIOUsage{
+					Device: device, ReadBPS: rate(device, "rbytes"), WriteBPS: rate(device, "wbytes"),
+					ReadIOPS: rate(device, "rios"), WriteIOPS: rate(device, "wios"),
+				})
+			}
+		}
+
+		usage.metricsIOStats = ioStats
+	}
+
+	usage.metricsTime = now
+
+	return metrics
+}
+
+func (instance *runtimeInstanceInfo) checkLimitsUsage(
+	cgroupDir string, now time.Time,
+) (alerts []cloudprotocol.AlertItem) {
+	limits := instance.service.serviceConfig.ResourceLimits
+	usage := &instance.limitsUsage
+
+	if limits.MemoryHigh != nil {
+		if alert, ok := instance.checkMemoryHigh(cgroupDir); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if limits.SwapLimit != nil && *limits.SwapLimit != 0 {
+		if alert, ok := instance.checkSwap(cgroupDir, *limits.SwapLimit); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if len(limits.IOLimits) != 0 {
+		alerts = append(alerts, instance.checkIO(cgroupDir, limits.IOLimits, now)...)
+	}
+
+	usage.checkTime = now
+
+	return alerts
+}
+
+// Alerts each time memory usage is throttled by memory.high since previous check.
+func (instance *runtimeInstanceInfo) checkMemoryHigh(cgroupDir string) (alert cloudprotocol.AlertItem, ok bool) {
+	usage := &instance.limitsUsage
+
+	events, err := readCgroupKeyValues(filepath.Join(cgroupDir, "memory.events"))
+	if err != nil {
+		log.WithField("instanceID", instance.InstanceID).Debugf("Can't read memory events: %v", err)
+
+		return alert, false
+	}
+
+	highEvents := events["high"]
+	prevHighEvents := usage.memoryHighEvents
+	usage.memoryHighEvents = highEvents
+
+	if usage.checkTime.IsZero() || highEvents <= prevHighEvents {
+		return alert, false
+	}
+
+	current, err := readCgroupValue(filepath.Join(cgroupDir, "memory.current"))
+	if err != nil {
+		log.WithField("instanceID", instance.InstanceID).Debugf("Can't read memory usage: %v", err)
+	}
+
+	return instance.quotaAlert(memoryHighParameter, current), true
+}
+
+// Alerts once when swap usage crosses threshold.
+func (instance *runtimeInstanceInfo) checkSwap(
+	cgroupDir string, swapLimit uint64,
+) (alert cloudprotocol.AlertItem, ok bool) {
+	usage := &instance.limitsUsage
+
+	current, err := readCgroupValue(filepath.Join(cgroupDir, "memory.swap.current"))
+	if err != nil {
+		log.WithField("instanceID", instance.InstanceID).Debugf("Can't read swap usage: %v", err)
+
+		return alert, false
+	}
+
+	reached := current*100 >= swapLimit*limitsAlertThreshold
+	alerted := usage.swapAlerted
+	usage.swapAlerted = reached
+
+	if !reached || alerted {
+		return alert, false
+	}
+
+	return instance.quotaAlert(swapParameter, current), true
+}
+
+// Alerts once when average IO rate since previous check crosses threshold.
+func (instance *runtimeInstanceInfo) checkIO(
+	cgroupDir string, ioLimits []IOLimit, now time.Time,
+) (alerts []cloudprotocol.AlertItem) {
+	usage := &instance.limitsUsage
+
+	ioStats, err := readIOStat(filepath.Join(cgroupDir, "io.stat"))
+	if err != nil {
+		log.WithField("instanceID", instance.InstanceID).Debugf("Can't read IO stat: %v", err)
+
+		return nil
+	}
+
+	prevIOStats := usage.ioStats
+	elapsed := now.Sub(usage.checkTime).Seconds()
+	usage.ioStats = ioStats
+
+	if usage.ioAlerted == nil {
+		usage.ioAlerted = make(map[string]bool)
+	}
+
+	for _, ioLimit := range ioLimits {
+		major, minor, err := getDeviceNumber(ioLimit.Device)
+		if err != nil {
+			log.WithField("instanceID", instance.InstanceID).Debugf("Can't get IO device number: %v", err)
+
+			continue
+		}
+
+		deviceKey := fmt.Sprintf("%d:%d", major, minor)
+
+		for _, item := range []struct {
+			key   string
+			limit uint64
+		}{
+			{"rbytes", ioLimit.ReadBPS},
+			{"wbytes", ioLimit.WriteBPS},
+			{"rios", ioLimit.ReadIOPS},
+			{"wios", ioLimit.WriteIOPS},
+		} {
+			if item.limit == 0 || prevIOStats == nil || elapsed <= 0 {
+				continue
+			}
+
+			current, prev := ioStats[deviceKey][item.key], prevIOStats[deviceKey][item.key]
+			if current < prev {
+				continue
+			}
+
+			rate := uint64(float64(current-prev) / elapsed)
+			parameter := fmt.Sprintf("%s:%s:%s", ioParameter, ioLimit.Device, ioLimitKey(item.key))
+
+			reached := rate*100 >= item.limit*limitsAlertThreshold
+			alerted := usage.ioAlerted[parameter]
+			usage.ioAlerted[parameter] = reached
+
+			if reached && !alerted {
+				alerts = append(alerts, instance.quotaAlert(parameter, rate))
+			}
+		}
+	}
+
+	return alerts
+}
+
+func (instance *runtimeInstanceInfo) quotaAlert(parameter string, value uint64) cloudprotocol.AlertItem {
+	return cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagInstanceQuota,
+		Payload: cloudprotocol.InstanceQuotaAlert{
+			InstanceIdent: instance.InstanceIdent,
+			Parameter:     parameter,
+			Value:         value,
+		},
+	}
+}
+
+func ioLimitKey(statKey string) string {
+	switch statKey {
+	case "rbytes":
+		return "rbps"
+
+	case "wbytes":
+		return "wbps"
+
+	case "rios":
+		return "riops"
+
+	default:
+		return "wiops"
+	}
+}
+
+func validateCgroupWeight(name string, weight, allowedWeight uint64) error {
+	if weight < minCgroupWeight || weight > maxCgroupWeight {
+		return aoserrors.Errorf("weight %d is out of range [%d, %d]", weight, minCgroupWeight, maxCgroupWeight)
+	}
+
+	if weight > allowedWeight {
+		return aoserrors.Errorf("%s weight %d exceeds allowed %d", name, weight, allowedWeight)
+	}
+
+	return nil
+}
+
+func getDeviceNumber(device string) (major, minor int64, err error) {
+	var stat unix.Stat_t
+
+	if err = unix.Stat(device, &stat); err != nil {
+		return 0, 0, aoserrors.Wrap(err)
+	}
+
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK && stat.Mode&unix.S_IFMT != unix.S_IFCHR {
+		return 0, 0, aoserrors.Errorf("%s is not a device", device)
+	}
+
+	return int64(unix.Major(stat.Rdev)), int64(unix.Minor(stat.Rdev)), nil
+}
+
+func readCgroupValue(fileName string) (uint64, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return value, nil
+}
+
+func readCgroupKeyValues(fileName string) (map[string]uint64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 { //nolint:gomnd // key value pair
+			continue
+		}
+
+		if values[fields[0]], err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+	}
+
+	return values, aoserrors.Wrap(scanner.Err())
+}
+
+// Parses io.stat lines like "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+func readIOStat(fileName string) (map[string]map[string]uint64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	ioStats := make(map[string]map[string]uint64)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		deviceStats := make(map[string]uint64)
+
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+
+			if deviceStats[key], err = strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, aoserrors.Wrap(err)
+			}
+		}
+
+		ioStats[fields[0]] = deviceStats
+	}
+
+	return ioStats, aoserrors.Wrap(scanner.Err())
+}
@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/opencontainers/runc/libcontainer/specconv"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/exp/slices"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	capPrefix = "CAP_"
+	capAll    = "ALL"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// nolint:gochecknoglobals
+var knownCapabilities = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER", "CAP_FSETID", "CAP_KILL", "CAP_SETGID",
+	"CAP_SETUID", "CAP_SETPCAP", "CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER", "CAP_SYS_MODULE", "CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT", "CAP_SYS_PTRACE", "CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD", "CAP_LEASE", "CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL", "CAP_SETFCAP", "CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF", "CAP_CHECKPOINT_RESTORE",
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (spec *runtimeSpec) setSecurity(security *SecurityConfig) error {
+	if security == nil {
+		return nil
+	}
+
+	capDrop, err := normalizeCapabilities(security.CapDrop, true)
+	if err != nil {
+		return err
+	}
+
+	capAdd, err := normalizeCapabilities(security.CapAdd, false)
+	if err != nil {
+		return err
+	}
+
+	if len(capAdd) == 0 && security.SeccompProfile == "" && security.Seccomp == nil &&
+		security.AppArmorProfile == "" && security.SELinuxLabel == "" {
+		spec.dropCapabilities(capDrop)
+
+		return nil
+	}
+
+	profiles, err := spec.resourceManager.GetSecurityProfiles()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Only capabilities allowed by unit config can be added
+	for _, capability := range capAdd {
+		if !slices.Contains(profiles.Capabilities, capability) {
+			return aoserrors.Errorf("capability %s is not allowed", capability)
+		}
+	}
+
+	spec.dropCapabilities(capDrop)
+	spec.addCapabilities(capAdd)
+
+	if err = spec.setSeccomp(security, profiles.Seccomp); err != nil {
+		return err
+	}
+
+	if security.AppArmorProfile != "" {
+		if !slices.Contains(profiles.AppArmor, security.AppArmorProfile) {
+			return aoserrors.Errorf("unknown AppArmor profile %s", security.AppArmorProfile)
+		}
+
+		spec.ociSpec.Process.ApparmorProfile = security.AppArmorProfile
+	}
+
+	if security.SELinuxLabel != "" {
+		if !slices.Contains(profiles.SELinux, security.SELinuxLabel) {
+			return aoserrors.Errorf("unknown SELinux label %s", security.SELinuxLabel)
+		}
+
+		spec.ociSpec.Process.SelinuxLabel = security.SELinuxLabel
+	}
+
+	return nil
+}
+
+func (spec *runtimeSpec) setSeccomp(
+	security *SecurityConfig, unitProfiles map[string]runtimespec.LinuxSeccomp,
+) error {
+	var profile runtimespec.LinuxSeccomp
+
+	switch {
+	case security.SeccompProfile != "" && security.Seccomp != nil:
+		return aoserrors.New("seccomp profile should be either embedded or referenced")
+
+	case security.SeccompProfile != "":
+		unitProfile, ok := unitProfiles[security.SeccompProfile]
+		if !ok {
+			return aoserrors.Errorf("unknown seccomp profile %s", security.SeccompProfile)
+		}
+
+		profile = unitProfile
+
+	case security.Seccomp != nil:
+		profile = *security.Seccomp
+
+	default:
+		return nil
+	}
+
+	if _, err := specconv.SetupSeccomp(&profile); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Profile is shared between instances, copy syscalls as they are extended by resources
+	profile.Syscalls = append([]runtimespec.LinuxSyscall(nil), profile.Syscalls...)
+	spec.ociSpec.Linux.Seccomp = &profile
+
+	return nil
+}
+
+func (spec *runtimeSpec) dropCapabilities(capabilities []string) {
+	if len(capabilities) == 0 || spec.ociSpec.Process.Capabilities == nil {
+		return
+	}
+
+	specCaps := spec.ociSpec.Process.Capabilities
+
+	for _, capSet := range []*[]string{
+		&specCaps.Bounding, &specCaps.Effective, &specCaps.Inheritable, &specCaps.Permitted, &specCaps.Ambient,
+	} {
+		if slices.Contains(capabilities, capAll) {
+			*capSet = nil
+
+			continue
+		}
+
+		keptCaps := make([]string, 0, len(*capSet))
+
+		for _, capability := range *capSet {
+			if !slices.Contains(capabilities, capability) {
+				keptCaps = append(keptCaps, capability)
+			}
+		}
+
+		*capSet = keptCaps
+	}
+}
+
+func normalizeCapabilities(capabilities []string, allowAll bool) ([]string, error) {
+	normalized := make([]string, 0, len(capabilities))
+
+	for _, capability := range capabilities {
+		capability = strings.ToUpper(strings.TrimSpace(capability))
+
+		if capability == capAll && allowAll {
+			normalized = append(normalized, capability)
+
+			continue
+		}
+
+		if !strings.HasPrefix(capability, capPrefix) {
+			capability = capPrefix + capability
+		}
+
+		if !slices.Contains(knownCapabilities, capability) {
+			return nil, aoserrors.Errorf("unknown capability %s", capability)
+		}
+
+		normalized = append(normalized, capability)
+	}
+
+	return normalized, nil
+}
@@ -24,7 +24,9 @@ import (
 	"github.com/aoscloud/aos_common/aoserrors"
 	"github.com/aoscloud/aos_common/aostypes"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 
+	"github.com/aoscloud/aos_servicemanager/networkmanager"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
 )
 
@@ -32,9 +34,68 @@ import (
  * Types
  **********************************************************************************************************************/
 
+// ServiceConfig Aos service config extended with SM specific parameters.
+type ServiceConfig struct {
+	aostypes.ServiceConfig
+	PortMappings   []networkmanager.PortMapping `json:"portMappings,omitempty"`
+	WritableLayer  *WritableLayer               `json:"writableLayer,omitempty"`
+	HostFS         *HostFS                      `json:"hostFs,omitempty"`
+	Security       *SecurityConfig              `json:"security,omitempty"`
+	UserNamespace  bool                         `json:"userNamespace,omitempty"`
+	ResourceLimits *ResourceLimits              `json:"resourceLimits,omitempty"`
+}
+
+// WritableLayer persistent writable rootfs layer bound to the instance storage.
+type WritableLayer struct {
+	Size          uint64 `json:"size"`
+	ResetOnUpdate bool   `json:"resetOnUpdate,omitempty"`
+}
+
+// HostFS host filesystem exposure requested by service.
+type HostFS struct {
+	Paths        []HostPath `json:"paths,omitempty"`
+	NoHostRootFS bool       `json:"noHostRootfs,omitempty"`
+}
+
+// HostPath host path bound into service at the same location, read-only by default.
+type HostPath struct {
+	Path      string `json:"path"`
+	ReadWrite bool   `json:"readWrite,omitempty"`
+}
+
+// SecurityConfig service capabilities, seccomp and LSM profiles.
+// Seccomp profile is either embedded into the service config or referenced by name from the unit config.
+type SecurityConfig struct {
+	CapAdd          []string                  `json:"capAdd,omitempty"`
+	CapDrop         []string                  `json:"capDrop,omitempty"`
+	SeccompProfile  string                    `json:"seccompProfile,omitempty"`
+	Seccomp         *runtimespec.LinuxSeccomp `json:"seccomp,omitempty"`
+	AppArmorProfile string                    `json:"apparmorProfile,omitempty"`
+	SELinuxLabel    string                    `json:"selinuxLabel,omitempty"`
+}
+
+// ResourceLimits cgroup v2 resource controls in addition to service quotas.
+type ResourceLimits struct {
+	CPUWeight  *uint64   `json:"cpuWeight,omitempty"`
+	CPUs       string    `json:"cpus,omitempty"`
+	MemoryHigh *uint64   `json:"memoryHigh,omitempty"`
+	SwapLimit  *uint64   `json:"swapLimit,omitempty"`
+	IOWeight   *uint64   `json:"ioWeight,omitempty"`
+	IOLimits   []IOLimit `json:"ioLimits,omitempty"`
+}
+
+// IOLimit IO limits of host block device, zero value means unlimited.
+type IOLimit struct {
+	Device    string `json:"device"`
+	ReadBPS   uint64 `json:"rbps,omitempty"`
+	WriteBPS  uint64 `json:"wbps,omitempty"`
+	ReadIOPS  uint64 `json:"riops,omitempty"`
+	WriteIOPS uint64 `json:"wiops,omitempty"`
+}
+
 type serviceInfo struct {
 	servicemanager.ServiceInfo
-	serviceConfig *aostypes.ServiceConfig
+	serviceConfig *ServiceConfig
 	imageConfig   *imagespec.Image
 	err           error
 }
@@ -43,7 +104,10 @@ type serviceInfo struct {
  * Vars
  **********************************************************************************************************************/
 
-var errOfflineTimeout = errors.New("offline timeout")
+var (
+	errOfflineTimeout = errors.New("offline timeout")
+	errDeviceRemoved  = errors.New("device is removed from system")
+)
 
 /***********************************************************************************************************************
  * Private
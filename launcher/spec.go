@@ -26,6 +26,7 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -36,8 +37,11 @@ import (
 	"github.com/opencontainers/runc/libcontainer/specconv"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 
+	"github.com/aoscloud/aos_servicemanager/resourcemanager"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
 )
 
 /***********************************************************************************************************************
@@ -52,6 +56,7 @@ const (
 	envAosInstanceIndex = "AOS_INSTANCE_INDEX"
 	envAosInstanceID    = "AOS_INSTANCE_ID"
 	envAosSecret        = "AOS_SECRET"
+	envAosServicePrefix = "AOS_SERVICE_"
 )
 
 /***********************************************************************************************************************
@@ -73,6 +78,13 @@ func (spec *runtimeSpec) applyImageConfig(image *imagespec.Image) error {
 		return aoserrors.Errorf("unsupported OS in image config %s", image.OS)
 	}
 
+	imagePlatform, node := imagespec.Platform{OS: strOS, Architecture: image.Architecture}, platform.Node()
+
+	if !platform.Matches(&imagePlatform, node) {
+		return aoserrors.Errorf("%w: node %s, image config %s", platform.ErrNoMatch, platform.String(&node),
+			platform.String(&imagePlatform))
+	}
+
 	spec.ociSpec.Process.Args = nil
 	spec.ociSpec.Process.Args = append(spec.ociSpec.Process.Args, image.Config.Entrypoint...)
 	spec.ociSpec.Process.Args = append(spec.ociSpec.Process.Args, image.Config.Cmd...)
@@ -117,7 +129,7 @@ func (spec *runtimeSpec) setCPULimit(cpuLimit uint64) {
 	spec.ociSpec.Linux.Resources.CPU.Quota = &cpuQuota
 }
 
-func (spec *runtimeSpec) applyServiceConfig(config *aostypes.ServiceConfig) error {
+func (spec *runtimeSpec) applyServiceConfig(config *ServiceConfig) error {
 	if config.Hostname != nil {
 		spec.ociSpec.Hostname = *config.Hostname
 	}
@@ -164,7 +176,15 @@ func (spec *runtimeSpec) applyServiceConfig(config *aostypes.ServiceConfig) erro
 		return err
 	}
 
-	if err := spec.setResources(config.Resources); err != nil {
+	if err := spec.setHostFS(config.HostFS); err != nil {
+		return err
+	}
+
+	if err := spec.setSecurity(config.Security); err != nil {
+		return err
+	}
+
+	if err := spec.setResourceLimits(config.ResourceLimits, config.Quotas.RAMLimit); err != nil {
 		return err
 	}
 
@@ -225,6 +245,25 @@ func (spec *runtimeSpec) save(fileName string) error {
 	return aoserrors.Wrap(encoder.Encode(spec.ociSpec))
 }
 
+func loadRuntimeSpec(fileName string, resourceManager ResourceManager) (*runtimeSpec, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	spec := &runtimeSpec{resourceManager: resourceManager}
+
+	if err = json.Unmarshal(data, &spec.ociSpec); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if spec.ociSpec.Linux == nil || spec.ociSpec.Linux.Resources == nil || spec.ociSpec.Process == nil {
+		return nil, aoserrors.New("runtime spec is malformed")
+	}
+
+	return spec, nil
+}
+
 func (spec *runtimeSpec) addBindMount(source, destination, attr string) error {
 	absSource, err := filepath.Abs(source)
 	if err != nil {
@@ -291,6 +330,75 @@ func (spec *runtimeSpec) bindHostDirs(workingDir string) {
 	}
 }
 
+func (spec *runtimeSpec) setHostFS(hostFS *HostFS) error {
+	if hostFS == nil {
+		return nil
+	}
+
+	hostFSInfo, err := spec.resourceManager.GetHostFSInfo()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if hostFS.NoHostRootFS && !hostFSInfo.AllowNoHostRootFS {
+		return aoserrors.New("disabling host rootfs is not allowed")
+	}
+
+	for _, hostPath := range hostFS.Paths {
+		sourcePath, err := resolveHostPath(hostPath, hostFSInfo.AllowedPaths)
+		if err != nil {
+			return err
+		}
+
+		attr := "ro"
+
+		if hostPath.ReadWrite {
+			attr = "rw"
+		}
+
+		if err := spec.addBindMount(sourcePath, filepath.Clean(hostPath.Path), attr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Symlinks are resolved before the check, so a link inside an allowed path can't expose a path outside of it.
+// The resolved path is used as mount source to not follow links replaced after the check.
+func resolveHostPath(hostPath HostPath, allowedPaths []resourcemanager.HostPathInfo) (string, error) {
+	if !filepath.IsAbs(hostPath.Path) {
+		return "", aoserrors.Errorf("host path %s is not absolute", hostPath.Path)
+	}
+
+	requestedPath, err := filepath.EvalSymlinks(hostPath.Path)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	for _, allowedPath := range allowedPaths {
+		if hostPath.ReadWrite && !allowedPath.ReadWrite {
+			continue
+		}
+
+		resolvedPath, err := filepath.EvalSymlinks(allowedPath.Path)
+		if err != nil {
+			continue
+		}
+
+		relPath, err := filepath.Rel(resolvedPath, requestedPath)
+		if err != nil {
+			continue
+		}
+
+		if relPath != ".." && !strings.HasPrefix(relPath, "../") {
+			return requestedPath, nil
+		}
+	}
+
+	return "", aoserrors.Errorf("host path %s is not allowed", hostPath.Path)
+}
+
 func (spec *runtimeSpec) addHostDevice(hostPath, containerPath, permissions string) error {
 	log.WithFields(log.Fields{"hostPath": hostPath, "containerPath": containerPath}).Debug("Add host device")
 
@@ -400,8 +508,6 @@ func (spec *runtimeSpec) setRootfs(rootfsPath string) {
 }
 
 func (spec *runtimeSpec) setDevices(devices []aostypes.ServiceDevice) error {
-	const numDeviceFields = 2
-
 	for _, device := range devices {
 		deviceInfo, err := spec.resourceManager.GetDeviceInfo(device.Name)
 		if err != nil {
@@ -409,19 +515,13 @@ func (spec *runtimeSpec) setDevices(devices []aostypes.ServiceDevice) error {
 		}
 
 		for _, hostDevice := range deviceInfo.HostDevices {
-			deviceFields := strings.SplitN(hostDevice, ":", numDeviceFields)
-			if len(deviceFields) < 1 || len(deviceFields) > numDeviceFields {
-				return aoserrors.Errorf("host device field is malformed: %s", hostDevice)
-			}
+			var hostPath, containerPath string
 
-			hostDevice = deviceFields[0]
-			containerDevice := hostDevice
-
-			if len(deviceFields) == numDeviceFields {
-				containerDevice = deviceFields[1]
+			if hostPath, containerPath, err = parseHostDevice(hostDevice); err != nil {
+				return err
 			}
 
-			if err = spec.addHostDevice(hostDevice, containerDevice, device.Permissions); err != nil {
+			if err = spec.addHostDevice(hostPath, containerPath, device.Permissions); err != nil {
 				return err
 			}
 		}
@@ -436,15 +536,36 @@ func (spec *runtimeSpec) setDevices(devices []aostypes.ServiceDevice) error {
 	return nil
 }
 
-func (spec *runtimeSpec) setResources(resources []string) error {
-	for _, resource := range resources {
-		resourceInfo, err := spec.resourceManager.GetResourceInfo(resource)
-		if err != nil {
-			return aoserrors.Wrap(err)
-		}
+// Host device has "host path[:container path]" format, container path is the same as host path if omitted.
+func parseHostDevice(hostDevice string) (hostPath, containerPath string, err error) {
+	const numDeviceFields = 2
+
+	deviceFields := strings.SplitN(hostDevice, ":", numDeviceFields)
+	if len(deviceFields) < 1 || len(deviceFields) > numDeviceFields {
+		return "", "", aoserrors.Errorf("host device field is malformed: %s", hostDevice)
+	}
+
+	hostPath, containerPath = deviceFields[0], deviceFields[0]
 
+	if len(deviceFields) == numDeviceFields {
+		containerPath = deviceFields[1]
+	}
+
+	return hostPath, containerPath, nil
+}
+
+func (spec *runtimeSpec) setResources(
+	resources []resourcemanager.ResourceInfo, serviceConfig *ServiceConfig,
+) error {
+	var security *SecurityConfig
+
+	if serviceConfig != nil {
+		security = serviceConfig.Security
+	}
+
+	for _, resourceInfo := range resources {
 		for _, group := range resourceInfo.Groups {
-			if err = spec.addAdditionalGroup(group); err != nil {
+			if err := spec.addAdditionalGroup(group); err != nil {
 				return err
 			}
 		}
@@ -459,11 +580,156 @@ func (spec *runtimeSpec) setResources(resources []string) error {
 		}
 
 		spec.mergeEnv(resourceInfo.Env)
+
+		if err := spec.addResourceSysctl(resourceInfo.Name, resourceInfo.Sysctl); err != nil {
+			return err
+		}
+
+		if err := spec.addResourceTmpfs(resourceInfo.Name, resourceInfo.Tmpfs); err != nil {
+			return err
+		}
+
+		if err := spec.addResourceCapabilities(resourceInfo.Name, resourceInfo.Capabilities, security); err != nil {
+			return err
+		}
+
+		if err := spec.allowResourceSyscalls(resourceInfo.Name, resourceInfo.SeccompAllow); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Resource sysctl can't override value declared by service or other resource.
+func (spec *runtimeSpec) addResourceSysctl(resource string, sysctl map[string]string) error {
+	if len(sysctl) == 0 {
+		return nil
+	}
+
+	if spec.ociSpec.Linux.Sysctl == nil {
+		spec.ociSpec.Linux.Sysctl = make(map[string]string)
+	}
+
+	for name, value := range sysctl {
+		if curValue, ok := spec.ociSpec.Linux.Sysctl[name]; ok && curValue != value {
+			return aoserrors.Errorf("resource %s sysctl %s=%s conflicts with declared value %s",
+				resource, name, value, curValue)
+		}
+
+		spec.ociSpec.Linux.Sysctl[name] = value
+	}
+
+	return nil
+}
+
+// Resource tmpfs can't replace mount declared by service or other resource.
+func (spec *runtimeSpec) addResourceTmpfs(resource string, tmpfsMounts []resourcemanager.TmpfsInfo) error {
+	for _, tmpfs := range tmpfsMounts {
+		destination := filepath.Clean(tmpfs.Destination)
+
+		for _, mount := range spec.ociSpec.Mounts {
+			if filepath.Clean(mount.Destination) == destination {
+				return aoserrors.Errorf("resource %s tmpfs %s conflicts with declared mount", resource, destination)
+			}
+		}
+
+		spec.addMount(runtimespec.Mount{
+			Destination: destination,
+			Type:        "tmpfs",
+			Source:      "tmpfs",
+			Options:     []string{"nosuid", "nodev", "mode=1777", "size=" + strconv.FormatUint(tmpfs.Size, 10)},
+		})
+	}
+
+	return nil
+}
+
+// Resource can't grant capability dropped by service.
+func (spec *runtimeSpec) addResourceCapabilities(
+	resource string, capabilities []string, security *SecurityConfig,
+) error {
+	resourceCaps, err := normalizeCapabilities(capabilities, false)
+	if err != nil {
+		return aoserrors.Errorf("resource %s: %v", resource, err)
+	}
+
+	if security != nil {
+		capDrop, err := normalizeCapabilities(security.CapDrop, true)
+		if err != nil {
+			return err
+		}
+
+		for _, capability := range resourceCaps {
+			if slices.Contains(capDrop, capAll) || slices.Contains(capDrop, capability) {
+				return aoserrors.Errorf("resource %s capability %s conflicts with dropped capability",
+					resource, capability)
+			}
+		}
+	}
+
+	spec.addCapabilities(resourceCaps)
+
+	return nil
+}
+
+// Resource can't allow syscall explicitly restricted by seccomp profile.
+func (spec *runtimeSpec) allowResourceSyscalls(resource string, syscalls []string) error {
+	if spec.ociSpec.Linux.Seccomp != nil {
+		for _, rule := range spec.ociSpec.Linux.Seccomp.Syscalls {
+			if rule.Action == runtimespec.ActAllow || rule.Action == runtimespec.ActLog {
+				continue
+			}
+
+			for _, syscall := range syscalls {
+				if slices.Contains(rule.Names, syscall) {
+					return aoserrors.Errorf("resource %s syscall %s conflicts with declared seccomp rule",
+						resource, syscall)
+				}
+			}
+		}
+	}
+
+	spec.allowSyscalls(syscalls)
+
+	return nil
+}
+
+func (spec *runtimeSpec) addCapabilities(capabilities []string) {
+	if len(capabilities) == 0 {
+		return
+	}
+
+	if spec.ociSpec.Process.Capabilities == nil {
+		spec.ociSpec.Process.Capabilities = &runtimespec.LinuxCapabilities{}
+	}
+
+	specCaps := spec.ociSpec.Process.Capabilities
+
+	// Instances run as non root user, so capabilities should be ambient to be effective
+	for _, capSet := range []*[]string{
+		&specCaps.Bounding, &specCaps.Effective, &specCaps.Inheritable, &specCaps.Permitted, &specCaps.Ambient,
+	} {
+		for _, capability := range capabilities {
+			if !slices.Contains(*capSet, capability) {
+				*capSet = append(*capSet, capability)
+			}
+		}
+	}
+}
+
+// Syscalls are allowed only if seccomp profile is set, without profile all syscalls are allowed.
+func (spec *runtimeSpec) allowSyscalls(syscalls []string) {
+	if len(syscalls) == 0 || spec.ociSpec.Linux.Seccomp == nil {
+		return
+	}
+
+	spec.ociSpec.Linux.Seccomp.Syscalls = append(spec.ociSpec.Linux.Seccomp.Syscalls, runtimespec.LinuxSyscall{
+		Names:  syscalls,
+		Action: runtimespec.ActAllow,
+	})
+}
+
 func (spec *runtimeSpec) setNamespacePath(namespaceType runtimespec.LinuxNamespaceType, namespacePath string) {
 	for i, namespace := range spec.ociSpec.Linux.Namespaces {
 		if namespace.Type == namespaceType {
@@ -505,13 +771,13 @@ func (launcher *Launcher) getImageConfig(service servicemanager.ServiceInfo) (*i
 	return &imageConfig, nil
 }
 
-func (launcher *Launcher) getServiceConfig(service servicemanager.ServiceInfo) (*aostypes.ServiceConfig, error) {
+func (launcher *Launcher) getServiceConfig(service servicemanager.ServiceInfo) (*ServiceConfig, error) {
 	imageParts, err := launcher.serviceProvider.GetImageParts(service)
 	if err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	var serviceConfig aostypes.ServiceConfig
+	var serviceConfig ServiceConfig
 
 	if imageParts.ServiceConfigPath != "" {
 		if err = getJSONFromFile(
@@ -535,7 +801,13 @@ func (launcher *Launcher) createRuntimeSpec(instance *runtimeInstanceInfo) (*run
 	spec.setRootfs(filepath.Join(instance.runtimeDir, instanceRootFS))
 	spec.bindHostDirs(launcher.config.WorkingDir)
 	spec.setNamespacePath(runtimespec.NetworkNamespace, launcher.networkManager.GetNetnsPath(instance.InstanceID))
+
+	if instance.userNS {
+		spec.setUserNamespace(instance.userNSHostID)
+	}
+
 	spec.mergeEnv(createAosEnvVars(instance))
+	spec.mergeEnv(launcher.createServiceDiscoveryEnvVars(instance))
 	instance.overrideEnvVars = launcher.getInstanceEnvVars(instance.InstanceInfo)
 	spec.mergeEnv(instance.overrideEnvVars)
 
@@ -546,7 +818,12 @@ func (launcher *Launcher) createRuntimeSpec(instance *runtimeInstanceInfo) (*run
 			return nil, err
 		}
 
-		if err := spec.addBindMount(absStatePath, instanceStateFile, "rw"); err != nil {
+		stateSource, err := mountIDMapped(instance, absStatePath, "state")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := spec.addBindMount(stateSource, instanceStateFile, "rw"); err != nil {
 			return nil, err
 		}
 	}
@@ -558,7 +835,12 @@ func (launcher *Launcher) createRuntimeSpec(instance *runtimeInstanceInfo) (*run
 			return nil, err
 		}
 
-		if err := spec.addBindMount(absStoragePath, instanceStorageDir, "rw"); err != nil {
+		storageSource, err := mountIDMapped(instance, absStoragePath, "storage")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := spec.addBindMount(storageSource, instanceStorageDir, "rw"); err != nil {
 			return nil, err
 		}
 	}
@@ -575,6 +857,10 @@ func (launcher *Launcher) createRuntimeSpec(instance *runtimeInstanceInfo) (*run
 		return nil, err
 	}
 
+	if err := spec.setResources(instance.resources, instance.service.serviceConfig); err != nil {
+		return nil, err
+	}
+
 	fileName := filepath.Join(instance.runtimeDir, runtimeConfigFile)
 
 	log.WithFields(instanceLogFields(instance, log.Fields{"fileName": fileName})).Debug("Save runtime spec")
@@ -598,3 +884,55 @@ func createAosEnvVars(instance *runtimeInstanceInfo) (aosEnvVars []string) {
 
 	return aosEnvVars
 }
+
+func (launcher *Launcher) createServiceDiscoveryEnvVars(instance *runtimeInstanceInfo) (envVars []string) {
+	// AllowedConnections format service-UUID/port/protocol
+	dependencies := make([]string, 0, len(instance.service.serviceConfig.AllowedConnections))
+
+	for connection := range instance.service.serviceConfig.AllowedConnections {
+		serviceID := strings.Split(connection, "/")[0]
+
+		if serviceID != "" && serviceID != instance.ServiceID && !slices.Contains(dependencies, serviceID) {
+			dependencies = append(dependencies, serviceID)
+		}
+	}
+
+	sort.Strings(dependencies)
+
+	for _, serviceID := range dependencies {
+		endpoints := launcher.networkManager.GetServiceEndpoints(serviceID)
+		if len(endpoints) == 0 {
+			continue
+		}
+
+		// Prefer endpoint of the same subject
+		endpoint := endpoints[0]
+
+		for _, subjectEndpoint := range endpoints {
+			if subjectEndpoint.SubjectID == instance.SubjectID {
+				endpoint = subjectEndpoint
+
+				break
+			}
+		}
+
+		prefix := envAosServicePrefix + envVarNameFromID(serviceID)
+
+		envVars = append(envVars,
+			fmt.Sprintf("%s_HOST=%s", prefix, endpoint.Hostname),
+			fmt.Sprintf("%s_IP=%s", prefix, endpoint.IP),
+			fmt.Sprintf("%s_PORTS=%s", prefix, strings.Join(endpoint.Ports, ",")))
+	}
+
+	return envVars
+}
+
+func envVarNameFromID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+
+		return '_'
+	}, strings.ToUpper(id))
+}
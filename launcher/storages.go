@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/utils/fs"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/layermanager"
+	"github.com/aoscloud/aos_servicemanager/spacepolicy"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// StoppedItem storage, service or layer which is not used by current instances. Priority is the priority of the
+// instance which used the item last time.
+type StoppedItem struct {
+	Type     string
+	ID       string
+	Priority uint64
+}
+
+type stoppedItemKey struct {
+	itemType string
+	id       string
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// GetSpaceUsage returns space used by instance storages and their writable layers.
+func (launcher *Launcher) GetSpaceUsage() (usage spacepolicy.Usage, err error) {
+	usage.Type = spacepolicy.ItemTypeStorage
+
+	if launcher.config.StorageDir == "" {
+		return usage, nil
+	}
+
+	for _, dir := range []string{
+		launcher.config.StorageDir, filepath.Join(launcher.config.WorkingDir, writableLayersDir),
+	} {
+		size, err := fs.GetDirSize(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return usage, aoserrors.Wrap(err)
+		}
+
+		usage.Size += uint64(size)
+	}
+	usage.Path = launcher.config.StorageDir
+
+	return usage, nil
+}
+
+// GetEvictableItems returns storages which are not used by current instances. Storage priority is the priority
+// of the instance which used it last time.
+func (launcher *Launcher) GetEvictableItems() (items []spacepolicy.Item, err error) {
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	if launcher.config.StorageDir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(launcher.config.StorageDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	usedStorages, err := launcher.getUsedStorages()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if containsStorage(entry.Name(), usedStorages) {
+			continue
+		}
+
+		storagePath := launcher.getAbsStoragePath(entry.Name())
+
+		size, err := fs.GetDirSize(storagePath)
+		if err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		layerSize, err := fs.GetDirSize(launcher.getWritableLayerDir(entry.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		size += layerSize
+
+		item := spacepolicy.Item{
+			Type: spacepolicy.ItemTypeStorage,
+			ID:   entry.Name(),
+			Path: storagePath,
+			Size: uint64(size),
+		}
+
+		if info, err := entry.Info(); err == nil {
+			item.Timestamp = info.ModTime()
+		}
+
+		for key, priority := range launcher.stoppedItems {
+			if key.itemType == spacepolicy.ItemTypeStorage && containsStorage(entry.Name(), []string{key.id}) &&
+				priority > item.Priority {
+				item.Priority = priority
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// EvictItem removes storage which is not used by current instances.
+func (launcher *Launcher) EvictItem(item spacepolicy.Item) error {
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	usedStorages, err := launcher.getUsedStorages()
+	if err != nil {
+		return err
+	}
+
+	if containsStorage(item.ID, usedStorages) {
+		return aoserrors.Errorf("storage %s is in use", item.ID)
+	}
+
+	for _, dir := range []string{launcher.getAbsStoragePath(item.ID), launcher.getWritableLayerDir(item.ID)} {
+		if err = os.RemoveAll(dir); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	for key := range launcher.stoppedItems {
+		if key.itemType == spacepolicy.ItemTypeStorage && containsStorage(item.ID, []string{key.id}) {
+			launcher.removeStoppedItem(key)
+		}
+	}
+
+	return nil
+}
+
+// GetItemPriority returns priority of the instance which used service or layer last time.
+func (launcher *Launcher) GetItemPriority(item spacepolicy.Item) uint64 {
+	launcher.Lock()
+	defer launcher.Unlock()
+
+	if item.Type == spacepolicy.ItemTypeStorage {
+		return 0
+	}
+
+	return launcher.stoppedItems[stoppedItemKey{itemType: item.Type, id: item.ID}]
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (launcher *Launcher) getUsedStorages() (usedStorages []string, err error) {
+	instances, err := launcher.storage.GetAllInstances()
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	for _, instance := range instances {
+		if instance.StoragePath != "" {
+			usedStorages = append(usedStorages, instance.StoragePath)
+		}
+	}
+
+	return usedStorages, nil
+}
+
+func (launcher *Launcher) loadStoppedItems() error {
+	items, err := launcher.storage.GetStoppedItems()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, item := range items {
+		key := stoppedItemKey{itemType: item.Type, id: item.ID}
+
+		// Removed layers are not reported by layer provider anymore
+		if item.Type == spacepolicy.ItemTypeLayer {
+			if _, err := launcher.layerProvider.GetLayerInfoByDigest(item.ID); errors.Is(err, layermanager.ErrNotExist) {
+				launcher.removeStoppedItem(key)
+
+				continue
+			}
+		}
+
+		launcher.stoppedItems[key] = item.Priority
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) setItemsStopped(instances []InstanceInfo) {
+	stoppedItems := make(map[stoppedItemKey]uint64)
+
+	setStopped := func(key stoppedItemKey, priority uint64) {
+		if curPriority, ok := stoppedItems[key]; !ok || priority > curPriority {
+			stoppedItems[key] = priority
+		}
+	}
+
+	for _, instance := range instances {
+		if instance.StoragePath != "" {
+			setStopped(stoppedItemKey{itemType: spacepolicy.ItemTypeStorage, id: instance.StoragePath},
+				instance.Priority)
+		}
+
+		setStopped(stoppedItemKey{itemType: spacepolicy.ItemTypeService, id: instance.ServiceID}, instance.Priority)
+
+		for _, digest := range launcher.getServiceLayers(instance.ServiceID) {
+			setStopped(stoppedItemKey{itemType: spacepolicy.ItemTypeLayer, id: digest}, instance.Priority)
+		}
+	}
+
+	for key, priority := range stoppedItems {
+		launcher.stoppedItems[key] = priority
+
+		if err := launcher.storage.SetStoppedItem(StoppedItem{
+			Type: key.itemType, ID: key.id, Priority: priority,
+		}); err != nil {
+			log.Errorf("Can't set stopped item: %v", err)
+		}
+	}
+}
+
+func (launcher *Launcher) removeStoppedItem(key stoppedItemKey) {
+	delete(launcher.stoppedItems, key)
+
+	if err := launcher.storage.RemoveStoppedItem(key.itemType, key.id); err != nil {
+		log.Errorf("Can't remove stopped item: %v", err)
+	}
+}
+
+func (launcher *Launcher) getServiceLayers(serviceID string) []string {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	service, ok := launcher.currentServices[serviceID]
+	if !ok || service.err != nil {
+		return nil
+	}
+
+	imageParts, err := launcher.serviceProvider.GetImageParts(service.ServiceInfo)
+	if err != nil {
+		return nil
+	}
+
+	return imageParts.LayersDigest
+}
+
+// Checks if storage entry of storage dir contains any of storage paths.
+func containsStorage(entry string, storagePaths []string) bool {
+	for _, storagePath := range storagePaths {
+		storagePath = filepath.Clean(strings.TrimPrefix(storagePath, "/"))
+
+		if storagePath == entry || strings.HasPrefix(storagePath, entry+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+
+	"github.com/aoscloud/aos_servicemanager/config"
+	"github.com/aoscloud/aos_servicemanager/utils/idmap"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	userNSRangeSize        = 65536
+	maxUserNSHostID        = 1 << 32
+	instanceIDMappedDir    = "idmapped"
+	instanceMergedFSDir    = "mergedfs"
+	sysfsDir               = "/sys"
+	userNSVisibleSysfsPath = "devices/system/cpu"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// IDMapMountFunc idmapped mount function.
+//
+//nolint:gochecknoglobals
+var IDMapMountFunc = idmap.Mount
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func validateUserNSConfig(userNS config.UserNamespace) error {
+	if userNS.SubIDCount == 0 {
+		return nil
+	}
+
+	// Container root must never be mapped to host root
+	if userNS.SubIDStart == 0 {
+		return aoserrors.New("user namespace sub IDs can't start from host root")
+	}
+
+	if userNS.SubIDCount < userNSRangeSize {
+		return aoserrors.Errorf("user namespace sub IDs count should be at least %d", userNSRangeSize)
+	}
+
+	if err := idmap.CheckRange(userNS.SubIDStart, userNS.SubIDCount); err != nil {
+		return aoserrors.Errorf("invalid user namespace sub IDs: %v", err)
+	}
+
+	return nil
+}
+
+func (launcher *Launcher) allocateUserNS(instance *runtimeInstanceInfo) error {
+	if !instance.service.serviceConfig.UserNamespace {
+		return nil
+	}
+
+	if instance.UID >= userNSRangeSize || instance.service.GID >= userNSRangeSize {
+		return aoserrors.Errorf("instance UID %d or GID %d can't be mapped into user namespace",
+			instance.UID, instance.service.GID)
+	}
+
+	numRanges := launcher.config.UserNamespace.SubIDCount / userNSRangeSize
+	if numRanges == 0 {
+		return aoserrors.New("user namespace is not configured")
+	}
+
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	usedIDs := make(map[uint32]struct{}, len(launcher.userNSRanges))
+
+	for _, hostID := range launcher.userNSRanges {
+		usedIDs[hostID] = struct{}{}
+	}
+
+	for i := uint32(0); i < numRanges; i++ {
+		hostID := uint64(launcher.config.UserNamespace.SubIDStart) + uint64(i)*userNSRangeSize
+
+		if hostID == 0 || hostID+userNSRangeSize > maxUserNSHostID {
+			return aoserrors.Errorf("user namespace host ID %d is out of range", hostID)
+		}
+
+		if _, ok := usedIDs[uint32(hostID)]; ok {
+			continue
+		}
+
+		launcher.userNSRanges[instance.InstanceID] = uint32(hostID)
+		instance.userNSHostID, instance.userNS = uint32(hostID), true
+
+		return nil
+	}
+
+	return aoserrors.New("no free user namespace range")
+}
+
+func (launcher *Launcher) releaseUserNS(instance *runtimeInstanceInfo) {
+	launcher.runMutex.Lock()
+	defer launcher.runMutex.Unlock()
+
+	delete(launcher.userNSRanges, instance.InstanceID)
+
+	instance.userNSHostID, instance.userNS = 0, false
+}
+
+func (instance *runtimeInstanceInfo) userNSMapping() idmap.Mapping {
+	return idmap.Mapping{ContainerID: 0, HostID: instance.userNSHostID, Size: userNSRangeSize}
+}
+
+// Returns path to be mounted into instance: source itself if instance doesn't use user namespace, otherwise
+// idmapped clone of source which keeps on-disk owners inside the instance user namespace.
+func mountIDMapped(instance *runtimeInstanceInfo, source, name string) (string, error) {
+	if !instance.userNS {
+		return source, nil
+	}
+
+	target := filepath.Join(instance.runtimeDir, instanceIDMappedDir, name)
+
+	if err := createMountTarget(source, target); err != nil {
+		return "", err
+	}
+
+	if err := IDMapMountFunc(source, target, instance.userNSMapping(), instance.userNSMapping()); err != nil {
+		os.RemoveAll(target)
+
+		return "", aoserrors.Wrap(err)
+	}
+
+	return target, nil
+}
+
+func umountIDMapped(instance *runtimeInstanceInfo) (err error) {
+	idmappedDir := filepath.Join(instance.runtimeDir, instanceIDMappedDir)
+
+	entries, readErr := os.ReadDir(idmappedDir)
+	if readErr != nil && !os.IsNotExist(readErr) {
+		err = aoserrors.Wrap(readErr)
+	}
+
+	for _, entry := range entries {
+		if unmountErr := UnmountFunc(filepath.Join(idmappedDir, entry.Name())); unmountErr != nil && err == nil {
+			err = aoserrors.Wrap(unmountErr)
+		}
+	}
+
+	mergedFSDir := filepath.Join(instance.runtimeDir, instanceMergedFSDir)
+
+	if _, statErr := os.Stat(mergedFSDir); statErr == nil {
+		if unmountErr := UnmountFunc(mergedFSDir); unmountErr != nil && err == nil {
+			err = aoserrors.Wrap(unmountErr)
+		}
+	}
+
+	return err
+}
+
+func createMountTarget(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if info.IsDir() {
+		return aoserrors.Wrap(os.MkdirAll(target, 0o755))
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE, 0o600)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return aoserrors.Wrap(file.Close())
+}
+
+func (spec *runtimeSpec) setUserNamespace(hostID uint32) {
+	spec.setNamespacePath(runtimespec.UserNamespace, "")
+
+	mapping := []runtimespec.LinuxIDMapping{{ContainerID: 0, HostID: hostID, Size: userNSRangeSize}}
+
+	spec.ociSpec.Linux.UIDMappings = mapping
+	spec.ociSpec.Linux.GIDMappings = mapping
+
+	// sysfs can't be mounted in user namespace which doesn't own network namespace, host sysfs is bound instead
+	for i, mount := range spec.ociSpec.Mounts {
+		if mount.Type == "sysfs" {
+			spec.ociSpec.Mounts[i] = runtimespec.Mount{
+				Destination: mount.Destination,
+				Type:        "bind",
+				Source:      sysfsDir,
+				Options:     []string{"rbind", "nosuid", "noexec", "nodev", "ro"},
+			}
+
+			spec.maskSysfs(mount.Destination)
+		}
+	}
+}
+
+// Host sysfs exposes host devices and cgroups, everything except CPU topology is masked.
+func (spec *runtimeSpec) maskSysfs(destination string) {
+	visiblePath := ""
+
+	for _, name := range strings.Split(userNSVisibleSysfsPath, "/") {
+		entries, err := os.ReadDir(filepath.Join(sysfsDir, visiblePath))
+		if err != nil {
+			log.Warnf("Can't read sysfs dir: %v", err)
+
+			return
+		}
+
+		for _, entry := range entries {
+			if entry.Name() == name || entry.Type()&os.ModeSymlink != 0 {
+				continue
+			}
+
+			maskedPath := filepath.Join(destination, visiblePath, entry.Name())
+
+			if !slices.Contains(spec.ociSpec.Linux.MaskedPaths, maskedPath) {
+				spec.ociSpec.Linux.MaskedPaths = append(spec.ociSpec.Linux.MaskedPaths, maskedPath)
+			}
+		}
+
+		visiblePath = filepath.Join(visiblePath, name)
+	}
+}
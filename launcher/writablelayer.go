@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package launcher
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	writableLayersDir        = "writablelayers"
+	oldWritableLayerDir      = ".writablelayer"
+	writableLayerImage       = "layer.img"
+	writableLayerVersionFile = "version"
+	writableLayerFSType      = "ext4"
+	instanceWritableLayerDir = "writablelayer"
+	writableLayerUpperDir    = "upper"
+	writableLayerWorkDir     = "work"
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// Create and mount writable layer image functions.
+//
+//nolint:gochecknoglobals
+var (
+	CreateLayerImageFunc = createLayerImage
+	MountLayerImageFunc  = mountLayerImage
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// Writable layer is kept as fixed size image bound to the instance storage, so its size is limited by the image size.
+// The image is stored outside of the storage to be not accessible by the instance. Returns overlay upper and work
+// dirs or empty strings if the service has no writable layer.
+func (launcher *Launcher) prepareWritableLayer(instance *runtimeInstanceInfo) (upperDir, workDir string, err error) {
+	if instance.service.serviceConfig == nil || instance.service.serviceConfig.WritableLayer == nil {
+		return "", "", nil
+	}
+
+	writableLayer := instance.service.serviceConfig.WritableLayer
+
+	if writableLayer.Size == 0 {
+		return "", "", aoserrors.New("writable layer size is not set")
+	}
+
+	if instance.StoragePath == "" {
+		return "", "", aoserrors.New("writable layer requires instance storage")
+	}
+
+	layerDir := launcher.getWritableLayerDir(instance.StoragePath)
+	imagePath := filepath.Join(layerDir, writableLayerImage)
+
+	if err = launcher.moveOldWritableLayer(instance.StoragePath, layerDir); err != nil {
+		return "", "", err
+	}
+
+	if err = resetOutdatedLayer(layerDir, writableLayer, instance.service.AosVersion); err != nil {
+		return "", "", err
+	}
+
+	if _, err = os.Stat(imagePath); err != nil {
+		if !os.IsNotExist(err) {
+			return "", "", aoserrors.Wrap(err)
+		}
+
+		if err = os.MkdirAll(layerDir, 0o700); err != nil {
+			return "", "", aoserrors.Wrap(err)
+		}
+
+		log.WithFields(instanceLogFields(instance, nil)).WithField(
+			"size", writableLayer.Size).Debug("Create writable layer")
+
+		if err = CreateLayerImageFunc(imagePath, writableLayer.Size); err != nil {
+			return "", "", aoserrors.Wrap(err)
+		}
+	}
+
+	if err = os.WriteFile(filepath.Join(layerDir, writableLayerVersionFile),
+		[]byte(strconv.FormatUint(instance.service.AosVersion, 10)), 0o600); err != nil {
+		return "", "", aoserrors.Wrap(err)
+	}
+
+	mountPoint := filepath.Join(instance.runtimeDir, instanceWritableLayerDir)
+
+	if err = MountLayerImageFunc(imagePath, mountPoint); err != nil {
+		os.RemoveAll(mountPoint)
+
+		return "", "", aoserrors.Wrap(err)
+	}
+
+	upperDir = filepath.Join(mountPoint, writableLayerUpperDir)
+	workDir = filepath.Join(mountPoint, writableLayerWorkDir)
+
+	for _, dir := range []string{upperDir, workDir} {
+		if err = os.MkdirAll(dir, 0o755); err != nil {
+			return "", "", aoserrors.Wrap(err)
+		}
+	}
+
+	return upperDir, workDir, nil
+}
+
+func (launcher *Launcher) getWritableLayerDir(storagePath string) string {
+	return filepath.Join(launcher.config.WorkingDir, writableLayersDir, filepath.Clean("/"+storagePath))
+}
+
+// Layers created by previous versions are kept inside the storage and should be moved out of it.
+func (launcher *Launcher) moveOldWritableLayer(storagePath, layerDir string) error {
+	oldLayerDir := filepath.Join(launcher.getAbsStoragePath(storagePath), oldWritableLayerDir)
+
+	if _, err := os.Stat(oldLayerDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	log.WithFields(log.Fields{"from": oldLayerDir, "to": layerDir}).Debug("Move writable layer")
+
+	if err := os.RemoveAll(layerDir); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(layerDir), 0o700); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := os.Rename(oldLayerDir, layerDir); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func umountWritableLayer(instance *runtimeInstanceInfo) error {
+	mountPoint := filepath.Join(instance.runtimeDir, instanceWritableLayerDir)
+
+	if _, err := os.Stat(mountPoint); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	if err := UnmountFunc(mountPoint); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// Layer created by other service version is removed if reset on update is set.
+func resetOutdatedLayer(layerDir string, writableLayer *WritableLayer, aosVersion uint64) error {
+	if !writableLayer.ResetOnUpdate {
+		return nil
+	}
+
+	versionData, err := os.ReadFile(filepath.Join(layerDir, writableLayerVersionFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	if strings.TrimSpace(string(versionData)) == strconv.FormatUint(aosVersion, 10) {
+		return nil
+	}
+
+	log.WithFields(log.Fields{"layerDir": layerDir, "aosVersion": aosVersion}).Debug("Reset writable layer")
+
+	if err = os.RemoveAll(layerDir); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func createLayerImage(imagePath string, size uint64) (err error) {
+	file, err := os.OpenFile(imagePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(imagePath)
+		}
+	}()
+
+	if err = file.Truncate(int64(size)); err != nil {
+		file.Close()
+
+		return aoserrors.Wrap(err)
+	}
+
+	if err = file.Close(); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if output, err := exec.Command("mkfs."+writableLayerFSType, "-q", "-F", imagePath).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("can't create writable layer FS: %v (%s)", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func mountLayerImage(imagePath, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if output, err := exec.Command(
+		"mount", "-t", writableLayerFSType, "-o", "loop", imagePath, mountPoint).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("can't mount writable layer: %v (%s)", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
@@ -22,10 +22,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,10 +35,21 @@ import (
 	"github.com/aoscloud/aos_common/aostypes"
 	"github.com/aoscloud/aos_common/image"
 	"github.com/aoscloud/aos_common/spaceallocator"
+	"github.com/aoscloud/aos_common/utils/action"
+	"github.com/opencontainers/go-digest"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aoscloud/aos_servicemanager/config"
+	"github.com/aoscloud/aos_servicemanager/scrubber"
+	"github.com/aoscloud/aos_servicemanager/spacepolicy"
+	"github.com/aoscloud/aos_servicemanager/utils/blobstore"
+	"github.com/aoscloud/aos_servicemanager/utils/downloader"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
+	"github.com/aoscloud/aos_servicemanager/utils/install"
+	"github.com/aoscloud/aos_servicemanager/utils/ociregistry"
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
 	"github.com/aoscloud/aos_servicemanager/utils/whiteouts"
 )
 
@@ -46,13 +59,22 @@ import (
 
 const (
 	layerOCIDescriptor = "layer.json"
+	layerDownloadDir   = "layers"
+	unpackedLayerDir   = "unpacked"
 )
 
 /***********************************************************************************************************************
  * Vars
  **********************************************************************************************************************/
 
-var ErrNotExist = errors.New("layer does not exist")
+var (
+	// ErrNotExist layer does not exist error.
+	ErrNotExist = errors.New("layer does not exist")
+	// ErrQuarantined layer is quarantined due to integrity check failure.
+	ErrQuarantined = errors.New("layer is quarantined")
+	// ErrInUse layer is used by installed services.
+	ErrInUse = errors.New("layer is in use")
+)
 
 // NewSpaceAllocator space allocator constructor.
 // nolint:gochecknoglobals // used for unit test mock
@@ -62,6 +84,14 @@ var NewSpaceAllocator = spaceallocator.New
 // nolint:gochecknoglobals // used for unit test mock
 var RemoveCachedLayersPeriod = 24 * time.Hour
 
+// nolint:gochecknoglobals // used for unit test mock
+var (
+	// PackImage packs layer into filesystem image.
+	PackImage = fsimage.Pack
+	// VerifyImage verifies packed layer.
+	VerifyImage = fsimage.Verify
+)
+
 /***********************************************************************************************************************
  * Types
  **********************************************************************************************************************/
@@ -75,14 +105,23 @@ type SpaceAllocator interface {
 type LayerManager struct {
 	sync.Mutex
 	layerStorage           LayerStorage
+	usageProvider          LayerUsageProvider
 	layersDir              string
 	extractDir             string
-	downloadDir            string
 	layerTTLDays           uint64
-	layerAllocator         spaceallocator.Allocator
-	downloadAllocator      spaceallocator.Allocator
+	installConcurrency     int
+	installTimeout         time.Duration
+	layerAllocator         *spacepolicy.ReservingAllocator
+	partLimit              uint
 	extractAllocator       spaceallocator.Allocator
+	spaceReclaimer         SpaceReclaimer
+	downloader             *downloader.Downloader
+	registry               *ociregistry.Client
+	platform               imagespec.Platform
+	blobStore              *blobstore.BlobStore
+	fsImage                config.FSImage
 	validateTTLStopChannel chan struct{}
+	progressChannel        chan progress.Event
 }
 
 // LayerStorage provides API to add, remove or access layer information.
@@ -92,46 +131,74 @@ type LayerStorage interface {
 	GetLayersInfo() ([]LayerInfo, error)
 	GetLayerInfoByDigest(digest string) (LayerInfo, error)
 	SetLayerCached(digest string, cached bool) error
+	SetLayerContentDigest(digest string, contentDigest string) error
+	SetLayerQuarantined(digest string, quarantined bool) error
+	blobstore.Storage
+}
+
+// SpaceReclaimer frees node space by evicting items of other owners.
+type SpaceReclaimer interface {
+	ReclaimSpace(path string, size uint64) error
+}
+
+// LayerUsageProvider provides service versions which use layers.
+type LayerUsageProvider interface {
+	GetLayerUsers() (layerUsers map[string][]LayerUser, err error)
+}
+
+// LayerUser service version which uses layer.
+type LayerUser struct {
+	ServiceID  string
+	AosVersion uint64
 }
 
 // LayerInfo layer information.
 type LayerInfo struct {
 	aostypes.VersionInfo
-	Digest    string
-	LayerID   string
-	Path      string
-	OSVersion string
-	Timestamp time.Time
-	Cached    bool
-	Size      uint64
+	Digest        string
+	LayerID       string
+	Path          string
+	OSVersion     string
+	Timestamp     time.Time
+	Cached        bool
+	Size          uint64
+	ContentDigest string
+	Quarantined   bool
+	FSImage       fsimage.Info
 }
 
 /**********************************************************************************************************************
  * Public
  **********************************************************************************************************************/
 // New creates new layer manager instance.
-func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerManager, err error) {
+func New(
+	config *config.Config, layerStorage LayerStorage, usageProvider LayerUsageProvider,
+) (layermanager *LayerManager, err error) {
 	layermanager = &LayerManager{
 		layersDir:              config.LayersDir,
 		layerStorage:           layerStorage,
+		usageProvider:          usageProvider,
 		extractDir:             config.ExtractDir,
-		downloadDir:            config.DownloadDir,
 		layerTTLDays:           config.LayerTTLDays,
+		installConcurrency:     config.InstallConcurrency,
+		installTimeout:         config.InstallTimeout.Duration,
+		registry:               ociregistry.New(config.InsecureRegistries),
+		platform:               platform.Node(),
+		fsImage:                config.FSImage,
 		validateTTLStopChannel: make(chan struct{}),
+		progressChannel:        make(chan progress.Event, progress.EventChannelSize),
 	}
 
-	if err := os.RemoveAll(layermanager.downloadDir); err != nil {
-		return nil, aoserrors.Wrap(err)
+	if layermanager.fsImage.Format != "" {
+		if err = fsimage.CheckFormat(layermanager.fsImage.Format, layermanager.fsImage.Verity); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := os.RemoveAll(layermanager.extractDir); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if err := os.MkdirAll(layermanager.downloadDir, 0o755); err != nil {
-		return nil, aoserrors.Wrap(err)
-	}
-
 	if err := os.MkdirAll(layermanager.extractDir, 0o755); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
@@ -140,13 +207,16 @@ func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerM
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if layermanager.layerAllocator, err = NewSpaceAllocator(
-		layermanager.layersDir, config.LayersPartLimit, layermanager.removeLayer); err != nil {
+	layerAllocator, err := NewSpaceAllocator(layermanager.layersDir, config.LayersPartLimit, layermanager.removeLayer)
+	if err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if layermanager.downloadAllocator, err = NewSpaceAllocator(
-		layermanager.downloadDir, 0, nil); err != nil {
+	layermanager.layerAllocator = spacepolicy.NewReservingAllocator(layerAllocator)
+	layermanager.partLimit = config.LayersPartLimit
+
+	if layermanager.downloader, err = downloader.New(
+		filepath.Join(config.DownloadDir, layerDownloadDir)); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
@@ -155,6 +225,11 @@ func New(config *config.Config, layerStorage LayerStorage) (layermanager *LayerM
 		return nil, aoserrors.Wrap(err)
 	}
 
+	// Layers are stored as layersDir/<algorithm>/<hex> which is the blob store layout
+	if layermanager.blobStore, err = blobstore.New(layermanager.layersDir, layerStorage); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
 	if err := layermanager.removeDamagedLayerFolders(); err != nil {
 		log.Errorf("Can't remove damaged layer folders: %v", err)
 	}
@@ -178,8 +253,8 @@ func (layermanager *LayerManager) Close() {
 		log.Errorf("Can't close layer allocator: %v", err)
 	}
 
-	if err := layermanager.downloadAllocator.Close(); err != nil {
-		log.Errorf("Can't close download allocator: %v", err)
+	if err := layermanager.downloader.Close(); err != nil {
+		log.Errorf("Can't close downloader: %v", err)
 	}
 
 	if err := layermanager.extractAllocator.Close(); err != nil {
@@ -195,11 +270,26 @@ func (layermanager *LayerManager) GetLayerInfoByDigest(digest string) (layer Lay
 		return layer, aoserrors.Wrap(err)
 	}
 
+	if layer.Quarantined {
+		return layer, aoserrors.Wrap(ErrQuarantined)
+	}
+
 	return layer, nil
 }
 
+// GetLayerUsers returns service versions which use the layer.
+func (layermanager *LayerManager) GetLayerUsers(digest string) (users []LayerUser, err error) {
+	layerUsers, err := layermanager.getLayerUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	return layerUsers[digest], nil
+}
+
 // ProcessDesiredLayers installs, removes, restores desired layers on the system.
-func (layermanager *LayerManager) ProcessDesiredLayers(desiredLayers []aostypes.LayerInfo) error {
+// Installation is aborted when ctx is canceled.
+func (layermanager *LayerManager) ProcessDesiredLayers(ctx context.Context, desiredLayers []aostypes.LayerInfo) error {
 	layers, err := layermanager.layerStorage.GetLayersInfo()
 	if err != nil {
 		return aoserrors.Wrap(err)
@@ -209,22 +299,241 @@ func (layermanager *LayerManager) ProcessDesiredLayers(desiredLayers []aostypes.
 		return err
 	}
 
-	if err = layermanager.installLayers(desiredLayers); err != nil {
+	if err = layermanager.installLayers(ctx, desiredLayers); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// GetInstallProgressChannel returns layers install progress channel.
+func (layermanager *LayerManager) GetInstallProgressChannel() (channel <-chan progress.Event) {
+	return layermanager.progressChannel
+}
+
+// GetScrubItems returns layers to be verified by scrubber.
+func (layermanager *LayerManager) GetScrubItems() (items []scrubber.Item, err error) {
+	layers, err := layermanager.layerStorage.GetLayersInfo()
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	for _, layer := range layers {
+		if layer.Quarantined {
+			continue
+		}
+
+		items = append(items, scrubber.Item{
+			Type: scrubber.ItemTypeLayer, ID: layer.LayerID, AosVersion: layer.AosVersion, Digest: layer.Digest,
+		})
+	}
+
+	return items, nil
+}
+
+// VerifyItem verifies unpacked layer content. Content digest of layers installed without it is recorded on first
+// verification.
+func (layermanager *LayerManager) VerifyItem(
+	ctx context.Context, item scrubber.Item, limiter *scrubber.Limiter,
+) error {
+	layer, err := layermanager.layerStorage.GetLayerInfoByDigest(item.Digest)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Packed layer is read by verity tools, so it is not limited by IO budget
+	if layer.FSImage.Format != "" {
+		return layermanager.verifyLayerImage(ctx, layer)
+	}
+
+	contentDigest, err := scrubber.HashDir(ctx, layer.Path, limiter)
+	if err == nil && layer.ContentDigest == "" {
+		return aoserrors.Wrap(layermanager.layerStorage.SetLayerContentDigest(layer.Digest, string(contentDigest)))
+	}
+
+	if err == nil && string(contentDigest) == layer.ContentDigest {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return aoserrors.Wrap(ctx.Err())
+	}
+
+	// Layer could be removed during verification
+	if _, getErr := layermanager.layerStorage.GetLayerInfoByDigest(item.Digest); errors.Is(getErr, ErrNotExist) {
+		return nil
+	}
+
+	if err == nil {
+		err = aoserrors.New("incorrect layer content checksum")
+	}
+
+	return aoserrors.Errorf("%v: %w", err, scrubber.ErrCorrupted)
+}
+
+// QuarantineItem marks layer as quarantined. Quarantined layer is not used by instances and is reinstalled when it
+// is desired next time.
+func (layermanager *LayerManager) QuarantineItem(item scrubber.Item) error {
+	return layermanager.QuarantineLayer(item.Digest)
+}
+
+// QuarantineLayer marks damaged layer to be installed again on next desired layers processing.
+func (layermanager *LayerManager) QuarantineLayer(digest string) error {
+	layermanager.Lock()
+	defer layermanager.Unlock()
+
+	if err := layermanager.layerStorage.SetLayerQuarantined(digest, true); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// SetSpaceReclaimer sets space reclaimer which is used when there is no space to install layer.
+func (layermanager *LayerManager) SetSpaceReclaimer(spaceReclaimer SpaceReclaimer) {
+	layermanager.spaceReclaimer = spaceReclaimer
+}
+
+// GetSpaceUsage returns space used by installed layers.
+func (layermanager *LayerManager) GetSpaceUsage() (usage spacepolicy.Usage, err error) {
+	layers, err := layermanager.layerStorage.GetLayersInfo()
+	if err != nil {
+		return usage, aoserrors.Wrap(err)
+	}
+
+	usage.Type = spacepolicy.ItemTypeLayer
+	usage.Path = layermanager.layersDir
+	usage.PartLimit = layermanager.partLimit
+	usage.ReservedSize = layermanager.layerAllocator.ReservedSize()
+
+	for _, layer := range layers {
+		usage.Size += layer.Size
+	}
+
+	return usage, nil
+}
+
+// GetEvictableItems returns cached layers which are not used by services.
+func (layermanager *LayerManager) GetEvictableItems() (items []spacepolicy.Item, err error) {
+	layers, err := layermanager.layerStorage.GetLayersInfo()
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	layerUsers, err := layermanager.getLayerUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range layers {
+		if !layer.Cached || len(layerUsers[layer.Digest]) > 0 {
+			continue
+		}
+
+		items = append(items, spacepolicy.Item{
+			Type:       spacepolicy.ItemTypeLayer,
+			ID:         layer.Digest,
+			AosVersion: layer.AosVersion,
+			Path:       layer.Path,
+			Size:       layer.Size,
+			Timestamp:  layer.Timestamp,
+		})
+	}
+
+	return items, nil
+}
+
+// EvictItem removes cached layer.
+func (layermanager *LayerManager) EvictItem(item spacepolicy.Item) error {
+	layer, err := layermanager.layerStorage.GetLayerInfoByDigest(item.ID)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if !layer.Cached {
+		return aoserrors.Errorf("layer %s is not cached", layer.Digest)
+	}
+
+	if err = layermanager.removeLayer(layer.Digest); err != nil {
+		return err
+	}
+
+	layermanager.layerAllocator.RestoreOutdatedItem(layer.Digest)
+	layermanager.layerAllocator.FreeSpace(layer.Size)
+
+	return nil
+}
+
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
 
-func (layermanager *LayerManager) installLayers(desiredLayers []aostypes.LayerInfo) error {
-	for _, desiredLayer := range desiredLayers {
-		if err := layermanager.installLayer(desiredLayer); err != nil {
-			return err
-		}
+// If there is no space, items of other owners are evicted by space reclaimer and allocation is retried.
+func (layermanager *LayerManager) allocateSpace(
+	allocator spaceallocator.Allocator, path string, size uint64,
+) (spaceallocator.Space, error) {
+	space, err := allocator.AllocateSpace(size)
+	if !errors.Is(err, spaceallocator.ErrNoSpace) || layermanager.spaceReclaimer == nil {
+		return space, aoserrors.Wrap(err)
+	}
+
+	if reclaimErr := layermanager.spaceReclaimer.ReclaimSpace(path, size); reclaimErr != nil {
+		log.Warnf("Can't reclaim space: %v", reclaimErr)
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if space, err = allocator.AllocateSpace(size); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return space, nil
+}
+
+func (layermanager *LayerManager) installLayers(ctx context.Context, desiredLayers []aostypes.LayerInfo) error {
+	var installErrorsMutex sync.Mutex
+
+	installErr := &progress.InstallError{
+		ItemType: progress.ItemTypeLayer, Total: len(desiredLayers), Errors: make(map[string]error),
+	}
+	actionHandler := action.New(layermanager.installConcurrency)
+
+	for _, layer := range desiredLayers {
+		layer := layer
+
+		actionHandler.Execute(layer.Digest, func(digest string) error {
+			installCtx, cancelFunc := install.WithTimeout(ctx, layermanager.installTimeout)
+			defer cancelFunc()
+
+			layermanager.sendProgress(layer, progress.Event{State: progress.StateStarted})
+
+			// Failed layer doesn't break installation of other layers
+			if err := layermanager.installLayer(installCtx, layer); err != nil {
+				log.WithFields(log.Fields{
+					"id":         layer.ID,
+					"aosVersion": layer.AosVersion,
+					"digest":     layer.Digest,
+				}).Errorf("Can't install layer: %v", err)
+
+				layermanager.sendProgress(layer, progress.Event{State: progress.StateFailed, Error: err.Error()})
+
+				installErrorsMutex.Lock()
+				installErr.Errors[layer.Digest] = err
+				installErrorsMutex.Unlock()
+
+				return err
+			}
+
+			layermanager.sendProgress(layer, progress.Event{State: progress.StateInstalled})
+
+			return nil
+		})
+	}
+
+	actionHandler.Wait()
+
+	if len(installErr.Errors) != 0 {
+		return installErr
 	}
 
 	return nil
@@ -233,6 +542,11 @@ func (layermanager *LayerManager) installLayers(desiredLayers []aostypes.LayerIn
 func (layermanager *LayerManager) updateCachedLayers(
 	desiredLayers []aostypes.LayerInfo, storeLayers []LayerInfo,
 ) (installLayers []aostypes.LayerInfo, err error) {
+	layerUsers, err := layermanager.getLayerUsers()
+	if err != nil {
+		return desiredLayers, err
+	}
+
 nextLayer:
 	for _, storeLayer := range storeLayers {
 		for i, desiredLayer := range desiredLayers {
@@ -240,6 +554,15 @@ nextLayer:
 				continue
 			}
 
+			// Quarantined layer is removed and installed again
+			if storeLayer.Quarantined {
+				if err := layermanager.deleteLayer(storeLayer.Digest); err != nil {
+					return desiredLayers, err
+				}
+
+				continue nextLayer
+			}
+
 			if storeLayer.Cached {
 				if err := layermanager.setLayerCached(storeLayer, false); err != nil {
 					return desiredLayers, aoserrors.Wrap(err)
@@ -251,8 +574,11 @@ nextLayer:
 			continue nextLayer
 		}
 
-		if !storeLayer.Cached {
-			if err := layermanager.setLayerCached(storeLayer, true); err != nil {
+		// Layer used by installed services is kept until the services are removed
+		cached := len(layerUsers[storeLayer.Digest]) == 0
+
+		if storeLayer.Cached != cached {
+			if err := layermanager.setLayerCached(storeLayer, cached); err != nil {
 				return desiredLayers, aoserrors.Wrap(err)
 			}
 		}
@@ -262,7 +588,7 @@ nextLayer:
 }
 
 func (layermanager *LayerManager) installLayer(
-	layerInfo aostypes.LayerInfo,
+	ctx context.Context, layerInfo aostypes.LayerInfo,
 ) (err error) {
 	log.WithFields(log.Fields{
 		"id":         layerInfo.ID,
@@ -270,6 +596,10 @@ func (layermanager *LayerManager) installLayer(
 		"digest":     layerInfo.Digest,
 	}).Debug("Install layer")
 
+	if err = ctx.Err(); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	extractLayerDir := filepath.Join(layermanager.extractDir, layerInfo.Digest)
 
 	if err := os.MkdirAll(extractLayerDir, 0o755); err != nil {
@@ -277,7 +607,7 @@ func (layermanager *LayerManager) installLayer(
 	}
 	defer os.RemoveAll(extractLayerDir)
 
-	layerDescriptor, spaceExtract, err := layermanager.extractPackageByURL(extractLayerDir, &layerInfo)
+	layerDescriptor, spaceExtract, err := layermanager.extractPackageByURL(ctx, extractLayerDir, &layerInfo)
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -293,13 +623,13 @@ func (layermanager *LayerManager) installLayer(
 		return aoserrors.Wrap(err)
 	}
 
-	spaceLayer, err := layermanager.layerAllocator.AllocateSpace(uint64(layerDescriptor.Size))
+	spaceLayer, err := layermanager.allocateSpace(
+		layermanager.layerAllocator, layermanager.layersDir, uint64(layerDescriptor.Size))
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	storeLayerPath := filepath.Join(layermanager.layersDir,
-		(string)(layerDescriptor.Digest.Algorithm()), layerDescriptor.Digest.Hex())
+	storeLayerPath := layermanager.blobStore.GetBlobPath(layerDescriptor.Digest)
 
 	defer func() {
 		if err != nil {
@@ -319,26 +649,63 @@ func (layermanager *LayerManager) installLayer(
 		}
 	}()
 
-	if err = unpackLayer(layerPath, storeLayerPath); err != nil {
+	contentPath := storeLayerPath
+
+	// Packed layer is unpacked to extract dir and packed into image stored in layers dir
+	if layermanager.fsImage.Format != "" {
+		contentPath = filepath.Join(extractLayerDir, unpackedLayerDir)
+	}
+
+	if err = unpackLayer(ctx, layerPath, contentPath); err != nil {
 		return err
 	}
 
+	var fsImage fsimage.Info
+
+	if layermanager.fsImage.Format != "" {
+		if fsImage, err = PackImage(ctx, contentPath, storeLayerPath,
+			layermanager.fsImage.Format, layermanager.fsImage.Verity); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	if _, _, err = layermanager.blobStore.AddBlob(
+		layerDescriptor.Digest, storeLayerPath, uint64(layerDescriptor.Size)); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Content digest is used by scrubber to verify unpacked layer
+	contentDigest, err := scrubber.HashDir(ctx, contentPath, nil)
+	if err != nil {
+		if _, releaseErr := layermanager.blobStore.ReleaseBlob(layerDescriptor.Digest); releaseErr != nil {
+			log.Errorf("Can't release layer blob: %v", releaseErr)
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
 	var osVersion string
 
 	if layerDescriptor.Platform != nil {
 		osVersion = layerDescriptor.Platform.OSVersion
 	}
 
-	if err = layermanager.layerStorage.AddLayer(LayerInfo{
-		LayerID:     layerInfo.ID,
-		Digest:      layerInfo.Digest,
-		Path:        storeLayerPath,
-		OSVersion:   osVersion,
-		Size:        uint64(layerDescriptor.Size),
-		VersionInfo: layerInfo.VersionInfo,
-		Timestamp:   time.Now().UTC(),
+	if err = layermanager.addLayer(LayerInfo{
+		LayerID:       layerInfo.ID,
+		Digest:        layerInfo.Digest,
+		Path:          storeLayerPath,
+		OSVersion:     osVersion,
+		Size:          uint64(layerDescriptor.Size),
+		VersionInfo:   layerInfo.VersionInfo,
+		Timestamp:     time.Now().UTC(),
+		ContentDigest: string(contentDigest),
+		FSImage:       fsImage,
 	}); err != nil {
-		return aoserrors.Wrap(err)
+		if _, releaseErr := layermanager.blobStore.ReleaseBlob(layerDescriptor.Digest); releaseErr != nil {
+			log.Errorf("Can't release layer blob: %v", releaseErr)
+		}
+
+		return err
 	}
 
 	log.WithFields(log.Fields{
@@ -350,6 +717,49 @@ func (layermanager *LayerManager) installLayer(
 	return nil
 }
 
+func (layermanager *LayerManager) addLayer(layer LayerInfo) error {
+	layermanager.Lock()
+	defer layermanager.Unlock()
+
+	if err := layermanager.layerStorage.AddLayer(layer); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (layermanager *LayerManager) verifyLayerImage(ctx context.Context, layer LayerInfo) error {
+	err := VerifyImage(ctx, layer.Path, layer.FSImage)
+	if err == nil {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return aoserrors.Wrap(ctx.Err())
+	}
+
+	// Layer could be removed during verification
+	if _, getErr := layermanager.layerStorage.GetLayerInfoByDigest(layer.Digest); errors.Is(getErr, ErrNotExist) {
+		return nil
+	}
+
+	return aoserrors.Errorf("%v: %w", err, scrubber.ErrCorrupted)
+}
+
+func (layermanager *LayerManager) progressEvent(layerInfo aostypes.LayerInfo) progress.Event {
+	return progress.Event{
+		ItemType: progress.ItemTypeLayer, ID: layerInfo.ID, AosVersion: layerInfo.AosVersion, Digest: layerInfo.Digest,
+	}
+}
+
+func (layermanager *LayerManager) sendProgress(layerInfo aostypes.LayerInfo, event progress.Event) {
+	item := layermanager.progressEvent(layerInfo)
+
+	event.ItemType, event.ID, event.AosVersion, event.Digest = item.ItemType, item.ID, item.AosVersion, item.Digest
+
+	progress.Send(layermanager.progressChannel, event)
+}
+
 func (layermanager *LayerManager) setLayerCached(layer LayerInfo, cached bool) error {
 	if err := layermanager.layerStorage.SetLayerCached(layer.Digest, cached); err != nil {
 		return aoserrors.Wrap(err)
@@ -396,6 +806,12 @@ func (layermanager *LayerManager) removeOutdatedLayers() error {
 		if layer.Cached &&
 			layer.Timestamp.Add(time.Hour*24*time.Duration(layermanager.layerTTLDays)).Before(time.Now()) {
 			if err := layermanager.removeLayer(layer.Digest); err != nil {
+				if errors.Is(err, ErrInUse) {
+					log.WithField("digest", layer.Digest).Warnf("Outdated layer is not removed: %v", err)
+
+					continue
+				}
+
 				return err
 			}
 
@@ -406,14 +822,31 @@ func (layermanager *LayerManager) removeOutdatedLayers() error {
 	return nil
 }
 
+// Layer used by installed services is not removed.
 func (layermanager *LayerManager) removeLayer(digest string) error {
+	users, err := layermanager.GetLayerUsers(digest)
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		return aoserrors.Errorf("%w: %s used by %s", ErrInUse, digest, layerUsersString(users))
+	}
+
+	return layermanager.deleteLayer(digest)
+}
+
+func (layermanager *LayerManager) deleteLayer(digest string) error {
+	layermanager.Lock()
+	defer layermanager.Unlock()
+
 	layer, err := layermanager.layerStorage.GetLayerInfoByDigest(digest)
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if err = os.RemoveAll(layer.Path); err != nil {
-		return aoserrors.Wrap(err)
+	if err = layermanager.releaseLayerBlob(layer); err != nil {
+		return err
 	}
 
 	if err = layermanager.layerStorage.DeleteLayerByDigest(digest); err != nil {
@@ -425,14 +858,49 @@ func (layermanager *LayerManager) removeLayer(digest string) error {
 	return nil
 }
 
+func (layermanager *LayerManager) getLayerUsers() (layerUsers map[string][]LayerUser, err error) {
+	if layermanager.usageProvider == nil {
+		return nil, nil
+	}
+
+	if layerUsers, err = layermanager.usageProvider.GetLayerUsers(); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return layerUsers, nil
+}
+
+func (layermanager *LayerManager) releaseLayerBlob(layer LayerInfo) error {
+	layerDigest := digest.NewDigestFromEncoded(
+		digest.Algorithm(filepath.Base(filepath.Dir(layer.Path))), filepath.Base(layer.Path))
+
+	if layermanager.blobStore.GetBlobPath(layerDigest) == filepath.Clean(layer.Path) {
+		if _, err := layermanager.blobStore.ReleaseBlob(layerDigest); err == nil {
+			return nil
+		}
+	}
+
+	// Layer is not in the blob store
+	if err := os.RemoveAll(layer.Path); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
 func (layermanager *LayerManager) setOutdatedLayers() error {
 	layersInfo, err := layermanager.layerStorage.GetLayersInfo()
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
+	layerUsers, err := layermanager.getLayerUsers()
+	if err != nil {
+		return err
+	}
+
 	for _, layer := range layersInfo {
-		if layer.Cached {
+		if layer.Cached && len(layerUsers[layer.Digest]) == 0 {
 			if err = layermanager.layerAllocator.AddOutdatedItem(
 				layer.Digest, layer.Size, layer.Timestamp); err != nil {
 				return aoserrors.Wrap(err)
@@ -495,8 +963,12 @@ func (layermanager *LayerManager) removeDamagedLayerFolders() error {
 }
 
 func (layermanager *LayerManager) extractPackageByURL(
-	extractDir string, layerInfo *aostypes.LayerInfo,
+	ctx context.Context, extractDir string, layerInfo *aostypes.LayerInfo,
 ) (layerDescriptor imagespec.Descriptor, space spaceallocator.Space, err error) {
+	if ociregistry.IsReference(layerInfo.URL) {
+		return layermanager.pullPackageByReference(ctx, extractDir, layerInfo)
+	}
+
 	urlVal, err := url.Parse(layerInfo.URL)
 	if err != nil {
 		return layerDescriptor, nil, aoserrors.Wrap(err)
@@ -504,33 +976,28 @@ func (layermanager *LayerManager) extractPackageByURL(
 
 	var sourceFile string
 
-	if urlVal.Scheme != "file" {
-		spaceDownload, err := layermanager.downloadAllocator.AllocateSpace(layerInfo.Size)
-		if err != nil {
-			return layerDescriptor, nil, aoserrors.Wrap(err)
-		}
-
-		defer func() {
-			if err := spaceDownload.Release(); err != nil {
-				log.Errorf("Can't release memory: %v", err)
-			}
-		}()
+	fileInfo := image.FileInfo{
+		Sha256: layerInfo.Sha256,
+		Sha512: layerInfo.Sha512,
+		Size:   layerInfo.Size,
+	}
 
-		if sourceFile, err = image.Download(context.Background(), layermanager.downloadDir, layerInfo.URL); err != nil {
+	if urlVal.Scheme != "file" {
+		// Downloaded file is checked by downloader
+		if sourceFile, err = layermanager.downloader.Download(ctx, layerInfo.URL, fileInfo,
+			progress.DownloadNotifier(layermanager.progressChannel, layermanager.progressEvent(*layerInfo))); err != nil {
 			return layerDescriptor, nil, aoserrors.Wrap(err)
 		}
 
-		defer os.RemoveAll(sourceFile)
+		defer layermanager.downloader.Release(sourceFile)
 	} else {
 		sourceFile = urlVal.Path
-	}
 
-	if err = image.CheckFileInfo(context.Background(), sourceFile, image.FileInfo{
-		Sha256: layerInfo.Sha256,
-		Sha512: layerInfo.Sha512,
-		Size:   layerInfo.Size,
-	}); err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+		layermanager.sendProgress(*layerInfo, progress.Event{State: progress.StateValidating})
+
+		if err = image.CheckFileInfo(ctx, sourceFile, fileInfo); err != nil {
+			return layerDescriptor, nil, aoserrors.Wrap(err)
+		}
 	}
 
 	size, err := image.GetUncompressedTarContentSize(sourceFile)
@@ -538,12 +1005,23 @@ func (layermanager *LayerManager) extractPackageByURL(
 		return layerDescriptor, nil, aoserrors.Wrap(err)
 	}
 
-	spaceExtract, err := layermanager.extractAllocator.AllocateSpace(uint64(size))
+	spaceExtract, err := layermanager.allocateSpace(
+		layermanager.extractAllocator, layermanager.extractDir, uint64(size))
 	if err != nil {
 		return layerDescriptor, nil, aoserrors.Wrap(err)
 	}
 
-	if err = image.UnpackTarImage(sourceFile, extractDir); err != nil {
+	defer func() {
+		if err != nil {
+			if releaseErr := spaceExtract.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
+	layermanager.sendProgress(*layerInfo, progress.Event{State: progress.StateExtracting})
+
+	if err = install.UnpackTarImage(ctx, sourceFile, extractDir); err != nil {
 		return layerDescriptor, nil, aoserrors.Wrap(err)
 	}
 
@@ -553,17 +1031,55 @@ func (layermanager *LayerManager) extractPackageByURL(
 		return layerDescriptor, nil, aoserrors.Wrap(err)
 	}
 
-	if err = json.Unmarshal(byteValue, &layerDescriptor); err != nil {
-		return layerDescriptor, nil, aoserrors.Wrap(err)
+	if layerDescriptor, err = selectLayerDescriptor(byteValue, layermanager.platform); err != nil {
+		return layerDescriptor, nil, err
 	}
 
 	return layerDescriptor, spaceExtract, nil
 }
 
+// Layer descriptor may be an image index with layer descriptors for different platforms.
+func selectLayerDescriptor(data []byte, node imagespec.Platform) (layerDescriptor imagespec.Descriptor, err error) {
+	if !platform.IsIndex(data) {
+		if err = json.Unmarshal(data, &layerDescriptor); err != nil {
+			return layerDescriptor, aoserrors.Wrap(err)
+		}
+
+		if !platform.Matches(layerDescriptor.Platform, node) {
+			return layerDescriptor, aoserrors.Errorf("%w: node %s, layer %s", platform.ErrNoMatch,
+				platform.String(&node), platform.String(layerDescriptor.Platform))
+		}
+
+		return layerDescriptor, nil
+	}
+
+	var index imagespec.Index
+
+	if err = json.Unmarshal(data, &index); err != nil {
+		return layerDescriptor, aoserrors.Wrap(err)
+	}
+
+	if layerDescriptor, err = platform.Select(index.Manifests, node); err != nil {
+		return layerDescriptor, aoserrors.Wrap(err)
+	}
+
+	return layerDescriptor, nil
+}
+
 func getValidLayerPath(layerDescriptor imagespec.Descriptor, unTarPath string) (layerPath string, err error) {
 	return filepath.Join(unTarPath, layerDescriptor.Digest.Hex()), nil
 }
 
+func layerUsersString(users []LayerUser) string {
+	usersStr := make([]string, 0, len(users))
+
+	for _, user := range users {
+		usersStr = append(usersStr, fmt.Sprintf("%s version %d", user.ServiceID, user.AosVersion))
+	}
+
+	return strings.Join(usersStr, ", ")
+}
+
 func releaseAllocatedSpace(path string, spaceLayer spaceallocator.Space) {
 	if err := os.RemoveAll(path); err != nil {
 		log.Warnf("Can't remove layer storage dir: %v", err)
@@ -574,8 +1090,8 @@ func releaseAllocatedSpace(path string, spaceLayer spaceallocator.Space) {
 	}
 }
 
-func unpackLayer(source, destination string) error {
-	if err := image.UnpackTarImage(source, destination); err != nil {
+func unpackLayer(ctx context.Context, source, destination string) error {
+	if err := install.UnpackTarImage(ctx, source, destination); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
@@ -25,10 +25,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -43,6 +46,10 @@ import (
 
 	"github.com/aoscloud/aos_servicemanager/config"
 	"github.com/aoscloud/aos_servicemanager/layermanager"
+	"github.com/aoscloud/aos_servicemanager/scrubber"
+	"github.com/aoscloud/aos_servicemanager/utils/blobstore"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
 )
 
 /***********************************************************************************************************************
@@ -60,6 +67,7 @@ const (
 
 type testLayerStorage struct {
 	layers       []layermanager.LayerInfo
+	blobs        map[string]blobstore.BlobInfo
 	addLayerFail bool
 	getLayerFail bool
 }
@@ -78,6 +86,12 @@ type testSpace struct {
 	size      uint64
 }
 
+type testUsageProvider struct {
+	sync.Mutex
+
+	layerUsers map[string][]layermanager.LayerUser
+}
+
 type testOutdatedItem struct {
 	id   string
 	size uint64
@@ -146,7 +160,7 @@ func TestProcessDesiredLayers(t *testing.T) {
 			ExtractDir:   filepath.Join(tmpDir, "extract"),
 			DownloadDir:  filepath.Join(tmpDir, "download"),
 			LayerTTLDays: 0,
-		}, testLayerStorage)
+		}, testLayerStorage, nil)
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %s", err)
 	}
@@ -196,7 +210,7 @@ func TestProcessDesiredLayers(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := layerManager.ProcessDesiredLayers(tCase.desiredLayers); err != nil {
+		if err := layerManager.ProcessDesiredLayers(context.Background(), tCase.desiredLayers); err != nil {
 			t.Errorf("Can't process desired layers: %v", err)
 		}
 
@@ -251,7 +265,7 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, testStorage)
+		}, testStorage, nil)
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -263,7 +277,7 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 		t.Fatalf("Can't create layer: %v", err)
 	}
 
-	if err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+	if err = layerManager.ProcessDesiredLayers(context.Background(), []aostypes.LayerInfo{layerInfo}); err != nil {
 		t.Fatalf("Can't process desired layers: %v", err)
 	}
 
@@ -283,7 +297,7 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, testStorage)
+		}, testStorage, nil)
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -297,7 +311,7 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 		t.Fatalf("Can't create layer: %v", err)
 	}
 
-	if err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+	if err = layerManager.ProcessDesiredLayers(context.Background(), []aostypes.LayerInfo{layerInfo}); err != nil {
 		t.Fatalf("Can't install layer: %v", err)
 	}
 
@@ -319,7 +333,7 @@ func TestRemoveDemageLayerFolder(t *testing.T) {
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, testStorage)
+		}, testStorage, nil)
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -344,7 +358,7 @@ func TestRemoteDownloadLayer(t *testing.T) {
 			LayersDir:   layersDir,
 			ExtractDir:  filepath.Join(tmpDir, "extract"),
 			DownloadDir: filepath.Join(tmpDir, "download"),
-		}, &testLayerStorage{})
+		}, &testLayerStorage{}, nil)
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -391,7 +405,7 @@ func TestRemoteDownloadLayer(t *testing.T) {
 
 	layerInfo.URL = "http://:9000/downloadImage"
 
-	if err = layerManager.ProcessDesiredLayers([]aostypes.LayerInfo{layerInfo}); err != nil {
+	if err = layerManager.ProcessDesiredLayers(context.Background(), []aostypes.LayerInfo{layerInfo}); err != nil {
 		t.Fatalf("Can't install layer: %v", err)
 	}
 }
@@ -413,7 +427,7 @@ func TestInstallLayerNotEnoughSpace(t *testing.T) {
 			ExtractDir:   filepath.Join(tmpDir, "extract"),
 			DownloadDir:  filepath.Join(tmpDir, "download"),
 			LayerTTLDays: 2,
-		}, &testLayerStorage{})
+		}, &testLayerStorage{}, nil)
 	if err != nil {
 		t.Fatalf("Can't create layer manager: %v", err)
 	}
@@ -460,12 +474,477 @@ func TestInstallLayerNotEnoughSpace(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := layerManager.ProcessDesiredLayers(tCase.desiredLayers); !errors.Is(err, tCase.processDesiredError) {
+		if err := layerManager.ProcessDesiredLayers(context.Background(), tCase.desiredLayers); !errors.Is(err, tCase.processDesiredError) {
 			t.Errorf("Can't process desired layers: %v", err)
 		}
 	}
 }
 
+func TestParallelInstallLayers(t *testing.T) {
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:          layersDir,
+			ExtractDir:         filepath.Join(tmpDir, "extract"),
+			DownloadDir:        filepath.Join(tmpDir, "download"),
+			InstallConcurrency: 3,
+		}, &testLayerStorage{}, nil)
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	var desiredLayers []aostypes.LayerInfo
+
+	for i := 0; i < 3; i++ {
+		layerInfo, err := createLayer(
+			filepath.Join(tmpDir, fmt.Sprintf("layerdir%d", i)), int64(uint64(i+1)*kilobyte), fmt.Sprintf("layer%d", i))
+		if err != nil {
+			t.Fatalf("Can't prepare layer: %v", err)
+		}
+
+		desiredLayers = append(desiredLayers, layerInfo)
+	}
+
+	// layer1 has wrong checksum and should not break installation of other layers
+	desiredLayers[1].Sha256 = []byte("wrong checksum")
+
+	err = layerManager.ProcessDesiredLayers(context.Background(), desiredLayers)
+
+	var installErr *progress.InstallError
+
+	if !errors.As(err, &installErr) {
+		t.Fatalf("Install error expected: %v", err)
+	}
+
+	if _, ok := installErr.Errors[desiredLayers[1].Digest]; !ok || len(installErr.Errors) != 1 {
+		t.Errorf("Wrong failed layers: %v", installErr.Errors)
+	}
+
+	for i, layer := range desiredLayers {
+		_, err := layerManager.GetLayerInfoByDigest(layer.Digest)
+
+		if i == 1 && err == nil {
+			t.Errorf("Layer %s should not be installed", layer.ID)
+		}
+
+		if i != 1 && err != nil {
+			t.Errorf("Can't get layer info: %v", err)
+		}
+	}
+
+	started := make(map[string]bool)
+	results := make(map[string]string)
+
+	for len(results) < len(desiredLayers) {
+		select {
+		case event := <-layerManager.GetInstallProgressChannel():
+			if event.ItemType != progress.ItemTypeLayer {
+				t.Errorf("Wrong item type: %s", event.ItemType)
+			}
+
+			switch event.State {
+			case progress.StateStarted:
+				started[event.ID] = true
+
+			case progress.StateFailed:
+				if event.Error == "" {
+					t.Errorf("Failed event of layer %s has no error", event.ID)
+				}
+
+				results[event.ID] = event.State
+
+			case progress.StateInstalled:
+				results[event.ID] = event.State
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("Wait progress event timeout")
+		}
+	}
+
+	if len(started) != len(desiredLayers) {
+		t.Errorf("Wrong started layers: %v", started)
+	}
+
+	expectedResults := map[string]string{
+		"layer0": progress.StateInstalled,
+		"layer1": progress.StateFailed,
+		"layer2": progress.StateInstalled,
+	}
+
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("Wrong install results: %v", results)
+	}
+}
+
+func TestScrubLayer(t *testing.T) {
+	testLayerStorage := &testLayerStorage{}
+
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+		}, testLayerStorage, nil)
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %s", err)
+	}
+	defer layerManager.Close()
+
+	layer, err := createLayer(filepath.Join(tmpDir, "layerdir1"), int64(1*kilobyte), "scrubLayer")
+	if err != nil {
+		t.Fatalf("Can't prepare layer: %v", err)
+	}
+
+	if err = layerManager.ProcessDesiredLayers(context.Background(), []aostypes.LayerInfo{layer}); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	items, err := layerManager.GetScrubItems()
+	if err != nil {
+		t.Fatalf("Can't get scrub items: %v", err)
+	}
+
+	if len(items) != 1 || items[0].Digest != layer.Digest {
+		t.Fatalf("Unexpected scrub items: %v", items)
+	}
+
+	if err = layerManager.VerifyItem(context.Background(), items[0], nil); err != nil {
+		t.Errorf("Can't verify layer: %v", err)
+	}
+
+	layerInfo, err := layerManager.GetLayerInfoByDigest(layer.Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(layerInfo.Path, "corrupted"), []byte("corrupted"), 0o600); err != nil {
+		t.Fatalf("Can't corrupt layer: %v", err)
+	}
+
+	if err = layerManager.VerifyItem(context.Background(), items[0], nil); !errors.Is(err, scrubber.ErrCorrupted) {
+		t.Errorf("Unexpected verify error: %v", err)
+	}
+
+	if err = layerManager.QuarantineItem(items[0]); err != nil {
+		t.Fatalf("Can't quarantine layer: %v", err)
+	}
+
+	if _, err = layerManager.GetLayerInfoByDigest(layer.Digest); !errors.Is(err, layermanager.ErrQuarantined) {
+		t.Errorf("Unexpected get layer error: %v", err)
+	}
+
+	// Quarantined layer is reinstalled
+	if err = layerManager.ProcessDesiredLayers(context.Background(), []aostypes.LayerInfo{layer}); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	if _, err = layerManager.GetLayerInfoByDigest(layer.Digest); err != nil {
+		t.Errorf("Can't get layer info: %v", err)
+	}
+}
+
+func TestRegistryLayer(t *testing.T) {
+	testLayerStorage := &testLayerStorage{}
+
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layerContentDir := filepath.Join(tmpDir, "registryLayer")
+
+	if err := os.MkdirAll(layerContentDir, 0o755); err != nil {
+		t.Fatalf("Can't create layer dir: %v", err)
+	}
+	defer os.RemoveAll(layerContentDir)
+
+	if err := ioutil.WriteFile(filepath.Join(layerContentDir, "layer.txt"), []byte("layer"), 0o600); err != nil {
+		t.Fatalf("Can't create layer file: %v", err)
+	}
+
+	layerBlob, err := exec.Command("tar", "-C", layerContentDir, "-cf", "-", "./").Output()
+	if err != nil {
+		t.Fatalf("Can't create layer blob: %v", err)
+	}
+
+	layerDescriptor := imagespec.Descriptor{
+		MediaType: imagespec.MediaTypeImageLayer, Digest: digest.FromBytes(layerBlob), Size: int64(len(layerBlob)),
+	}
+
+	manifest, err := json.Marshal(imagespec.Manifest{Layers: []imagespec.Descriptor{layerDescriptor}})
+	if err != nil {
+		t.Fatalf("Can't marshal manifest: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/aos/layer/manifests/" + digest.FromBytes(manifest).String():
+			_, _ = w.Write(manifest)
+
+		case "/v2/aos/layer/blobs/" + layerDescriptor.Digest.String():
+			_, _ = w.Write(layerBlob)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:          layersDir,
+			ExtractDir:         filepath.Join(tmpDir, "extract"),
+			DownloadDir:        filepath.Join(tmpDir, "download"),
+			InsecureRegistries: []string{host},
+		}, testLayerStorage, nil)
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %s", err)
+	}
+	defer layerManager.Close()
+
+	layer := aostypes.LayerInfo{
+		VersionInfo: aostypes.VersionInfo{AosVersion: 1},
+		ID:          "registryLayer",
+		Digest:      "sha256:registrylayer",
+		URL:         host + "/aos/layer@" + digest.FromBytes(manifest).String(),
+	}
+
+	if err = layerManager.ProcessDesiredLayers(context.Background(), []aostypes.LayerInfo{layer}); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	layerInfo, err := layerManager.GetLayerInfoByDigest(layer.Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(layerInfo.Path, "layer.txt"))
+	if err != nil {
+		t.Fatalf("Can't read layer file: %v", err)
+	}
+
+	if string(content) != "layer" {
+		t.Errorf("Wrong layer content: %s", content)
+	}
+}
+
+func TestLayerInUse(t *testing.T) {
+	testLayerStorage := &testLayerStorage{}
+	usageProvider := &testUsageProvider{}
+
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	layermanager.RemoveCachedLayersPeriod = 1 * time.Second
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:    layersDir,
+			ExtractDir:   filepath.Join(tmpDir, "extract"),
+			DownloadDir:  filepath.Join(tmpDir, "download"),
+			LayerTTLDays: 0,
+		}, testLayerStorage, usageProvider)
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %v", err)
+	}
+	defer layerManager.Close()
+
+	usedLayer, err := createLayer(filepath.Join(tmpDir, "layerdir1"), int64(1*kilobyte), "usedLayer")
+	if err != nil {
+		t.Fatalf("Can't prepare layer: %v", err)
+	}
+
+	unusedLayer, err := createLayer(filepath.Join(tmpDir, "layerdir2"), int64(2*kilobyte), "unusedLayer")
+	if err != nil {
+		t.Fatalf("Can't prepare layer: %v", err)
+	}
+
+	if err = layerManager.ProcessDesiredLayers(
+		context.Background(), []aostypes.LayerInfo{usedLayer, unusedLayer}); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	usageProvider.setLayerUsers(map[string][]layermanager.LayerUser{
+		usedLayer.Digest: {{ServiceID: "service1", AosVersion: 1}},
+	})
+
+	if err = layerManager.ProcessDesiredLayers(context.Background(), nil); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	users, err := layerManager.GetLayerUsers(usedLayer.Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer users: %v", err)
+	}
+
+	if len(users) != 1 || users[0].ServiceID != "service1" || users[0].AosVersion != 1 {
+		t.Errorf("Wrong layer users: %v", users)
+	}
+
+	// Only unused layer can be evicted
+	if len(layerAllocator.outdatedItems) != 1 || layerAllocator.outdatedItems[0].id != unusedLayer.Digest {
+		t.Errorf("Wrong outdated items: %v", layerAllocator.outdatedItems)
+	}
+
+	if err = layerAllocator.remover(usedLayer.Digest); !errors.Is(err, layermanager.ErrInUse) {
+		t.Errorf("Wrong remove error: %v", err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if _, err = layerManager.GetLayerInfoByDigest(unusedLayer.Digest); err == nil {
+		t.Error("Unused layer should be removed")
+	}
+
+	layer, err := layerManager.GetLayerInfoByDigest(usedLayer.Digest)
+	if err != nil {
+		t.Fatalf("Used layer should not be removed: %v", err)
+	}
+
+	if layer.Cached {
+		t.Error("Used layer should not be cached")
+	}
+
+	// Layer is cached when services don't use it anymore
+	usageProvider.setLayerUsers(nil)
+
+	if err = layerManager.ProcessDesiredLayers(context.Background(), nil); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	if layer, err = layerManager.GetLayerInfoByDigest(usedLayer.Digest); err != nil {
+		t.Fatalf("Can't get layer: %v", err)
+	}
+
+	if !layer.Cached {
+		t.Error("Unused layer should be cached")
+	}
+}
+
+func TestPackedLayer(t *testing.T) {
+	testLayerStorage := &testLayerStorage{}
+
+	layerAllocator = &testAllocator{}
+
+	defer func() {
+		if err := os.RemoveAll(layersDir); err != nil {
+			t.Errorf("Can't remove layers dir: %v", err)
+		}
+	}()
+
+	expectedImage := fsimage.Info{Format: fsimage.FormatErofs, Verity: fsimage.VerityFS, RootHash: "sha256:1111"}
+
+	layermanager.PackImage = func(
+		ctx context.Context, srcDir, dstDir, format, verity string,
+	) (fsimage.Info, error) {
+		if _, err := os.Stat(filepath.Join(srcDir, "layer.txt")); err != nil {
+			return fsimage.Info{}, aoserrors.Wrap(err)
+		}
+
+		if err := os.MkdirAll(dstDir, 0o755); err != nil {
+			return fsimage.Info{}, aoserrors.Wrap(err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dstDir, "image"), []byte(expectedImage.RootHash), 0o600); err != nil {
+			return fsimage.Info{}, aoserrors.Wrap(err)
+		}
+
+		return fsimage.Info{Format: format, Verity: verity, RootHash: expectedImage.RootHash}, nil
+	}
+	layermanager.VerifyImage = func(ctx context.Context, dir string, info fsimage.Info) error {
+		rootHash, err := ioutil.ReadFile(filepath.Join(dir, "image"))
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if string(rootHash) != info.RootHash {
+			return fsimage.ErrRootHashMismatch
+		}
+
+		return nil
+	}
+
+	defer func() {
+		layermanager.PackImage = fsimage.Pack
+		layermanager.VerifyImage = fsimage.Verify
+	}()
+
+	layerManager, err := layermanager.New(
+		&config.Config{
+			LayersDir:   layersDir,
+			ExtractDir:  filepath.Join(tmpDir, "extract"),
+			DownloadDir: filepath.Join(tmpDir, "download"),
+			FSImage:     config.FSImage{Format: fsimage.FormatErofs, Verity: fsimage.VerityFS},
+		}, testLayerStorage, nil)
+	if err != nil {
+		t.Fatalf("Can't create layer manager: %s", err)
+	}
+	defer layerManager.Close()
+
+	layer, err := createLayer(filepath.Join(tmpDir, "layerdir1"), int64(1*kilobyte), "packedLayer")
+	if err != nil {
+		t.Fatalf("Can't prepare layer: %v", err)
+	}
+
+	if err = layerManager.ProcessDesiredLayers(context.Background(), []aostypes.LayerInfo{layer}); err != nil {
+		t.Fatalf("Can't process desired layers: %v", err)
+	}
+
+	layerInfo, err := layerManager.GetLayerInfoByDigest(layer.Digest)
+	if err != nil {
+		t.Fatalf("Can't get layer info: %v", err)
+	}
+
+	if layerInfo.FSImage != expectedImage {
+		t.Errorf("Wrong layer image: %v", layerInfo.FSImage)
+	}
+
+	if !fsimage.IsPacked(layerInfo.Path) || layerInfo.ContentDigest == "" {
+		t.Error("Layer should be packed")
+	}
+
+	item := scrubber.Item{Type: scrubber.ItemTypeLayer, Digest: layer.Digest}
+
+	if err = layerManager.VerifyItem(context.Background(), item, nil); err != nil {
+		t.Errorf("Can't verify layer: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(layerInfo.Path, "image"), []byte("corrupted"), 0o600); err != nil {
+		t.Fatalf("Can't corrupt layer: %v", err)
+	}
+
+	if err = layerManager.VerifyItem(context.Background(), item, nil); !errors.Is(err, scrubber.ErrCorrupted) {
+		t.Errorf("Unexpected verify error: %v", err)
+	}
+}
+
 /***********************************************************************************************************************
  * Interfaces
  **********************************************************************************************************************/
@@ -553,6 +1032,20 @@ func (space *testSpace) Release() error {
 	return nil
 }
 
+func (provider *testUsageProvider) setLayerUsers(layerUsers map[string][]layermanager.LayerUser) {
+	provider.Lock()
+	defer provider.Unlock()
+
+	provider.layerUsers = layerUsers
+}
+
+func (provider *testUsageProvider) GetLayerUsers() (map[string][]layermanager.LayerUser, error) {
+	provider.Lock()
+	defer provider.Unlock()
+
+	return provider.layerUsers, nil
+}
+
 func (infoProvider *testLayerStorage) AddLayer(layerInfo layermanager.LayerInfo) (err error) {
 	if infoProvider.addLayerFail {
 		return aoserrors.New("can't add layer")
@@ -615,6 +1108,30 @@ func (infoProvider *testLayerStorage) SetLayerCached(digest string, cached bool)
 	return aoserrors.New("layer not found")
 }
 
+func (infoProvider *testLayerStorage) SetLayerContentDigest(digest string, contentDigest string) error {
+	for i, layer := range infoProvider.layers {
+		if layer.Digest == digest {
+			infoProvider.layers[i].ContentDigest = contentDigest
+
+			return nil
+		}
+	}
+
+	return aoserrors.New("layer not found")
+}
+
+func (infoProvider *testLayerStorage) SetLayerQuarantined(digest string, quarantined bool) error {
+	for i, layer := range infoProvider.layers {
+		if layer.Digest == digest {
+			infoProvider.layers[i].Quarantined = quarantined
+
+			return nil
+		}
+	}
+
+	return aoserrors.New("layer not found")
+}
+
 func (infoProvider *testLayerStorage) SetLayerTimestamp(digest string, timestamp time.Time) error {
 	for i, layer := range infoProvider.layers {
 		if layer.Digest == digest {
@@ -627,6 +1144,51 @@ func (infoProvider *testLayerStorage) SetLayerTimestamp(digest string, timestamp
 	return aoserrors.New("layer not found")
 }
 
+func (infoProvider *testLayerStorage) AddBlob(blob blobstore.BlobInfo) error {
+	if infoProvider.blobs == nil {
+		infoProvider.blobs = make(map[string]blobstore.BlobInfo)
+	}
+
+	infoProvider.blobs[blob.Path] = blob
+
+	return nil
+}
+
+func (infoProvider *testLayerStorage) GetBlob(path string) (blobstore.BlobInfo, error) {
+	blob, ok := infoProvider.blobs[path]
+	if !ok {
+		return blob, blobstore.ErrNotExist
+	}
+
+	return blob, nil
+}
+
+func (infoProvider *testLayerStorage) GetBlobs() (blobs []blobstore.BlobInfo, err error) {
+	for _, blob := range infoProvider.blobs {
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, nil
+}
+
+func (infoProvider *testLayerStorage) SetBlobRefCount(path string, refCount uint64) error {
+	blob, ok := infoProvider.blobs[path]
+	if !ok {
+		return blobstore.ErrNotExist
+	}
+
+	blob.RefCount = refCount
+	infoProvider.blobs[path] = blob
+
+	return nil
+}
+
+func (infoProvider *testLayerStorage) RemoveBlob(path string) error {
+	delete(infoProvider.blobs, path)
+
+	return nil
+}
+
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layermanager
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
+	"github.com/aoscloud/aos_common/spaceallocator"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/utils/ociregistry"
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// Layer is pulled as single layer image. Layer blob is stored into extract dir the same way as unpacked layer
+// package.
+func (layermanager *LayerManager) pullPackageByReference(
+	ctx context.Context, extractDir string, layerInfo *aostypes.LayerInfo,
+) (layerDescriptor imagespec.Descriptor, space spaceallocator.Space, err error) {
+	ref, err := ociregistry.ParseReference(layerInfo.URL)
+	if err != nil {
+		return layerDescriptor, nil, aoserrors.Wrap(err)
+	}
+
+	var expectedDigest digest.Digest
+
+	if len(layerInfo.Sha256) > 0 {
+		expectedDigest = digest.NewDigestFromEncoded(digest.SHA256, hex.EncodeToString(layerInfo.Sha256))
+	}
+
+	manifestJSON, err := layermanager.registry.GetPlatformManifest(ctx, ref, expectedDigest, layermanager.platform)
+	if err != nil {
+		return layerDescriptor, nil, aoserrors.Wrap(err)
+	}
+
+	var manifest imagespec.Manifest
+
+	if err = json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return layerDescriptor, nil, aoserrors.Wrap(err)
+	}
+
+	if len(manifest.Layers) != 1 {
+		return layerDescriptor, nil, aoserrors.Errorf("layer image should contain one layer: %s", ref)
+	}
+
+	layerDescriptor = manifest.Layers[0]
+
+	if !platform.Matches(layerDescriptor.Platform, layermanager.platform) {
+		return layerDescriptor, nil, aoserrors.Errorf("%w: node %s, layer %s", platform.ErrNoMatch,
+			platform.String(&layermanager.platform), platform.String(layerDescriptor.Platform))
+	}
+
+	if space, err = layermanager.allocateSpace(
+		layermanager.extractAllocator, layermanager.extractDir, uint64(layerDescriptor.Size)); err != nil {
+		return layerDescriptor, nil, aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			if releaseErr := space.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
+	if err = layermanager.registry.GetBlob(ctx, ref, layerDescriptor,
+		filepath.Join(extractDir, layerDescriptor.Digest.Hex()),
+		progress.DownloadNotifier(layermanager.progressChannel, layermanager.progressEvent(*layerInfo))); err != nil {
+		return layerDescriptor, nil, aoserrors.Wrap(err)
+	}
+
+	return layerDescriptor, space, nil
+}
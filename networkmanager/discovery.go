@@ -0,0 +1,406 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package networkmanager provides set of API to configure network
+package networkmanager
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	dnsHostsFileName   = "addnhosts"
+	dnsPidFileName     = "pidfile"
+	dnsConfFileName    = "dnsmasq.conf"
+	dnsLockFileName    = "lock"
+	dnsRecordsFileName = "aosrecords.conf"
+	dnsEndpointMark    = "# aos endpoint"
+)
+
+const (
+	dnsStopTimeout   = 5 * time.Second
+	dnsStopPollDelay = 10 * time.Millisecond
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// DNSNameConfDir dnsname plugin configuration directory. Used to be able to mock in tests.
+// nolint:gochecknoglobals
+var DNSNameConfDir = "/run/containers/cni/dnsname"
+
+// DNSMasqPath dnsmasq binary used to restart DNS server of the network. Used to be able to mock in tests.
+// nolint:gochecknoglobals
+var DNSMasqPath = "dnsmasq"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// ServiceEndpoint endpoint published by running instance inside its provider network.
+type ServiceEndpoint struct {
+	aostypes.InstanceIdent
+	InstanceID string
+	NetworkID  string
+	IP         string
+	Hostname   string
+	Ports      []string
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// GetServiceEndpoints returns endpoints published by running instances of the service.
+func (manager *NetworkManager) GetServiceEndpoints(serviceID string) (endpoints []ServiceEndpoint) {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	for _, instances := range manager.instancesData {
+		for _, networkInstanceData := range instances {
+			if networkInstanceData.endpoint != nil && networkInstanceData.endpoint.ServiceID == serviceID {
+				endpoints = append(endpoints, *networkInstanceData.endpoint)
+			}
+		}
+	}
+
+	sortServiceEndpoints(endpoints)
+
+	return endpoints
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (manager *NetworkManager) registerServiceEndpoint(
+	instanceID, networkID, instanceIP string, params NetworkParams,
+) error {
+	if params.ServiceID == "" || params.SubjectID == "" || len(params.ExposedPorts) == 0 {
+		return nil
+	}
+
+	endpoint := &ServiceEndpoint{
+		InstanceIdent: params.InstanceIdent,
+		InstanceID:    instanceID,
+		NetworkID:     networkID,
+		IP:            instanceIP,
+		Hostname:      fmt.Sprintf("%d.%s.%s.%s", params.Instance, params.SubjectID, params.ServiceID, networkID),
+	}
+
+	// ExposedPorts format port/protocol
+	for _, exposedPort := range params.ExposedPorts {
+		portConfig := strings.Split(exposedPort, "/")
+
+		port := portConfig[0] + "/tcp"
+		if len(portConfig) == exposePortConfigExpectedLen {
+			port = portConfig[0] + "/" + strings.ToLower(portConfig[1])
+		}
+
+		endpoint.Ports = append(endpoint.Ports, port)
+	}
+
+	manager.Lock()
+	defer manager.Unlock()
+
+	networkInstanceData, ok := manager.instancesData[networkID][instanceID]
+	if !ok {
+		return aoserrors.Errorf("can't find network instanceID: %s", instanceID)
+	}
+
+	networkInstanceData.endpoint = endpoint
+
+	manager.instancesData[networkID][instanceID] = networkInstanceData
+
+	return manager.updateDNSRecords(networkID)
+}
+
+// Should be called under manager lock. A records are published in dnsname plugin additional hosts file, SRV and TXT
+// records are published in dnsmasq config file included into the plugin dnsmasq config.
+func (manager *NetworkManager) updateDNSRecords(networkID string) error {
+	networkDir := path.Join(DNSNameConfDir, networkID)
+
+	// dnsmasq is not started for this network yet
+	if _, err := os.Stat(networkDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	// dnsname plugin modifies the files under the same lock
+	unlock, err := lockDNSConfig(networkDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := ioutil.ReadFile(path.Join(networkDir, dnsHostsFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	var (
+		endpoints []ServiceEndpoint
+		hosts     strings.Builder
+	)
+
+	for _, networkInstanceData := range manager.instancesData[networkID] {
+		if networkInstanceData.endpoint != nil {
+			endpoints = append(endpoints, *networkInstanceData.endpoint)
+		}
+	}
+
+	sortServiceEndpoints(endpoints)
+
+	// Keep hosts added by dnsname plugin and replace previously published endpoints
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasSuffix(line, dnsEndpointMark) {
+			continue
+		}
+
+		hosts.WriteString(line + "\n")
+	}
+
+	for _, endpoint := range endpoints {
+		hosts.WriteString(fmt.Sprintf("%s\t%s %s\n", endpoint.IP, endpoint.Hostname, dnsEndpointMark))
+	}
+
+	if err = ioutil.WriteFile(
+		path.Join(networkDir, dnsHostsFileName), []byte(hosts.String()), 0o644); err != nil { // nolint:gosec
+		return aoserrors.Wrap(err)
+	}
+
+	recordsChanged, err := writeDNSRecords(networkDir, endpoints)
+	if err != nil {
+		return err
+	}
+
+	// dnsmasq reads SRV and TXT records on start only
+	if recordsChanged {
+		return restartDNSServer(networkID)
+	}
+
+	return reloadDNSHosts(networkID)
+}
+
+func lockDNSConfig(networkDir string) (unlock func(), err error) {
+	lockFile, err := os.OpenFile(path.Join(networkDir, dnsLockFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return func() {
+		if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN); err != nil {
+			log.Errorf("Can't unlock DNS config: %v", err)
+		}
+
+		lockFile.Close()
+	}, nil
+}
+
+// SRV records are published as _<service>._<proto>.<network> pointing to instance hostnames, TXT records of instance
+// hostnames contain instance ident and exposed ports.
+func writeDNSRecords(networkDir string, endpoints []ServiceEndpoint) (changed bool, err error) {
+	var records strings.Builder
+
+	for _, endpoint := range endpoints {
+		txt := []string{
+			endpoint.Hostname,
+			"serviceid=" + endpoint.ServiceID, "subjectid=" + endpoint.SubjectID,
+			"instance=" + strconv.FormatUint(endpoint.Instance, 10),
+		}
+
+		for _, port := range endpoint.Ports {
+			portConfig := strings.Split(port, "/")
+
+			records.WriteString(fmt.Sprintf("srv-host=_%s._%s.%s,%s,%s\n",
+				endpoint.ServiceID, portConfig[1], endpoint.NetworkID, endpoint.Hostname, portConfig[0]))
+
+			txt = append(txt, "port="+port)
+		}
+
+		records.WriteString("txt-record=" + strings.Join(txt, ",") + "\n")
+	}
+
+	recordsFile := path.Join(networkDir, dnsRecordsFileName)
+
+	data, err := ioutil.ReadFile(recordsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, aoserrors.Wrap(err)
+	}
+
+	if err == nil && string(data) == records.String() {
+		return false, nil
+	}
+
+	if err = ioutil.WriteFile(recordsFile, []byte(records.String()), 0o644); err != nil { // nolint:gosec
+		return false, aoserrors.Wrap(err)
+	}
+
+	// Include records into config generated by dnsname plugin
+	confFile := path.Join(networkDir, dnsConfFileName)
+	includeLine := "conf-file=" + recordsFile
+
+	conf, err := ioutil.ReadFile(confFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+
+		return false, aoserrors.Wrap(err)
+	}
+
+	if !slices.Contains(strings.Split(string(conf), "\n"), includeLine) {
+		if len(conf) != 0 && !strings.HasSuffix(string(conf), "\n") {
+			conf = append(conf, '\n')
+		}
+
+		if err = ioutil.WriteFile(
+			confFile, append(conf, []byte(includeLine+"\n")...), 0o644); err != nil { // nolint:gosec
+			return false, aoserrors.Wrap(err)
+		}
+	}
+
+	return true, nil
+}
+
+func getDNSServerPid(networkID string) (pid int, err error) {
+	data, err := ioutil.ReadFile(path.Join(DNSNameConfDir, networkID, dnsPidFileName))
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if pid, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return pid, nil
+}
+
+// dnsmasq rereads additional hosts files on SIGHUP.
+func reloadDNSHosts(networkID string) error {
+	pid, err := getDNSServerPid(networkID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err = syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			log.WithField("networkID", networkID).Warn("dnsmasq is not running")
+
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// dnsmasq is started the same way as dnsname plugin does.
+func restartDNSServer(networkID string) error {
+	pid, err := getDNSServerPid(networkID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	log.WithField("networkID", networkID).Debug("Restart dnsmasq")
+
+	if err = stopProcess(pid); err != nil {
+		return err
+	}
+
+	confFile := path.Join(DNSNameConfDir, networkID, dnsConfFileName)
+
+	if output, err := exec.Command(DNSMasqPath, "-u", "root", "--conf-file="+confFile).CombinedOutput(); err != nil {
+		return aoserrors.Errorf("can't start dnsmasq: %v, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+func stopProcess(pid int) error {
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	for start := time.Now(); time.Since(start) < dnsStopTimeout; time.Sleep(dnsStopPollDelay) {
+		if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+	}
+
+	return aoserrors.Errorf("process %d is not stopped", pid)
+}
+
+func sortServiceEndpoints(endpoints []ServiceEndpoint) {
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].ServiceID != endpoints[j].ServiceID {
+			return endpoints[i].ServiceID < endpoints[j].ServiceID
+		}
+
+		if endpoints[i].SubjectID != endpoints[j].SubjectID {
+			return endpoints[i].SubjectID < endpoints[j].SubjectID
+		}
+
+		return endpoints[i].Instance < endpoints[j].Instance
+	})
+}
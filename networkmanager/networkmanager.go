@@ -23,12 +23,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"os"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aoscloud/aos_common/aoserrors"
 	"github.com/aoscloud/aos_common/aostypes"
@@ -64,8 +64,11 @@ const (
  **********************************************************************************************************************/
 
 type netInstanceData struct {
-	instanceIP string
-	hosts      []string
+	instanceIP   string
+	hosts        []string
+	portMappings []PortMapping
+	endpoint     *ServiceEndpoint
+	restored     bool
 }
 
 // NetworkManager network manager instance.
@@ -94,6 +97,7 @@ type NetworkParams struct {
 	ResolvConfFilePath string
 	UploadLimit        uint64
 	DownloadLimit      uint64
+	PortMappings       []PortMapping
 }
 
 type cniNetwork struct {
@@ -173,7 +177,9 @@ var (
  **********************************************************************************************************************/
 
 // New creates network manager instance.
-func New(cfg *config.Config, trafficStorage TrafficStorage) (manager *NetworkManager, err error) {
+func New(
+	cfg *config.Config, trafficStorage TrafficStorage, alertSender AlertSender,
+) (manager *NetworkManager, err error) {
 	log.Debug("Create network manager")
 
 	cniDir := path.Join(cfg.WorkingDir, "cni")
@@ -196,16 +202,12 @@ func New(cfg *config.Config, trafficStorage TrafficStorage) (manager *NetworkMan
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if err = manager.deleteAllNetworks(); err != nil {
-		log.Errorf("Can't delete all networks: %s", err)
-	}
-
-	if err = os.RemoveAll(cniDir); err != nil {
-		return nil, aoserrors.Wrap(err)
+	if err = manager.restoreNetworks(); err != nil {
+		log.Errorf("Can't restore networks: %s", err)
 	}
 
 	if trafficStorage != nil {
-		manager.trafficMonitoring, err = newTrafficMonitor(trafficStorage)
+		manager.trafficMonitoring, err = newTrafficMonitor(cfg.TrafficMonitoring, trafficStorage, alertSender)
 		if err != nil {
 			return manager, err
 		}
@@ -238,7 +240,9 @@ func (manager *NetworkManager) GetNetnsPath(instanceID string) (pathToNetNS stri
 func (manager *NetworkManager) AddInstanceToNetwork(instanceID, networkID string, params NetworkParams) error {
 	log.WithFields(log.Fields{"instanceID": instanceID, "networkID": networkID}).Debug("Add instance to network")
 
-	if manager.isInstanceInNetwork(instanceID, networkID) {
+	restored := manager.isInstanceRestored(instanceID, networkID)
+
+	if !restored && manager.isInstanceInNetwork(instanceID, networkID) {
 		return aoserrors.Errorf("Instance %s already in the network %s", instanceID, networkID)
 	}
 
@@ -247,6 +251,18 @@ func (manager *NetworkManager) AddInstanceToNetwork(instanceID, networkID string
 		return err
 	}
 
+	if params.PortMappings, err = normalizePortMappings(params.PortMappings); err != nil {
+		return err
+	}
+
+	if restored {
+		var reattached bool
+
+		if reattached, err = manager.reattachInstance(instanceID, networkID, ipSubnet, params); err != nil || reattached {
+			return err
+		}
+	}
+
 	manager.addInstanceNetworkToCache(instanceID, networkID)
 
 	defer func() {
@@ -257,6 +273,10 @@ func (manager *NetworkManager) AddInstanceToNetwork(instanceID, networkID string
 		}
 	}()
 
+	if err = manager.reservePortMappings(instanceID, networkID, params.PortMappings); err != nil {
+		return err
+	}
+
 	if err = createNetNS(instanceID); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -293,7 +313,7 @@ func (manager *NetworkManager) AddInstanceToNetwork(instanceID, networkID string
 
 	if manager.trafficMonitoring != nil {
 		if err = manager.trafficMonitoring.startInstanceTrafficMonitor(
-			instanceID, instanceIP, params.DownloadLimit, params.UploadLimit); err != nil {
+			params.InstanceIdent, instanceID, instanceIP, params.DownloadLimit, params.UploadLimit); err != nil {
 			return aoserrors.Wrap(err)
 		}
 	}
@@ -302,6 +322,10 @@ func (manager *NetworkManager) AddInstanceToNetwork(instanceID, networkID string
 		return err
 	}
 
+	if err = manager.registerServiceEndpoint(instanceID, networkID, instanceIP, params); err != nil {
+		return err
+	}
+
 	log.WithFields(log.Fields{
 		"instanceID": instanceID,
 		"IP":         instanceIP,
@@ -358,7 +382,7 @@ func (manager *NetworkManager) GetSystemTraffic() (inputTraffic, outputTraffic u
 		return 0, 0, err
 	}
 
-	return inputTrafficData.currentValue, outputTrafficData.currentValue, nil
+	return inputTrafficData.primaryValue(), outputTrafficData.primaryValue(), nil
 }
 
 func (manager *NetworkManager) GetInstanceTraffic(instanceID string) (inputTraffic, outputTraffic uint64, err error) {
@@ -377,7 +401,51 @@ func (manager *NetworkManager) GetInstanceTraffic(instanceID string) (inputTraff
 		return 0, 0, err
 	}
 
-	return inTrafficData.currentValue, outTrafficData.currentValue, nil
+	return inTrafficData.primaryValue(), outTrafficData.primaryValue(), nil
+}
+
+// GetInstanceTrafficCounters returns values of all configured traffic counters for instance.
+func (manager *NetworkManager) GetInstanceTrafficCounters(instanceID string) ([]TrafficCounterInfo, error) {
+	if manager.trafficMonitoring == nil {
+		return nil, errTrafficMonitorDisable
+	}
+
+	return manager.trafficMonitoring.getInstanceCounters(instanceID)
+}
+
+// GetSystemTrafficHistory returns system traffic history for the specified time range.
+func (manager *NetworkManager) GetSystemTrafficHistory(from, till time.Time) ([]TrafficHistoryItem, error) {
+	if manager.trafficMonitoring == nil {
+		return nil, errTrafficMonitorDisable
+	}
+
+	return manager.trafficMonitoring.getTrafficHistory("", from, till)
+}
+
+// GetInstanceTrafficHistory returns instance traffic history for the specified time range.
+func (manager *NetworkManager) GetInstanceTrafficHistory(
+	instanceID string, from, till time.Time,
+) ([]TrafficHistoryItem, error) {
+	if manager.trafficMonitoring == nil {
+		return nil, errTrafficMonitorDisable
+	}
+
+	return manager.trafficMonitoring.getTrafficHistory(instanceID, from, till)
+}
+
+// GetTrafficHistory returns traffic history of the instance or system traffic history if instance is not set.
+func (manager *NetworkManager) GetTrafficHistory(
+	instanceIdent *aostypes.InstanceIdent, from, till time.Time,
+) ([]TrafficHistoryItem, error) {
+	if manager.trafficMonitoring == nil {
+		return nil, errTrafficMonitorDisable
+	}
+
+	if instanceIdent == nil {
+		return manager.trafficMonitoring.getTrafficHistory("", from, till)
+	}
+
+	return manager.trafficMonitoring.getInstanceTrafficHistory(*instanceIdent, from, till)
 }
 
 func (manager *NetworkManager) SetTrafficPeriod(period int) error {
@@ -389,7 +457,7 @@ func (manager *NetworkManager) SetTrafficPeriod(period int) error {
 		return errors.New("failed to set traffic period, unexpected value")
 	}
 
-	manager.trafficMonitoring.trafficPeriod = period
+	manager.trafficMonitoring.setTrafficPeriod(period)
 
 	return nil
 }
@@ -411,6 +479,7 @@ func (manager *NetworkManager) updateInstanceNetworkCache(
 
 	networkInstanceData.hosts = hosts
 	networkInstanceData.instanceIP = instanceIP
+	networkInstanceData.restored = false
 
 	manager.instancesData[networkID][instanceID] = networkInstanceData
 
@@ -432,6 +501,8 @@ func (manager *NetworkManager) deleteInstanceNetworkFromCache(instanceID, networ
 	manager.Lock()
 	defer manager.Unlock()
 
+	networkInstanceData := manager.instancesData[networkID][instanceID]
+
 	delete(manager.instancesData[networkID], instanceID)
 	networkEmpty := len(manager.instancesData[networkID]) == 0
 
@@ -439,6 +510,10 @@ func (manager *NetworkManager) deleteInstanceNetworkFromCache(instanceID, networ
 		return manager.clearNetwork(networkID)
 	}
 
+	if networkInstanceData.endpoint != nil {
+		return manager.updateDNSRecords(networkID)
+	}
+
 	return nil
 }
 
@@ -506,7 +581,7 @@ func (manager *NetworkManager) prepareCNIConfig(
 	instanceID, networkID string, ipSubnet *net.IPNet, params NetworkParams) (
 	netConfig *cni.NetworkConfigList, runtimeConfig *cni.RuntimeConf, hosts []string, err error,
 ) {
-	if hosts, err = manager.prepareHostnameList(networkID, params); err != nil {
+	if hosts, err = manager.prepareHostnameList(instanceID, networkID, params); err != nil {
 		return nil, nil, nil, err
 	}
 
@@ -518,30 +593,7 @@ func (manager *NetworkManager) prepareCNIConfig(
 		return nil, nil, nil, aoserrors.Wrap(err)
 	}
 
-	return netConfig, manager.prepareRuntimeConfig(instanceID, networkID, hosts), hosts, nil
-}
-
-func (manager *NetworkManager) deleteAllNetworks() error {
-	log.Debug("Delete all networks")
-
-	filesNetworkID, err := ioutil.ReadDir(manager.networkDir)
-	if err != nil {
-		return nil // nolint:nilerr
-	}
-
-	for _, networkIDFile := range filesNetworkID {
-		if networkErr := manager.deleteNetworkInstances(networkIDFile.Name()); networkErr != nil {
-			log.Errorf("Can't delete network: %s", err)
-
-			if err == nil {
-				err = networkErr
-			}
-		}
-	}
-
-	os.RemoveAll(manager.networkDir)
-
-	return aoserrors.Wrap(err)
+	return netConfig, manager.prepareRuntimeConfig(instanceID, networkID, hosts, params.PortMappings), hosts, nil
 }
 
 func (manager *NetworkManager) isInstanceInNetwork(instanceID, networkID string) (status bool) {
@@ -557,56 +609,6 @@ func (manager *NetworkManager) isInstanceInNetwork(instanceID, networkID string)
 	return false
 }
 
-func (manager *NetworkManager) deleteNetworkInstances(networkID string) error {
-	log.WithFields(log.Fields{"networkID": networkID}).Debug("Delete network instances")
-
-	networkDir := path.Join(manager.networkDir, networkID)
-
-	if _, err := os.Stat(networkDir); err != nil {
-		log.WithFields(log.Fields{"networkID": networkID}).Warn("Network doesn't exist")
-		return nil // nolint:nilerr
-	}
-
-	filesInstanceID, err := ioutil.ReadDir(networkDir)
-	if err != nil {
-		return aoserrors.Wrap(err)
-	}
-
-skip:
-	for _, instanceIDFile := range filesInstanceID {
-		for _, skipFile := range skipNetworkFileNames {
-			if instanceIDFile.Name() == skipFile {
-				continue skip
-			}
-		}
-
-		if netErr := manager.tryRemoveInstanceFromNetwork(instanceIDFile.Name(), networkID); netErr != nil {
-			if err == nil {
-				err = netErr
-			}
-		}
-	}
-
-	if clearErr := manager.clearNetwork(networkID); clearErr != nil && err == nil {
-		err = clearErr
-	}
-
-	return err
-}
-
-func (manager *NetworkManager) tryRemoveInstanceFromNetwork(instanceFileName, networkID string) error {
-	instanceID, err := readInstanceIDFromFile(path.Join(manager.networkDir, networkID, instanceFileName))
-	if err != nil {
-		return nil // nolint:nilerr
-	}
-
-	if err := manager.removeInstanceFromNetwork(instanceID, networkID); err != nil {
-		return aoserrors.Wrap(err)
-	}
-
-	return nil
-}
-
 func (manager *NetworkManager) clearNetwork(networkID string) error {
 	log.WithFields(log.Fields{"networkID": networkID}).Debug("Clear network")
 
@@ -672,9 +674,9 @@ func (manager *NetworkManager) postNetworkClear(networkID string) error {
 	return nil
 }
 
-func (manager *NetworkManager) prepareRuntimeConfig(instanceID, networkID string, hosts []string) (
-	runtimeConfig *cni.RuntimeConf,
-) {
+func (manager *NetworkManager) prepareRuntimeConfig(
+	instanceID, networkID string, hosts []string, portMappings []PortMapping,
+) (runtimeConfig *cni.RuntimeConf) {
 	runtimeConfig = &cni.RuntimeConf{
 		ContainerID: instanceID,
 		NetNS:       manager.GetNetnsPath(instanceID),
@@ -690,10 +692,14 @@ func (manager *NetworkManager) prepareRuntimeConfig(instanceID, networkID string
 		runtimeConfig.CapabilityArgs["aliases"] = map[string][]string{networkID: hosts}
 	}
 
+	if len(portMappings) != 0 {
+		runtimeConfig.CapabilityArgs["portMappings"] = portMappings
+	}
+
 	return runtimeConfig
 }
 
-func (manager *NetworkManager) isHostnameExists(networkID string, hosts []string) error {
+func (manager *NetworkManager) isHostnameExists(instanceID, networkID string, hosts []string) error {
 	manager.RLock()
 	defer manager.RUnlock()
 
@@ -702,7 +708,11 @@ func (manager *NetworkManager) isHostnameExists(networkID string, hosts []string
 		return nil
 	}
 
-	for _, networkInstanceData := range instances {
+	for existInstanceID, networkInstanceData := range instances {
+		if existInstanceID == instanceID {
+			continue
+		}
+
 		for _, existHostname := range networkInstanceData.hosts {
 			for _, newHostname := range hosts {
 				if existHostname == newHostname {
@@ -715,7 +725,9 @@ func (manager *NetworkManager) isHostnameExists(networkID string, hosts []string
 	return nil
 }
 
-func (manager *NetworkManager) prepareHostnameList(networkID string, params NetworkParams) (hosts []string, err error) {
+func (manager *NetworkManager) prepareHostnameList(
+	instanceID, networkID string, params NetworkParams,
+) (hosts []string, err error) {
 	hosts = append(hosts, params.Aliases...)
 
 	if params.Hostname != "" {
@@ -733,7 +745,7 @@ func (manager *NetworkManager) prepareHostnameList(networkID string, params Netw
 	if len(hosts) != 0 {
 		hosts = tryAppendDomainNameToHostname(hosts, networkID)
 
-		if err = manager.isHostnameExists(networkID, hosts); err != nil {
+		if err = manager.isHostnameExists(instanceID, networkID, hosts); err != nil {
 			return nil, aoserrors.Wrap(err)
 		}
 	}
@@ -928,13 +940,25 @@ func prepareNetworkConfigList(networkDir, instanceID, networkID string, subnetwo
 
 	// Firewall
 
-	firewallConfig, err := getFirewallPluginConfig(instanceID, params.ExposedPorts, params.AllowedConnections)
+	firewallConfig, err := getFirewallPluginConfig(
+		instanceID, appendPublishedPorts(params.ExposedPorts, params.PortMappings), params.AllowedConnections)
 	if err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
 	networkConfig.Plugins = append(networkConfig.Plugins, firewallConfig)
 
+	// Port mapping
+
+	if len(params.PortMappings) > 0 {
+		portMapConfig, err := getPortMapPluginConfig()
+		if err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		networkConfig.Plugins = append(networkConfig.Plugins, portMapConfig)
+	}
+
 	// Bandwidth
 
 	if params.IngressKbit > 0 || params.EgressKbit > 0 {
@@ -20,23 +20,29 @@ package networkmanager_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
+	"os/exec"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 	"unicode"
 
 	"github.com/aoscloud/aos_common/aoserrors"
 	"github.com/aoscloud/aos_common/aostypes"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
 	cni "github.com/containernetworking/cni/libcni"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 
 	"github.com/aoscloud/aos_servicemanager/config"
 	"github.com/aoscloud/aos_servicemanager/networkmanager"
@@ -49,6 +55,7 @@ import (
 type testCNIInterface struct {
 	networkConfig        *cni.NetworkConfigList
 	runtimeConfig        *cni.RuntimeConf
+	result               types.Result
 	errorAddNetwork      bool
 	emptyIPAddress       bool
 	errorValidateNetwork bool
@@ -82,14 +89,22 @@ type testTrafficMonitoringData struct {
 type trafficData struct {
 	lastUpdate   time.Time
 	currentValue uint64
+	alertLevel   int
 }
 
 type testTrafficStorage struct {
 	chains             map[string]trafficData
+	history            map[string][]networkmanager.TrafficHistoryItem
+	instances          map[aostypes.InstanceIdent]string
 	disableSaveTraffic bool
 	disableLoadTraffic bool
 }
 
+type testAlertSender struct {
+	sync.Mutex
+	alerts []cloudprotocol.AlertItem
+}
+
 type iptablesData struct {
 	countChain int
 	limit      uint64
@@ -160,7 +175,7 @@ func TestDeleteAllNetwork(t *testing.T) {
 
 	networkmanager.CNIPlugins = &testCNIInterface{}
 
-	manager, err := networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil)
+	manager, err := networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -187,7 +202,7 @@ func TestBaseNetwork(t *testing.T) {
 		networkmanager.GetIPSubnet = nil
 	}()
 
-	manager, err := networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil)
+	manager, err := networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -323,7 +338,7 @@ func TestFirewallPlugin(t *testing.T) {
 		networkmanager.GetIPSubnet = nil
 	}()
 
-	manager, err := networkmanager.New(&config.Config{}, nil)
+	manager, err := networkmanager.New(&config.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -381,7 +396,7 @@ func TestBandwithPlugin(t *testing.T) {
 		networkmanager.GetIPSubnet = nil
 	}()
 
-	manager, err := networkmanager.New(&config.Config{}, nil)
+	manager, err := networkmanager.New(&config.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -402,6 +417,107 @@ func TestBandwithPlugin(t *testing.T) {
 	}
 }
 
+func TestPortMapPlugin(t *testing.T) {
+	cniInterface := &testCNIInterface{}
+
+	networkmanager.CNIPlugins = cniInterface
+	networkmanager.GetIPSubnet = getIPSubnet
+
+	defer func() {
+		networkmanager.GetIPSubnet = nil
+	}()
+
+	manager, err := networkmanager.New(&config.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	portMappings := []networkmanager.PortMapping{
+		{HostPort: 8080, ContainerPort: 80},
+		{HostIP: "127.0.0.1", HostPort: 5353, ContainerPort: 53, Protocol: "UDP"},
+	}
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", networkmanager.NetworkParams{
+		ExposedPorts: []string{"80"},
+		PortMappings: portMappings,
+	}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	expectedConfig := createPlugins([]string{
+		createBridgePlugin(""),
+		strings.TrimSuffix(createFirewallPlugin("80", ""), `]}`) + `,{"port":"53","protocol":"udp"}]}`,
+		`{"type":"portmap","snat":true,"capabilities":{"portMappings":true}}`,
+		createDNSPlugin(),
+	})
+
+	if string(cniInterface.networkConfig.Bytes) != expectedConfig {
+		t.Errorf("Wrong network config: %s", string(cniInterface.networkConfig.Bytes))
+	}
+
+	expectedMappings := []networkmanager.PortMapping{
+		{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		{HostIP: "127.0.0.1", HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+	}
+
+	if !reflect.DeepEqual(cniInterface.runtimeConfig.CapabilityArgs["portMappings"], expectedMappings) {
+		t.Errorf("Wrong port mappings: %v", cniInterface.runtimeConfig.CapabilityArgs["portMappings"])
+	}
+
+	// Conflicts with instance0
+
+	for _, portMapping := range []networkmanager.PortMapping{
+		{HostPort: 8080, ContainerPort: 8080},
+		{HostIP: "10.0.0.1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		{HostIP: "0.0.0.0", HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+	} {
+		if err := manager.AddInstanceToNetwork("instance1", "network1", networkmanager.NetworkParams{
+			PortMappings: []networkmanager.PortMapping{portMapping},
+		}); !errors.Is(err, networkmanager.ErrPortConflict) {
+			t.Errorf("Port conflict error expected for %v: %v", portMapping, err)
+		}
+	}
+
+	// No conflicts: different protocol or host IP
+
+	if err := manager.AddInstanceToNetwork("instance1", "network1", networkmanager.NetworkParams{
+		PortMappings: []networkmanager.PortMapping{
+			{HostPort: 8080, ContainerPort: 80, Protocol: "udp"},
+			{HostIP: "10.0.0.1", HostPort: 5353, ContainerPort: 53, Protocol: "udp"},
+		},
+	}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	// Wrong mappings
+
+	for _, portMappings := range [][]networkmanager.PortMapping{
+		{{HostPort: 9000}},
+		{{HostPort: 9000, ContainerPort: 90, Protocol: "icmp"}},
+		{{HostIP: "wrongIP", HostPort: 9000, ContainerPort: 90}},
+		{{HostPort: 9000, ContainerPort: 90}, {HostPort: 9000, ContainerPort: 91}},
+	} {
+		if err := manager.AddInstanceToNetwork("instance2", "network0", networkmanager.NetworkParams{
+			PortMappings: portMappings,
+		}); err == nil {
+			t.Errorf("Should be error: wrong port mappings %v", portMappings)
+		}
+	}
+
+	// Released ports can be published again
+
+	if err := manager.RemoveInstanceFromNetwork("instance0", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+
+	if err := manager.AddInstanceToNetwork("instance2", "network0", networkmanager.NetworkParams{
+		PortMappings: portMappings,
+	}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+}
+
 func TestDNSPluginPositive(t *testing.T) {
 	testData := []testPluginsData{
 		{
@@ -510,7 +626,7 @@ func TestDNSPluginPositive(t *testing.T) {
 		networkmanager.GetIPSubnet = nil
 	}()
 
-	manager, err := networkmanager.New(&config.Config{}, nil)
+	manager, err := networkmanager.New(&config.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -613,7 +729,7 @@ func TestDNSPluginNegative(t *testing.T) {
 		networkmanager.GetIPSubnet = nil
 	}()
 
-	manager, err := networkmanager.New(&config.Config{}, nil)
+	manager, err := networkmanager.New(&config.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -632,6 +748,336 @@ func TestDNSPluginNegative(t *testing.T) {
 	}
 }
 
+func TestServiceDiscovery(t *testing.T) {
+	cniInterface := &testCNIInterface{}
+
+	networkmanager.CNIPlugins = cniInterface
+	networkmanager.GetIPSubnet = getIPSubnet
+
+	defer func() {
+		networkmanager.GetIPSubnet = nil
+	}()
+
+	networkmanager.DNSNameConfDir = path.Join(tmpDir, "dnsname")
+	networkmanager.DNSMasqPath = path.Join(tmpDir, "dnsmasq.sh")
+
+	defer func() {
+		networkmanager.DNSNameConfDir = "/run/containers/cni/dnsname"
+		networkmanager.DNSMasqPath = "dnsmasq"
+	}()
+
+	// dnsname plugin writes config and hosts of added instances, sleep process is used instead of running dnsmasq
+	networkDir := path.Join(networkmanager.DNSNameConfDir, "network0")
+	hostsFile := path.Join(networkDir, "addnhosts")
+	confFile := path.Join(networkDir, "dnsmasq.conf")
+	recordsFile := path.Join(networkDir, "aosrecords.conf")
+	dnsmasqArgsFile := path.Join(tmpDir, "dnsmasq.args")
+
+	if err := os.MkdirAll(networkDir, 0o755); err != nil {
+		t.Fatalf("Can't create dnsname dir: %v", err)
+	}
+
+	if err := ioutil.WriteFile(hostsFile,
+		[]byte("192.168.0.1\tinstance0\n192.168.0.1\tinstance1\n192.168.0.1\tinstance2\n"), 0o600); err != nil {
+		t.Fatalf("Can't write DNS hosts: %v", err)
+	}
+
+	if err := ioutil.WriteFile(confFile, []byte("interface=network0\naddn-hosts="+hostsFile), 0o600); err != nil {
+		t.Fatalf("Can't write dnsmasq config: %v", err)
+	}
+
+	if err := ioutil.WriteFile(networkmanager.DNSMasqPath,
+		[]byte("#!/bin/sh\necho \"$@\" >> "+dnsmasqArgsFile+"\n"), 0o700); err != nil { // nolint:gosec
+		t.Fatalf("Can't write dnsmasq script: %v", err)
+	}
+
+	dnsmasq := exec.Command("sleep", "100")
+
+	if err := dnsmasq.Start(); err != nil {
+		t.Fatalf("Can't start process: %v", err)
+	}
+
+	dnsmasqExit := make(chan error, 1)
+
+	go func() {
+		dnsmasqExit <- dnsmasq.Wait()
+	}()
+
+	if err := ioutil.WriteFile(path.Join(networkmanager.DNSNameConfDir, "network0", "pidfile"),
+		[]byte(strconv.Itoa(dnsmasq.Process.Pid)), 0o600); err != nil {
+		t.Fatalf("Can't write pid file: %v", err)
+	}
+
+	manager, err := networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil, nil)
+	if err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	instances := []struct {
+		instanceID string
+		params     networkmanager.NetworkParams
+	}{
+		{
+			instanceID: "instance0",
+			params: networkmanager.NetworkParams{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+				ExposedPorts:  []string{"80", "53/UDP"},
+			},
+		},
+		{
+			instanceID: "instance1",
+			params: networkmanager.NetworkParams{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 1},
+				ExposedPorts:  []string{"80"},
+			},
+		},
+		{
+			instanceID: "instance2",
+			params: networkmanager.NetworkParams{
+				InstanceIdent: aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject0", Instance: 0},
+			},
+		},
+	}
+
+	for _, instance := range instances {
+		if err := manager.AddInstanceToNetwork(instance.instanceID, "network0", instance.params); err != nil {
+			t.Fatalf("Can't add instance to network: %s", err)
+		}
+	}
+
+	expectedEndpoints := []networkmanager.ServiceEndpoint{
+		{
+			InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+			InstanceID:    "instance0", NetworkID: "network0", IP: "192.168.0.1",
+			Hostname: "0.subject0.service0.network0", Ports: []string{"80/tcp", "53/udp"},
+		},
+		{
+			InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 1},
+			InstanceID:    "instance1", NetworkID: "network0", IP: "192.168.0.1",
+			Hostname: "1.subject0.service0.network0", Ports: []string{"80/tcp"},
+		},
+	}
+
+	if endpoints := manager.GetServiceEndpoints("service0"); !reflect.DeepEqual(endpoints, expectedEndpoints) {
+		t.Errorf("Wrong service endpoints: %v", endpoints)
+	}
+
+	if endpoints := manager.GetServiceEndpoints("service1"); len(endpoints) != 0 {
+		t.Errorf("Service without exposed ports should not be published: %v", endpoints)
+	}
+
+	expectedHosts := "192.168.0.1\tinstance0\n192.168.0.1\tinstance1\n192.168.0.1\tinstance2\n" +
+		"192.168.0.1\t0.subject0.service0.network0 # aos endpoint\n" +
+		"192.168.0.1\t1.subject0.service0.network0 # aos endpoint\n"
+
+	if hosts, err := ioutil.ReadFile(hostsFile); err != nil || string(hosts) != expectedHosts {
+		t.Errorf("Wrong DNS hosts: %s, err: %v", string(hosts), err)
+	}
+
+	expectedRecords := "srv-host=_service0._tcp.network0,0.subject0.service0.network0,80\n" +
+		"srv-host=_service0._udp.network0,0.subject0.service0.network0,53\n" +
+		"txt-record=0.subject0.service0.network0,serviceid=service0,subjectid=subject0,instance=0," +
+		"port=80/tcp,port=53/udp\n" +
+		"srv-host=_service0._tcp.network0,1.subject0.service0.network0,80\n" +
+		"txt-record=1.subject0.service0.network0,serviceid=service0,subjectid=subject0,instance=1,port=80/tcp\n"
+
+	if records, err := ioutil.ReadFile(recordsFile); err != nil || string(records) != expectedRecords {
+		t.Errorf("Wrong DNS records: %s, err: %v", string(records), err)
+	}
+
+	expectedConf := "interface=network0\naddn-hosts=" + hostsFile + "\nconf-file=" + recordsFile + "\n"
+
+	if conf, err := ioutil.ReadFile(confFile); err != nil || string(conf) != expectedConf {
+		t.Errorf("Wrong dnsmasq config: %s, err: %v", string(conf), err)
+	}
+
+	if err := manager.RemoveInstanceFromNetwork("instance0", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+
+	if endpoints := manager.GetServiceEndpoints("service0"); !reflect.DeepEqual(endpoints, expectedEndpoints[1:]) {
+		t.Errorf("Wrong service endpoints: %v", endpoints)
+	}
+
+	hosts, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		t.Fatalf("Can't read DNS hosts: %v", err)
+	}
+
+	if strings.Contains(string(hosts), "0.subject0.service0.network0") {
+		t.Errorf("DNS host of removed instance should be deleted: %s", string(hosts))
+	}
+
+	records, err := ioutil.ReadFile(recordsFile)
+	if err != nil {
+		t.Fatalf("Can't read DNS records: %v", err)
+	}
+
+	if strings.Contains(string(records), "0.subject0.service0.network0") {
+		t.Errorf("DNS records of removed instance should be deleted: %s", string(records))
+	}
+
+	// dnsmasq should be restarted to reload records: on adding instance0, instance1 and on removing instance0
+	select {
+	case err = <-dnsmasqExit:
+		if err == nil || !strings.Contains(err.Error(), "terminated") {
+			t.Errorf("dnsmasq should be terminated by SIGTERM: %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Error("dnsmasq should be stopped")
+	}
+
+	dnsmasqArgs, err := ioutil.ReadFile(dnsmasqArgsFile)
+	if err != nil {
+		t.Fatalf("Can't read dnsmasq args: %v", err)
+	}
+
+	if expectedArgs := strings.Repeat("-u root --conf-file="+confFile+"\n", 3); string(dnsmasqArgs) != expectedArgs {
+		t.Errorf("Wrong dnsmasq args: %s", string(dnsmasqArgs))
+	}
+
+	if err := manager.RemoveInstanceFromNetwork("instance1", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+
+	if err := manager.RemoveInstanceFromNetwork("instance2", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+}
+
+func TestRestoreNetworks(t *testing.T) {
+	cniInterface := &testCNIInterface{}
+
+	networkmanager.CNIPlugins = cniInterface
+	networkmanager.GetIPSubnet = getIPSubnet
+
+	defer func() {
+		networkmanager.GetIPSubnet = nil
+	}()
+
+	networkDir := path.Join(tmpDir, "cni", "networks", "network0")
+	instanceIPPath := path.Join(networkDir, "192.168.0.1")
+	staleIPPath := path.Join(networkDir, "192.168.0.2")
+
+	writeInstanceIPFiles := func() {
+		if err := os.MkdirAll(networkDir, 0o755); err != nil {
+			t.Fatalf("Can't create network dir: %s", err)
+		}
+
+		if err := ioutil.WriteFile(instanceIPPath, []byte("instance0\neth0"), 0o600); err != nil {
+			t.Fatalf("Can't write network instance data: %s", err)
+		}
+
+		// instance without network namespace can't be restored
+		if err := ioutil.WriteFile(staleIPPath, []byte("instance1\neth0"), 0o600); err != nil {
+			t.Fatalf("Can't write network instance data: %s", err)
+		}
+	}
+
+	params := networkmanager.NetworkParams{
+		InstanceIdent: aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0},
+		ExposedPorts:  []string{"80"},
+		PortMappings:  []networkmanager.PortMapping{{HostPort: 8080, ContainerPort: 80}},
+	}
+
+	manager, err := networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil, nil)
+	if err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", params); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	manager.Close()
+
+	writeInstanceIPFiles()
+
+	// Instance with the same network params is reattached without CNI ADD
+
+	if manager, err = networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil, nil); err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+
+	if _, err := os.Stat(staleIPPath); err == nil {
+		t.Error("Stale instance IP file should be removed")
+	}
+
+	cniInterface.errorAddNetwork = true
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", params); err != nil {
+		t.Fatalf("Can't reattach instance to network: %s", err)
+	}
+
+	cniInterface.errorAddNetwork = false
+
+	if ip, err := manager.GetInstanceIP("instance0", "network0"); err != nil || ip != "192.168.0.1" {
+		t.Errorf("Wrong instance IP: %s, err: %v", ip, err)
+	}
+
+	if endpoints := manager.GetServiceEndpoints("service0"); len(endpoints) != 1 {
+		t.Errorf("Wrong service endpoints: %v", endpoints)
+	}
+
+	if err := manager.AddInstanceToNetwork("instance1", "network1", networkmanager.NetworkParams{
+		PortMappings: params.PortMappings,
+	}); !errors.Is(err, networkmanager.ErrPortConflict) {
+		t.Errorf("Port conflict error expected: %v", err)
+	}
+
+	if err := manager.ReleaseRestoredInstances(); err != nil {
+		t.Errorf("Can't release restored instances: %s", err)
+	}
+
+	if _, err := manager.GetInstanceIP("instance0", "network0"); err != nil {
+		t.Errorf("Reattached instance should not be released: %s", err)
+	}
+
+	manager.Close()
+
+	// Instance with changed network params is added to the network again
+
+	if manager, err = networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil, nil); err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+
+	params.Hostname = "newhost"
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", params); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	if aliases, ok := cniInterface.runtimeConfig.CapabilityArgs["aliases"].(map[string][]string); !ok ||
+		!slices.Contains(aliases["network0"], "newhost") {
+		t.Errorf("Wrong instance aliases: %v", cniInterface.runtimeConfig.CapabilityArgs["aliases"])
+	}
+
+	manager.Close()
+
+	writeInstanceIPFiles()
+
+	// Restored instances which are not added back are released
+
+	if manager, err = networkmanager.New(&config.Config{WorkingDir: tmpDir}, nil, nil); err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	if _, err := manager.GetInstanceIP("instance0", "network0"); err != nil {
+		t.Errorf("Instance network should be restored: %s", err)
+	}
+
+	if err := manager.ReleaseRestoredInstances(); err != nil {
+		t.Errorf("Can't release restored instances: %s", err)
+	}
+
+	if _, err := manager.GetInstanceIP("instance0", "network0"); err == nil {
+		t.Error("Restored instance should be released")
+	}
+}
+
 func TestTrafficMonitoring(t *testing.T) {
 	networkmanager.CNIPlugins = &testCNIInterface{}
 
@@ -648,7 +1094,7 @@ func TestTrafficMonitoring(t *testing.T) {
 
 	networkmanager.UpdateIptablesCachePeriod = 10 * time.Millisecond
 
-	manager, err := networkmanager.New(&config.Config{}, &storage)
+	manager, err := networkmanager.New(&config.Config{}, &storage, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -823,7 +1269,7 @@ func TestTrafficMonitoring(t *testing.T) {
 
 	storage.disableSaveTraffic = true
 
-	manager, err = networkmanager.New(&config.Config{}, &storage)
+	manager, err = networkmanager.New(&config.Config{}, &storage, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -840,6 +1286,311 @@ func TestTrafficMonitoring(t *testing.T) {
 	manager.Close()
 }
 
+func TestTrafficCounters(t *testing.T) {
+	networkmanager.CNIPlugins = &testCNIInterface{}
+
+	storage := testTrafficStorage{chains: make(map[string]trafficData)}
+	alertSender := &testAlertSender{}
+
+	iptableInterface := &testIPTablesInterface{
+		disableResetMonitoringTraffic: true,
+		chain:                         make(map[string]iptablesData),
+		trafficLimitCounter:           20,
+		notifyIptablesCacheUpdate:     make(chan struct{}),
+	}
+
+	networkmanager.IPTables = iptableInterface
+	networkmanager.IsSamePeriod = iptableInterface.isSamePeriod
+	networkmanager.UpdateIptablesCachePeriod = 10 * time.Millisecond
+
+	wrongConfigs := []config.TrafficMonitoring{
+		{Counters: []config.TrafficCounter{{Name: "monthly", Period: "month", ResetDay: 31}}},
+		{Counters: []config.TrafficCounter{{Name: "hourly", Period: "hour", ResetHour: 24}}},
+		{Counters: []config.TrafficCounter{{Name: "rolling", Period: "rolling"}}},
+		{Counters: []config.TrafficCounter{{Name: "weekly", Period: "week"}}},
+		{Counters: []config.TrafficCounter{{Period: "day"}}},
+		{AlertThresholds: []uint64{120}},
+	}
+
+	for _, trafficConfig := range wrongConfigs {
+		if _, err := networkmanager.New(
+			&config.Config{TrafficMonitoring: trafficConfig}, &storage, alertSender); err == nil {
+			t.Errorf("Should be error: wrong traffic monitoring config %v", trafficConfig)
+		}
+	}
+
+	trafficConfig := config.TrafficMonitoring{
+		Counters: []config.TrafficCounter{
+			{Name: "daily", Period: "day", Limited: true},
+			{Name: "monthly", Period: "month", ResetDay: 15},
+			{Name: "rolling", Period: "rolling", Window: aostypes.Duration{Duration: time.Hour}},
+		},
+		AlertThresholds: []uint64{80, 50},
+	}
+
+	manager, err := networkmanager.New(&config.Config{TrafficMonitoring: trafficConfig}, &storage, alertSender)
+	if err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	instanceIdent := aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}
+	networkParams := networkmanager.NetworkParams{
+		InstanceIdent: instanceIdent,
+		DownloadLimit: 100,
+		UploadLimit:   100,
+	}
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", networkParams); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		iptableInterface.waitUpdateIptablesCache()
+	}
+
+	counters, err := manager.GetInstanceTrafficCounters("instance0")
+	if err != nil {
+		t.Fatalf("Can't get instance traffic counters: %s", err)
+	}
+
+	if len(counters) != 3 {
+		t.Fatalf("Unexpected traffic counters count: %d", len(counters))
+	}
+
+	for i, name := range []string{"daily", "monthly", "rolling"} {
+		if counters[i].Name != name {
+			t.Errorf("Unexpected traffic counter name: %s", counters[i].Name)
+		}
+
+		if counters[i].InputTraffic != counters[0].InputTraffic ||
+			counters[i].OutputTraffic != counters[0].OutputTraffic {
+			t.Errorf("Unexpected traffic counter %s value", counters[i].Name)
+		}
+	}
+
+	in, out, err := manager.GetInstanceTraffic("instance0")
+	if err != nil {
+		t.Fatalf("Can't get instance traffic: %s", err)
+	}
+
+	if in != counters[0].InputTraffic || out != counters[0].OutputTraffic {
+		t.Error("Instance traffic should be equal to the first counter value")
+	}
+
+	if in < 50 {
+		t.Fatalf("Unexpected instance traffic: %d", in)
+	}
+
+	alertSender.Lock()
+	alerts := alertSender.alerts
+	alertSender.Unlock()
+
+	parameters := make(map[string]bool)
+
+	for _, alert := range alerts {
+		quotaAlert, ok := alert.Payload.(cloudprotocol.InstanceQuotaAlert)
+		if !ok || alert.Tag != cloudprotocol.AlertTagInstanceQuota {
+			t.Errorf("Unexpected alert: %v", alert)
+
+			continue
+		}
+
+		if quotaAlert.InstanceIdent != instanceIdent {
+			t.Errorf("Unexpected alert instance ident: %v", quotaAlert.InstanceIdent)
+		}
+
+		parameters[quotaAlert.Parameter] = true
+	}
+
+	if !parameters["inTraffic"] || !parameters["outTraffic"] {
+		t.Errorf("Traffic alerts should be sent: %v", alerts)
+	}
+
+	if err := manager.RemoveInstanceFromNetwork("instance0", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+
+	storedCounters := 0
+
+	for key := range storage.chains {
+		if strings.HasSuffix(key, ":monthly") || strings.HasSuffix(key, ":rolling") {
+			storedCounters++
+		}
+	}
+
+	// monthly and rolling counters for system and instance input and output chains
+	if storedCounters != 8 {
+		t.Errorf("Unexpected stored traffic counters count: %d", storedCounters)
+	}
+
+	if _, err := manager.GetInstanceTrafficCounters("instance0"); err == nil {
+		t.Error("Should be an error: can't get traffic counters after remove instance from network")
+	}
+
+	manager.Close()
+
+	// Reached alert levels are stored with counters and restored, so alerts are not sent again after restart
+	instanceChains := 0
+
+	for key, data := range storage.chains {
+		if strings.Contains(key, ":") || strings.HasPrefix(key, "AOS_SYSTEM_") {
+			continue
+		}
+
+		if data.alertLevel == 0 {
+			t.Errorf("Alert level of %s should be stored", key)
+		}
+
+		storage.chains[key] = trafficData{lastUpdate: time.Now(), currentValue: 100, alertLevel: 2}
+		instanceChains++
+	}
+
+	if instanceChains != 2 {
+		t.Errorf("Unexpected stored instance chains count: %d", instanceChains)
+	}
+
+	alertSender.Lock()
+	alertSender.alerts = nil
+	alertSender.Unlock()
+
+	if manager, err = networkmanager.New(
+		&config.Config{TrafficMonitoring: trafficConfig}, &storage, alertSender); err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", networkParams); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		iptableInterface.waitUpdateIptablesCache()
+	}
+
+	alertSender.Lock()
+	alerts = alertSender.alerts
+	alertSender.Unlock()
+
+	if len(alerts) != 0 {
+		t.Errorf("Traffic alerts should not be sent again: %v", alerts)
+	}
+
+	if err := manager.RemoveInstanceFromNetwork("instance0", "network0"); err != nil {
+		t.Fatalf("Can't remove instance from network: %s", err)
+	}
+}
+
+func TestTrafficHistory(t *testing.T) {
+	networkmanager.CNIPlugins = &testCNIInterface{}
+
+	storage := testTrafficStorage{chains: make(map[string]trafficData)}
+
+	iptableInterface := &testIPTablesInterface{
+		disableResetMonitoringTraffic: true,
+		chain:                         make(map[string]iptablesData),
+		trafficLimitCounter:           20,
+		notifyIptablesCacheUpdate:     make(chan struct{}),
+	}
+
+	networkmanager.IPTables = iptableInterface
+	networkmanager.IsSamePeriod = iptableInterface.isSamePeriod
+	networkmanager.UpdateIptablesCachePeriod = 10 * time.Millisecond
+
+	historyBucket := time.Hour
+	historyTime := time.Now().UTC().Truncate(historyBucket)
+
+	// Outdated item should be removed on next bucket
+	if err := storage.SetTrafficHistoryData("instance0", historyTime.Add(-48*time.Hour), 10, 10); err != nil {
+		t.Fatalf("Can't set traffic history: %v", err)
+	}
+
+	// Stored value of current bucket should be restored
+	if err := storage.SetTrafficHistoryData("instance0", historyTime, 1000, 1000); err != nil {
+		t.Fatalf("Can't set traffic history: %v", err)
+	}
+
+	manager, err := networkmanager.New(&config.Config{TrafficMonitoring: config.TrafficMonitoring{
+		HistoryBucket: aostypes.Duration{Duration: historyBucket},
+		HistoryDepth:  aostypes.Duration{Duration: 24 * time.Hour},
+	}}, &storage, nil)
+	if err != nil {
+		t.Fatalf("Can't create network manager: %s", err)
+	}
+	defer manager.Close()
+
+	if err := manager.AddInstanceToNetwork("instance0", "network0", networkmanager.NetworkParams{}); err != nil {
+		t.Fatalf("Can't add instance to network: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		iptableInterface.waitUpdateIptablesCache()
+	}
+
+	history, err := manager.GetInstanceTrafficHistory(
+		"instance0", historyTime.Add(-72*time.Hour), historyTime.Add(historyBucket))
+	if err != nil {
+		t.Fatalf("Can't get instance traffic history: %v", err)
+	}
+
+	in, out, err := manager.GetInstanceTraffic("instance0")
+	if err != nil {
+		t.Fatalf("Can't get instance traffic: %v", err)
+	}
+
+	// History bucket could be switched during test, in this case outdated item is removed
+	// and bucket value contains only traffic of current bucket.
+	switch len(history) {
+	case 2:
+		if !history[1].Timestamp.Equal(historyTime) ||
+			history[1].InputTraffic != 1000+in || history[1].OutputTraffic != 1000+out {
+			t.Errorf("Wrong traffic history item: %v", history[1])
+		}
+
+	case 1:
+		if history[0].Timestamp.Equal(historyTime) {
+			t.Errorf("Wrong traffic history item: %v", history[0])
+		}
+
+	default:
+		t.Errorf("Wrong traffic history count: %d", len(history))
+	}
+
+	if history, err = manager.GetSystemTrafficHistory(historyTime, historyTime.Add(historyBucket)); err != nil {
+		t.Fatalf("Can't get system traffic history: %v", err)
+	}
+
+	if len(history) == 0 {
+		t.Error("System traffic history should not be empty")
+	}
+
+	// History is queried by CM with instance ident
+	instanceIdent := aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 0}
+
+	if _, err = manager.GetTrafficHistory(&instanceIdent, historyTime, historyTime); err == nil {
+		t.Error("Error expected for unknown instance")
+	}
+
+	storage.instances = map[aostypes.InstanceIdent]string{instanceIdent: "instance0"}
+
+	identHistory, err := manager.GetTrafficHistory(&instanceIdent, historyTime.Add(-72*time.Hour), historyTime)
+	if err != nil {
+		t.Fatalf("Can't get traffic history: %v", err)
+	}
+
+	if len(identHistory) == 0 || !identHistory[len(identHistory)-1].Timestamp.Equal(historyTime) {
+		t.Errorf("Wrong instance traffic history: %v", identHistory)
+	}
+
+	if history, err = manager.GetTrafficHistory(nil, historyTime, historyTime.Add(historyBucket)); err != nil {
+		t.Fatalf("Can't get system traffic history: %v", err)
+	}
+
+	if len(history) == 0 {
+		t.Error("System traffic history should not be empty")
+	}
+}
+
 func TestAddNetworkFail(t *testing.T) {
 	cniInterface := &testCNIInterface{
 		errorAddNetwork: true,
@@ -848,7 +1599,7 @@ func TestAddNetworkFail(t *testing.T) {
 
 	networkmanager.CNIPlugins = cniInterface
 
-	manager, err := networkmanager.New(&config.Config{}, nil)
+	manager, err := networkmanager.New(&config.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("Can't create network manager: %s", err)
 	}
@@ -932,27 +1683,31 @@ func TestAddNetworkFail(t *testing.T) {
  * Private
  **********************************************************************************************************************/
 
-func (storage *testTrafficStorage) SetTrafficMonitorData(chain string, timestamp time.Time, value uint64) error {
+func (storage *testTrafficStorage) SetTrafficMonitorData(
+	chain string, timestamp time.Time, value uint64, alertLevel int,
+) error {
 	if storage.disableSaveTraffic {
 		return aoserrors.New("problem to save traffic")
 	}
 
-	storage.chains[chain] = trafficData{lastUpdate: timestamp, currentValue: value}
+	storage.chains[chain] = trafficData{lastUpdate: timestamp, currentValue: value, alertLevel: alertLevel}
 
 	return nil
 }
 
-func (storage *testTrafficStorage) GetTrafficMonitorData(chain string) (timestamp time.Time, value uint64, err error) {
+func (storage *testTrafficStorage) GetTrafficMonitorData(
+	chain string,
+) (timestamp time.Time, value uint64, alertLevel int, err error) {
 	if storage.disableLoadTraffic {
-		return timestamp, 0, aoserrors.New("problem to load traffic")
+		return timestamp, 0, 0, aoserrors.New("problem to load traffic")
 	}
 
 	data, ok := storage.chains[chain]
 	if !ok {
-		return timestamp, 0, networkmanager.ErrEntryNotExist
+		return timestamp, 0, 0, networkmanager.ErrEntryNotExist
 	}
 
-	return data.lastUpdate, data.currentValue, nil
+	return data.lastUpdate, data.currentValue, data.alertLevel, nil
 }
 
 func (storage *testTrafficStorage) RemoveTrafficMonitorData(chain string) error {
@@ -965,6 +1720,75 @@ func (storage *testTrafficStorage) RemoveTrafficMonitorData(chain string) error
 	return nil
 }
 
+func (sender *testAlertSender) SendAlert(alert cloudprotocol.AlertItem) {
+	sender.Lock()
+	defer sender.Unlock()
+
+	sender.alerts = append(sender.alerts, alert)
+}
+
+func (storage *testTrafficStorage) SetTrafficHistoryData(
+	instanceID string, timestamp time.Time, inValue, outValue uint64,
+) error {
+	if storage.history == nil {
+		storage.history = make(map[string][]networkmanager.TrafficHistoryItem)
+	}
+
+	item := networkmanager.TrafficHistoryItem{Timestamp: timestamp, InputTraffic: inValue, OutputTraffic: outValue}
+
+	for i, historyItem := range storage.history[instanceID] {
+		if historyItem.Timestamp.Equal(timestamp) {
+			storage.history[instanceID][i] = item
+
+			return nil
+		}
+	}
+
+	storage.history[instanceID] = append(storage.history[instanceID], item)
+
+	return nil
+}
+
+func (storage *testTrafficStorage) GetTrafficHistoryData(
+	instanceID string, from, till time.Time,
+) (history []networkmanager.TrafficHistoryItem, err error) {
+	for _, item := range storage.history[instanceID] {
+		if !item.Timestamp.Before(from) && !item.Timestamp.After(till) {
+			history = append(history, item)
+		}
+	}
+
+	return history, nil
+}
+
+func (storage *testTrafficStorage) RemoveTrafficHistoryData(till time.Time) error {
+	for instanceID, items := range storage.history {
+		var history []networkmanager.TrafficHistoryItem
+
+		for _, item := range items {
+			if !item.Timestamp.Before(till) {
+				history = append(history, item)
+			}
+		}
+
+		storage.history[instanceID] = history
+	}
+
+	return nil
+}
+
+func (storage *testTrafficStorage) GetInstanceIDs(filter cloudprotocol.InstanceFilter) (instances []string, err error) {
+	for instanceIdent, instanceID := range storage.instances {
+		if (filter.ServiceID == nil || *filter.ServiceID == instanceIdent.ServiceID) &&
+			(filter.SubjectID == nil || *filter.SubjectID == instanceIdent.SubjectID) &&
+			(filter.Instance == nil || *filter.Instance == instanceIdent.Instance) {
+			instances = append(instances, instanceID)
+		}
+	}
+
+	return instances, nil
+}
+
 func createPlugins(plugins []string) string {
 	networkConfig := `{"name":"network0","cniVersion":"0.4.0","plugins":[`
 
@@ -1086,6 +1910,8 @@ func (c *testCNIInterface) AddNetworkList(ctx context.Context, list *cni.Network
 		result.IPs = append(result.IPs, ipConfig)
 	}
 
+	c.result = result
+
 	return result, nil
 }
 
@@ -1115,7 +1941,7 @@ func (c *testCNIInterface) GetNetworkListCachedConfig(list *cni.NetworkConfigLis
 		return nil, nil, aoserrors.Wrap(err)
 	}
 
-	return config, rt, nil
+	return config, c.runtimeConfig, nil
 }
 
 func (c *testCNIInterface) DelNetworkList(ctx context.Context, list *cni.NetworkConfigList, rt *cni.RuntimeConf) error {
@@ -1159,7 +1985,7 @@ func (c *testCNIInterface) GetNetworkCachedResult(net *cni.NetworkConfig, rt *cn
 func (c *testCNIInterface) GetNetworkListCachedResult(
 	net *cni.NetworkConfigList, rt *cni.RuntimeConf,
 ) (types.Result, error) {
-	return nil, nil
+	return c.result, nil
 }
 
 func (c *testCNIInterface) ValidateNetwork(ctx context.Context, net *cni.NetworkConfig) ([]string, error) {
@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package networkmanager provides set of API to configure network
+package networkmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"golang.org/x/exp/slices"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const defaultPortProtocol = "tcp"
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// PortMapping instance port published to the host network.
+type PortMapping struct {
+	HostIP        string `json:"hostIP,omitempty"`
+	HostPort      uint16 `json:"hostPort"`
+	ContainerPort uint16 `json:"containerPort"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type portMapNetConf struct {
+	Type         string          `json:"type"`
+	SNAT         bool            `json:"snat"`
+	Capabilities map[string]bool `json:"capabilities"`
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrPortConflict is returned when host port is already published by another instance.
+var ErrPortConflict = errors.New("host port already published")
+
+// nolint:gochecknoglobals
+var supportedPortProtocols = []string{"tcp", "udp", "sctp"}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func normalizePortMappings(portMappings []PortMapping) (normalized []PortMapping, err error) {
+	for _, portMapping := range portMappings {
+		if portMapping.HostPort == 0 || portMapping.ContainerPort == 0 {
+			return nil, aoserrors.Errorf("invalid port mapping %s: port is not set", portMapping)
+		}
+
+		portMapping.Protocol = strings.ToLower(portMapping.Protocol)
+
+		if portMapping.Protocol == "" {
+			portMapping.Protocol = defaultPortProtocol
+		}
+
+		if !slices.Contains(supportedPortProtocols, portMapping.Protocol) {
+			return nil, aoserrors.Errorf("invalid port mapping %s: unsupported protocol", portMapping)
+		}
+
+		if portMapping.HostIP != "" {
+			ip := net.ParseIP(portMapping.HostIP)
+			if ip == nil {
+				return nil, aoserrors.Errorf("invalid port mapping %s: wrong host IP", portMapping)
+			}
+
+			if ip.IsUnspecified() {
+				portMapping.HostIP = ""
+			}
+		}
+
+		for _, existMapping := range normalized {
+			if existMapping.overlaps(portMapping) {
+				return nil, aoserrors.Errorf("%w: %s duplicates %s", ErrPortConflict, portMapping, existMapping)
+			}
+		}
+
+		normalized = append(normalized, portMapping)
+	}
+
+	return normalized, nil
+}
+
+func (portMapping PortMapping) String() string {
+	hostIP := portMapping.HostIP
+	if hostIP == "" {
+		hostIP = net.IPv4zero.String()
+	}
+
+	return net.JoinHostPort(hostIP, strconv.FormatUint(uint64(portMapping.HostPort), 10)) + "->" +
+		strconv.FormatUint(uint64(portMapping.ContainerPort), 10) + "/" + portMapping.Protocol
+}
+
+func (portMapping PortMapping) overlaps(other PortMapping) bool {
+	if portMapping.HostPort != other.HostPort || portMapping.Protocol != other.Protocol {
+		return false
+	}
+
+	return portMapping.HostIP == "" || other.HostIP == "" || portMapping.HostIP == other.HostIP
+}
+
+func (manager *NetworkManager) reservePortMappings(instanceID, networkID string, portMappings []PortMapping) error {
+	manager.Lock()
+	defer manager.Unlock()
+
+	for _, instances := range manager.instancesData {
+		for existInstanceID, networkInstanceData := range instances {
+			if existInstanceID == instanceID {
+				continue
+			}
+
+			for _, existMapping := range networkInstanceData.portMappings {
+				for _, portMapping := range portMappings {
+					if existMapping.overlaps(portMapping) {
+						return aoserrors.Errorf("%w: %s is used by instance %s",
+							ErrPortConflict, portMapping, existInstanceID)
+					}
+				}
+			}
+		}
+	}
+
+	networkInstanceData, ok := manager.instancesData[networkID][instanceID]
+	if !ok {
+		return aoserrors.Errorf("can't find network instanceID: %s", instanceID)
+	}
+
+	networkInstanceData.portMappings = portMappings
+
+	manager.instancesData[networkID][instanceID] = networkInstanceData
+
+	return nil
+}
+
+func getPortMapPluginConfig() (config json.RawMessage, err error) {
+	portMap := &portMapNetConf{
+		Type:         "portmap",
+		SNAT:         true,
+		Capabilities: map[string]bool{"portMappings": true},
+	}
+
+	if config, err = json.Marshal(portMap); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return config, nil
+}
+
+func appendPublishedPorts(exposedPorts []string, portMappings []PortMapping) []string {
+	ports := append([]string{}, exposedPorts...)
+
+	// published container ports should be reachable through the instance firewall
+	for _, portMapping := range portMappings {
+		containerPort := strconv.FormatUint(uint64(portMapping.ContainerPort), 10)
+
+		if slices.Contains(ports, containerPort+"/"+portMapping.Protocol) ||
+			(portMapping.Protocol == defaultPortProtocol && slices.Contains(ports, containerPort)) {
+			continue
+		}
+
+		ports = append(ports, containerPort+"/"+portMapping.Protocol)
+	}
+
+	return ports
+}
@@ -0,0 +1,330 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package networkmanager provides set of API to configure network
+package networkmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	cni "github.com/containernetworking/cni/libcni"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// ReleaseRestoredInstances removes restored instances which were not added back to the network.
+func (manager *NetworkManager) ReleaseRestoredInstances() (err error) {
+	manager.RLock()
+
+	restoredInstances := make(map[string][]string)
+
+	for networkID, instances := range manager.instancesData {
+		for instanceID, networkInstanceData := range instances {
+			if networkInstanceData.restored {
+				restoredInstances[networkID] = append(restoredInstances[networkID], instanceID)
+			}
+		}
+	}
+
+	manager.RUnlock()
+
+	for networkID, instanceIDs := range restoredInstances {
+		for _, instanceID := range instanceIDs {
+			log.WithFields(log.Fields{
+				"instanceID": instanceID, "networkID": networkID,
+			}).Debug("Release restored instance network")
+
+			if removeErr := manager.RemoveInstanceFromNetwork(instanceID, networkID); removeErr != nil && err == nil {
+				err = removeErr
+			}
+		}
+	}
+
+	return err
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (manager *NetworkManager) restoreNetworks() error {
+	log.Debug("Restore networks")
+
+	networkDirs, err := ioutil.ReadDir(manager.networkDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	for _, networkDir := range networkDirs {
+		if !networkDir.IsDir() {
+			continue
+		}
+
+		if restoreErr := manager.restoreNetworkInstances(networkDir.Name()); restoreErr != nil {
+			log.WithField("networkID", networkDir.Name()).Errorf("Can't restore network: %v", restoreErr)
+
+			if err == nil {
+				err = restoreErr
+			}
+		}
+	}
+
+	return err
+}
+
+func (manager *NetworkManager) restoreNetworkInstances(networkID string) (err error) {
+	networkDir := path.Join(manager.networkDir, networkID)
+
+	// Host-local IPAM keeps allocated IPs as files named by IP address with instance ID inside
+	filesInstanceIP, err := ioutil.ReadDir(networkDir)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, instanceIPFile := range filesInstanceIP {
+		if slices.Contains(skipNetworkFileNames, instanceIPFile.Name()) || net.ParseIP(instanceIPFile.Name()) == nil {
+			continue
+		}
+
+		instanceIPPath := path.Join(networkDir, instanceIPFile.Name())
+
+		instanceID, readErr := readInstanceIDFromFile(instanceIPPath)
+		if readErr != nil {
+			log.WithField("file", instanceIPPath).Warnf("Can't read network instance: %v", readErr)
+
+			continue
+		}
+
+		if restoreErr := manager.restoreInstance(instanceID, networkID, instanceIPFile.Name()); restoreErr != nil {
+			log.WithFields(log.Fields{
+				"instanceID": instanceID, "networkID": networkID,
+			}).Warnf("Can't restore instance network: %v", restoreErr)
+
+			if removeErr := manager.removeInstanceFromNetwork(instanceID, networkID); removeErr != nil {
+				log.WithField("instanceID", instanceID).Errorf("Can't remove instance from network: %v", removeErr)
+			}
+
+			// Release instance IP even if CNI failed to do it
+			if removeErr := os.Remove(instanceIPPath); removeErr != nil && !os.IsNotExist(removeErr) {
+				log.WithField("file", instanceIPPath).Errorf("Can't remove network instance: %v", removeErr)
+			}
+
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"instanceID": instanceID, "networkID": networkID, "IP": instanceIPFile.Name(),
+		}).Debug("Instance network restored")
+	}
+
+	manager.Lock()
+	defer manager.Unlock()
+
+	if len(manager.instancesData[networkID]) == 0 {
+		return manager.clearNetwork(networkID)
+	}
+
+	return nil
+}
+
+func (manager *NetworkManager) restoreInstance(instanceID, networkID, instanceIP string) error {
+	if _, err := os.Stat(manager.GetNetnsPath(instanceID)); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	networkConfig, runtimeConfig := getRuntimeNetConfig(instanceID, networkID)
+
+	confBytes, runtimeConfig, err := manager.cniInterface.GetNetworkListCachedConfig(networkConfig, runtimeConfig)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if confBytes == nil || runtimeConfig == nil {
+		return aoserrors.Errorf("instance %s not found in network %s", instanceID, networkID)
+	}
+
+	networkInstanceData := netInstanceData{instanceIP: instanceIP, restored: true}
+
+	var aliases map[string][]string
+
+	if err = getCapabilityArg(runtimeConfig, "aliases", &aliases); err != nil {
+		return err
+	}
+
+	networkInstanceData.hosts = aliases[networkID]
+
+	if err = getCapabilityArg(runtimeConfig, "portMappings", &networkInstanceData.portMappings); err != nil {
+		return err
+	}
+
+	manager.Lock()
+	defer manager.Unlock()
+
+	if _, ok := manager.instancesData[networkID]; !ok {
+		manager.instancesData[networkID] = make(map[string]netInstanceData)
+	}
+
+	manager.instancesData[networkID][instanceID] = networkInstanceData
+
+	return nil
+}
+
+func (manager *NetworkManager) isInstanceRestored(instanceID, networkID string) bool {
+	manager.RLock()
+	defer manager.RUnlock()
+
+	return manager.instancesData[networkID][instanceID].restored
+}
+
+// Reuses restored instance network if its configuration is not changed. Otherwise, the restored network is removed
+// and the caller should add the instance to the network from scratch.
+func (manager *NetworkManager) reattachInstance(
+	instanceID, networkID string, ipSubnet *net.IPNet, params NetworkParams,
+) (reattached bool, err error) {
+	defer func() {
+		if err != nil || !reattached {
+			if removeErr := manager.RemoveInstanceFromNetwork(instanceID, networkID); removeErr != nil && err == nil {
+				err = removeErr
+			}
+		}
+	}()
+
+	manager.RLock()
+	restoredData := manager.instancesData[networkID][instanceID]
+	manager.RUnlock()
+
+	netConfig, runtimeConfig, hosts, err := manager.prepareCNIConfig(instanceID, networkID, ipSubnet, params)
+	if err != nil {
+		return false, err
+	}
+
+	cachedConfig, _, err := manager.cniInterface.GetNetworkListCachedConfig(getRuntimeNetConfig(instanceID, networkID))
+	if err != nil {
+		return false, aoserrors.Wrap(err)
+	}
+
+	if isNetworkConfigChanged(cachedConfig, netConfig) || !slices.Equal(restoredData.hosts, hosts) ||
+		!slices.Equal(restoredData.portMappings, params.PortMappings) {
+		log.WithField("instanceID", instanceID).Debug("Instance network config changed")
+
+		return false, nil
+	}
+
+	cachedResult, err := manager.cniInterface.GetNetworkListCachedResult(netConfig, runtimeConfig)
+	if err != nil {
+		return false, aoserrors.Wrap(err)
+	}
+
+	if cachedResult == nil {
+		return false, aoserrors.Errorf("no cached network result for instance %s", instanceID)
+	}
+
+	result, err := current.GetResult(cachedResult)
+	if err != nil {
+		return false, aoserrors.Wrap(err)
+	}
+
+	if len(result.IPs) == 0 || result.IPs[0].Address.IP.String() != restoredData.instanceIP {
+		return false, aoserrors.Errorf("wrong cached IP address for instance %s", instanceID)
+	}
+
+	if err = manager.reservePortMappings(instanceID, networkID, params.PortMappings); err != nil {
+		return false, err
+	}
+
+	if err = createResolvConfAndHostFile(
+		networkID, restoredData.instanceIP, result.DNS.Nameservers, params); err != nil {
+		return false, err
+	}
+
+	if manager.trafficMonitoring != nil {
+		if err = manager.trafficMonitoring.startInstanceTrafficMonitor(
+			params.InstanceIdent, instanceID, restoredData.instanceIP, params.DownloadLimit,
+			params.UploadLimit); err != nil {
+			return false, aoserrors.Wrap(err)
+		}
+	}
+
+	if err = manager.updateInstanceNetworkCache(instanceID, networkID, restoredData.instanceIP, hosts); err != nil {
+		return false, err
+	}
+
+	if err = manager.registerServiceEndpoint(instanceID, networkID, restoredData.instanceIP, params); err != nil {
+		return false, err
+	}
+
+	log.WithFields(log.Fields{
+		"instanceID": instanceID,
+		"IP":         restoredData.instanceIP,
+	}).Debug("Instance has been reattached to the network")
+
+	return true, nil
+}
+
+func isNetworkConfigChanged(cachedConfig []byte, netConfig *cni.NetworkConfigList) bool {
+	cachedNetConfig, err := cni.ConfListFromBytes(cachedConfig)
+	if err != nil {
+		return true
+	}
+
+	if cachedNetConfig.Name != netConfig.Name || cachedNetConfig.CNIVersion != netConfig.CNIVersion ||
+		len(cachedNetConfig.Plugins) != len(netConfig.Plugins) {
+		return true
+	}
+
+	// Plugin configs are normalized by libcni, so they can be compared as is
+	for i, plugin := range cachedNetConfig.Plugins {
+		if !bytes.Equal(plugin.Bytes, netConfig.Plugins[i].Bytes) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getCapabilityArg(runtimeConfig *cni.RuntimeConf, name string, value interface{}) error {
+	arg, ok := runtimeConfig.CapabilityArgs[name]
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(arg)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = json.Unmarshal(data, value); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
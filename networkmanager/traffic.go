@@ -21,14 +21,19 @@ import (
 	"context"
 	"errors"
 	"hash/fnv"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
 	"github.com/coreos/go-iptables/iptables"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/config"
 )
 
 /***********************************************************************************************************************
@@ -44,15 +49,35 @@ const (
 	YearPeriod
 )
 
+const (
+	maxResetDay    = 28
+	maxResetHour   = 23
+	maxPercentage  = 100
+	rollingPeriod  = "rolling"
+	counterKeySep  = ":"
+	defaultCounter = "default"
+)
+
 /***********************************************************************************************************************
  * Types
  **********************************************************************************************************************/
 
 // TrafficStorage provides API to create, remove or access monitoring data.
 type TrafficStorage interface {
-	SetTrafficMonitorData(chain string, timestamp time.Time, value uint64) (err error)
-	GetTrafficMonitorData(chain string) (timestamp time.Time, value uint64, err error)
+	SetTrafficMonitorData(chain string, timestamp time.Time, value uint64, alertLevel int) (err error)
+	GetTrafficMonitorData(chain string) (timestamp time.Time, value uint64, alertLevel int, err error)
 	RemoveTrafficMonitorData(chain string) (err error)
+	SetTrafficHistoryData(instanceID string, timestamp time.Time, inValue, outValue uint64) (err error)
+	GetTrafficHistoryData(instanceID string, from, till time.Time) (history []TrafficHistoryItem, err error)
+	RemoveTrafficHistoryData(till time.Time) (err error)
+	GetInstanceIDs(filter cloudprotocol.InstanceFilter) (instances []string, err error)
+}
+
+// TrafficHistoryItem traffic history bucket.
+type TrafficHistoryItem struct {
+	Timestamp     time.Time
+	InputTraffic  uint64
+	OutputTraffic uint64
 }
 
 type trafficChains struct {
@@ -60,20 +85,60 @@ type trafficChains struct {
 	outChain string
 }
 
-type trafficData struct {
-	disabled     bool
-	addresses    string
+// AlertSender provides alert sender interface.
+type AlertSender interface {
+	SendAlert(alert cloudprotocol.AlertItem)
+}
+
+// TrafficCounterInfo traffic counter info.
+type TrafficCounterInfo struct {
+	Name          string
+	InputTraffic  uint64
+	OutputTraffic uint64
+}
+
+type trafficCounterConfig struct {
+	name      string
+	period    int
+	resetDay  int
+	resetHour int
+	window    time.Duration
+	limited   bool
+}
+
+type trafficSample struct {
+	timestamp time.Time
+	value     uint64
+}
+
+type trafficCounter struct {
+	trafficCounterConfig
+	storageKey   string
 	currentValue uint64
-	initialValue uint64
-	subValue     uint64
-	limit        uint64
 	lastUpdate   time.Time
+	samples      []trafficSample
+	alertLevel   int
+}
+
+type trafficData struct {
+	disabled      bool
+	addresses     string
+	chainValue    uint64
+	historyValue  uint64
+	limit         uint64
+	counters      []*trafficCounter
+	instanceIdent *aostypes.InstanceIdent
 }
 
 type trafficMonitoring struct {
 	sync.RWMutex
 	iptables            IPTablesInterface
-	trafficPeriod       int
+	counterConfigs      []trafficCounterConfig
+	alertThresholds     []uint64
+	alertSender         AlertSender
+	historyBucket       time.Duration
+	historyDepth        time.Duration
+	historyTime         time.Time
 	skipAddresses       string
 	inChain             string
 	outChain            string
@@ -112,6 +177,15 @@ var (
 	IPTables     IPTablesInterface
 )
 
+// nolint:gochecknoglobals
+var trafficPeriods = map[string]int{
+	"minute": MinutePeriod,
+	"hour":   HourPeriod,
+	"day":    DayPeriod,
+	"month":  MonthPeriod,
+	"year":   YearPeriod,
+}
+
 // UpdateIptablesCachePeriod is used to be able to mocking the functionality of networking in tests.
 // nolint:gochecknoglobals
 var UpdateIptablesCachePeriod = 1 * time.Minute
@@ -120,12 +194,35 @@ var UpdateIptablesCachePeriod = 1 * time.Minute
  * Private
  **********************************************************************************************************************/
 
-func newTrafficMonitor(trafficStorage TrafficStorage) (monitor *trafficMonitoring, err error) {
+func newTrafficMonitor(
+	cfg config.TrafficMonitoring, trafficStorage TrafficStorage, alertSender AlertSender,
+) (monitor *trafficMonitoring, err error) {
 	monitor = &trafficMonitoring{
-		trafficPeriod:  DayPeriod,
-		trafficStorage: trafficStorage,
+		trafficStorage:  trafficStorage,
+		alertSender:     alertSender,
+		alertThresholds: append([]uint64(nil), cfg.AlertThresholds...),
+		historyBucket:   cfg.HistoryBucket.Duration,
+		historyDepth:    cfg.HistoryDepth.Duration,
+	}
+
+	if monitor.historyBucket < 0 || monitor.historyDepth < 0 {
+		return nil, aoserrors.New("wrong traffic history parameters")
 	}
 
+	if monitor.counterConfigs, err = parseTrafficCounters(cfg.Counters); err != nil {
+		return nil, err
+	}
+
+	for _, threshold := range monitor.alertThresholds {
+		if threshold == 0 || threshold > maxPercentage {
+			return nil, aoserrors.Errorf("wrong traffic alert threshold: %d", threshold)
+		}
+	}
+
+	sort.Slice(monitor.alertThresholds, func(i, j int) bool {
+		return monitor.alertThresholds[i] < monitor.alertThresholds[j]
+	})
+
 	monitor.trafficMap = make(map[string]*trafficData)
 	monitor.instanceChainsMap = make(map[string]*trafficChains)
 
@@ -154,14 +251,22 @@ func newTrafficMonitor(trafficStorage TrafficStorage) (monitor *trafficMonitorin
 
 	monitor.skipAddresses = strings.Join(skipNetworks, ",")
 
-	if err = monitor.createTrafficChain(monitor.inChain, "INPUT", "0/0", 0); err != nil {
+	if err = monitor.createTrafficChain(monitor.inChain, "INPUT", "0/0", 0, nil); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if err = monitor.createTrafficChain(monitor.outChain, "OUTPUT", "0/0", 0); err != nil {
+	if err = monitor.createTrafficChain(monitor.outChain, "OUTPUT", "0/0", 0, nil); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
+	if monitor.historyBucket != 0 {
+		monitor.historyTime = time.Now().UTC().Truncate(monitor.historyBucket)
+
+		if err = monitor.loadTrafficHistory("", monitor.inChain, monitor.outChain); err != nil {
+			return nil, err
+		}
+	}
+
 	return monitor, nil
 }
 
@@ -327,7 +432,9 @@ func (monitor *trafficMonitoring) deleteAllRules(chain string, rulespec ...strin
 	}
 }
 
-func (monitor *trafficMonitoring) createTrafficChain(chain, rootChain, addresses string, limit uint64) (err error) {
+func (monitor *trafficMonitoring) createTrafficChain(
+	chain, rootChain, addresses string, limit uint64, instanceIdent *aostypes.InstanceIdent,
+) (err error) {
 	var skipAddrType, addrType string
 
 	log.WithField("chain", chain).Debug("Create iptables chain")
@@ -361,15 +468,25 @@ func (monitor *trafficMonitoring) createTrafficChain(chain, rootChain, addresses
 		return aoserrors.Wrap(err)
 	}
 
-	traffic := trafficData{addresses: addresses}
+	traffic := trafficData{addresses: addresses, limit: limit, instanceIdent: instanceIdent}
 
-	if limit != 0 {
-		traffic.limit = limit
-	}
+	monitor.RLock()
+	counterConfigs := monitor.counterConfigs
+	monitor.RUnlock()
 
-	traffic.lastUpdate, traffic.initialValue, err = monitor.trafficStorage.GetTrafficMonitorData(chain)
-	if err != nil && !errors.Is(err, ErrEntryNotExist) {
-		return aoserrors.Wrap(err)
+	for i, counterConfig := range counterConfigs {
+		counter := &trafficCounter{trafficCounterConfig: counterConfig, storageKey: chain}
+
+		// Keep the first counter stored by chain name to be compatible with data stored by previous versions
+		if i != 0 {
+			counter.storageKey = chain + counterKeySep + counterConfig.name
+		}
+
+		if err = monitor.loadTrafficCounter(counter); err != nil {
+			return err
+		}
+
+		traffic.counters = append(traffic.counters, counter)
 	}
 
 	monitor.Lock()
@@ -385,9 +502,11 @@ func (monitor *trafficMonitoring) deleteTrafficChain(chain, rootChain string) (e
 	monitor.Lock()
 	// Store traffic data to DB
 	if traffic, ok := monitor.trafficMap[chain]; ok {
-		if err := monitor.trafficStorage.SetTrafficMonitorData(chain,
-			traffic.lastUpdate, traffic.currentValue); err != nil {
-			log.Errorf("Can't set traffic monitoring: %s", err)
+		for _, counter := range traffic.counters {
+			if err := monitor.trafficStorage.SetTrafficMonitorData(counter.storageKey,
+				counter.lastUpdate, counter.currentValue, counter.alertLevel); err != nil {
+				log.Errorf("Can't set traffic monitoring: %s", err)
+			}
 		}
 	}
 
@@ -412,6 +531,8 @@ func (monitor *trafficMonitoring) deleteTrafficChain(chain, rootChain string) (e
 func (monitor *trafficMonitoring) processTrafficMonitor() (err error) {
 	timestamp := time.Now().UTC()
 
+	monitor.updateHistoryBucket(timestamp)
+
 	for chain, traffic := range monitor.trafficMap {
 		var (
 			value    uint64
@@ -425,30 +546,43 @@ func (monitor *trafficMonitoring) processTrafficMonitor() (err error) {
 			}
 		}
 
-		if !IsSamePeriod(monitor.trafficPeriod, timestamp, traffic.lastUpdate) {
-			log.WithField("chain", chain).Debug("Reset stats")
-			// we count statistics per day, if date is different then reset stats
-			traffic.initialValue = 0
-			traffic.subValue = value
+		// Unfortunately, github.com/coreos/go-iptables/iptables doesn't provide API to reset chain statistics.
+		// We use difference between current and previous chain values to update all counters.
+		delta := value
+
+		if value >= traffic.chainValue {
+			delta = value - traffic.chainValue
 		}
 
-		// initialValue is used to keep traffic between resets
-		// Unfortunately, github.com/coreos/go-iptables/iptables doesn't provide API to reset chain statistics.
-		// We use subValue to reset statistics.
-		traffic.currentValue = traffic.initialValue + value - traffic.subValue
-		traffic.lastUpdate = timestamp
+		traffic.chainValue = value
+
+		monitor.Lock()
+		for _, counter := range traffic.counters {
+			counter.update(timestamp, delta)
+		}
+
+		traffic.historyValue += delta
+		monitor.Unlock()
 
 		if chainErr = monitor.checkTrafficLimit(traffic, chain); chainErr != nil && err == nil {
 			err = chainErr
 			continue
 		}
 
-		if chainErr = monitor.trafficStorage.SetTrafficMonitorData(
-			chain, traffic.lastUpdate, traffic.currentValue); chainErr != nil && err == nil {
-			err = aoserrors.Wrap(err)
+		monitor.checkTrafficAlerts(traffic, chain)
+
+		for _, counter := range traffic.counters {
+			if chainErr = monitor.trafficStorage.SetTrafficMonitorData(counter.storageKey, counter.lastUpdate,
+				counter.currentValue, counter.alertLevel); chainErr != nil && err == nil {
+				err = aoserrors.Wrap(chainErr)
+			}
 		}
 	}
 
+	if historyErr := monitor.storeTrafficHistory(); historyErr != nil && err == nil {
+		err = historyErr
+	}
+
 	return err
 }
 
@@ -486,7 +620,7 @@ func (monitor *trafficMonitoring) deleteAllTrafficChains() (err error) {
 }
 
 func (monitor *trafficMonitoring) startInstanceTrafficMonitor(
-	instanceID, ipAddress string, downloadLimit, uploadLimit uint64,
+	instanceIdent aostypes.InstanceIdent, instanceID, ipAddress string, downloadLimit, uploadLimit uint64,
 ) (err error) {
 	if ipAddress == "" {
 		return nil
@@ -497,11 +631,13 @@ func (monitor *trafficMonitoring) startInstanceTrafficMonitor(
 	chainBase := strconv.FormatUint(hash.Sum64(), 16)
 	serviceChains := trafficChains{inChain: "AOS_" + chainBase + "_IN", outChain: "AOS_" + chainBase + "_OUT"}
 
-	if err = monitor.createTrafficChain(serviceChains.inChain, "FORWARD", ipAddress, downloadLimit); err != nil {
+	if err = monitor.createTrafficChain(
+		serviceChains.inChain, "FORWARD", ipAddress, downloadLimit, &instanceIdent); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if err = monitor.createTrafficChain(serviceChains.outChain, "FORWARD", ipAddress, uploadLimit); err != nil {
+	if err = monitor.createTrafficChain(
+		serviceChains.outChain, "FORWARD", ipAddress, uploadLimit, &instanceIdent); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
@@ -509,6 +645,12 @@ func (monitor *trafficMonitoring) startInstanceTrafficMonitor(
 	monitor.instanceChainsMap[instanceID] = &serviceChains
 	monitor.Unlock()
 
+	if monitor.historyBucket != 0 {
+		if err = monitor.loadTrafficHistory(instanceID, serviceChains.inChain, serviceChains.outChain); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -563,21 +705,214 @@ func (monitor *trafficMonitoring) getInputOutputTrafficData(
 	return inputTrafficData, outputTrafficData, nil
 }
 
+func (monitor *trafficMonitoring) getInstanceCounters(
+	instanceID string,
+) (counters []TrafficCounterInfo, err error) {
+	instanceChains := monitor.getInstanceChains(instanceID)
+	if instanceChains == nil {
+		return nil, aoserrors.Errorf("chain for instance %s is not found", instanceID)
+	}
+
+	input, output, err := monitor.getInputOutputTrafficData(instanceChains.inChain, instanceChains.outChain)
+	if err != nil {
+		return nil, err
+	}
+
+	monitor.RLock()
+	defer monitor.RUnlock()
+
+	for i, inCounter := range input.counters {
+		counter := TrafficCounterInfo{Name: inCounter.name, InputTraffic: inCounter.currentValue}
+
+		if i < len(output.counters) {
+			counter.OutputTraffic = output.counters[i].currentValue
+		}
+
+		counters = append(counters, counter)
+	}
+
+	return counters, nil
+}
+
+func (monitor *trafficMonitoring) getTrafficHistory(
+	instanceID string, from, till time.Time,
+) (history []TrafficHistoryItem, err error) {
+	if monitor.historyBucket == 0 {
+		return nil, aoserrors.New("traffic history is disabled")
+	}
+
+	if history, err = monitor.trafficStorage.GetTrafficHistoryData(instanceID, from, till); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return history, nil
+}
+
+func (monitor *trafficMonitoring) getInstanceTrafficHistory(
+	instanceIdent aostypes.InstanceIdent, from, till time.Time,
+) ([]TrafficHistoryItem, error) {
+	instanceIDs, err := monitor.trafficStorage.GetInstanceIDs(cloudprotocol.InstanceFilter{
+		ServiceID: &instanceIdent.ServiceID, SubjectID: &instanceIdent.SubjectID, Instance: &instanceIdent.Instance,
+	})
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if len(instanceIDs) == 0 {
+		return nil, aoserrors.Errorf("instance %v not found", instanceIdent)
+	}
+
+	return monitor.getTrafficHistory(instanceIDs[0], from, till)
+}
+
+func (monitor *trafficMonitoring) loadTrafficHistory(instanceID, inChain, outChain string) error {
+	input, output, err := monitor.getInputOutputTrafficData(inChain, outChain)
+	if err != nil {
+		return err
+	}
+
+	monitor.RLock()
+	historyTime := monitor.historyTime
+	monitor.RUnlock()
+
+	history, err := monitor.trafficStorage.GetTrafficHistoryData(instanceID, historyTime, historyTime)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if len(history) == 0 {
+		return nil
+	}
+
+	monitor.Lock()
+	input.historyValue = history[0].InputTraffic
+	output.historyValue = history[0].OutputTraffic
+	monitor.Unlock()
+
+	return nil
+}
+
+func (monitor *trafficMonitoring) updateHistoryBucket(timestamp time.Time) {
+	if monitor.historyBucket == 0 {
+		return
+	}
+
+	historyTime := timestamp.Truncate(monitor.historyBucket)
+
+	monitor.Lock()
+
+	if historyTime.Equal(monitor.historyTime) {
+		monitor.Unlock()
+
+		return
+	}
+
+	monitor.historyTime = historyTime
+
+	for _, traffic := range monitor.trafficMap {
+		traffic.historyValue = 0
+	}
+
+	monitor.Unlock()
+
+	if monitor.historyDepth == 0 {
+		return
+	}
+
+	if err := monitor.trafficStorage.RemoveTrafficHistoryData(historyTime.Add(-monitor.historyDepth)); err != nil {
+		log.Errorf("Can't remove outdated traffic history: %v", err)
+	}
+}
+
+func (monitor *trafficMonitoring) storeTrafficHistory() (err error) {
+	if monitor.historyBucket == 0 {
+		return nil
+	}
+
+	monitor.RLock()
+
+	historyChains := map[string]trafficChains{"": {inChain: monitor.inChain, outChain: monitor.outChain}}
+
+	for instanceID, instanceChains := range monitor.instanceChainsMap {
+		historyChains[instanceID] = *instanceChains
+	}
+
+	monitor.RUnlock()
+
+	for instanceID, chains := range historyChains {
+		input, output, chainErr := monitor.getInputOutputTrafficData(chains.inChain, chains.outChain)
+		if chainErr != nil {
+			continue
+		}
+
+		monitor.RLock()
+		historyTime, inValue, outValue := monitor.historyTime, input.historyValue, output.historyValue
+		monitor.RUnlock()
+
+		if chainErr = monitor.trafficStorage.SetTrafficHistoryData(
+			instanceID, historyTime, inValue, outValue); chainErr != nil && err == nil {
+			err = aoserrors.Wrap(chainErr)
+		}
+	}
+
+	return err
+}
+
+func (monitor *trafficMonitoring) setTrafficPeriod(period int) {
+	monitor.Lock()
+	defer monitor.Unlock()
+
+	if monitor.counterConfigs[0].window == 0 {
+		monitor.counterConfigs[0].period = period
+	}
+
+	for _, traffic := range monitor.trafficMap {
+		if len(traffic.counters) > 0 && traffic.counters[0].window == 0 {
+			traffic.counters[0].period = period
+		}
+	}
+}
+
+func (monitor *trafficMonitoring) loadTrafficCounter(counter *trafficCounter) (err error) {
+	var value uint64
+
+	counter.lastUpdate, value, counter.alertLevel, err = monitor.trafficStorage.GetTrafficMonitorData(
+		counter.storageKey)
+	if err != nil {
+		if errors.Is(err, ErrEntryNotExist) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	counter.currentValue = value
+
+	// Samples distribution within rolling window is not stored, treat stored value as single sample
+	if counter.window != 0 && value != 0 {
+		counter.samples = []trafficSample{{timestamp: counter.lastUpdate, value: value}}
+	}
+
+	return nil
+}
+
 func (monitor *trafficMonitoring) checkTrafficLimit(traffic *trafficData, chain string) (err error) {
 	if traffic.limit != 0 {
-		if traffic.currentValue > traffic.limit && !traffic.disabled {
+		currentValue := traffic.limitedValue()
+
+		if currentValue > traffic.limit && !traffic.disabled {
 			// disable chain
 			if chainErr := monitor.setChainState(chain, traffic.addresses, false); chainErr != nil && err == nil {
-				err = aoserrors.Errorf("can't disable chain: %s", err)
+				err = aoserrors.Errorf("can't disable chain: %s", chainErr)
 			} else {
 				resetTrafficData(traffic, true)
 			}
 		}
 
-		if traffic.currentValue < traffic.limit && traffic.disabled {
+		if currentValue < traffic.limit && traffic.disabled {
 			// enable chain
 			if chainErr := monitor.setChainState(chain, traffic.addresses, true); chainErr != nil && err == nil {
-				err = aoserrors.Errorf("can't enable chain: %s", err)
+				err = aoserrors.Errorf("can't enable chain: %s", chainErr)
 			} else {
 				resetTrafficData(traffic, false)
 			}
@@ -587,8 +922,168 @@ func (monitor *trafficMonitoring) checkTrafficLimit(traffic *trafficData, chain
 	return err
 }
 
+func (monitor *trafficMonitoring) checkTrafficAlerts(traffic *trafficData, chain string) {
+	if traffic.limit == 0 || traffic.instanceIdent == nil || len(monitor.alertThresholds) == 0 {
+		return
+	}
+
+	parameter := "inTraffic"
+
+	if strings.HasSuffix(chain, "_OUT") {
+		parameter = "outTraffic"
+	}
+
+	for _, counter := range traffic.counters {
+		if !counter.limited {
+			continue
+		}
+
+		level := 0
+
+		for _, threshold := range monitor.alertThresholds {
+			if counter.currentValue*maxPercentage >= traffic.limit*threshold {
+				level++
+			}
+		}
+
+		if level > counter.alertLevel && monitor.alertSender != nil {
+			log.WithFields(log.Fields{
+				"chain":     chain,
+				"counter":   counter.name,
+				"value":     counter.currentValue,
+				"threshold": monitor.alertThresholds[level-1],
+			}).Warn("Traffic alert threshold reached")
+
+			monitor.alertSender.SendAlert(cloudprotocol.AlertItem{
+				Timestamp: counter.lastUpdate,
+				Tag:       cloudprotocol.AlertTagInstanceQuota,
+				Payload: cloudprotocol.InstanceQuotaAlert{
+					InstanceIdent: *traffic.instanceIdent,
+					Parameter:     parameter,
+					Value:         counter.currentValue,
+				},
+			})
+		}
+
+		counter.alertLevel = level
+	}
+}
+
+func (traffic *trafficData) limitedValue() (value uint64) {
+	for _, counter := range traffic.counters {
+		if counter.limited && counter.currentValue > value {
+			value = counter.currentValue
+		}
+	}
+
+	return value
+}
+
+func (traffic *trafficData) primaryValue() uint64 {
+	if len(traffic.counters) == 0 {
+		return 0
+	}
+
+	return traffic.counters[0].currentValue
+}
+
+func (counter *trafficCounter) update(timestamp time.Time, value uint64) {
+	defer func() {
+		counter.lastUpdate = timestamp
+	}()
+
+	if counter.window != 0 {
+		counter.samples = append(counter.samples, trafficSample{timestamp: timestamp, value: value})
+
+		startTime := timestamp.Add(-counter.window)
+
+		for len(counter.samples) > 0 && !counter.samples[0].timestamp.After(startTime) {
+			counter.samples = counter.samples[1:]
+		}
+
+		counter.currentValue = 0
+
+		for _, sample := range counter.samples {
+			counter.currentValue += sample.value
+		}
+
+		return
+	}
+
+	if !IsSamePeriod(counter.period, counter.anchorTime(timestamp), counter.anchorTime(counter.lastUpdate)) {
+		log.WithField("counter", counter.storageKey).Debug("Reset stats")
+
+		counter.currentValue = 0
+
+		return
+	}
+
+	counter.currentValue += value
+}
+
+// anchorTime shifts time by reset anchor, so calendar period comparison can be used for anchored periods.
+func (counter *trafficCounter) anchorTime(t time.Time) time.Time {
+	if counter.resetDay > 1 {
+		t = t.AddDate(0, 0, -(counter.resetDay - 1))
+	}
+
+	return t.Add(-time.Duration(counter.resetHour) * time.Hour)
+}
+
 func resetTrafficData(traffic *trafficData, disable bool) {
 	traffic.disabled = disable
-	traffic.initialValue = traffic.currentValue
-	traffic.subValue = 0
+	traffic.chainValue = 0
+}
+
+func parseTrafficCounters(counters []config.TrafficCounter) (counterConfigs []trafficCounterConfig, err error) {
+	if len(counters) == 0 {
+		return []trafficCounterConfig{{name: defaultCounter, period: DayPeriod, limited: true}}, nil
+	}
+
+	limited := false
+
+	for _, counter := range counters {
+		counterConfig := trafficCounterConfig{
+			name:      counter.Name,
+			resetDay:  counter.ResetDay,
+			resetHour: counter.ResetHour,
+			limited:   counter.Limited,
+		}
+
+		if counterConfig.name == "" {
+			return nil, aoserrors.New("traffic counter name is not set")
+		}
+
+		if counter.Period == rollingPeriod {
+			if counterConfig.window = counter.Window.Duration; counterConfig.window <= 0 {
+				return nil, aoserrors.Errorf("wrong window for rolling traffic counter %s", counter.Name)
+			}
+		} else {
+			period, ok := trafficPeriods[counter.Period]
+			if !ok {
+				return nil, aoserrors.Errorf("wrong period for traffic counter %s: %s", counter.Name, counter.Period)
+			}
+
+			counterConfig.period = period
+		}
+
+		if counterConfig.resetDay < 0 || counterConfig.resetDay > maxResetDay {
+			return nil, aoserrors.Errorf("wrong reset day for traffic counter %s: %d", counter.Name, counter.ResetDay)
+		}
+
+		if counterConfig.resetHour < 0 || counterConfig.resetHour > maxResetHour {
+			return nil, aoserrors.Errorf("wrong reset hour for traffic counter %s: %d", counter.Name, counter.ResetHour)
+		}
+
+		limited = limited || counterConfig.limited
+
+		counterConfigs = append(counterConfigs, counterConfig)
+	}
+
+	// limits should be applied at least by one counter
+	if !limited {
+		counterConfigs[0].limited = true
+	}
+
+	return counterConfigs, nil
 }
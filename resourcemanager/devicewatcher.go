@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resourcemanager
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	deviceWatchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+	// Enough to read bunch of events with names up to NAME_MAX.
+	deviceEventsBufferSize = 64 * (unix.SizeofInotifyEvent + unix.NAME_MAX + 1)
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Watches host devices directory tree. Events are used only as change notification: devices are rediscovered on
+// each change as inotify doesn't report content of directories created before their watches are added.
+type deviceWatcher struct {
+	fd      int
+	file    *os.File
+	watches map[int]string
+	dirs    map[string]int
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func newDeviceWatcher(root string) (watcher *deviceWatcher, err error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	watcher = &deviceWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int]string),
+		dirs:    make(map[string]int),
+	}
+
+	if err = watcher.watchDirs(root); err != nil {
+		watcher.close()
+
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+func (watcher *deviceWatcher) close() error {
+	return aoserrors.Wrap(watcher.file.Close())
+}
+
+// Adds watches for directories which are not watched yet.
+func (watcher *deviceWatcher) watchDirs(root string) error {
+	return aoserrors.Wrap(filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return aoserrors.Wrap(err)
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		if _, ok := watcher.dirs[path]; ok {
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(watcher.fd, path, deviceWatchMask)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return aoserrors.Wrap(err)
+		}
+
+		watcher.watches[wd] = path
+		watcher.dirs[path] = wd
+
+		return nil
+	}))
+}
+
+// Blocks until at least one event is received or watcher is closed.
+func (watcher *deviceWatcher) waitEvents() error {
+	buffer := make([]byte, deviceEventsBufferSize)
+
+	for {
+		n, err := watcher.file.Read(buffer)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		changed := false
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buffer[offset])) //nolint:gosec
+
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				log.Warn("Device events queue overflow")
+			}
+
+			// Watch is removed by kernel when watched directory is deleted
+			if event.Mask&unix.IN_IGNORED != 0 {
+				delete(watcher.dirs, watcher.watches[int(event.Wd)])
+				delete(watcher.watches, int(event.Wd))
+			} else {
+				changed = true
+			}
+
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+		}
+
+		if changed {
+			return nil
+		}
+	}
+}
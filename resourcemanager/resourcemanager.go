@@ -20,6 +20,7 @@ package resourcemanager
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -33,7 +34,11 @@ import (
 	"github.com/aoscloud/aos_common/aoserrors"
 	"github.com/aoscloud/aos_common/aostypes"
 	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	"github.com/opencontainers/runc/libcontainer/specconv"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/utils/cpuset"
 )
 
 /***********************************************************************************************************************
@@ -41,8 +46,12 @@ import (
  **********************************************************************************************************************/
 
 const (
-	devHostDirectory  = "/dev/"
-	userHostDirectory = "/etc/group"
+	userHostDirectory       = "/etc/group"
+	deviceEventsChannelSize = 16
+	// Time to let udev finish creating device nodes and links before rediscovering host devices.
+	deviceSettleTime = 100 * time.Millisecond
+	// Maximal cgroup v2 CPU and IO weight.
+	maxCgroupWeight = 10000
 )
 
 /***********************************************************************************************************************
@@ -61,6 +70,16 @@ type ResourceManager struct {
 	unitConfig       unitConfig
 	unitConfigError  error
 	alertSender      AlertSender
+
+	deviceWatcher      *deviceWatcher
+	deviceEventChannel chan DeviceEvent
+	cancelFunction     context.CancelFunc
+}
+
+// DeviceEvent notifies about availability change of unit config device.
+type DeviceEvent struct {
+	Name      string
+	Available bool
 }
 
 // AlertSender provides alert sender interface.
@@ -68,17 +87,73 @@ type AlertSender interface {
 	SendAlert(alert cloudprotocol.AlertItem)
 }
 
+// HostFSInfo host filesystem parts allowed to be exposed to services.
+type HostFSInfo struct {
+	AllowedPaths      []HostPathInfo `json:"allowedPaths,omitempty"`
+	AllowNoHostRootFS bool           `json:"allowNoHostRootfs,omitempty"`
+}
+
+// HostPathInfo host path allowed to be bound into services.
+type HostPathInfo struct {
+	Path      string `json:"path"`
+	ReadWrite bool   `json:"readWrite,omitempty"`
+}
+
+// ResourceInfo unit config resource extended with SM specific grants.
+type ResourceInfo struct {
+	aostypes.ResourceInfo
+	Sysctl       map[string]string `json:"sysctl,omitempty"`
+	Tmpfs        []TmpfsInfo       `json:"tmpfs,omitempty"`
+	Capabilities []string          `json:"capabilities,omitempty"`
+	SeccompAllow []string          `json:"seccompAllow,omitempty"`
+}
+
+// TmpfsInfo tmpfs mount provided by resource.
+type TmpfsInfo struct {
+	Destination string `json:"destination"`
+	Size        uint64 `json:"size"`
+}
+
+// SecurityProfilesInfo seccomp and LSM profiles provided by the node and capabilities services are allowed to add.
+type SecurityProfilesInfo struct {
+	Seccomp      map[string]runtimespec.LinuxSeccomp `json:"seccomp,omitempty"`
+	AppArmor     []string                            `json:"apparmor,omitempty"`
+	SELinux      []string                            `json:"selinux,omitempty"`
+	Capabilities []string                            `json:"capabilities,omitempty"`
+}
+
+// ResourceLimitsInfo constraints of services cgroup resource limits.
+// Empty CPUs disables cpuset pinning, zero max swap disables swap usage, zero max weight disables setting the weight.
+type ResourceLimitsInfo struct {
+	CPUs         string   `json:"cpus,omitempty"`
+	IODevices    []string `json:"ioDevices,omitempty"`
+	MaxSwap      uint64   `json:"maxSwap,omitempty"`
+	MaxCPUWeight uint64   `json:"maxCpuWeight,omitempty"`
+	MaxIOWeight  uint64   `json:"maxIoWeight,omitempty"`
+}
+
 type unitConfig struct {
 	aostypes.NodeUnitConfig
-	VendorVersion string `json:"vendorVersion"`
+	Resources        []ResourceInfo       `json:"resources,omitempty"`
+	HostFS           HostFSInfo           `json:"hostFs"`
+	SecurityProfiles SecurityProfilesInfo `json:"securityProfiles"`
+	ResourceLimits   ResourceLimitsInfo   `json:"resourceLimits"`
+	VendorVersion    string               `json:"vendorVersion"`
 }
 
 /***********************************************************************************************************************
  * Vars
  **********************************************************************************************************************/
 
-// ErrNoAvailableDevice indicates there is no device available.
-var ErrNoAvailableDevice = errors.New("no device available")
+//nolint:gochecknoglobals // used to override in unit tests
+var devHostDirectory = "/dev/"
+
+var (
+	// ErrNoAvailableDevice indicates there is no device available.
+	ErrNoAvailableDevice = errors.New("no device available")
+	// ErrNoAvailableResource indicates resource is not provided by the node.
+	ErrNoAvailableResource = errors.New("resource is not available")
+)
 
 /***********************************************************************************************************************
  * Public
@@ -89,11 +164,23 @@ func New(nodeType, unitConfigFile string, alertSender AlertSender) (resourcemana
 	log.Debug("New resource manager")
 
 	resourcemanager = &ResourceManager{
-		nodeType:       nodeType,
-		unitConfigFile: unitConfigFile,
-		alertSender:    alertSender,
+		nodeType:           nodeType,
+		unitConfigFile:     unitConfigFile,
+		alertSender:        alertSender,
+		deviceEventChannel: make(chan DeviceEvent, deviceEventsChannelSize),
 	}
 
+	// Watch before discovering to not miss devices added in between
+	if resourcemanager.deviceWatcher, err = newDeviceWatcher(devHostDirectory); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err != nil {
+			resourcemanager.deviceWatcher.close()
+		}
+	}()
+
 	if resourcemanager.hostDevices, err = resourcemanager.discoverHostDevices(); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
@@ -110,9 +197,29 @@ func New(nodeType, unitConfigFile string, alertSender AlertSender) (resourcemana
 
 	log.WithField("version", resourcemanager.unitConfig.VendorVersion).Debug("Unit config version")
 
+	ctx, cancelFunction := context.WithCancel(context.Background())
+
+	resourcemanager.cancelFunction = cancelFunction
+
+	go resourcemanager.handleDeviceChanges(ctx)
+
 	return resourcemanager, nil
 }
 
+// Close closes resource manager.
+func (resourcemanager *ResourceManager) Close() error {
+	log.Debug("Close resource manager")
+
+	resourcemanager.cancelFunction()
+
+	return resourcemanager.deviceWatcher.close()
+}
+
+// DeviceEventChannel returns channel of unit config devices availability changes.
+func (resourcemanager *ResourceManager) DeviceEventChannel() <-chan DeviceEvent {
+	return resourcemanager.deviceEventChannel
+}
+
 // GetUnitConfigInfo returns unit config info.
 func (resourcemanager *ResourceManager) GetUnitConfigInfo() (version string) {
 	resourcemanager.Lock()
@@ -175,7 +282,7 @@ func (resourcemanager *ResourceManager) GetDeviceInfo(name string) (deviceInfo a
 }
 
 // GetResourceInfo returns resource information.
-func (resourcemanager *ResourceManager) GetResourceInfo(name string) (aostypes.ResourceInfo, error) {
+func (resourcemanager *ResourceManager) GetResourceInfo(name string) (ResourceInfo, error) {
 	resourcemanager.Lock()
 	defer resourcemanager.Unlock()
 
@@ -185,7 +292,43 @@ func (resourcemanager *ResourceManager) GetResourceInfo(name string) (aostypes.R
 		}
 	}
 
-	return aostypes.ResourceInfo{}, aoserrors.New("resource is not available")
+	return ResourceInfo{}, aoserrors.Wrap(ErrNoAvailableResource)
+}
+
+// GetHostFSInfo returns host filesystem parts allowed to be exposed to services.
+func (resourcemanager *ResourceManager) GetHostFSInfo() (HostFSInfo, error) {
+	resourcemanager.Lock()
+	defer resourcemanager.Unlock()
+
+	if resourcemanager.unitConfigError != nil {
+		return HostFSInfo{}, aoserrors.Wrap(resourcemanager.unitConfigError)
+	}
+
+	return resourcemanager.unitConfig.HostFS, nil
+}
+
+// GetSecurityProfiles returns seccomp and LSM profiles provided by the node.
+func (resourcemanager *ResourceManager) GetSecurityProfiles() (SecurityProfilesInfo, error) {
+	resourcemanager.Lock()
+	defer resourcemanager.Unlock()
+
+	if resourcemanager.unitConfigError != nil {
+		return SecurityProfilesInfo{}, aoserrors.Wrap(resourcemanager.unitConfigError)
+	}
+
+	return resourcemanager.unitConfig.SecurityProfiles, nil
+}
+
+// GetResourceLimits returns constraints of services cgroup resource limits.
+func (resourcemanager *ResourceManager) GetResourceLimits() (ResourceLimitsInfo, error) {
+	resourcemanager.Lock()
+	defer resourcemanager.Unlock()
+
+	if resourcemanager.unitConfigError != nil {
+		return ResourceLimitsInfo{}, aoserrors.Wrap(resourcemanager.unitConfigError)
+	}
+
+	return resourcemanager.unitConfig.ResourceLimits, nil
 }
 
 // AllocateDevice tries to allocate device.
@@ -204,6 +347,10 @@ func (resourcemanager *ResourceManager) AllocateDevice(device, instanceID string
 		return aoserrors.Wrap(err)
 	}
 
+	if !resourcemanager.isDevicePresent(deviceInfo) {
+		return aoserrors.Wrap(ErrNoAvailableDevice)
+	}
+
 	// get list of instances that are using this device
 	instances := resourcemanager.allocatedDevices[device]
 
@@ -286,17 +433,17 @@ func (resourcemanager *ResourceManager) GetDeviceInstances(device string) ([]str
  **********************************************************************************************************************/
 
 func (resourcemanager *ResourceManager) checkUnitConfig(configJSON, version string) error {
-	nodeConfig := aostypes.NodeUnitConfig{}
+	config := unitConfig{}
 
 	if version == resourcemanager.unitConfig.VendorVersion {
 		return aoserrors.New("invalid vendor version")
 	}
 
-	if err := json.Unmarshal([]byte(configJSON), &nodeConfig); err != nil {
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if err := resourcemanager.validateUnitConfig(nodeConfig); err != nil {
+	if err := resourcemanager.validateUnitConfig(config); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
@@ -307,6 +454,11 @@ func (resourcemanager *ResourceManager) discoverHostDevices() (hostDevices []str
 	err = filepath.Walk(devHostDirectory,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
+				// device may be removed while walking
+				if os.IsNotExist(err) {
+					return nil
+				}
+
 				return aoserrors.Wrap(err)
 			}
 
@@ -321,6 +473,142 @@ func (resourcemanager *ResourceManager) discoverHostDevices() (hostDevices []str
 	return hostDevices, nil
 }
 
+func (resourcemanager *ResourceManager) handleDeviceChanges(ctx context.Context) {
+	for {
+		if err := resourcemanager.deviceWatcher.waitEvents(); err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("Device watcher error: %v", err)
+			}
+
+			return
+		}
+
+		select {
+		case <-time.After(deviceSettleTime):
+
+		case <-ctx.Done():
+			return
+		}
+
+		if err := resourcemanager.deviceWatcher.watchDirs(devHostDirectory); err != nil {
+			log.Errorf("Can't watch host devices: %v", err)
+		}
+
+		hostDevices, err := resourcemanager.discoverHostDevices()
+		if err != nil {
+			log.Errorf("Can't discover host devices: %v", err)
+
+			continue
+		}
+
+		for _, event := range resourcemanager.updateHostDevices(hostDevices) {
+			select {
+			case resourcemanager.deviceEventChannel <- event:
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (resourcemanager *ResourceManager) updateHostDevices(hostDevices []string) (events []DeviceEvent) {
+	resourcemanager.Lock()
+	defer resourcemanager.Unlock()
+
+	prevAvailable := resourcemanager.getDevicesAvailability()
+	prevHostDevices := resourcemanager.hostDevices
+
+	resourcemanager.hostDevices = hostDevices
+
+	// Refresh unit config validation when device required by invalid config appears
+	if resourcemanager.unitConfigError != nil {
+		for _, device := range resourcemanager.unitConfig.Devices {
+			if !resourcemanager.isDevicePresent(device) || isDevicePresent(prevHostDevices, device) {
+				continue
+			}
+
+			if err := resourcemanager.loadUnitConfiguration(); err != nil {
+				log.Errorf("Unit configuration error: %v", err)
+			} else {
+				log.Info("Unit configuration is valid")
+			}
+
+			break
+		}
+	}
+
+	var deviceErrors []cloudprotocol.ResourceValidateError
+
+	availability := resourcemanager.getDevicesAvailability()
+
+	for _, device := range resourcemanager.unitConfig.Devices {
+		available := availability[device.Name]
+
+		if available == prevAvailable[device.Name] {
+			continue
+		}
+
+		log.WithFields(log.Fields{"device": device.Name, "available": available}).Info("Device availability changed")
+
+		events = append(events, DeviceEvent{Name: device.Name, Available: available})
+
+		if available {
+			continue
+		}
+
+		deviceError := cloudprotocol.ResourceValidateError{Name: device.Name}
+
+		for _, hostDevice := range device.HostDevices {
+			if !contains(hostDevices, hostDevice) {
+				deviceError.Errors = append(deviceError.Errors,
+					aoserrors.Errorf("device %s is not present on system", hostDevice).Error())
+			}
+		}
+
+		deviceErrors = append(deviceErrors, deviceError)
+	}
+
+	if len(deviceErrors) != 0 && resourcemanager.alertSender != nil {
+		resourcemanager.alertSender.SendAlert(cloudprotocol.AlertItem{
+			Timestamp: time.Now(),
+			Tag:       cloudprotocol.AlertTagResourceValidate,
+			Payload:   cloudprotocol.ResourceValidateAlert{ResourcesErrors: deviceErrors},
+		})
+	}
+
+	return events
+}
+
+// Device is available if unit config is valid and all its host devices are present.
+func (resourcemanager *ResourceManager) getDevicesAvailability() map[string]bool {
+	availability := make(map[string]bool)
+
+	if resourcemanager.unitConfigError != nil {
+		return availability
+	}
+
+	for _, device := range resourcemanager.unitConfig.Devices {
+		availability[device.Name] = resourcemanager.isDevicePresent(device)
+	}
+
+	return availability
+}
+
+func (resourcemanager *ResourceManager) isDevicePresent(device aostypes.DeviceInfo) bool {
+	return isDevicePresent(resourcemanager.hostDevices, device)
+}
+
+func isDevicePresent(hostDevices []string, device aostypes.DeviceInfo) bool {
+	for _, hostDevice := range device.HostDevices {
+		if !contains(hostDevices, hostDevice) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (resourcemanager *ResourceManager) discoverHostGroups() (hostGroups []string, err error) {
 	file, err := os.Open(userHostDirectory)
 	if err != nil {
@@ -371,14 +659,14 @@ func (resourcemanager *ResourceManager) loadUnitConfiguration() (err error) {
 		return aoserrors.Wrap(err)
 	}
 
-	if err = resourcemanager.validateUnitConfig(resourcemanager.unitConfig.NodeUnitConfig); err != nil {
+	if err = resourcemanager.validateUnitConfig(resourcemanager.unitConfig); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
 	return nil
 }
 
-func (resourcemanager *ResourceManager) validateUnitConfig(config aostypes.NodeUnitConfig) (err error) {
+func (resourcemanager *ResourceManager) validateUnitConfig(config unitConfig) (err error) {
 	if config.NodeType != resourcemanager.nodeType {
 		return aoserrors.New("invalid node type")
 	}
@@ -387,6 +675,97 @@ func (resourcemanager *ResourceManager) validateUnitConfig(config aostypes.NodeU
 		return aoserrors.Wrap(err)
 	}
 
+	if err = validateResources(config.Resources); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = validateHostFS(config.HostFS); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = validateSecurityProfiles(config.SecurityProfiles); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = validateResourceLimits(config.ResourceLimits); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func validateResourceLimits(limits ResourceLimitsInfo) error {
+	if limits.CPUs != "" {
+		if _, err := cpuset.Parse(limits.CPUs); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	for _, device := range limits.IODevices {
+		if !filepath.IsAbs(device) {
+			return aoserrors.Errorf("IO device %s is not absolute", device)
+		}
+	}
+
+	if limits.MaxCPUWeight > maxCgroupWeight {
+		return aoserrors.Errorf("max CPU weight %d exceeds %d", limits.MaxCPUWeight, maxCgroupWeight)
+	}
+
+	if limits.MaxIOWeight > maxCgroupWeight {
+		return aoserrors.Errorf("max IO weight %d exceeds %d", limits.MaxIOWeight, maxCgroupWeight)
+	}
+
+	return nil
+}
+
+func validateSecurityProfiles(profiles SecurityProfilesInfo) error {
+	for name, profile := range profiles.Seccomp {
+		profile := profile
+
+		if _, err := specconv.SetupSeccomp(&profile); err != nil {
+			return aoserrors.Errorf("invalid seccomp profile %s: %v", name, err)
+		}
+	}
+
+	for _, capability := range profiles.Capabilities {
+		if !strings.HasPrefix(capability, "CAP_") {
+			return aoserrors.Errorf("invalid allowed capability %s", capability)
+		}
+	}
+
+	return nil
+}
+
+func validateResources(resources []ResourceInfo) error {
+	for _, resource := range resources {
+		for _, tmpfs := range resource.Tmpfs {
+			if !filepath.IsAbs(tmpfs.Destination) {
+				return aoserrors.Errorf("resource %s tmpfs destination %s is not absolute", resource.Name,
+					tmpfs.Destination)
+			}
+		}
+
+		for _, capability := range resource.Capabilities {
+			if !strings.HasPrefix(capability, "CAP_") {
+				return aoserrors.Errorf("resource %s has invalid capability %s", resource.Name, capability)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateHostFS(hostFS HostFSInfo) error {
+	for _, hostPath := range hostFS.AllowedPaths {
+		if !filepath.IsAbs(hostPath.Path) {
+			return aoserrors.Errorf("host path %s is not absolute", hostPath.Path)
+		}
+
+		if filepath.Clean(hostPath.Path) == "/" {
+			return aoserrors.New("host root can't be allowed as host path")
+		}
+	}
+
 	return nil
 }
 
@@ -26,10 +26,12 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aoscloud/aos_common/aoserrors"
 	"github.com/aoscloud/aos_common/aostypes"
 	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -191,7 +193,7 @@ func TestGetResourceInfo(t *testing.T) {
 		t.Errorf("Can't get resource inf: %s", err)
 	}
 
-	if !reflect.DeepEqual(resourceInfo, aostypes.ResourceInfo{
+	if !reflect.DeepEqual(resourceInfo, ResourceInfo{ResourceInfo: aostypes.ResourceInfo{
 		Name: "system-dbus",
 		Mounts: []aostypes.FileSystemMount{{
 			Destination: "/var/run/dbus/system_bus_socket",
@@ -200,13 +202,50 @@ func TestGetResourceInfo(t *testing.T) {
 			Type:        "bind",
 		}},
 		Env: []string{"DBUS_SYSTEM_BUS_ADDRESS=unix:path=/var/run/dbus/system_bus_socket"},
+	}}) {
+		t.Errorf("Wrong resource info: %v", resourceInfo)
+	}
+
+	if resourceInfo, err = rm.GetResourceInfo("realtime"); err != nil {
+		t.Errorf("Can't get resource inf: %s", err)
+	}
+
+	if !reflect.DeepEqual(resourceInfo, ResourceInfo{
+		ResourceInfo: aostypes.ResourceInfo{Name: "realtime", Env: []string{"RT_ENABLED=1"}},
+		Sysctl:       map[string]string{"kernel.sched_rt_runtime_us": "-1"},
+		Tmpfs:        []TmpfsInfo{{Destination: "/run/rt", Size: 1048576}},
+		Capabilities: []string{"CAP_SYS_NICE"},
+		SeccompAllow: []string{"sched_setattr"},
 	}) {
 		t.Errorf("Wrong resource info: %v", resourceInfo)
 	}
 
 	// request incorrect resource
-	if _, err = rm.GetResourceInfo("invalid_id"); err == nil {
-		t.Error("Resource should be unavailable")
+	if _, err = rm.GetResourceInfo("invalid_id"); !errors.Is(err, ErrNoAvailableResource) {
+		t.Errorf("Resource should be unavailable: %v", err)
+	}
+}
+
+func TestInvalidResources(t *testing.T) {
+	if err := writeTestUnitConfigFile(createEmptyUnitConfigJSON()); err != nil {
+		t.Fatalf("Can't write unit config: %s", err)
+	}
+
+	rm, err := New("mainType", path.Join(tmpDir, "aos_unit.cfg"), &alertSender{})
+	if err != nil {
+		t.Fatalf("Can't create resource manager: %s", err)
+	}
+
+	invalidResources := []string{
+		`{"name": "resource0", "tmpfs": [{"destination": "run/tmp", "size": 1024}]}`,
+		`{"name": "resource1", "capabilities": ["SYS_NICE"]}`,
+	}
+
+	for _, resource := range invalidResources {
+		if err = rm.CheckUnitConfig(
+			fmt.Sprintf(`{"nodeType": "mainType", "resources": [%s]}`, resource), "2.0"); err == nil {
+			t.Errorf("Resource should be invalid: %s", resource)
+		}
 	}
 }
 
@@ -508,6 +547,189 @@ func TestUpdateErrorUnitConfig(t *testing.T) {
 	testAlertSender.checkAlert(t)
 }
 
+func TestGetHostFSInfo(t *testing.T) {
+	if err := writeTestUnitConfigFile(createHostFSUnitConfigJSON("/var/log")); err != nil {
+		t.Fatalf("Can't write unit config: %s", err)
+	}
+
+	rm, err := New("mainType", path.Join(tmpDir, "aos_unit.cfg"), &alertSender{})
+	if err != nil {
+		t.Fatalf("Can't create resource manager: %s", err)
+	}
+
+	hostFSInfo, err := rm.GetHostFSInfo()
+	if err != nil {
+		t.Fatalf("Can't get host FS info: %s", err)
+	}
+
+	if !reflect.DeepEqual(hostFSInfo, HostFSInfo{
+		AllowedPaths: []HostPathInfo{
+			{Path: "/var/log"},
+			{Path: "/opt/data", ReadWrite: true},
+		},
+		AllowNoHostRootFS: true,
+	}) {
+		t.Errorf("Wrong host FS info: %v", hostFSInfo)
+	}
+
+	if err = rm.CheckUnitConfig(createHostFSUnitConfigJSON("var/log"), "2.0"); err == nil {
+		t.Error("Relative host path should be rejected")
+	}
+
+	if err = rm.CheckUnitConfig(createHostFSUnitConfigJSON("/"), "2.0"); err == nil {
+		t.Error("Host root path should be rejected")
+	}
+
+	if err := writeTestUnitConfigFile(createHostFSUnitConfigJSON("var/log")); err != nil {
+		t.Fatalf("Can't write unit config: %s", err)
+	}
+
+	if rm, err = New("mainType", path.Join(tmpDir, "aos_unit.cfg"), &alertSender{}); err != nil {
+		t.Fatalf("Can't create resource manager: %s", err)
+	}
+
+	if _, err = rm.GetHostFSInfo(); err == nil {
+		t.Error("Host FS info should be unavailable for invalid unit config")
+	}
+}
+
+func TestGetSecurityProfiles(t *testing.T) {
+	if err := writeTestUnitConfigFile(createSecurityProfilesUnitConfigJSON("SCMP_ACT_ALLOW")); err != nil {
+		t.Fatalf("Can't write unit config: %s", err)
+	}
+
+	rm, err := New("mainType", path.Join(tmpDir, "aos_unit.cfg"), &alertSender{})
+	if err != nil {
+		t.Fatalf("Can't create resource manager: %s", err)
+	}
+
+	profiles, err := rm.GetSecurityProfiles()
+	if err != nil {
+		t.Fatalf("Can't get security profiles: %s", err)
+	}
+
+	if !reflect.DeepEqual(profiles, SecurityProfilesInfo{
+		Seccomp: map[string]runtimespec.LinuxSeccomp{
+			"minimal": {
+				DefaultAction: runtimespec.ActErrno,
+				Syscalls: []runtimespec.LinuxSyscall{
+					{Names: []string{"read", "write"}, Action: runtimespec.ActAllow},
+				},
+			},
+		},
+		AppArmor:     []string{"aos-service"},
+		SELinux:      []string{"system_u:system_r:aos_service_t:s0"},
+		Capabilities: []string{"CAP_SYS_TIME"},
+	}) {
+		t.Errorf("Wrong security profiles: %v", profiles)
+	}
+
+	if err = rm.CheckUnitConfig(createSecurityProfilesUnitConfigJSON("SCMP_ACT_UNKNOWN"), "2.0"); err == nil {
+		t.Error("Invalid seccomp profile should be rejected")
+	}
+
+	if err = rm.CheckUnitConfig(strings.Replace(createSecurityProfilesUnitConfigJSON("SCMP_ACT_ALLOW"),
+		"CAP_SYS_TIME", "SYS_TIME", 1), "2.0"); err == nil {
+		t.Error("Invalid allowed capability should be rejected")
+	}
+}
+
+func TestGetResourceLimits(t *testing.T) {
+	if err := writeTestUnitConfigFile(createResourceLimitsUnitConfigJSON("2-3", 1000)); err != nil {
+		t.Fatalf("Can't write unit config: %s", err)
+	}
+
+	rm, err := New("mainType", path.Join(tmpDir, "aos_unit.cfg"), &alertSender{})
+	if err != nil {
+		t.Fatalf("Can't create resource manager: %s", err)
+	}
+
+	limits, err := rm.GetResourceLimits()
+	if err != nil {
+		t.Fatalf("Can't get resource limits: %s", err)
+	}
+
+	if !reflect.DeepEqual(limits, ResourceLimitsInfo{
+		CPUs: "2-3", IODevices: []string{"/dev/mmcblk0"}, MaxSwap: 1048576, MaxCPUWeight: 1000, MaxIOWeight: 500,
+	}) {
+		t.Errorf("Wrong resource limits: %v", limits)
+	}
+
+	if err = rm.CheckUnitConfig(createResourceLimitsUnitConfigJSON("3-2", 1000), "2.0"); err == nil {
+		t.Error("Invalid cpuset should be rejected")
+	}
+
+	if err = rm.CheckUnitConfig(createResourceLimitsUnitConfigJSON("2-3", 20000), "2.0"); err == nil {
+		t.Error("Invalid max CPU weight should be rejected")
+	}
+}
+
+func TestDeviceHotplug(t *testing.T) {
+	devDir := path.Join(tmpDir, "dev")
+
+	if err := os.MkdirAll(devDir, 0o755); err != nil {
+		t.Fatalf("Can't create dev dir: %s", err)
+	}
+
+	defaultDevDir := devHostDirectory
+	devHostDirectory = devDir
+
+	defer func() { devHostDirectory = defaultDevDir }()
+
+	modemDevice := path.Join(devDir, "ttyUSB0")
+	usbDevice := path.Join(devDir, "bus", "usb", "001")
+
+	if err := writeTestUnitConfigFile(createHotplugUnitConfigJSON(modemDevice, usbDevice)); err != nil {
+		t.Fatalf("Can't write unit config: %s", err)
+	}
+
+	sender := &alertSender{}
+
+	rm, err := New("mainType", path.Join(tmpDir, "aos_unit.cfg"), sender)
+	if err != nil {
+		t.Fatalf("Can't create resource manager: %s", err)
+	}
+	defer rm.Close()
+
+	if rm.unitConfigError == nil {
+		t.Fatal("Unit config with missing devices should be invalid")
+	}
+
+	if err = os.WriteFile(modemDevice, nil, 0o600); err != nil {
+		t.Fatalf("Can't create device: %s", err)
+	}
+
+	if err = os.MkdirAll(usbDevice, 0o755); err != nil {
+		t.Fatalf("Can't create device: %s", err)
+	}
+
+	for _, expectedEvent := range []DeviceEvent{{Name: "modem", Available: true}, {Name: "usb", Available: true}} {
+		if err = waitDeviceEvent(rm, expectedEvent); err != nil {
+			t.Fatalf("Wait device event error: %s", err)
+		}
+	}
+
+	if err = rm.AllocateDevice("modem", "instance0"); err != nil {
+		t.Errorf("Can't allocate device: %s", err)
+	}
+
+	if err = os.Remove(modemDevice); err != nil {
+		t.Fatalf("Can't remove device: %s", err)
+	}
+
+	if err = waitDeviceEvent(rm, DeviceEvent{Name: "modem", Available: false}); err != nil {
+		t.Fatalf("Wait device event error: %s", err)
+	}
+
+	if len(sender.alert.ResourcesErrors) != 1 || sender.alert.ResourcesErrors[0].Name != "modem" {
+		t.Errorf("Wrong device removed alert: %v", sender.alert)
+	}
+
+	if err = rm.AllocateDevice("modem", "instance1"); !errors.Is(err, ErrNoAvailableDevice) {
+		t.Errorf("Removed device should not be allocated: %v", err)
+	}
+}
+
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
@@ -613,6 +835,14 @@ func createTestUnitConfigJSON(version string) (configJSON string) {
 				"options": ["rw", "bind"]
 			}],
 			"env": ["DBUS_SYSTEM_BUS_ADDRESS=unix:path=/var/run/dbus/system_bus_socket"]
+		},
+		{
+			"name": "realtime",
+			"env": ["RT_ENABLED=1"],
+			"sysctl": {"kernel.sched_rt_runtime_us": "-1"},
+			"tmpfs": [{"destination": "/run/rt", "size": 1048576}],
+			"capabilities": ["CAP_SYS_NICE"],
+			"seccompAllow": ["sched_setattr"]
 		}
 	]
 }`, version)
@@ -704,6 +934,94 @@ func createEmptyUnitConfigJSON() (configJSON string) {
 }`
 }
 
+func createHostFSUnitConfigJSON(allowedPath string) (configJSON string) {
+	return fmt.Sprintf(`{
+	"vendorVersion": "1.0",
+	"nodeType": "mainType",
+	"hostFs": {
+		"allowedPaths": [
+			{
+				"path": "%s"
+			},
+			{
+				"path": "/opt/data",
+				"readWrite": true
+			}
+		],
+		"allowNoHostRootfs": true
+	}
+}`, allowedPath)
+}
+
+func createSecurityProfilesUnitConfigJSON(syscallAction string) (configJSON string) {
+	return fmt.Sprintf(`{
+	"vendorVersion": "1.0",
+	"nodeType": "mainType",
+	"securityProfiles": {
+		"seccomp": {
+			"minimal": {
+				"defaultAction": "SCMP_ACT_ERRNO",
+				"syscalls": [
+					{
+						"names": ["read", "write"],
+						"action": "%s"
+					}
+				]
+			}
+		},
+		"apparmor": ["aos-service"],
+		"selinux": ["system_u:system_r:aos_service_t:s0"],
+		"capabilities": ["CAP_SYS_TIME"]
+	}
+}`, syscallAction)
+}
+
+func createResourceLimitsUnitConfigJSON(cpus string, maxCPUWeight uint64) (configJSON string) {
+	return fmt.Sprintf(`{
+	"vendorVersion": "1.0",
+	"nodeType": "mainType",
+	"resourceLimits": {
+		"cpus": "%s",
+		"ioDevices": ["/dev/mmcblk0"],
+		"maxSwap": 1048576,
+		"maxCpuWeight": %d,
+		"maxIoWeight": 500
+	}
+}`, cpus, maxCPUWeight)
+}
+
+func createHotplugUnitConfigJSON(modemDevice, usbDevice string) (configJSON string) {
+	return fmt.Sprintf(`{
+	"vendorVersion": "1.0",
+	"nodeType": "mainType",
+	"devices": [
+		{
+			"name": "modem",
+			"sharedCount": 1,
+			"hostDevices": ["%s"]
+		},
+		{
+			"name": "usb",
+			"hostDevices": ["%s"]
+		}
+	]
+}`, modemDevice, usbDevice)
+}
+
+func waitDeviceEvent(rm *ResourceManager, expectedEvent DeviceEvent) error {
+	select {
+	case event := <-rm.DeviceEventChannel():
+		if event != expectedEvent {
+			return aoserrors.Errorf("wrong device event: %v", event)
+		}
+
+		return nil
+
+	case <-time.After(5 * time.Second):
+		return aoserrors.New("wait device event timeout")
+	}
+}
+
 func writeTestUnitConfigFile(content string) (err error) {
 	if err := ioutil.WriteFile(path.Join(tmpDir, "aos_unit.cfg"), []byte(content), 0o600); err != nil {
 		return aoserrors.Wrap(err)
@@ -0,0 +1,348 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scrubber periodically verifies integrity of installed services and layers
+package scrubber
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/config"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Scrubbed item types.
+const (
+	ItemTypeService = "service"
+	ItemTypeLayer   = "layer"
+)
+
+const coreComponent = "aos-servicemanager"
+
+const readChunkSize = 64 * 1024
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Item scrubbed item.
+type Item struct {
+	Type       string
+	ID         string
+	AosVersion uint64
+	Digest     string
+}
+
+// ItemProvider provides items to scrub.
+type ItemProvider interface {
+	GetScrubItems() ([]Item, error)
+	VerifyItem(ctx context.Context, item Item, limiter *Limiter) error
+	QuarantineItem(item Item) error
+}
+
+// AlertSender provides interface to send alerts.
+type AlertSender interface {
+	SendAlert(alert cloudprotocol.AlertItem)
+}
+
+// Scrubber integrity scrubber instance.
+type Scrubber struct {
+	providers   []ItemProvider
+	alertSender AlertSender
+	ioBudget    uint64
+	cancelFunc  context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// Limiter limits read rate to IO budget.
+type Limiter struct {
+	sync.Mutex
+
+	rate      uint64
+	startTime time.Time
+	readBytes uint64
+}
+
+type limitedReader struct {
+	ctx     context.Context // nolint:containedctx // reader is used within the context only
+	reader  io.Reader
+	limiter *Limiter
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrCorrupted item integrity check failed.
+var ErrCorrupted = errors.New("item is corrupted")
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates new scrubber instance. Scrubbing is disabled if period is not set.
+func New(cfg config.Scrubber, alertSender AlertSender, providers ...ItemProvider) (scrubber *Scrubber) {
+	log.Debug("New scrubber")
+
+	scrubber = &Scrubber{providers: providers, alertSender: alertSender, ioBudget: cfg.IOBudget}
+
+	if cfg.Period.Duration == 0 {
+		return scrubber
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	scrubber.cancelFunc = cancelFunc
+
+	scrubber.wg.Add(1)
+
+	go scrubber.run(ctx, cfg.Period.Duration)
+
+	return scrubber
+}
+
+// Close closes scrubber instance.
+func (scrubber *Scrubber) Close() {
+	log.Debug("Close scrubber")
+
+	if scrubber.cancelFunc != nil {
+		scrubber.cancelFunc()
+	}
+
+	scrubber.wg.Wait()
+}
+
+// Scrub verifies all items once. Corrupted items are quarantined and reported by alerts.
+func (scrubber *Scrubber) Scrub(ctx context.Context) error {
+	limiter := NewLimiter(scrubber.ioBudget)
+
+	for _, provider := range scrubber.providers {
+		items, err := provider.GetScrubItems()
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		for _, item := range items {
+			if err = ctx.Err(); err != nil {
+				return aoserrors.Wrap(err)
+			}
+
+			scrubber.scrubItem(ctx, provider, item, limiter)
+		}
+	}
+
+	return nil
+}
+
+// NewLimiter creates new limiter. Zero rate means unlimited.
+func NewLimiter(rate uint64) *Limiter {
+	return &Limiter{rate: rate, startTime: time.Now()}
+}
+
+// Reader returns reader limited by IO budget.
+func (limiter *Limiter) Reader(ctx context.Context, reader io.Reader) io.Reader {
+	if limiter == nil || limiter.rate == 0 {
+		return reader
+	}
+
+	return &limitedReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+// HashDir calculates digest of directory content including file modes, owners, symlinks and device numbers.
+func HashDir(ctx context.Context, dir string, limiter *Limiter) (digest.Digest, error) {
+	hash := sha256.New()
+
+	if err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err = ctx.Err(); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		relPath, err := filepath.Rel(dir, name)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		var uid, gid, rdev uint64
+
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid, rdev = uint64(stat.Uid), uint64(stat.Gid), stat.Rdev
+		}
+
+		content, err := hashContent(ctx, name, info, limiter)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "%q %o %d %d %d %s\n", relPath, uint32(info.Mode()), uid, gid, rdev, content)
+
+		return nil
+	}); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return digest.NewDigest(digest.SHA256, hash), nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (scrubber *Scrubber) run(ctx context.Context, period time.Duration) {
+	defer scrubber.wg.Done()
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			log.Debug("Start scrubbing")
+
+			if err := scrubber.Scrub(ctx); err != nil {
+				log.Errorf("Scrubbing failed: %v", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (scrubber *Scrubber) scrubItem(ctx context.Context, provider ItemProvider, item Item, limiter *Limiter) {
+	logFields := log.Fields{"type": item.Type, "id": item.ID, "aosVersion": item.AosVersion, "digest": item.Digest}
+
+	err := provider.VerifyItem(ctx, item, limiter)
+	if err == nil {
+		log.WithFields(logFields).Debug("Item verified")
+
+		return
+	}
+
+	if !errors.Is(err, ErrCorrupted) {
+		log.WithFields(logFields).Warnf("Can't verify item: %v", err)
+
+		return
+	}
+
+	log.WithFields(logFields).Errorf("Item is corrupted: %v", err)
+
+	if quarantineErr := provider.QuarantineItem(item); quarantineErr != nil {
+		log.WithFields(logFields).Errorf("Can't quarantine item: %v", quarantineErr)
+	}
+
+	if scrubber.alertSender != nil {
+		scrubber.alertSender.SendAlert(cloudprotocol.AlertItem{
+			Timestamp: time.Now(),
+			Tag:       cloudprotocol.AlertTagAosCore,
+			Payload: cloudprotocol.CoreAlert{
+				CoreComponent: coreComponent,
+				Message: fmt.Sprintf("%s %s version %d is corrupted and quarantined: %v",
+					item.Type, item.ID, item.AosVersion, err),
+			},
+		})
+	}
+}
+
+func hashContent(ctx context.Context, name string, info os.FileInfo, limiter *Limiter) (string, error) {
+	switch {
+	case info.Mode().IsRegular():
+		file, err := os.Open(name)
+		if err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+
+		if _, err = io.Copy(hash, limiter.Reader(ctx, file)); err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+
+		return fmt.Sprintf("%x", hash.Sum(nil)), nil
+
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(name)
+		if err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+
+		return fmt.Sprintf("%q", target), nil
+
+	default:
+		return "-", nil
+	}
+}
+
+func (reader *limitedReader) Read(buffer []byte) (count int, err error) {
+	if len(buffer) > readChunkSize {
+		buffer = buffer[:readChunkSize]
+	}
+
+	count, err = reader.reader.Read(buffer)
+
+	if waitErr := reader.limiter.wait(reader.ctx, uint64(count)); waitErr != nil {
+		return count, waitErr
+	}
+
+	return count, err // nolint:wrapcheck // io.EOF should not be wrapped
+}
+
+func (limiter *Limiter) wait(ctx context.Context, count uint64) error {
+	limiter.Lock()
+
+	limiter.readBytes += count
+	delay := time.Until(limiter.startTime.Add(
+		time.Duration(float64(limiter.readBytes) / float64(limiter.rate) * float64(time.Second))))
+
+	limiter.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		return aoserrors.Wrap(ctx.Err())
+	}
+}
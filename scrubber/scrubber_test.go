@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrubber_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/config"
+	"github.com/aoscloud/aos_servicemanager/scrubber"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testProvider struct {
+	items       []scrubber.Item
+	corrupted   map[string]bool
+	quarantined []string
+}
+
+type testAlertSender struct {
+	alerts []cloudprotocol.AlertItem
+}
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestScrub(t *testing.T) {
+	provider := &testProvider{
+		items: []scrubber.Item{
+			{Type: scrubber.ItemTypeService, ID: "service1", AosVersion: 1},
+			{Type: scrubber.ItemTypeService, ID: "service2", AosVersion: 1},
+			{Type: scrubber.ItemTypeLayer, ID: "layer1", Digest: "sha256:1111"},
+		},
+		corrupted: map[string]bool{"service2": true, "layer1": true},
+	}
+	alertSender := &testAlertSender{}
+
+	instance := scrubber.New(config.Scrubber{}, alertSender, provider)
+	defer instance.Close()
+
+	if err := instance.Scrub(context.Background()); err != nil {
+		t.Fatalf("Scrubbing failed: %v", err)
+	}
+
+	if len(provider.quarantined) != 2 || provider.quarantined[0] != "service2" ||
+		provider.quarantined[1] != "layer1" {
+		t.Errorf("Unexpected quarantined items: %v", provider.quarantined)
+	}
+
+	if len(alertSender.alerts) != 2 {
+		t.Fatalf("Unexpected alerts count: %d", len(alertSender.alerts))
+	}
+
+	for _, alert := range alertSender.alerts {
+		if alert.Tag != cloudprotocol.AlertTagAosCore {
+			t.Errorf("Unexpected alert tag: %s", alert.Tag)
+		}
+	}
+}
+
+func TestHashDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "scrubber_")
+	if err != nil {
+		t.Fatalf("Can't create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err = os.MkdirAll(filepath.Join(tmpDir, "dir"), 0o755); err != nil {
+		t.Fatalf("Can't create dir: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(tmpDir, "dir", "file"), []byte("content"), 0o644); err != nil {
+		t.Fatalf("Can't create file: %v", err)
+	}
+
+	if err = os.Symlink("file", filepath.Join(tmpDir, "dir", "link")); err != nil {
+		t.Fatalf("Can't create symlink: %v", err)
+	}
+
+	digest, err := scrubber.HashDir(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("Can't hash dir: %v", err)
+	}
+
+	changes := []func() error{
+		func() error { return os.Chmod(filepath.Join(tmpDir, "dir", "file"), 0o600) },
+		func() error { return ioutil.WriteFile(filepath.Join(tmpDir, "dir", "file"), []byte("changed"), 0o600) },
+		func() error {
+			if err := os.Remove(filepath.Join(tmpDir, "dir", "link")); err != nil {
+				return aoserrors.Wrap(err)
+			}
+
+			return aoserrors.Wrap(os.Symlink("other", filepath.Join(tmpDir, "dir", "link")))
+		},
+	}
+
+	for _, change := range changes {
+		if err = change(); err != nil {
+			t.Fatalf("Can't change dir: %v", err)
+		}
+
+		newDigest, err := scrubber.HashDir(context.Background(), tmpDir, nil)
+		if err != nil {
+			t.Fatalf("Can't hash dir: %v", err)
+		}
+
+		if newDigest == digest {
+			t.Error("Digest should be changed")
+		}
+
+		digest = newDigest
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	const rate = 64 * 1024
+
+	limiter := scrubber.NewLimiter(rate)
+	data := make([]byte, rate/2)
+
+	startTime := time.Now()
+
+	if _, err := io.Copy(ioutil.Discard, limiter.Reader(context.Background(), bytes.NewReader(data))); err != nil {
+		t.Fatalf("Can't read data: %v", err)
+	}
+
+	if elapsed := time.Since(startTime); elapsed < 400*time.Millisecond {
+		t.Errorf("Read rate is not limited: %v", elapsed)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+
+	if _, err := io.Copy(ioutil.Discard, limiter.Reader(ctx, bytes.NewReader(data))); err == nil {
+		t.Error("Error expected on canceled context")
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func (provider *testProvider) GetScrubItems() ([]scrubber.Item, error) {
+	return provider.items, nil
+}
+
+func (provider *testProvider) VerifyItem(ctx context.Context, item scrubber.Item, limiter *scrubber.Limiter) error {
+	if provider.corrupted[item.ID] {
+		return aoserrors.Errorf("checksum mismatch: %w", scrubber.ErrCorrupted)
+	}
+
+	return nil
+}
+
+func (provider *testProvider) QuarantineItem(item scrubber.Item) error {
+	provider.quarantined = append(provider.quarantined, item.ID)
+
+	return nil
+}
+
+func (sender *testAlertSender) SendAlert(alert cloudprotocol.AlertItem) {
+	sender.alerts = append(sender.alerts, alert)
+}
@@ -47,8 +47,10 @@ import (
 	"github.com/aoscloud/aos_servicemanager/networkmanager"
 	resource "github.com/aoscloud/aos_servicemanager/resourcemanager"
 	"github.com/aoscloud/aos_servicemanager/runner"
+	"github.com/aoscloud/aos_servicemanager/scrubber"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
 	"github.com/aoscloud/aos_servicemanager/smclient"
+	"github.com/aoscloud/aos_servicemanager/spacepolicy"
 )
 
 /***********************************************************************************************************************
@@ -78,6 +80,8 @@ type serviceManager struct {
 	layerMgr          *layermanager.LayerManager
 	serviceMgr        *servicemanager.ServiceManager
 	runner            *runner.Runner
+	scrubber          *scrubber.Scrubber
+	spacePolicy       *spacepolicy.Policy
 }
 
 type journalHook struct {
@@ -176,11 +180,12 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 		}
 	}
 
-	if sm.layerMgr, err = layermanager.New(cfg, sm.db); err != nil {
+	if sm.serviceMgr, err = servicemanager.New(cfg, sm.db); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.serviceMgr, err = servicemanager.New(cfg, sm.db); err != nil {
+	// Layers used by installed services are not removed
+	if sm.layerMgr, err = layermanager.New(cfg, sm.db, sm.serviceMgr); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
@@ -200,7 +205,7 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 		return sm, aoserrors.Wrap(err)
 	}
 
-	if sm.network, err = networkmanager.New(cfg, sm.db); err != nil {
+	if sm.network, err = networkmanager.New(cfg, sm.db, sm.alerts); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
@@ -233,6 +238,18 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 		return sm, aoserrors.Wrap(err)
 	}
 
+	sm.spacePolicy = spacepolicy.New(cfg.SpacePolicy, sm.alerts, sm.launcher, sm.layerMgr, sm.serviceMgr, sm.launcher)
+
+	sm.serviceMgr.SetSpaceReclaimer(sm.spacePolicy)
+	sm.layerMgr.SetSpaceReclaimer(sm.spacePolicy)
+
+	sm.scrubber = scrubber.New(cfg.Scrubber, sm.alerts, sm.serviceMgr, sm.layerMgr)
+
+	// Stored instances are reattached to the restored networks on launcher start, remove the rest
+	if err = sm.network.ReleaseRestoredInstances(); err != nil {
+		log.Errorf("Can't release restored instances: %v", err)
+	}
+
 	if sm.logging, err = logging.New(cfg, sm.db); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
@@ -242,7 +259,7 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 		NodeType:   sm.iam.GetNodeType(),
 		SystemInfo: sm.monitor.GetSystemInfo(),
 	}, sm.iam, sm.serviceMgr, sm.layerMgr, sm.launcher, sm.resourcemanager, sm.alerts, sm.monitorController, sm.logging,
-		sm.cryptoContext, false); err != nil {
+		sm.network, sm.cryptoContext, false); err != nil {
 		return sm, aoserrors.Wrap(err)
 	}
 
@@ -250,6 +267,10 @@ func newServiceManager(cfg *config.Config) (sm *serviceManager, err error) {
 }
 
 func (sm *serviceManager) close() {
+	if sm.scrubber != nil {
+		sm.scrubber.Close()
+	}
+
 	if sm.serviceMgr != nil {
 		sm.serviceMgr.Close()
 	}
@@ -266,6 +287,10 @@ func (sm *serviceManager) close() {
 		sm.launcher.Close()
 	}
 
+	if sm.resourcemanager != nil {
+		sm.resourcemanager.Close()
+	}
+
 	if sm.runner != nil {
 		sm.runner.Close()
 	}
@@ -18,21 +18,32 @@
 package servicemanager
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/aoscloud/aos_common/aoserrors"
 	"github.com/aoscloud/aos_common/aostypes"
+	"github.com/aoscloud/aos_common/utils/fs"
 	"github.com/opencontainers/go-digest"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/exp/slices"
 	"golang.org/x/mod/sumdb/dirhash"
+
+	"github.com/aoscloud/aos_servicemanager/scrubber"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
 )
 
 /***********************************************************************************************************************
@@ -41,10 +52,26 @@ import (
 
 const manifestFileName = "manifest.json"
 
+// Original image index is kept when platform manifest is selected on install.
+const indexFileName = "index.json"
+
 const buffSize = 1024 * 1024
 
 const blobsFolder = "blobs"
 
+// Delta package annotations. Rootfs layer of delta package contains OCI layer diff against base rootfs.
+const (
+	deltaBaseRootFSAnnotation = "org.aoscloud.delta.base.rootfs"
+	deltaRootFSAnnotation     = "org.aoscloud.delta.rootfs"
+	deltaFullURLAnnotation    = "org.aoscloud.delta.full.url"
+	deltaFullSha256Annotation = "org.aoscloud.delta.full.sha256"
+	deltaFullSha512Annotation = "org.aoscloud.delta.full.sha512"
+	deltaFullSizeAnnotation   = "org.aoscloud.delta.full.size"
+)
+
+// Root hash of packed rootfs image. Set on rootfs layer, so packed rootfs is shared only with identical images.
+const rootFSImageAnnotation = "org.aoscloud.rootfs.image.roothash"
+
 /***********************************************************************************************************************
  * Types
  **********************************************************************************************************************/
@@ -57,6 +84,20 @@ type ImageParts struct {
 	LayersDigest      []string
 }
 
+type deltaInfo struct {
+	baseRootFSDigest digest.Digest
+	rootFSDigest     digest.Digest
+	fullURL          string
+	fullSha256       []byte
+	fullSha512       []byte
+	fullSize         uint64
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
 type serviceManifest struct {
 	imagespec.Manifest
 	AosService *imagespec.Descriptor `json:"aosService,omitempty"`
@@ -66,6 +107,91 @@ type serviceManifest struct {
  * Private
  **********************************************************************************************************************/
 
+// If image manifest is an image index, manifest matching the node platform is selected and blobs of other platforms
+// are removed. Returns size of removed blobs.
+func resolveImageIndex(installDir string, node imagespec.Platform) (removedSize uint64, err error) {
+	indexJSON, err := ioutil.ReadFile(path.Join(installDir, manifestFileName))
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if !platform.IsIndex(indexJSON) {
+		return 0, nil
+	}
+
+	manifestDigest, err := selectPlatformManifest(installDir, indexJSON, node)
+	if err != nil {
+		return 0, err
+	}
+
+	manifestJSON, err := ioutil.ReadFile(getBlobPath(installDir, manifestDigest))
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(path.Join(installDir, indexFileName), indexJSON, 0o644); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(path.Join(installDir, manifestFileName), manifestJSON, 0o644); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	manifest, err := getImageManifest(installDir)
+	if err != nil {
+		return 0, err
+	}
+
+	usedBlobs := append(getImageBlobs(manifest), manifestDigest)
+
+	blobFiles, err := filepath.Glob(path.Join(installDir, blobsFolder, "*", "*"))
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	for _, blobFile := range blobFiles {
+		blobDigest := digest.NewDigestFromEncoded(
+			digest.Algorithm(filepath.Base(filepath.Dir(blobFile))), filepath.Base(blobFile))
+
+		if slices.Contains(usedBlobs, blobDigest) {
+			continue
+		}
+
+		size, err := fs.GetDirSize(blobFile)
+		if err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		if err = os.RemoveAll(blobFile); err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		removedSize += uint64(size)
+	}
+
+	return removedSize, nil
+}
+
+// Returns digest of validated platform manifest blob.
+func selectPlatformManifest(installDir string, indexJSON []byte, node imagespec.Platform) (digest.Digest, error) {
+	var index imagespec.Index
+
+	if err := json.Unmarshal(indexJSON, &index); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	descriptor, err := platform.Select(index.Manifests, node)
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	if err = validateDigest(installDir, descriptor.Digest); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return descriptor.Digest, nil
+}
+
 func validateUnpackedImage(installDir string) (err error) {
 	manifest, err := getImageManifest(installDir)
 	if err != nil {
@@ -102,8 +228,10 @@ func validateUnpackedImage(installDir string) (err error) {
 	}
 
 	// validate service rootfs layer
-	rootfsPath := path.Join(
-		installDir, blobsFolder, string(manifest.Layers[0].Digest.Algorithm()), manifest.Layers[0].Digest.Hex())
+	rootfsPath, err := filepath.EvalSymlinks(getBlobPath(installDir, manifest.Layers[0].Digest))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
 
 	fi, err := os.Stat(rootfsPath)
 	if err != nil {
@@ -114,6 +242,11 @@ func validateUnpackedImage(installDir string) (err error) {
 		return aoserrors.Wrap(validateDigest(installDir, manifest.Layers[0].Digest))
 	}
 
+	// Packed rootfs is verified by the kernel on read against root hash stored in DB
+	if fsimage.IsPacked(rootfsPath) {
+		return nil
+	}
+
 	rootfsHash, err := dirhash.HashDir(rootfsPath, rootfsPath, dirDigest)
 	if err != nil {
 		return aoserrors.Wrap(err)
@@ -126,6 +259,101 @@ func validateUnpackedImage(installDir string) (err error) {
 	return nil
 }
 
+// Blobs and rootfs are read within scrubber IO budget.
+func verifyServiceImage(ctx context.Context, service ServiceInfo, limiter *scrubber.Limiter) error {
+	manifestCheckSum, err := getManifestChecksum(service.ImagePath)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if !bytes.Equal(service.ManifestDigest, manifestCheckSum) {
+		return aoserrors.New("manifest checksum mismatch")
+	}
+
+	manifest, err := getImageManifest(service.ImagePath)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return aoserrors.New("no layers in image")
+	}
+
+	for _, blobDigest := range getImageBlobs(manifest) {
+		blobPath, err := filepath.EvalSymlinks(getBlobPath(service.ImagePath, blobDigest))
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		fi, err := os.Stat(blobPath)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if !fi.IsDir() {
+			if err = verifyBlob(ctx, blobPath, blobDigest, limiter); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// Packed rootfs is read by verity tools, so it is not limited by IO budget
+		if service.FSImage.Format != "" {
+			if err = VerifyImage(ctx, blobPath, service.FSImage); err != nil {
+				return aoserrors.Wrap(err)
+			}
+
+			continue
+		}
+
+		rootfsHash, err := dirhash.HashDir(blobPath, blobPath,
+			func(files []string, open func(string) (io.ReadCloser, error)) (string, error) {
+				return dirDigest(files, func(name string) (io.ReadCloser, error) {
+					file, err := open(name)
+					if err != nil {
+						return nil, err
+					}
+
+					return &limitedReadCloser{Reader: limiter.Reader(ctx, file), Closer: file}, nil
+				})
+			})
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if blobDigest.String() != rootfsHash {
+			return aoserrors.New("incorrect rootfs checksum")
+		}
+	}
+
+	return nil
+}
+
+func verifyBlob(ctx context.Context, blobPath string, blobDigest digest.Digest, limiter *scrubber.Limiter) error {
+	if err := blobDigest.Validate(); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	file, err := os.Open(blobPath)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	verifier := blobDigest.Verifier()
+
+	if _, err = io.Copy(verifier, limiter.Reader(ctx, file)); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if !verifier.Verified() {
+		return aoserrors.Errorf("blob %s hash mismatch", blobDigest)
+	}
+
+	return nil
+}
+
 func getImageManifest(installDir string) (manifest *serviceManifest, err error) {
 	manifestJSON, err := ioutil.ReadFile(path.Join(installDir, manifestFileName))
 	if err != nil {
@@ -226,7 +454,96 @@ func getLayersFromManifest(manifest *serviceManifest) (layers []string) {
 	return layers
 }
 
-func updateRootFSDigestInManifest(installDir string, digest digest.Digest) (err error) {
+func getImageBlobs(manifest *serviceManifest) (blobs []digest.Digest) {
+	descriptors := []*imagespec.Descriptor{&manifest.Config, manifest.AosService}
+
+	if len(manifest.Layers) > 0 {
+		descriptors = append(descriptors, &manifest.Layers[0])
+	}
+
+	for _, descriptor := range descriptors {
+		if descriptor != nil && descriptor.Digest != "" && !slices.Contains(blobs, descriptor.Digest) {
+			blobs = append(blobs, descriptor.Digest)
+		}
+	}
+
+	return blobs
+}
+
+// Rootfs ownership depends on service GID, so rootfs is shared only between services with the same GID.
+func getStoreDigest(manifest *serviceManifest, blobDigest digest.Digest, gid uint32) digest.Digest {
+	if len(manifest.Layers) > 0 && blobDigest == manifest.Layers[0].Digest {
+		if rootHash := manifest.Layers[0].Annotations[rootFSImageAnnotation]; rootHash != "" {
+			return digest.FromString(fmt.Sprintf("%s:%d:%s", blobDigest, gid, rootHash))
+		}
+
+		return digest.FromString(fmt.Sprintf("%s:%d", blobDigest, gid))
+	}
+
+	return blobDigest
+}
+
+// Returns store digests of image blobs moved to the blob store.
+func getImageStoreDigests(imagePath string, gid uint32) (storeDigests []digest.Digest, err error) {
+	manifest, err := getImageManifest(imagePath)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	for _, blobDigest := range getImageBlobs(manifest) {
+		// Blobs of services installed before the blob store are not symlinks
+		if fileInfo, err := os.Lstat(getBlobPath(imagePath, blobDigest)); err != nil ||
+			fileInfo.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		storeDigests = append(storeDigests, getStoreDigest(manifest, blobDigest, gid))
+	}
+
+	return storeDigests, nil
+}
+
+// Returns nil if image is not a delta package.
+func getDeltaInfo(manifest *serviceManifest) (delta *deltaInfo, err error) {
+	baseRootFS, ok := manifest.Annotations[deltaBaseRootFSAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	delta = &deltaInfo{fullURL: manifest.Annotations[deltaFullURLAnnotation]}
+
+	if delta.baseRootFSDigest, err = digest.Parse(baseRootFS); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if delta.rootFSDigest, err = digest.Parse(manifest.Annotations[deltaRootFSAnnotation]); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if delta.fullURL == "" {
+		return delta, nil
+	}
+
+	if delta.fullSha256, err = hex.DecodeString(manifest.Annotations[deltaFullSha256Annotation]); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if delta.fullSha512, err = hex.DecodeString(manifest.Annotations[deltaFullSha512Annotation]); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if delta.fullSize, err = strconv.ParseUint(manifest.Annotations[deltaFullSizeAnnotation], 10, 64); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return delta, nil
+}
+
+func getBlobPath(installDir string, blobDigest digest.Digest) string {
+	return path.Join(installDir, blobsFolder, string(blobDigest.Algorithm()), blobDigest.Hex())
+}
+
+func updateRootFSInManifest(installDir string, digest digest.Digest, fsImage fsimage.Info) (err error) {
 	manifest, err := getImageManifest(installDir)
 	if err != nil {
 		return aoserrors.Wrap(err)
@@ -234,6 +551,14 @@ func updateRootFSDigestInManifest(installDir string, digest digest.Digest) (err
 
 	manifest.Layers[0].Digest = digest
 
+	if fsImage.Format != "" {
+		if manifest.Layers[0].Annotations == nil {
+			manifest.Layers[0].Annotations = make(map[string]string)
+		}
+
+		manifest.Layers[0].Annotations[rootFSImageAnnotation] = fsImage.RootHash
+	}
+
 	return aoserrors.Wrap(saveImageManifest(manifest, installDir))
 }
 
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicemanager
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
+	"github.com/aoscloud/aos_common/spaceallocator"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/aoscloud/aos_servicemanager/utils/ociregistry"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
+)
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// Service image is pulled into the same layout as unpacked service package. Only rootfs layer is pulled, other
+// layers are installed by layer manager.
+func (sm *ServiceManager) pullPackageByReference(
+	ctx context.Context, serviceInfo *aostypes.ServiceInfo,
+) (imagePath string, serviceSize uint64, space spaceallocator.Space, err error) {
+	ref, err := ociregistry.ParseReference(serviceInfo.URL)
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	var expectedDigest digest.Digest
+
+	if len(serviceInfo.Sha256) > 0 {
+		expectedDigest = digest.NewDigestFromEncoded(digest.SHA256, hex.EncodeToString(serviceInfo.Sha256))
+	}
+
+	manifestJSON, err := sm.registry.GetPlatformManifest(ctx, ref, expectedDigest, sm.platform)
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	var manifest serviceManifest
+
+	if err = json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	if len(manifest.Layers) == 0 {
+		return "", 0, nil, aoserrors.New("no layers in image")
+	}
+
+	descriptors := []imagespec.Descriptor{manifest.Config, manifest.Layers[0]}
+
+	if manifest.AosService != nil {
+		descriptors = append(descriptors, *manifest.AosService)
+	}
+
+	var blobsSize uint64
+
+	for _, descriptor := range descriptors {
+		blobsSize += uint64(descriptor.Size)
+	}
+
+	size := uint64(len(manifestJSON)) + blobsSize
+
+	packageSpace, err := sm.allocateSpace(size)
+	if err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	var packagePath string
+
+	defer func() {
+		if err != nil {
+			releaseAllocatedSpace(packagePath, nil, packageSpace)
+		}
+	}()
+
+	if packagePath, err = ioutil.TempDir(sm.servicesDir, ""); err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	if err = os.MkdirAll(path.Join(packagePath, blobsFolder, string(digest.SHA256)), 0o755); err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	var downloaded uint64
+
+	notifier := progress.DownloadNotifier(sm.progressChannel, sm.progressEvent(*serviceInfo))
+
+	for _, descriptor := range descriptors {
+		blobPath := getBlobPath(packagePath, descriptor.Digest)
+
+		if _, err = os.Stat(blobPath); err == nil {
+			continue
+		}
+
+		if err = sm.registry.GetBlob(ctx, ref, descriptor, blobPath, func(blobDownloaded, _ uint64) {
+			notifier(downloaded+blobDownloaded, blobsSize)
+		}); err != nil {
+			return "", 0, nil, aoserrors.Wrap(err)
+		}
+
+		downloaded += uint64(descriptor.Size)
+	}
+
+	if err = ioutil.WriteFile(path.Join(packagePath, manifestFileName), manifestJSON, 0o644); err != nil {
+		return "", 0, nil, aoserrors.Wrap(err)
+	}
+
+	return packagePath, size, packageSpace, nil
+}
@@ -20,11 +20,13 @@ package servicemanager
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -37,11 +39,25 @@ import (
 	"github.com/aoscloud/aos_common/aostypes"
 	"github.com/aoscloud/aos_common/image"
 	"github.com/aoscloud/aos_common/spaceallocator"
+	"github.com/aoscloud/aos_common/utils/action"
+	"github.com/aoscloud/aos_common/utils/fs"
 	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 	"golang.org/x/mod/sumdb/dirhash"
 
 	"github.com/aoscloud/aos_servicemanager/config"
+	"github.com/aoscloud/aos_servicemanager/layermanager"
+	"github.com/aoscloud/aos_servicemanager/scrubber"
+	"github.com/aoscloud/aos_servicemanager/spacepolicy"
+	"github.com/aoscloud/aos_servicemanager/utils/blobstore"
+	"github.com/aoscloud/aos_servicemanager/utils/downloader"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
+	"github.com/aoscloud/aos_servicemanager/utils/install"
+	"github.com/aoscloud/aos_servicemanager/utils/ociregistry"
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
 	"github.com/aoscloud/aos_servicemanager/utils/whiteouts"
 )
 
@@ -49,7 +65,13 @@ import (
  * Consts
  **********************************************************************************************************************/
 
-const tmpRootFSDir = "tmprootfs"
+const (
+	tmpRootFSDir       = "tmprootfs"
+	tmpDeltaDir        = "tmpdelta"
+	tmpFSImageDir      = "tmpfsimage"
+	tmpBaseRootFSDir   = "tmpbaserootfs"
+	serviceDownloadDir = "services"
+)
 
 /***********************************************************************************************************************
  * Types
@@ -62,18 +84,36 @@ type ServiceStorage interface {
 	AddService(ServiceInfo) error
 	RemoveService(serviceID string, aosVersion uint64) error
 	SetServiceCached(serviceID string, aosVersion uint64, cached bool) error
+	SetServiceQuarantined(serviceID string, aosVersion uint64, quarantined bool) error
+	SetServicePrefetched(serviceID string, aosVersion uint64, prefetched bool) error
+	blobstore.Storage
 }
 
 // ServiceManager instance.
 type ServiceManager struct {
 	sync.Mutex
 	servicesDir            string
-	downloadDir            string
 	serviceTTLDays         uint64
+	installConcurrency     int
+	installTimeout         time.Duration
+	signaturePolicy        string
+	caPool                 *x509.CertPool
 	serviceInfoProvider    ServiceStorage
-	serviceAllocator       spaceallocator.Allocator
-	downloadAllocator      spaceallocator.Allocator
+	serviceAllocator       *spacepolicy.ReservingAllocator
+	partLimit              uint
+	spaceReclaimer         SpaceReclaimer
+	downloader             *downloader.Downloader
+	registry               *ociregistry.Client
+	platform               imagespec.Platform
+	blobStore              *blobstore.BlobStore
+	fsImage                config.FSImage
 	validateTTLStopChannel chan struct{}
+	progressChannel        chan progress.Event
+}
+
+// SpaceReclaimer frees node space by evicting items of other owners.
+type SpaceReclaimer interface {
+	ReclaimSpace(path string, size uint64) error
 }
 
 // ServiceInfo service information.
@@ -87,6 +127,14 @@ type ServiceInfo struct {
 	Cached          bool
 	Size            uint64
 	GID             uint32
+	Quarantined     bool
+	Prefetched      bool
+	FSImage         fsimage.Info
+}
+
+// deltaBaseError is returned when base version of delta package is not installed.
+type deltaBaseError struct {
+	delta deltaInfo
 }
 
 /***********************************************************************************************************************
@@ -98,6 +146,12 @@ var (
 	ErrNotExist = errors.New("service not exist")
 	// ErrVersionMismatch new service version <= existing one.
 	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrNotSigned service image is not signed.
+	ErrNotSigned = errors.New("image is not signed")
+	// ErrNotTrusted service image signature is not trusted.
+	ErrNotTrusted = errors.New("image signature is not trusted")
+	// ErrQuarantined service is quarantined due to integrity check failure.
+	ErrQuarantined = errors.New("service is quarantined")
 )
 
 // NewSpaceAllocator space allocator constructor.
@@ -108,6 +162,18 @@ var NewSpaceAllocator = spaceallocator.New
 // nolint:gochecknoglobals // used for unit test mock
 var RemoveCachedServicesPeriod = 24 * time.Hour
 
+// nolint:gochecknoglobals // used for unit test mock
+var (
+	// PackImage packs service rootfs into filesystem image.
+	PackImage = fsimage.Pack
+	// VerifyImage verifies packed service rootfs.
+	VerifyImage = fsimage.Verify
+	// MountImage mounts packed service rootfs.
+	MountImage = fsimage.Mount
+	// UmountImage unmounts packed service rootfs.
+	UmountImage = fsimage.Umount
+)
+
 /***********************************************************************************************************************
  * Public
  **********************************************************************************************************************/
@@ -118,30 +184,53 @@ func New(
 ) (sm *ServiceManager, err error) {
 	sm = &ServiceManager{
 		servicesDir:            config.ServicesDir,
-		downloadDir:            config.DownloadDir,
 		serviceTTLDays:         config.ServiceTTLDays,
+		installConcurrency:     config.InstallConcurrency,
+		installTimeout:         config.InstallTimeout.Duration,
+		signaturePolicy:        config.ImageSignaturePolicy,
 		serviceInfoProvider:    serviceInfoProvider,
+		registry:               ociregistry.New(config.InsecureRegistries),
+		platform:               platform.Node(),
+		fsImage:                config.FSImage,
 		validateTTLStopChannel: make(chan struct{}),
+		progressChannel:        make(chan progress.Event, progress.EventChannelSize),
 	}
 
 	if err = os.MkdirAll(sm.servicesDir, 0o755); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if err := os.RemoveAll(sm.downloadDir); err != nil {
-		return nil, aoserrors.Wrap(err)
+	switch sm.signaturePolicy {
+	case SignaturePolicyNone:
+
+	case SignaturePolicyVerify, SignaturePolicyRequire:
+		if sm.caPool, err = getCACertPool(config.CACert); err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+	default:
+		return nil, aoserrors.Errorf("unsupported image signature policy: %s", sm.signaturePolicy)
 	}
 
-	if err := os.MkdirAll(sm.downloadDir, 0o755); err != nil {
+	if sm.fsImage.Format != "" {
+		if err = fsimage.CheckFormat(sm.fsImage.Format, sm.fsImage.Verity); err != nil {
+			return nil, err
+		}
+	}
+
+	serviceAllocator, err := NewSpaceAllocator(sm.servicesDir, config.ServicesPartLimit, sm.removeOutdatedService)
+	if err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if sm.serviceAllocator, err = NewSpaceAllocator(
-		sm.servicesDir, config.ServicesPartLimit, sm.removeOutdatedService); err != nil {
+	sm.serviceAllocator = spacepolicy.NewReservingAllocator(serviceAllocator)
+	sm.partLimit = config.ServicesPartLimit
+
+	if sm.downloader, err = downloader.New(filepath.Join(config.DownloadDir, serviceDownloadDir)); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
-	if sm.downloadAllocator, err = NewSpaceAllocator(sm.downloadDir, 0, nil); err != nil {
+	if sm.blobStore, err = blobstore.New(filepath.Join(sm.servicesDir, blobsFolder), serviceInfoProvider); err != nil {
 		return nil, aoserrors.Wrap(err)
 	}
 
@@ -155,8 +244,8 @@ func New(
 			continue
 		}
 
-		if err := sm.serviceAllocator.AddOutdatedItem(
-			fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion), service.Size, service.Timestamp); err != nil {
+		if err := sm.serviceAllocator.AddOutdatedItem(fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion),
+			service.Size+sm.getReleasableBlobsSize(service), service.Timestamp); err != nil {
 			return nil, aoserrors.Wrap(err)
 		}
 	}
@@ -180,8 +269,8 @@ func (sm *ServiceManager) Close() {
 		log.Errorf("Can't close service allocator: %v", err)
 	}
 
-	if err := sm.downloadAllocator.Close(); err != nil {
-		log.Errorf("Can't close download allocator: %v", err)
+	if err := sm.downloader.Close(); err != nil {
+		log.Errorf("Can't close downloader: %v", err)
 	}
 
 	close(sm.validateTTLStopChannel)
@@ -195,7 +284,7 @@ func (sm *ServiceManager) GetServiceInfo(serviceID string) (serviceInfo ServiceI
 	}
 
 	for _, service := range services {
-		if service.ServiceID == serviceID && !service.Cached {
+		if service.ServiceID == serviceID && !service.Cached && !service.Prefetched {
 			return service, nil
 		}
 	}
@@ -208,26 +297,69 @@ func (sm *ServiceManager) GetImageParts(service ServiceInfo) (parts ImageParts,
 	return getImageParts(service.ImagePath)
 }
 
+// GetLayerUsers returns installed service versions which use layers, by layer digest.
+func (sm *ServiceManager) GetLayerUsers() (layerUsers map[string][]layermanager.LayerUser, err error) {
+	services, err := sm.serviceInfoProvider.GetServices()
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	layerUsers = make(map[string][]layermanager.LayerUser)
+
+	for _, service := range services {
+		parts, err := getImageParts(service.ImagePath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"serviceID": service.ServiceID, "aosVersion": service.AosVersion,
+			}).Warnf("Can't get service layers: %v", err)
+
+			continue
+		}
+
+		for _, layerDigest := range parts.LayersDigest {
+			layerUsers[layerDigest] = append(layerUsers[layerDigest], layermanager.LayerUser{
+				ServiceID: service.ServiceID, AosVersion: service.AosVersion,
+			})
+		}
+	}
+
+	return layerUsers, nil
+}
+
 // ProcessDesiredServices installs, removes, restores desired services on the system.
-func (sm *ServiceManager) ProcessDesiredServices(desiredServices []aostypes.ServiceInfo) error {
+// Prefetch services are installed but kept inactive until they are desired. Their space stays reserved.
+// Installation is aborted when ctx is canceled.
+func (sm *ServiceManager) ProcessDesiredServices(
+	ctx context.Context, desiredServices, prefetchServices []aostypes.ServiceInfo,
+) error {
 	services, err := sm.serviceInfoProvider.GetServices()
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if desiredServices, err = sm.updateCachedServices(desiredServices, services); err != nil {
+	if desiredServices, prefetchServices, err = sm.updateCachedServices(
+		desiredServices, prefetchServices, services); err != nil {
 		return err
 	}
 
-	if err = sm.installServices(desiredServices); err != nil {
+	if err = sm.installServices(ctx, desiredServices, prefetchServices); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// GetInstallProgressChannel returns services install progress channel.
+func (sm *ServiceManager) GetInstallProgressChannel() (channel <-chan progress.Event) {
+	return sm.progressChannel
+}
+
 // ValidateService validate service.
 func (sm *ServiceManager) ValidateService(service ServiceInfo) error {
+	if service.Quarantined {
+		return aoserrors.Wrap(ErrQuarantined)
+	}
+
 	manifestCheckSum, err := getManifestChecksum(service.ImagePath)
 	if err != nil {
 		return aoserrors.Wrap(err)
@@ -237,67 +369,361 @@ func (sm *ServiceManager) ValidateService(service ServiceInfo) error {
 		return aoserrors.New("manifest checksum mismatch")
 	}
 
-	return validateUnpackedImage(service.ImagePath)
+	if err = validateUnpackedImage(service.ImagePath); err != nil {
+		return err
+	}
+
+	return sm.checkImageSignature(service.ImagePath)
+}
+
+// GetScrubItems returns services to be verified by scrubber.
+func (sm *ServiceManager) GetScrubItems() (items []scrubber.Item, err error) {
+	services, err := sm.serviceInfoProvider.GetServices()
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		if service.Quarantined {
+			continue
+		}
+
+		items = append(items, scrubber.Item{
+			Type: scrubber.ItemTypeService, ID: service.ServiceID, AosVersion: service.AosVersion,
+		})
+	}
+
+	return items, nil
+}
+
+// VerifyItem verifies service integrity.
+func (sm *ServiceManager) VerifyItem(ctx context.Context, item scrubber.Item, limiter *scrubber.Limiter) error {
+	service, err := sm.getServiceVersion(item.ID, item.AosVersion)
+	if err != nil {
+		return err
+	}
+
+	if err = verifyServiceImage(ctx, service, limiter); err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	// Service could be removed during verification
+	if _, getErr := sm.getServiceVersion(item.ID, item.AosVersion); errors.Is(getErr, ErrNotExist) {
+		return nil
+	}
+
+	return aoserrors.Errorf("%v: %w", err, scrubber.ErrCorrupted)
+}
+
+// QuarantineItem marks service as quarantined. Quarantined service is not started and is reinstalled when it is
+// desired next time.
+func (sm *ServiceManager) QuarantineItem(item scrubber.Item) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	if err := sm.serviceInfoProvider.SetServiceQuarantined(item.ID, item.AosVersion, true); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// SetSpaceReclaimer sets space reclaimer which is used when there is no space to install service.
+func (sm *ServiceManager) SetSpaceReclaimer(spaceReclaimer SpaceReclaimer) {
+	sm.spaceReclaimer = spaceReclaimer
+}
+
+// GetSpaceUsage returns space used by installed services.
+func (sm *ServiceManager) GetSpaceUsage() (usage spacepolicy.Usage, err error) {
+	services, err := sm.serviceInfoProvider.GetServices()
+	if err != nil {
+		return usage, aoserrors.Wrap(err)
+	}
+
+	usage.Type = spacepolicy.ItemTypeService
+	usage.Path = sm.servicesDir
+	usage.PartLimit = sm.partLimit
+	usage.ReservedSize = sm.serviceAllocator.ReservedSize()
+
+	for _, service := range services {
+		usage.Size += service.Size
+	}
+
+	blobs, err := sm.serviceInfoProvider.GetBlobs()
+	if err != nil {
+		return usage, aoserrors.Wrap(err)
+	}
+
+	for _, blob := range blobs {
+		usage.Size += blob.Size
+	}
+
+	return usage, nil
+}
+
+// GetEvictableItems returns cached services.
+func (sm *ServiceManager) GetEvictableItems() (items []spacepolicy.Item, err error) {
+	services, err := sm.serviceInfoProvider.GetServices()
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		if !service.Cached {
+			continue
+		}
+
+		items = append(items, spacepolicy.Item{
+			Type:       spacepolicy.ItemTypeService,
+			ID:         service.ServiceID,
+			AosVersion: service.AosVersion,
+			Path:       service.ImagePath,
+			Size:       service.Size + sm.getReleasableBlobsSize(service),
+			Timestamp:  service.Timestamp,
+		})
+	}
+
+	return items, nil
+}
+
+// EvictItem removes cached service.
+func (sm *ServiceManager) EvictItem(item spacepolicy.Item) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	services, err := sm.serviceInfoProvider.GetAllServiceVersions(item.ID)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		if service.AosVersion != item.AosVersion {
+			continue
+		}
+
+		if !service.Cached {
+			return aoserrors.Errorf("service %s version %d is not cached", service.ServiceID, service.AosVersion)
+		}
+
+		return sm.removeService(service)
+	}
+
+	return aoserrors.Wrap(ErrNotExist)
 }
 
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
 
+// If there is no space, items of other owners are evicted by space reclaimer and allocation is retried.
+func (sm *ServiceManager) allocateSpace(size uint64) (spaceallocator.Space, error) {
+	space, err := sm.serviceAllocator.AllocateSpace(size)
+	if !errors.Is(err, spaceallocator.ErrNoSpace) || sm.spaceReclaimer == nil {
+		return space, aoserrors.Wrap(err)
+	}
+
+	if reclaimErr := sm.spaceReclaimer.ReclaimSpace(sm.servicesDir, size); reclaimErr != nil {
+		log.Warnf("Can't reclaim space: %v", reclaimErr)
+
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if space, err = sm.serviceAllocator.AllocateSpace(size); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return space, nil
+}
+
+func (sm *ServiceManager) getServiceVersion(serviceID string, aosVersion uint64) (service ServiceInfo, err error) {
+	services, err := sm.serviceInfoProvider.GetAllServiceVersions(serviceID)
+	if err != nil {
+		return service, aoserrors.Wrap(err)
+	}
+
+	for _, storeService := range services {
+		if storeService.AosVersion == aosVersion {
+			return storeService, nil
+		}
+	}
+
+	return service, aoserrors.Wrap(ErrNotExist)
+}
+
 func (sm *ServiceManager) updateCachedServices(
-	desiredServices []aostypes.ServiceInfo, storeServices []ServiceInfo,
-) (installServices []aostypes.ServiceInfo, err error) {
-nextService:
+	desiredServices, prefetchServices []aostypes.ServiceInfo, storeServices []ServiceInfo,
+) (installServices, installPrefetchServices []aostypes.ServiceInfo, err error) {
+	installServices = slices.Clone(desiredServices)
+
+	// Desired service is activated even if it is also requested to prefetch
+	for _, prefetchService := range prefetchServices {
+		if findService(installServices, prefetchService.ID, prefetchService.AosVersion) < 0 {
+			installPrefetchServices = append(installPrefetchServices, prefetchService)
+		}
+	}
+
 	for _, storeService := range storeServices {
-		for i, desiredService := range desiredServices {
-			if desiredService.ID == storeService.ServiceID && desiredService.AosVersion == storeService.AosVersion {
-				if storeService.Cached {
-					if err := sm.setServiceCached(storeService, false); err != nil {
-						return desiredServices, err
-					}
-				}
+		if i := findService(installServices, storeService.ServiceID, storeService.AosVersion); i >= 0 {
+			reinstall, err := sm.updateStoredService(storeService, false)
+			if err != nil {
+				return installServices, installPrefetchServices, err
+			}
 
-				desiredServices = append(desiredServices[:i], desiredServices[i+1:]...)
+			if !reinstall {
+				installServices = append(installServices[:i], installServices[i+1:]...)
+			}
 
-				continue nextService
+			continue
+		}
+
+		if i := findService(installPrefetchServices, storeService.ServiceID, storeService.AosVersion); i >= 0 {
+			reinstall, err := sm.updateStoredService(storeService, true)
+			if err != nil {
+				return installServices, installPrefetchServices, err
 			}
+
+			if !reinstall {
+				installPrefetchServices = append(installPrefetchServices[:i], installPrefetchServices[i+1:]...)
+			}
+
+			continue
 		}
 
 		if !storeService.Cached {
 			if err := sm.setServiceCached(storeService, true); err != nil {
-				return desiredServices, err
+				return installServices, installPrefetchServices, err
 			}
 		}
 	}
 
-	return desiredServices, nil
+	return installServices, installPrefetchServices, nil
 }
 
-func (sm *ServiceManager) installServices(desiredServices []aostypes.ServiceInfo) error {
-	for _, desiredService := range desiredServices {
-		if err := sm.installService(desiredService); err != nil {
-			return err
+// Quarantined service is removed and should be installed again.
+func (sm *ServiceManager) updateStoredService(storeService ServiceInfo, prefetch bool) (reinstall bool, err error) {
+	if storeService.Quarantined {
+		if err := sm.removeService(storeService); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	if storeService.Cached {
+		if err := sm.setServiceCached(storeService, false); err != nil {
+			return false, err
 		}
 	}
 
+	if storeService.Prefetched != prefetch {
+		if err := sm.serviceInfoProvider.SetServicePrefetched(
+			storeService.ServiceID, storeService.AosVersion, prefetch); err != nil {
+			return false, aoserrors.Wrap(err)
+		}
+	}
+
+	return false, nil
+}
+
+func (sm *ServiceManager) installServices(
+	ctx context.Context, desiredServices, prefetchServices []aostypes.ServiceInfo,
+) error {
+	services := append(slices.Clone(desiredServices), prefetchServices...)
+
+	var installErrorsMutex sync.Mutex
+
+	installErr := &progress.InstallError{
+		ItemType: progress.ItemTypeService, Total: len(services), Errors: make(map[string]error),
+	}
+	actionHandler := action.New(sm.installConcurrency)
+
+	for i, service := range services {
+		i, service := i, service
+
+		actionHandler.Execute(service.ID, func(serviceID string) error {
+			installCtx, cancelFunc := install.WithTimeout(ctx, sm.installTimeout)
+			defer cancelFunc()
+
+			sm.sendProgress(service, progress.Event{State: progress.StateStarted})
+
+			// Failed service doesn't break installation of other services
+			if err := sm.installService(installCtx, service, i >= len(desiredServices)); err != nil {
+				log.WithFields(log.Fields{
+					"ID":         service.ID,
+					"AosVersion": service.AosVersion,
+				}).Errorf("Can't install service: %v", err)
+
+				sm.sendProgress(service, progress.Event{State: progress.StateFailed, Error: err.Error()})
+
+				installErrorsMutex.Lock()
+				installErr.Errors[service.ID] = err
+				installErrorsMutex.Unlock()
+
+				return err
+			}
+
+			sm.sendProgress(service, progress.Event{State: progress.StateInstalled})
+
+			return nil
+		})
+	}
+
+	actionHandler.Wait()
+
+	if len(installErr.Errors) != 0 {
+		return installErr
+	}
+
 	return nil
 }
 
-func (sm *ServiceManager) installService(serviceInfo aostypes.ServiceInfo) error {
+// If base version of delta package is not installed, full package referenced by delta package is installed instead.
+func (sm *ServiceManager) installService(ctx context.Context, serviceInfo aostypes.ServiceInfo, prefetch bool) error {
+	err := sm.installServicePackage(ctx, serviceInfo, prefetch)
+
+	var baseErr *deltaBaseError
+
+	if !errors.As(err, &baseErr) || baseErr.delta.fullURL == "" {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ID":         serviceInfo.ID,
+		"AosVersion": serviceInfo.AosVersion,
+	}).Warnf("Install full package: %v", err)
+
+	serviceInfo.URL = baseErr.delta.fullURL
+	serviceInfo.Sha256 = baseErr.delta.fullSha256
+	serviceInfo.Sha512 = baseErr.delta.fullSha512
+	serviceInfo.Size = baseErr.delta.fullSize
+
+	return sm.installServicePackage(ctx, serviceInfo, prefetch)
+}
+
+func (sm *ServiceManager) installServicePackage(
+	ctx context.Context, serviceInfo aostypes.ServiceInfo, prefetch bool,
+) error {
 	log.WithFields(log.Fields{
 		"ID":         serviceInfo.ID,
 		"AosVersion": serviceInfo.AosVersion,
+		"prefetch":   prefetch,
 	}).Debug("Install service")
 
+	if err := ctx.Err(); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
 	var spacePackage, spaceService spaceallocator.Space
 
-	imagePath, size, spacePackage, err := sm.extractPackageByURL(&serviceInfo)
+	imagePath, size, spacePackage, err := sm.extractPackageByURL(ctx, &serviceInfo)
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
 	defer func() {
 		if err != nil {
+			// Blobs stored by this install are freed by releasing the allocated space
+			sm.releaseImageBlobs(imagePath, serviceInfo.GID)
 			releaseAllocatedSpace(imagePath, spaceService, spacePackage)
 
 			log.WithFields(log.Fields{
@@ -312,11 +738,29 @@ func (sm *ServiceManager) installService(serviceInfo aostypes.ServiceInfo) error
 		acceptAllocatedSpace(spaceService, spacePackage)
 	}()
 
+	removedSize, err := resolveImageIndex(imagePath, sm.platform)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	// Blobs of other platforms are removed from the package
+	sm.serviceAllocator.FreeSpace(removedSize)
+	size -= removedSize
+
 	if err = validateUnpackedImage(imagePath); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	serviceSize, space, rootFSDigest, err := sm.prepareServiceFS(imagePath, int(serviceInfo.GID))
+	if err = sm.saveSignedManifest(imagePath); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = sm.checkImageSignature(imagePath); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	serviceSize, space, rootFSDigest, err := sm.prepareServiceFS(ctx, imagePath, serviceInfo.ID,
+		int(serviceInfo.GID))
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -324,16 +768,32 @@ func (sm *ServiceManager) installService(serviceInfo aostypes.ServiceInfo) error
 	spaceService = space
 	size += uint64(serviceSize)
 
-	if err = updateRootFSDigestInManifest(imagePath, rootFSDigest); err != nil {
+	fsImage, freedSize, err := sm.packServiceFS(ctx, imagePath, rootFSDigest)
+	if err != nil {
+		return err
+	}
+
+	sm.serviceAllocator.FreeSpace(freedSize)
+	size -= freedSize
+
+	if err = updateRootFSInManifest(imagePath, rootFSDigest, fsImage); err != nil {
 		return aoserrors.Wrap(err)
 	}
 
+	blobsSize, sharedSize, err := sm.storeImageBlobs(imagePath, serviceInfo.GID)
+	if err != nil {
+		return err
+	}
+
+	// Stored blobs are accounted by the blob store and freed when their last reference is released
+	size -= blobsSize
+
 	manifestDigest, err := getManifestChecksum(imagePath)
 	if err != nil {
 		return aoserrors.Wrap(err)
 	}
 
-	if err = sm.serviceInfoProvider.AddService(ServiceInfo{
+	if err = sm.addService(ServiceInfo{
 		VersionInfo:     serviceInfo.VersionInfo,
 		ServiceID:       serviceInfo.ID,
 		ServiceProvider: serviceInfo.ProviderID,
@@ -342,10 +802,15 @@ func (sm *ServiceManager) installService(serviceInfo aostypes.ServiceInfo) error
 		ManifestDigest:  manifestDigest,
 		Timestamp:       time.Now().UTC(),
 		GID:             serviceInfo.GID,
+		Prefetched:      prefetch,
+		FSImage:         fsImage,
 	}); err != nil {
-		return aoserrors.Wrap(err)
+		return err
 	}
 
+	// Shared blobs are already accounted by the services which stored them first
+	sm.serviceAllocator.FreeSpace(sharedSize)
+
 	log.WithFields(log.Fields{
 		"id":         serviceInfo.ID,
 		"aosVersion": serviceInfo.AosVersion,
@@ -355,6 +820,31 @@ func (sm *ServiceManager) installService(serviceInfo aostypes.ServiceInfo) error
 	return nil
 }
 
+func (sm *ServiceManager) addService(service ServiceInfo) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	if err := sm.serviceInfoProvider.AddService(service); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (sm *ServiceManager) progressEvent(serviceInfo aostypes.ServiceInfo) progress.Event {
+	return progress.Event{
+		ItemType: progress.ItemTypeService, ID: serviceInfo.ID, AosVersion: serviceInfo.AosVersion,
+	}
+}
+
+func (sm *ServiceManager) sendProgress(serviceInfo aostypes.ServiceInfo, event progress.Event) {
+	item := sm.progressEvent(serviceInfo)
+
+	event.ItemType, event.ID, event.AosVersion = item.ItemType, item.ID, item.AosVersion
+
+	progress.Send(sm.progressChannel, event)
+}
+
 func (sm *ServiceManager) validateTTLs() {
 	removeTicker := time.NewTicker(RemoveCachedServicesPeriod)
 	defer removeTicker.Stop()
@@ -396,6 +886,9 @@ func (sm *ServiceManager) removeOutdatedServices(services []ServiceInfo) error {
 }
 
 func (sm *ServiceManager) removeOutdatedService(id string) error {
+	sm.Lock()
+	defer sm.Unlock()
+
 	serviceInfo := strings.Split(id, "_")
 	if len(serviceInfo) < 2 { //nolint:gomnd
 		return aoserrors.New("Unexpected service id format")
@@ -417,6 +910,9 @@ func (sm *ServiceManager) removeOutdatedService(id string) error {
 
 	for _, service := range services {
 		if service.AosVersion == aosVersion {
+			// Outdated item size includes released blobs, it is freed by the allocator
+			sm.releaseImageBlobs(service.ImagePath, service.GID)
+
 			if err := os.RemoveAll(service.ImagePath); err != nil {
 				return aoserrors.Wrap(err)
 			}
@@ -439,7 +935,7 @@ func (sm *ServiceManager) setServiceCached(service ServiceInfo, cached bool) err
 
 	if cached {
 		if err := sm.serviceAllocator.AddOutdatedItem(
-			id, service.Size, service.Timestamp); err != nil {
+			id, service.Size+sm.getReleasableBlobsSize(service), service.Timestamp); err != nil {
 			return aoserrors.Wrap(err)
 		}
 
@@ -452,6 +948,8 @@ func (sm *ServiceManager) setServiceCached(service ServiceInfo, cached bool) err
 }
 
 func (sm *ServiceManager) removeService(service ServiceInfo) error {
+	freedSize := sm.releaseImageBlobs(service.ImagePath, service.GID)
+
 	if err := os.RemoveAll(service.ImagePath); err != nil {
 		return aoserrors.Wrap(err)
 	}
@@ -460,7 +958,7 @@ func (sm *ServiceManager) removeService(service ServiceInfo) error {
 		sm.serviceAllocator.RestoreOutdatedItem(fmt.Sprintf("%s_%d", service.ServiceID, service.AosVersion))
 	}
 
-	sm.serviceAllocator.FreeSpace(service.Size)
+	sm.serviceAllocator.FreeSpace(service.Size + freedSize)
 
 	if err := sm.serviceInfoProvider.RemoveService(service.ServiceID, service.AosVersion); err != nil {
 		return aoserrors.Wrap(err)
@@ -475,27 +973,87 @@ func (sm *ServiceManager) removeService(service ServiceInfo) error {
 }
 
 func (sm *ServiceManager) prepareServiceFS(
-	imagePath string, gid int,
+	ctx context.Context, imagePath, serviceID string, gid int,
 ) (serviceSize int64, space spaceallocator.Space, rootFSDigest digest.Digest, err error) {
 	imageParts, err := getImageParts(imagePath)
 	if err != nil {
 		return 0, nil, "", aoserrors.Wrap(err)
 	}
 
+	manifest, err := getImageManifest(imagePath)
+	if err != nil {
+		return 0, nil, "", aoserrors.Wrap(err)
+	}
+
+	delta, err := getDeltaInfo(manifest)
+	if err != nil {
+		return 0, nil, "", aoserrors.Wrap(err)
+	}
+
 	if serviceSize, err = image.GetUncompressedTarContentSize(imageParts.ServiceFSPath); err != nil {
 		return 0, nil, "", aoserrors.Wrap(err)
 	}
 
-	if space, err = sm.serviceAllocator.AllocateSpace(uint64(serviceSize)); err != nil {
+	var baseRootFS string
+
+	if delta != nil {
+		var baseFSImage fsimage.Info
+
+		if baseRootFS, baseFSImage, err = sm.getDeltaBaseRootFS(serviceID, delta); err != nil {
+			return 0, nil, "", err
+		}
+
+		// Packed base rootfs is mounted to apply delta
+		if baseFSImage.Format != "" {
+			mountPoint := filepath.Join(imagePath, tmpBaseRootFSDir)
+
+			if err = MountImage(baseRootFS, mountPoint, baseFSImage); err != nil {
+				return 0, nil, "", aoserrors.Wrap(err)
+			}
+
+			defer func() {
+				if umountErr := UmountImage(mountPoint); umountErr != nil {
+					log.Errorf("Can't umount base rootfs: %v", umountErr)
+				}
+
+				os.RemoveAll(mountPoint)
+			}()
+
+			baseRootFS = mountPoint
+		}
+
+		baseSize, err := fs.GetDirSize(baseRootFS)
+		if err != nil {
+			return 0, nil, "", aoserrors.Wrap(err)
+		}
+
+		serviceSize += baseSize
+	}
+
+	if space, err = sm.allocateSpace(uint64(serviceSize)); err != nil {
 		return 0, nil, "", aoserrors.Wrap(err)
 	}
 
+	defer func() {
+		if err != nil {
+			if releaseErr := space.Release(); releaseErr != nil {
+				log.Errorf("Can't release memory: %v", releaseErr)
+			}
+		}
+	}()
+
 	originRootFSPath := imageParts.ServiceFSPath
 
 	tmpRootFS := filepath.Join(imagePath, tmpRootFSDir)
 
-	// unpack rootfs layer
-	if err = image.UnpackTarImage(imageParts.ServiceFSPath, tmpRootFS); err != nil {
+	if delta != nil {
+		err = applyRootFSDelta(ctx, baseRootFS, imageParts.ServiceFSPath, tmpRootFS)
+	} else {
+		// unpack rootfs layer
+		err = install.UnpackTarImage(ctx, imageParts.ServiceFSPath, tmpRootFS)
+	}
+
+	if err != nil {
 		return 0, nil, "", aoserrors.Wrap(err)
 	}
 
@@ -515,6 +1073,10 @@ func (sm *ServiceManager) prepareServiceFS(
 			return aoserrors.Wrap(err)
 		}
 
+		if err = ctx.Err(); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
 		if err = os.Chown(name, 0, gid); err != nil {
 			return aoserrors.Wrap(err)
 		}
@@ -524,8 +1086,11 @@ func (sm *ServiceManager) prepareServiceFS(
 		return 0, nil, "", aoserrors.Wrap(err)
 	}
 
-	if err := whiteouts.OCIWhiteoutsToOverlay(tmpRootFS, 0, gid); err != nil {
-		return 0, nil, "", aoserrors.Wrap(err)
+	// Whiteouts of delta package are applied to base rootfs which whiteouts are already converted
+	if delta == nil {
+		if err := whiteouts.OCIWhiteoutsToOverlay(tmpRootFS, 0, gid); err != nil {
+			return 0, nil, "", aoserrors.Wrap(err)
+		}
 	}
 
 	rootFSHash, err := dirhash.HashDir(tmpRootFS, tmpRootFS, dirDigest)
@@ -537,6 +1102,10 @@ func (sm *ServiceManager) prepareServiceFS(
 		return 0, nil, "", aoserrors.Wrap(err)
 	}
 
+	if delta != nil && rootFSDigest != delta.rootFSDigest {
+		return 0, nil, "", aoserrors.Errorf("delta rootfs digest mismatch: %s != %s", rootFSDigest, delta.rootFSDigest)
+	}
+
 	if err = os.Rename(tmpRootFS, filepath.Join(path.Dir(originRootFSPath), rootFSDigest.Hex())); err != nil {
 		return 0, nil, "", aoserrors.Wrap(err)
 	}
@@ -544,6 +1113,190 @@ func (sm *ServiceManager) prepareServiceFS(
 	return serviceSize, space, rootFSDigest, nil
 }
 
+// Base rootfs is searched among current and cached versions of the service.
+func (sm *ServiceManager) getDeltaBaseRootFS(
+	serviceID string, delta *deltaInfo,
+) (baseRootFS string, baseFSImage fsimage.Info, err error) {
+	services, err := sm.serviceInfoProvider.GetAllServiceVersions(serviceID)
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return "", baseFSImage, aoserrors.Wrap(err)
+	}
+
+	for _, service := range services {
+		if service.Quarantined {
+			continue
+		}
+
+		manifest, err := getImageManifest(service.ImagePath)
+		if err != nil {
+			log.WithField("serviceID", serviceID).Warnf("Can't get service manifest: %v", err)
+
+			continue
+		}
+
+		if len(manifest.Layers) == 0 || manifest.Layers[0].Digest != delta.baseRootFSDigest {
+			continue
+		}
+
+		if baseRootFS, err = filepath.EvalSymlinks(getBlobPath(service.ImagePath, delta.baseRootFSDigest)); err != nil {
+			log.WithField("serviceID", serviceID).Warnf("Can't get base rootfs: %v", err)
+
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"serviceID": serviceID, "baseVersion": service.AosVersion,
+		}).Debug("Apply delta package")
+
+		return baseRootFS, service.FSImage, nil
+	}
+
+	return "", baseFSImage, aoserrors.Wrap(&deltaBaseError{delta: *delta})
+}
+
+// Unpacked rootfs is replaced by verity protected image if packing is configured. Returns size freed by packing.
+func (sm *ServiceManager) packServiceFS(
+	ctx context.Context, imagePath string, rootFSDigest digest.Digest,
+) (fsImage fsimage.Info, freedSize uint64, err error) {
+	if sm.fsImage.Format == "" {
+		return fsImage, 0, nil
+	}
+
+	rootFSPath := getBlobPath(imagePath, rootFSDigest)
+	tmpImagePath := filepath.Join(imagePath, tmpFSImageDir)
+
+	rootFSSize, err := fs.GetDirSize(rootFSPath)
+	if err != nil {
+		return fsImage, 0, aoserrors.Wrap(err)
+	}
+
+	if fsImage, err = PackImage(ctx, rootFSPath, tmpImagePath, sm.fsImage.Format, sm.fsImage.Verity); err != nil {
+		os.RemoveAll(tmpImagePath)
+
+		return fsImage, 0, aoserrors.Wrap(err)
+	}
+
+	imageSize, err := fs.GetDirSize(tmpImagePath)
+	if err != nil {
+		return fsImage, 0, aoserrors.Wrap(err)
+	}
+
+	if err = os.RemoveAll(rootFSPath); err != nil {
+		return fsImage, 0, aoserrors.Wrap(err)
+	}
+
+	if err = os.Rename(tmpImagePath, rootFSPath); err != nil {
+		return fsImage, 0, aoserrors.Wrap(err)
+	}
+
+	if imageSize < rootFSSize {
+		freedSize = uint64(rootFSSize - imageSize)
+	}
+
+	return fsImage, freedSize, nil
+}
+
+// Image blobs are moved to the blob store and replaced by symlinks, so identical blobs of different services are
+// stored once.
+func (sm *ServiceManager) storeImageBlobs(
+	imagePath string, gid uint32,
+) (blobsSize, sharedSize uint64, err error) {
+	manifest, err := getImageManifest(imagePath)
+	if err != nil {
+		return 0, 0, aoserrors.Wrap(err)
+	}
+
+	var storedBlobs []digest.Digest
+
+	defer func() {
+		if err != nil {
+			for _, blobDigest := range storedBlobs {
+				if _, releaseErr := sm.blobStore.ReleaseBlob(blobDigest); releaseErr != nil {
+					log.Errorf("Can't release blob: %v", releaseErr)
+				}
+			}
+		}
+	}()
+
+	for _, blobDigest := range getImageBlobs(manifest) {
+		blobPath := getBlobPath(imagePath, blobDigest)
+
+		blobSize, err := fs.GetDirSize(blobPath)
+		if err != nil {
+			return 0, 0, aoserrors.Wrap(err)
+		}
+
+		storeDigest := getStoreDigest(manifest, blobDigest, gid)
+
+		storePath, shared, err := sm.blobStore.AddBlob(storeDigest, blobPath, uint64(blobSize))
+		if err != nil {
+			return 0, 0, aoserrors.Wrap(err)
+		}
+
+		storedBlobs = append(storedBlobs, storeDigest)
+
+		if err = os.Symlink(storePath, blobPath); err != nil {
+			return 0, 0, aoserrors.Wrap(err)
+		}
+
+		blobsSize += uint64(blobSize)
+
+		if shared {
+			sharedSize += uint64(blobSize)
+		}
+	}
+
+	return blobsSize, sharedSize, nil
+}
+
+// Returns size of blobs removed from the blob store.
+func (sm *ServiceManager) releaseImageBlobs(imagePath string, gid uint32) (freedSize uint64) {
+	storeDigests, err := getImageStoreDigests(imagePath, gid)
+	if err != nil {
+		log.WithField("imagePath", imagePath).Warnf("Can't release image blobs: %v", err)
+
+		return 0
+	}
+
+	for _, storeDigest := range storeDigests {
+		blobSize, err := sm.blobStore.ReleaseBlob(storeDigest)
+		if err != nil {
+			if !errors.Is(err, blobstore.ErrNotExist) {
+				log.WithField("digest", storeDigest).Errorf("Can't release blob: %v", err)
+			}
+
+			continue
+		}
+
+		freedSize += blobSize
+	}
+
+	return freedSize
+}
+
+// Returns size of blobs which are removed from the blob store if the service is removed.
+func (sm *ServiceManager) getReleasableBlobsSize(service ServiceInfo) (size uint64) {
+	storeDigests, err := getImageStoreDigests(service.ImagePath, service.GID)
+	if err != nil {
+		log.WithField("imagePath", service.ImagePath).Warnf("Can't get image blobs: %v", err)
+
+		return 0
+	}
+
+	for _, storeDigest := range storeDigests {
+		blob, err := sm.serviceInfoProvider.GetBlob(sm.blobStore.GetBlobPath(storeDigest))
+		if err != nil {
+			continue
+		}
+
+		if blob.RefCount <= 1 {
+			size += blob.Size
+		}
+	}
+
+	return size
+}
+
 func (sm *ServiceManager) removeDamagedServiceFolders(services []ServiceInfo) error {
 	for _, service := range services {
 		fi, err := os.Stat(service.ImagePath)
@@ -565,6 +1318,10 @@ filesLoop:
 	for _, file := range files {
 		fullPath := filepath.Join(sm.servicesDir, file.Name())
 
+		if file.Name() == blobsFolder {
+			continue
+		}
+
 		for _, service := range services {
 			if fullPath == service.ImagePath {
 				continue filesLoop
@@ -582,8 +1339,12 @@ filesLoop:
 }
 
 func (sm *ServiceManager) extractPackageByURL(
-	serviceInfo *aostypes.ServiceInfo,
+	ctx context.Context, serviceInfo *aostypes.ServiceInfo,
 ) (imagePath string, serviceSize uint64, space spaceallocator.Space, err error) {
+	if ociregistry.IsReference(serviceInfo.URL) {
+		return sm.pullPackageByReference(ctx, serviceInfo)
+	}
+
 	urlVal, err := url.Parse(serviceInfo.URL)
 	if err != nil {
 		return "", 0, nil, aoserrors.Wrap(err)
@@ -591,33 +1352,28 @@ func (sm *ServiceManager) extractPackageByURL(
 
 	var sourceFile string
 
-	if urlVal.Scheme != "file" {
-		space, err := sm.downloadAllocator.AllocateSpace(serviceInfo.Size)
-		if err != nil {
-			return "", 0, nil, aoserrors.Wrap(err)
-		}
-
-		defer func() {
-			if err := space.Release(); err != nil {
-				log.Errorf("Can't release memory: %v", err)
-			}
-		}()
+	fileInfo := image.FileInfo{
+		Sha256: serviceInfo.Sha256,
+		Sha512: serviceInfo.Sha512,
+		Size:   serviceInfo.Size,
+	}
 
-		if sourceFile, err = image.Download(context.Background(), sm.downloadDir, serviceInfo.URL); err != nil {
+	if urlVal.Scheme != "file" {
+		// Downloaded file is checked by downloader
+		if sourceFile, err = sm.downloader.Download(ctx, serviceInfo.URL, fileInfo,
+			progress.DownloadNotifier(sm.progressChannel, sm.progressEvent(*serviceInfo))); err != nil {
 			return "", 0, nil, aoserrors.Wrap(err)
 		}
 
-		defer os.RemoveAll(sourceFile)
+		defer sm.downloader.Release(sourceFile)
 	} else {
 		sourceFile = urlVal.Path
-	}
 
-	if err = image.CheckFileInfo(context.Background(), sourceFile, image.FileInfo{
-		Sha256: serviceInfo.Sha256,
-		Sha512: serviceInfo.Sha512,
-		Size:   serviceInfo.Size,
-	}); err != nil {
-		return "", 0, nil, aoserrors.Wrap(err)
+		sm.sendProgress(*serviceInfo, progress.Event{State: progress.StateValidating})
+
+		if err = image.CheckFileInfo(ctx, sourceFile, fileInfo); err != nil {
+			return "", 0, nil, aoserrors.Wrap(err)
+		}
 	}
 
 	size, err := image.GetUncompressedTarContentSize(sourceFile)
@@ -625,22 +1381,34 @@ func (sm *ServiceManager) extractPackageByURL(
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
-	if space, err = sm.serviceAllocator.AllocateSpace(uint64(size)); err != nil {
+	if space, err = sm.allocateSpace(uint64(size)); err != nil {
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
+	defer func() {
+		if err != nil {
+			releaseAllocatedSpace(imagePath, nil, space)
+		}
+	}()
+
 	imagePath, err = ioutil.TempDir(sm.servicesDir, "")
 	if err != nil {
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
-	if err = image.UnpackTarImage(sourceFile, imagePath); err != nil {
+	sm.sendProgress(*serviceInfo, progress.Event{State: progress.StateExtracting})
+
+	if err = install.UnpackTarImage(ctx, sourceFile, imagePath); err != nil {
 		return "", 0, nil, aoserrors.Wrap(err)
 	}
 
 	return imagePath, uint64(size), space, nil
 }
 
+func (err *deltaBaseError) Error() string {
+	return fmt.Sprintf("delta base rootfs %s not found", err.delta.baseRootFSDigest)
+}
+
 func acceptAllocatedSpace(spaceService spaceallocator.Space, spacePackage spaceallocator.Space) {
 	if err := spacePackage.Accept(); err != nil {
 		log.Errorf("Can't accept memory: %v", err)
@@ -682,3 +1450,38 @@ func getFileSize(fileName string) (size uint64, err error) {
 
 	return uint64(stat.Size), nil
 }
+
+func applyRootFSDelta(ctx context.Context, baseRootFS, deltaArchive, destination string) error {
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if output, err := exec.CommandContext(ctx, "cp", "-a", baseRootFS+"/.", destination).CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return aoserrors.Wrap(ctx.Err())
+		}
+
+		log.Errorf("Failed to copy base rootfs: %s", string(output))
+
+		return aoserrors.Wrap(err)
+	}
+
+	deltaDir := filepath.Join(filepath.Dir(destination), tmpDeltaDir)
+	defer os.RemoveAll(deltaDir)
+
+	if err := install.UnpackTarImage(ctx, deltaArchive, deltaDir); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := whiteouts.ApplyOCILayer(deltaDir, destination); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func findService(services []aostypes.ServiceInfo, serviceID string, aosVersion uint64) int {
+	return slices.IndexFunc(services, func(service aostypes.ServiceInfo) bool {
+		return service.ID == serviceID && service.AosVersion == aosVersion
+	})
+}
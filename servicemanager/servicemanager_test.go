@@ -19,15 +19,27 @@ package servicemanager_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -36,13 +48,19 @@ import (
 	"github.com/aoscloud/aos_common/aostypes"
 	"github.com/aoscloud/aos_common/image"
 	"github.com/aoscloud/aos_common/spaceallocator"
+	"github.com/aoscloud/aos_common/utils/cryptutils"
 	"github.com/aoscloud/aos_common/utils/fs"
 	"github.com/opencontainers/go-digest"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aoscloud/aos_servicemanager/config"
+	"github.com/aoscloud/aos_servicemanager/scrubber"
 	"github.com/aoscloud/aos_servicemanager/servicemanager"
+	"github.com/aoscloud/aos_servicemanager/utils/blobstore"
+	"github.com/aoscloud/aos_servicemanager/utils/fsimage"
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
 )
 
 func init() {
@@ -75,6 +93,7 @@ const (
 type testServiceStorage struct {
 	getAllError bool
 	Services    []servicemanager.ServiceInfo
+	Blobs       map[string]blobstore.BlobInfo
 }
 
 type testAllocator struct {
@@ -97,6 +116,14 @@ type testSpace struct {
 	size      uint64
 }
 
+type testRegistry struct {
+	sync.Mutex
+
+	server *httptest.Server
+	host   string
+	blobs  map[digest.Digest][]byte
+}
+
 type testOutdatedItem struct {
 	id   string
 	size uint64
@@ -253,7 +280,7 @@ func TestInstallService(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); err != nil {
+		if err := sm.ProcessDesiredServices(context.Background(), tCase.desiredServices, nil); err != nil {
 			t.Errorf("Can't process desired services: %v", err)
 		}
 
@@ -348,7 +375,7 @@ func TestRemoteDownloadLayer(t *testing.T) {
 
 	serviceInfo.URL = "http://:9000/downloadImage"
 
-	if err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
+	if err := sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{serviceInfo}, nil); err != nil {
 		t.Errorf("Can't process desired services: %v", err)
 	}
 
@@ -381,7 +408,7 @@ func TestImageParts(t *testing.T) {
 		t.Fatalf("Can't prepare test service: %s", err)
 	}
 
-	if err = sm.ProcessDesiredServices([]aostypes.ServiceInfo{serviceInfo}); err != nil {
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{serviceInfo}, nil); err != nil {
 		t.Errorf("Can't install service: %s", err)
 	}
 
@@ -408,7 +435,17 @@ func TestImageParts(t *testing.T) {
 	}
 
 	if len(imageParts.LayersDigest) != 1 {
-		t.Error("Count of layers should be 1")
+		t.Fatal("Count of layers should be 1")
+	}
+
+	layerUsers, err := sm.GetLayerUsers()
+	if err != nil {
+		t.Fatalf("Can't get layer users: %v", err)
+	}
+
+	if users := layerUsers[imageParts.LayersDigest[0]]; len(users) != 1 ||
+		users[0].ServiceID != serviceID || users[0].AosVersion != 1 {
+		t.Errorf("Wrong layer users: %v", users)
 	}
 }
 
@@ -436,7 +473,7 @@ func TestValidateService(t *testing.T) {
 		t.Errorf("Can't prepare test service: %s", err)
 	}
 
-	if err := sm.ProcessDesiredServices([]aostypes.ServiceInfo{service}); err != nil {
+	if err := sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service}, nil); err != nil {
 		t.Errorf("Can't process desired services: %v", err)
 	}
 
@@ -521,7 +558,7 @@ func TestAllocateMemoryInstallService(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); !errors.Is(err, tCase.processDesiredError) {
+		if err := sm.ProcessDesiredServices(context.Background(), tCase.desiredServices, nil); !errors.Is(err, tCase.processDesiredError) {
 			t.Errorf("Can't process desired service: %v", err)
 		}
 	}
@@ -596,7 +633,7 @@ func TestCachedServiceOnStart(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); !errors.Is(err, tCase.processDesiredError) {
+		if err := sm.ProcessDesiredServices(context.Background(), tCase.desiredServices, nil); !errors.Is(err, tCase.processDesiredError) {
 			t.Errorf("Can't process desired service: %v", err)
 		}
 	}
@@ -612,10 +649,10 @@ func TestCachedServiceOnStart(t *testing.T) {
 		t.Fatalf("Should be error not exist: %v", err)
 	}
 
-	if err := sm.ProcessDesiredServices(getDesiredServices(services, []expectedService{
+	if err := sm.ProcessDesiredServices(context.Background(), getDesiredServices(services, []expectedService{
 		{serviceID: "service2", version: 1},
 		{serviceID: "service3", version: 1},
-	})); err != nil {
+	}), nil); err != nil {
 		t.Errorf("Can't process desired service: %v", err)
 	}
 }
@@ -706,7 +743,7 @@ func TestRemoveServiceVersionOnInstall(t *testing.T) {
 	}
 
 	for _, tCase := range cases {
-		if err := sm.ProcessDesiredServices(tCase.desiredServices); err != nil {
+		if err := sm.ProcessDesiredServices(context.Background(), tCase.desiredServices, nil); err != nil {
 			t.Errorf("Can't process desired service: %v", err)
 		}
 
@@ -721,256 +758,1309 @@ func TestRemoveServiceVersionOnInstall(t *testing.T) {
 	}
 }
 
-/***********************************************************************************************************************
-* Interfaces
-***********************************************************************************************************************/
+func TestParallelInstall(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
 
-func newSpaceAllocator(
-	path string, partLimit uint, remover spaceallocator.ItemRemover,
-) (spaceallocator.Allocator, error) {
-	if serviceAllocator.partLimit < partLimit {
-		return nil, aoserrors.New("can't create space allocator")
+	config := &config.Config{
+		ServicesDir:        filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:        filepath.Join(tmpDir, "downloads"),
+		InstallConcurrency: 3,
 	}
 
-	if remover != nil {
-		serviceAllocator.remover = remover
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
 	}
+	defer sm.Close()
 
-	return serviceAllocator, nil
-}
+	fileServerDir, err := ioutil.TempDir("", "sm_fileserver")
+	if err != nil {
+		t.Fatalf("Error create temporary dir: %v", err)
+	}
+	defer os.RemoveAll(fileServerDir)
 
-func (allocator *testAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
-	allocator.Lock()
-	defer allocator.Unlock()
+	server := httptest.NewServer(http.FileServer(http.Dir(fileServerDir)))
+	defer server.Close()
 
-	if allocator.totalSize != 0 && allocator.allocatedSize+size > allocator.totalSize {
-		for allocator.allocatedSize+size > allocator.totalSize {
-			if len(allocator.outdatedItems) == 0 {
-				return nil, spaceallocator.ErrNoSpace
-			}
+	var desiredServices []aostypes.ServiceInfo
 
-			if err := allocator.remover(allocator.outdatedItems[0].id); err != nil {
-				return nil, err
-			}
+	for i := 0; i < 4; i++ {
+		serviceInfo, err := prepareService(
+			"Service content", fmt.Sprintf("service%d", i), 1, defaultServiceSize)
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
 
-			if allocator.outdatedItems[0].size < allocator.allocatedSize {
-				allocator.allocatedSize -= allocator.outdatedItems[0].size
-			} else {
-				allocator.allocatedSize = 0
-			}
+		desiredServices = append(desiredServices, serviceInfo)
+	}
 
-			allocator.outdatedItems = allocator.outdatedItems[1:]
-		}
+	// service0 is downloaded from remote server
+	urlVal, err := url.Parse(desiredServices[0].URL)
+	if err != nil {
+		t.Fatalf("Can't parse url: %v", err)
 	}
 
-	allocator.allocatedSize += size
+	if err = os.Rename(urlVal.Path, filepath.Join(fileServerDir, "service0")); err != nil {
+		t.Fatalf("Can't move service image: %v", err)
+	}
 
-	return &testSpace{allocator: allocator, size: size}, nil
-}
+	desiredServices[0].URL = server.URL + "/service0"
 
-func (allocator *testAllocator) FreeSpace(size uint64) {
-	allocator.Lock()
-	defer allocator.Unlock()
+	// service2 has wrong checksum and should not break installation of other services
+	desiredServices[2].Sha256 = []byte("wrong checksum")
 
-	if size > allocator.allocatedSize {
-		allocator.allocatedSize = 0
-	} else {
-		allocator.allocatedSize -= size
+	err = sm.ProcessDesiredServices(context.Background(), desiredServices, nil)
+
+	var installErr *progress.InstallError
+
+	if !errors.As(err, &installErr) {
+		t.Fatalf("Install error expected: %v", err)
 	}
-}
 
-func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
-	allocator.outdatedItems = append(allocator.outdatedItems, testOutdatedItem{id: id, size: size})
+	if _, ok := installErr.Errors["service2"]; !ok || len(installErr.Errors) != 1 {
+		t.Errorf("Wrong failed services: %v", installErr.Errors)
+	}
 
-	return nil
-}
+	for _, service := range desiredServices {
+		_, err := sm.GetServiceInfo(service.ID)
 
-func (allocator *testAllocator) RestoreOutdatedItem(id string) {
-	for i, item := range allocator.outdatedItems {
-		if item.id == id {
-			allocator.outdatedItems = append(allocator.outdatedItems[:i], allocator.outdatedItems[i+1:]...)
+		if service.ID == "service2" {
+			if !errors.Is(err, servicemanager.ErrNotExist) {
+				t.Errorf("Service %s should not be installed: %v", service.ID, err)
+			}
 
-			break
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Can't get service info: %v", err)
 		}
 	}
-}
 
-func (allocator *testAllocator) Close() error {
-	return nil
-}
+	started := make(map[string]bool)
+	results := make(map[string]string)
+	downloaded := false
 
-func (space *testSpace) Accept() error {
-	return nil
-}
+	for len(results) < len(desiredServices) {
+		select {
+		case event := <-sm.GetInstallProgressChannel():
+			if event.ItemType != progress.ItemTypeService {
+				t.Errorf("Wrong item type: %s", event.ItemType)
+			}
 
-func (space *testSpace) Release() error {
-	space.allocator.FreeSpace(space.size)
+			switch event.State {
+			case progress.StateStarted:
+				started[event.ID] = true
 
-	return nil
-}
+			case progress.StateDownloading:
+				if event.ID != "service0" {
+					t.Errorf("Unexpected download event for service %s", event.ID)
+				}
 
-func (storage *testServiceStorage) GetAllServiceVersions(
-	serviceID string,
-) (service []servicemanager.ServiceInfo, err error) {
-	if serviceID == errorGetServicID {
-		return service, aoserrors.New("can't get service")
-	}
+				if event.Total != 0 && event.Downloaded == event.Total {
+					downloaded = true
+				}
 
-	for _, storeService := range storage.Services {
-		if storeService.ServiceID == serviceID {
-			service = append(service, storeService)
+			case progress.StateFailed:
+				if event.Error == "" {
+					t.Errorf("Failed event of service %s has no error", event.ID)
+				}
+
+				results[event.ID] = event.State
+
+			case progress.StateInstalled:
+				results[event.ID] = event.State
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("Wait progress event timeout")
 		}
 	}
 
-	if len(service) == 0 {
-		return service, servicemanager.ErrNotExist
+	if len(started) != len(desiredServices) {
+		t.Errorf("Wrong started services: %v", started)
 	}
 
-	return service, nil
-}
+	if !downloaded {
+		t.Error("Download progress event expected")
+	}
 
-func (storage *testServiceStorage) GetServices() (services []servicemanager.ServiceInfo, err error) {
-	if storage.getAllError {
-		return nil, aoserrors.New("can't get services")
+	expectedResults := map[string]string{
+		"service0": progress.StateInstalled,
+		"service1": progress.StateInstalled,
+		"service2": progress.StateFailed,
+		"service3": progress.StateInstalled,
 	}
 
-	return storage.Services, nil
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("Wrong install results: %v", results)
+	}
 }
 
-func (storage *testServiceStorage) AddService(service servicemanager.ServiceInfo) (err error) {
-	if service.ServiceID == errorAddServiceID {
-		return aoserrors.New("can't add service")
+func TestCancelInstall(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
 	}
 
-	storage.Services = append(storage.Services, service)
+	serviceAllocator = &testAllocator{}
 
-	return err
-}
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
 
-func (storage *testServiceStorage) RemoveService(serviceID string, aosVersion uint64) error {
-	for i, outService := range storage.Services {
-		if outService.ServiceID == serviceID && outService.AosVersion == aosVersion {
-			storage.Services = append(storage.Services[:i], storage.Services[i+1:]...)
+	serviceInfo, err := prepareService("Service content", "service0", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
 
-			return nil
+	// Server never completes the download
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.FormatUint(serviceInfo.Size, 10))
+		w.WriteHeader(http.StatusOK)
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
 		}
-	}
 
-	return servicemanager.ErrNotExist
-}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
 
-func (storage *testServiceStorage) SetServiceCached(serviceID string, aosVersion uint64, cached bool) (err error) {
-	var found bool
+	serviceInfo.URL = server.URL + "/service0"
 
-	for i, serviceInfo := range storage.Services {
-		if serviceInfo.ServiceID == serviceID {
-			storage.Services[i].Cached = cached
+	ctx, cancelFunc := context.WithCancel(context.Background())
 
-			found = true
-		}
+	time.AfterFunc(100*time.Millisecond, cancelFunc)
+
+	if err := sm.ProcessDesiredServices(ctx, []aostypes.ServiceInfo{serviceInfo}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wrong install error: %v", err)
 	}
 
-	if !found {
-		err = servicemanager.ErrNotExist
+	if _, err := sm.GetServiceInfo(serviceInfo.ID); !errors.Is(err, servicemanager.ErrNotExist) {
+		t.Errorf("Service should not be installed: %v", err)
 	}
 
-	return err
+	if serviceAllocator.allocatedSize != 0 {
+		t.Errorf("Allocated space should be released: %d", serviceAllocator.allocatedSize)
+	}
 }
 
-/***********************************************************************************************************************
-* Private
-***********************************************************************************************************************/
+func TestSharedServiceBlobs(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
 
-func setup() (err error) {
-	if tmpDir, err = ioutil.TempDir("", "aos_"); err != nil {
-		return aoserrors.Wrap(err)
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
 	}
 
-	servicemanager.NewSpaceAllocator = newSpaceAllocator
-
-	return nil
-}
-
-func cleanup() {
-	os.RemoveAll(tmpDir)
-}
+	serviceAllocator = &testAllocator{}
 
-func prepareService(
-	testContent, serviceID string, aosVersion uint64, servicelayerSize int64,
-) (serviceInfo aostypes.ServiceInfo, err error) {
-	imageDir, err := ioutil.TempDir("", "aos_")
+	sm, err := servicemanager.New(config, serviceStorage)
 	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+		t.Fatalf("Can't create SM: %v", err)
 	}
+	defer sm.Close()
 
-	defer os.RemoveAll(imageDir)
+	var desiredServices []aostypes.ServiceInfo
 
-	// create dir
-	if err := os.MkdirAll(filepath.Join(imageDir, "rootfs", "home"), 0o755); err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
-	}
+	// Services are built from the same content
+	for i := 0; i < 2; i++ {
+		serviceInfo, err := prepareService("Service content", fmt.Sprintf("service%d", i), 1, defaultServiceSize)
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
 
-	file, err := os.Create(filepath.Join(imageDir, "rootfs", "home", "service.py"))
-	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+		desiredServices = append(desiredServices, serviceInfo)
+
+		if err = sm.ProcessDesiredServices(context.Background(), desiredServices, nil); err != nil {
+			t.Fatalf("Can't process desired services: %v", err)
+		}
 	}
 
-	if err := file.Truncate(servicelayerSize); err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+	var rootFSPaths []string
+
+	for _, desiredService := range desiredServices {
+		service, err := sm.GetServiceInfo(desiredService.ID)
+		if err != nil {
+			t.Fatalf("Can't get service info: %v", err)
+		}
+
+		if err = sm.ValidateService(service); err != nil {
+			t.Errorf("Service validation error: %v", err)
+		}
+
+		imageParts, err := sm.GetImageParts(service)
+		if err != nil {
+			t.Fatalf("Can't get image parts: %v", err)
+		}
+
+		rootFSPath, err := filepath.EvalSymlinks(imageParts.ServiceFSPath)
+		if err != nil {
+			t.Fatalf("Can't get rootfs path: %v", err)
+		}
+
+		rootFSPaths = append(rootFSPaths, rootFSPath)
+
+		if serviceStorage.Blobs[rootFSPath].RefCount != 2 {
+			t.Errorf("Wrong rootfs blob ref count: %d", serviceStorage.Blobs[rootFSPath].RefCount)
+		}
 	}
 
-	rootFsPath := filepath.Join(imageDir, "rootfs")
+	if rootFSPaths[0] != rootFSPaths[1] {
+		t.Error("Rootfs should be shared")
+	}
 
-	serviceSize, err := fs.GetDirSize(rootFsPath)
+	services, err := serviceStorage.GetServices()
 	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+		t.Fatalf("Can't get services: %v", err)
 	}
 
-	fsDigest, err := generateFsLayer(imageDir, rootFsPath)
-	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+	if services[1].Size != services[0].Size {
+		t.Errorf("Shared blobs should not be accounted twice: %d != %d", services[1].Size, services[0].Size)
 	}
 
-	aosSrvConfigDigest, err := generateAndSaveDigest(filepath.Join(imageDir, blobsFolder), []byte("{}"))
-	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+	if err = sm.ProcessDesiredServices(context.Background(), nil, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
 	}
 
-	imgSpecDigestDigest, err := generateAndSaveDigest(filepath.Join(imageDir, blobsFolder), []byte("{}"))
+	items, err := sm.GetEvictableItems()
 	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+		t.Fatalf("Can't get evictable items: %v", err)
 	}
 
-	imgAosLayerDigest, err := generateAndSaveDigest(imageDir, []byte("{}"))
-	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+	if len(items) != len(desiredServices) {
+		t.Fatalf("Wrong evictable items count: %d", len(items))
 	}
 
-	if err := genarateImageManfest(
-		imageDir, &imgSpecDigestDigest, &aosSrvConfigDigest, &fsDigest,
-		serviceSize, []digest.Digest{imgAosLayerDigest}); err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+	allocatedSize := serviceAllocator.allocatedSize
+
+	if err = sm.EvictItem(items[0]); err != nil {
+		t.Fatalf("Can't evict item: %v", err)
 	}
 
-	imageFile, err := ioutil.TempFile("", "aos_")
-	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+	// Shared blobs are still used by the second service
+	if allocatedSize-serviceAllocator.allocatedSize != items[0].Size {
+		t.Errorf("Wrong freed size: %d", allocatedSize-serviceAllocator.allocatedSize)
 	}
 
-	outputURL := imageFile.Name()
-	imageFile.Close()
+	if err = sm.EvictItem(items[1]); err != nil {
+		t.Fatalf("Can't evict item: %v", err)
+	}
 
-	if err = packImage(imageDir, outputURL); err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+	if serviceAllocator.allocatedSize != 0 {
+		t.Errorf("Allocated space should be freed: %d", serviceAllocator.allocatedSize)
 	}
+}
 
-	fileInfo, err := image.CreateFileInfo(context.Background(), outputURL)
-	if err != nil {
-		return serviceInfo, aoserrors.Wrap(err)
+func TestDeltaUpdate(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
 	}
 
-	return aostypes.ServiceInfo{
-		VersionInfo: aostypes.VersionInfo{
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	baseFiles := map[string]string{
+		filepath.Join("home", "service.py"): "base service",
+		filepath.Join("etc", "config"):      "base config",
+	}
+	fullFiles := map[string]string{
+		filepath.Join("home", "delta.py"): "delta service",
+		filepath.Join("etc", "config"):    "delta config",
+	}
+	deltaFiles := map[string]string{
+		filepath.Join("home", ".wh.service.py"): "",
+		filepath.Join("home", "delta.py"):       "delta service",
+		filepath.Join("etc", "config"):          "delta config",
+	}
+
+	baseService, err := prepareServiceImage("deltaService", 1, baseFiles, nil)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{baseService}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	baseRootFSDigest := getServiceRootFSDigest(t, sm, baseService.ID)
+
+	// Full package is installed as reference to get expected rootfs digest
+	referenceService, err := prepareServiceImage("deltaReference", 2, fullFiles, nil)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(
+		context.Background(), []aostypes.ServiceInfo{baseService, referenceService}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	rootFSDigest := getServiceRootFSDigest(t, sm, referenceService.ID)
+
+	for _, serviceID := range []string{"deltaService", "deltaFallback"} {
+		fullService, err := prepareServiceImage(serviceID, 2, fullFiles, nil)
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		deltaService, err := prepareServiceImage(serviceID, 2, deltaFiles, map[string]string{
+			"org.aoscloud.delta.base.rootfs": string(baseRootFSDigest),
+			"org.aoscloud.delta.rootfs":      string(rootFSDigest),
+			"org.aoscloud.delta.full.url":    fullService.URL,
+			"org.aoscloud.delta.full.sha256": hex.EncodeToString(fullService.Sha256),
+			"org.aoscloud.delta.full.sha512": hex.EncodeToString(fullService.Sha512),
+			"org.aoscloud.delta.full.size":   strconv.FormatUint(fullService.Size, 10),
+		})
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		// Full package is removed to check that delta is applied to the installed base version
+		if serviceID == "deltaService" {
+			fullURL, _ := url.Parse(fullService.URL)
+			os.Remove(fullURL.Path)
+		}
+
+		if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{
+			referenceService, deltaService,
+		}, nil); err != nil {
+			t.Fatalf("Can't process desired services: %v", err)
+		}
+
+		service, err := sm.GetServiceInfo(serviceID)
+		if err != nil {
+			t.Fatalf("Can't get service info: %v", err)
+		}
+
+		if service.AosVersion != 2 {
+			t.Errorf("Wrong service version: %d", service.AosVersion)
+		}
+
+		if err = sm.ValidateService(service); err != nil {
+			t.Errorf("Service validation error: %v", err)
+		}
+
+		if digest := getServiceRootFSDigest(t, sm, serviceID); digest != rootFSDigest {
+			t.Errorf("Wrong rootfs digest: %s", digest)
+		}
+
+		imageParts, err := sm.GetImageParts(service)
+		if err != nil {
+			t.Fatalf("Can't get image parts: %v", err)
+		}
+
+		if _, err = os.Stat(filepath.Join(imageParts.ServiceFSPath, "home", "service.py")); !os.IsNotExist(err) {
+			t.Error("Whiteout file should be removed")
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(imageParts.ServiceFSPath, "etc", "config"))
+		if err != nil {
+			t.Fatalf("Can't read file: %v", err)
+		}
+
+		if string(content) != "delta config" {
+			t.Errorf("Wrong file content: %s", string(content))
+		}
+	}
+}
+
+func TestImageSignature(t *testing.T) {
+	caKey, caCert := createCertificate(t, nil, nil)
+	signerKey, signerCert := createCertificate(t, caKey, caCert, x509.ExtKeyUsageCodeSigning)
+	serverKey, serverCert := createCertificate(t, caKey, caCert, x509.ExtKeyUsageServerAuth)
+	noUsageKey, noUsageCert := createCertificate(t, caKey, caCert)
+	untrustedKey, untrustedCert := createCertificate(t, nil, nil, x509.ExtKeyUsageCodeSigning)
+
+	caCertFile := filepath.Join(tmpDir, "ca.pem")
+
+	if err := ioutil.WriteFile(caCertFile, cryptutils.CertToPEM(caCert), 0o600); err != nil {
+		t.Fatalf("Can't write CA certificate: %v", err)
+	}
+
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir:          filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:          filepath.Join(tmpDir, "downloads"),
+		CACert:               caCertFile,
+		ImageSignaturePolicy: servicemanager.SignaturePolicyRequire,
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	unsignedService, err := prepareService("Service content", "unsigned", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(
+		context.Background(), []aostypes.ServiceInfo{unsignedService}, nil); !errors.Is(err, servicemanager.ErrNotSigned) {
+		t.Errorf("Wrong install error: %v", err)
+	}
+
+	untrustedService, err := prepareService("Service content", "untrusted", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if untrustedService, err = signServicePackage(untrustedService, untrustedKey, untrustedCert); err != nil {
+		t.Fatalf("Can't sign service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(
+		context.Background(), []aostypes.ServiceInfo{untrustedService}, nil); !errors.Is(err, servicemanager.ErrNotTrusted) {
+		t.Errorf("Wrong install error: %v", err)
+	}
+
+	// Certificates issued by trusted CA but not for code signing are rejected
+	for i, signer := range []struct {
+		key  *ecdsa.PrivateKey
+		cert *x509.Certificate
+	}{{serverKey, serverCert}, {noUsageKey, noUsageCert}} {
+		wrongUsageService, err := prepareService(
+			"Service content", fmt.Sprintf("wrongUsage%d", i), 1, defaultServiceSize)
+		if err != nil {
+			t.Fatalf("Can't prepare service: %v", err)
+		}
+
+		if wrongUsageService, err = signServicePackage(wrongUsageService, signer.key, signer.cert); err != nil {
+			t.Fatalf("Can't sign service: %v", err)
+		}
+
+		if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{wrongUsageService},
+			nil); !errors.Is(err, servicemanager.ErrNotTrusted) {
+			t.Errorf("Wrong install error: %v", err)
+		}
+	}
+
+	signedService, err := prepareService("Service content", "signed", 1, defaultServiceSize)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if signedService, err = signServicePackage(signedService, signerKey, signerCert); err != nil {
+		t.Fatalf("Can't sign service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{signedService}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	service, err := sm.GetServiceInfo(signedService.ID)
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if err = sm.ValidateService(service); err != nil {
+		t.Errorf("Service validation error: %v", err)
+	}
+
+	// Signed manifest is modified after install
+	signedManifestFile := filepath.Join(service.ImagePath, "manifest.json.signed")
+
+	signedManifest, err := ioutil.ReadFile(signedManifestFile)
+	if err != nil {
+		t.Fatalf("Can't read signed manifest: %v", err)
+	}
+
+	if err = ioutil.WriteFile(signedManifestFile, append(signedManifest, ' '), 0o600); err != nil {
+		t.Fatalf("Can't write signed manifest: %v", err)
+	}
+
+	if err = sm.ValidateService(service); !errors.Is(err, servicemanager.ErrNotTrusted) {
+		t.Errorf("Wrong validation error: %v", err)
+	}
+}
+
+func TestScrubService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	service, err := prepareServiceImage("scrubService", 1,
+		map[string]string{filepath.Join("home", "service.py"): "scrub service"}, nil)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	items, err := sm.GetScrubItems()
+	if err != nil {
+		t.Fatalf("Can't get scrub items: %v", err)
+	}
+
+	if len(items) != 1 || items[0].ID != service.ID {
+		t.Fatalf("Unexpected scrub items: %v", items)
+	}
+
+	if err = sm.VerifyItem(context.Background(), items[0], nil); err != nil {
+		t.Errorf("Can't verify service: %v", err)
+	}
+
+	rootFSDigest := getServiceRootFSDigest(t, sm, service.ID)
+
+	serviceInfo, err := sm.GetServiceInfo(service.ID)
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(serviceInfo.ImagePath, "blobs", string(rootFSDigest.Algorithm()),
+		rootFSDigest.Hex(), "home", "service.py"), []byte("corrupted"), 0o600); err != nil {
+		t.Fatalf("Can't corrupt service: %v", err)
+	}
+
+	if err = sm.VerifyItem(context.Background(), items[0], nil); !errors.Is(err, scrubber.ErrCorrupted) {
+		t.Errorf("Unexpected verify error: %v", err)
+	}
+
+	if err = sm.QuarantineItem(items[0]); err != nil {
+		t.Fatalf("Can't quarantine service: %v", err)
+	}
+
+	if serviceInfo, err = sm.GetServiceInfo(service.ID); err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if err = sm.ValidateService(serviceInfo); !errors.Is(err, servicemanager.ErrQuarantined) {
+		t.Errorf("Unexpected validate error: %v", err)
+	}
+
+	// Quarantined service is reinstalled
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	if serviceInfo, err = sm.GetServiceInfo(service.ID); err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if err = sm.ValidateService(serviceInfo); err != nil {
+		t.Errorf("Can't validate service: %v", err)
+	}
+}
+
+func TestPrefetchService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	service1, err := prepareServiceImage("prefetchService", 1,
+		map[string]string{filepath.Join("home", "service.py"): "service version 1"}, nil)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	service2, err := prepareServiceImage("prefetchService", 2,
+		map[string]string{filepath.Join("home", "service.py"): "service version 2"}, nil)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service1},
+		[]aostypes.ServiceInfo{service2}); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	// Prefetched version is installed but inactive
+	serviceInfo, err := sm.GetServiceInfo(service1.ID)
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if serviceInfo.AosVersion != 1 {
+		t.Errorf("Unexpected active service version: %d", serviceInfo.AosVersion)
+	}
+
+	services, err := serviceStorage.GetAllServiceVersions(service1.ID)
+	if err != nil {
+		t.Fatalf("Can't get service versions: %v", err)
+	}
+
+	if len(services) != 2 || !services[1].Prefetched || services[1].Cached {
+		t.Fatalf("Unexpected stored services: %v", services)
+	}
+
+	if len(serviceAllocator.outdatedItems) != 0 {
+		t.Errorf("Prefetched service should not be outdated: %v", serviceAllocator.outdatedItems)
+	}
+
+	// Layers of prefetched service are kept by layer manager
+	imageParts, err := sm.GetImageParts(services[1])
+	if err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
+	}
+
+	layerUsers, err := sm.GetLayerUsers()
+	if err != nil {
+		t.Fatalf("Can't get layer users: %v", err)
+	}
+
+	for _, layerDigest := range imageParts.LayersDigest {
+		found := false
+
+		for _, user := range layerUsers[layerDigest] {
+			if user.ServiceID == service2.ID && user.AosVersion == service2.AosVersion {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("Prefetched service should use layer %s: %v", layerDigest, layerUsers[layerDigest])
+		}
+	}
+
+	// Prefetched version is activated without download
+	if err = os.RemoveAll(strings.TrimPrefix(service2.URL, "file://")); err != nil {
+		t.Fatalf("Can't remove service package: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service2}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	if services, err = serviceStorage.GetAllServiceVersions(service1.ID); err != nil {
+		t.Fatalf("Can't get service versions: %v", err)
+	}
+
+	if len(services) != 2 || services[1].Prefetched || services[1].Cached {
+		t.Errorf("Unexpected stored services: %v", services)
+	}
+}
+
+func TestRegistryService(t *testing.T) {
+	registry := newTestRegistry()
+	defer registry.server.Close()
+
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir:        filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir:        filepath.Join(tmpDir, "downloads"),
+		InsecureRegistries: []string{registry.host},
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	service, err := prepareServiceImage("registryService", 1,
+		map[string]string{filepath.Join("home", "service.py"): "registry service"}, nil)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	manifestDigest, err := registry.pushService(service, "aos/service")
+	if err != nil {
+		t.Fatalf("Can't push service: %v", err)
+	}
+
+	service.URL = "oci://" + registry.host + "/aos/service@" + string(manifestDigest)
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	serviceInfo, err := sm.GetServiceInfo(service.ID)
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if err = sm.ValidateService(serviceInfo); err != nil {
+		t.Errorf("Can't validate service: %v", err)
+	}
+
+	imageParts, err := sm.GetImageParts(serviceInfo)
+	if err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(imageParts.ServiceFSPath, "home", "service.py"))
+	if err != nil {
+		t.Fatalf("Can't read service file: %v", err)
+	}
+
+	if string(content) != "registry service" {
+		t.Errorf("Wrong service content: %s", content)
+	}
+
+	if len(imageParts.LayersDigest) != 1 {
+		t.Errorf("Unexpected service layers: %v", imageParts.LayersDigest)
+	}
+
+	// Corrupted blob is not accepted
+	service.AosVersion = 2
+
+	for blobDigest := range registry.blobs {
+		if blobDigest != manifestDigest {
+			registry.blobs[blobDigest] = append(registry.blobs[blobDigest], 0)
+		}
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service}, nil); err == nil {
+		t.Error("Corrupted service should not be installed")
+	}
+}
+
+func TestMultiArchService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	node := platform.Node()
+	otherPlatform := imagespec.Platform{OS: "linux", Architecture: "riscv64"}
+
+	if node.Architecture == otherPlatform.Architecture {
+		otherPlatform.Architecture = "s390x"
+	}
+
+	service, err := prepareMultiArchService("multiArchService", 1, []imagespec.Platform{otherPlatform, node})
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	serviceInfo, err := sm.GetServiceInfo(service.ID)
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if err = sm.ValidateService(serviceInfo); err != nil {
+		t.Errorf("Can't validate service: %v", err)
+	}
+
+	imageParts, err := sm.GetImageParts(serviceInfo)
+	if err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(imageParts.ServiceFSPath, "home", "platform"))
+	if err != nil {
+		t.Fatalf("Can't read service file: %v", err)
+	}
+
+	if string(content) != platform.String(&node) {
+		t.Errorf("Wrong service platform: %s", content)
+	}
+
+	// Only blobs of selected platform are kept
+	blobFiles, err := filepath.Glob(filepath.Join(serviceInfo.ImagePath, blobsFolder, "*", "*"))
+	if err != nil {
+		t.Fatalf("Can't get blobs: %v", err)
+	}
+
+	// Manifest, image config, aos service config and rootfs
+	if len(blobFiles) != 4 {
+		t.Errorf("Unexpected blobs count: %d", len(blobFiles))
+	}
+
+	// Service without node platform is not installed
+	otherService, err := prepareMultiArchService("otherArchService", 1, []imagespec.Platform{otherPlatform})
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(
+		context.Background(), []aostypes.ServiceInfo{service, otherService}, nil); !errors.Is(err, platform.ErrNoMatch) {
+		t.Errorf("Wrong install error: %v", err)
+	}
+}
+
+func TestPackedService(t *testing.T) {
+	serviceStorage := &testServiceStorage{}
+
+	config := &config.Config{
+		ServicesDir: filepath.Join(tmpDir, "servicemanager", "services"),
+		DownloadDir: filepath.Join(tmpDir, "downloads"),
+		FSImage:     config.FSImage{Format: "ext4", Verity: fsimage.VerityDM},
+	}
+
+	serviceAllocator = &testAllocator{}
+
+	if _, err := servicemanager.New(config, serviceStorage); err == nil {
+		t.Error("Unsupported image format should fail")
+	}
+
+	config.FSImage.Format = fsimage.FormatSquashfs
+
+	expectedImage := fsimage.Info{Format: fsimage.FormatSquashfs, Verity: fsimage.VerityDM, RootHash: "1111"}
+
+	servicemanager.PackImage = func(
+		ctx context.Context, srcDir, dstDir, format, verity string,
+	) (fsimage.Info, error) {
+		if err := os.MkdirAll(dstDir, 0o755); err != nil {
+			return fsimage.Info{}, aoserrors.Wrap(err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dstDir, "image"), []byte(expectedImage.RootHash), 0o600); err != nil {
+			return fsimage.Info{}, aoserrors.Wrap(err)
+		}
+
+		return fsimage.Info{Format: format, Verity: verity, RootHash: expectedImage.RootHash}, nil
+	}
+	servicemanager.VerifyImage = func(ctx context.Context, dir string, info fsimage.Info) error {
+		rootHash, err := ioutil.ReadFile(filepath.Join(dir, "image"))
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if string(rootHash) != info.RootHash {
+			return fsimage.ErrRootHashMismatch
+		}
+
+		return nil
+	}
+
+	defer func() {
+		servicemanager.PackImage = fsimage.Pack
+		servicemanager.VerifyImage = fsimage.Verify
+	}()
+
+	sm, err := servicemanager.New(config, serviceStorage)
+	if err != nil {
+		t.Fatalf("Can't create SM: %v", err)
+	}
+	defer sm.Close()
+
+	service, err := prepareServiceImage("packedService", 1,
+		map[string]string{filepath.Join("home", "service.py"): "packed service"}, nil)
+	if err != nil {
+		t.Fatalf("Can't prepare service: %v", err)
+	}
+
+	if err = sm.ProcessDesiredServices(context.Background(), []aostypes.ServiceInfo{service}, nil); err != nil {
+		t.Fatalf("Can't process desired services: %v", err)
+	}
+
+	serviceInfo, err := sm.GetServiceInfo(service.ID)
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	if serviceInfo.FSImage != expectedImage {
+		t.Errorf("Wrong service image: %v", serviceInfo.FSImage)
+	}
+
+	imageParts, err := sm.GetImageParts(serviceInfo)
+	if err != nil {
+		t.Fatalf("Can't get image parts: %v", err)
+	}
+
+	if !fsimage.IsPacked(imageParts.ServiceFSPath) {
+		t.Error("Service rootfs should be packed")
+	}
+
+	if err = sm.ValidateService(serviceInfo); err != nil {
+		t.Errorf("Can't validate service: %v", err)
+	}
+
+	item := scrubber.Item{Type: scrubber.ItemTypeService, ID: service.ID, AosVersion: service.AosVersion}
+
+	if err = sm.VerifyItem(context.Background(), item, nil); err != nil {
+		t.Errorf("Can't verify service: %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(imageParts.ServiceFSPath, "image"), []byte("corrupted"),
+		0o600); err != nil {
+		t.Fatalf("Can't corrupt service: %v", err)
+	}
+
+	if err = sm.VerifyItem(context.Background(), item, nil); !errors.Is(err, scrubber.ErrCorrupted) {
+		t.Errorf("Unexpected verify error: %v", err)
+	}
+}
+
+/***********************************************************************************************************************
+* Interfaces
+***********************************************************************************************************************/
+
+func newSpaceAllocator(
+	path string, partLimit uint, remover spaceallocator.ItemRemover,
+) (spaceallocator.Allocator, error) {
+	if serviceAllocator.partLimit < partLimit {
+		return nil, aoserrors.New("can't create space allocator")
+	}
+
+	if remover != nil {
+		serviceAllocator.remover = remover
+	}
+
+	return serviceAllocator, nil
+}
+
+func (allocator *testAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	if allocator.totalSize != 0 && allocator.allocatedSize+size > allocator.totalSize {
+		for allocator.allocatedSize+size > allocator.totalSize {
+			if len(allocator.outdatedItems) == 0 {
+				return nil, spaceallocator.ErrNoSpace
+			}
+
+			if err := allocator.remover(allocator.outdatedItems[0].id); err != nil {
+				return nil, err
+			}
+
+			if allocator.outdatedItems[0].size < allocator.allocatedSize {
+				allocator.allocatedSize -= allocator.outdatedItems[0].size
+			} else {
+				allocator.allocatedSize = 0
+			}
+
+			allocator.outdatedItems = allocator.outdatedItems[1:]
+		}
+	}
+
+	allocator.allocatedSize += size
+
+	return &testSpace{allocator: allocator, size: size}, nil
+}
+
+func (allocator *testAllocator) FreeSpace(size uint64) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	if size > allocator.allocatedSize {
+		allocator.allocatedSize = 0
+	} else {
+		allocator.allocatedSize -= size
+	}
+}
+
+func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
+	allocator.outdatedItems = append(allocator.outdatedItems, testOutdatedItem{id: id, size: size})
+
+	return nil
+}
+
+func (allocator *testAllocator) RestoreOutdatedItem(id string) {
+	for i, item := range allocator.outdatedItems {
+		if item.id == id {
+			allocator.outdatedItems = append(allocator.outdatedItems[:i], allocator.outdatedItems[i+1:]...)
+
+			break
+		}
+	}
+}
+
+func (allocator *testAllocator) Close() error {
+	return nil
+}
+
+func (space *testSpace) Accept() error {
+	return nil
+}
+
+func (space *testSpace) Release() error {
+	space.allocator.FreeSpace(space.size)
+
+	return nil
+}
+
+func (storage *testServiceStorage) GetAllServiceVersions(
+	serviceID string,
+) (service []servicemanager.ServiceInfo, err error) {
+	if serviceID == errorGetServicID {
+		return service, aoserrors.New("can't get service")
+	}
+
+	for _, storeService := range storage.Services {
+		if storeService.ServiceID == serviceID {
+			service = append(service, storeService)
+		}
+	}
+
+	if len(service) == 0 {
+		return service, servicemanager.ErrNotExist
+	}
+
+	return service, nil
+}
+
+func (storage *testServiceStorage) GetServices() (services []servicemanager.ServiceInfo, err error) {
+	if storage.getAllError {
+		return nil, aoserrors.New("can't get services")
+	}
+
+	return storage.Services, nil
+}
+
+func (storage *testServiceStorage) AddService(service servicemanager.ServiceInfo) (err error) {
+	if service.ServiceID == errorAddServiceID {
+		return aoserrors.New("can't add service")
+	}
+
+	storage.Services = append(storage.Services, service)
+
+	return err
+}
+
+func (storage *testServiceStorage) RemoveService(serviceID string, aosVersion uint64) error {
+	for i, outService := range storage.Services {
+		if outService.ServiceID == serviceID && outService.AosVersion == aosVersion {
+			storage.Services = append(storage.Services[:i], storage.Services[i+1:]...)
+
+			return nil
+		}
+	}
+
+	return servicemanager.ErrNotExist
+}
+
+func (storage *testServiceStorage) SetServiceCached(serviceID string, aosVersion uint64, cached bool) (err error) {
+	var found bool
+
+	for i, serviceInfo := range storage.Services {
+		if serviceInfo.ServiceID == serviceID {
+			storage.Services[i].Cached = cached
+
+			found = true
+		}
+	}
+
+	if !found {
+		err = servicemanager.ErrNotExist
+	}
+
+	return err
+}
+
+func (storage *testServiceStorage) SetServiceQuarantined(
+	serviceID string, aosVersion uint64, quarantined bool,
+) error {
+	for i, serviceInfo := range storage.Services {
+		if serviceInfo.ServiceID == serviceID && serviceInfo.AosVersion == aosVersion {
+			storage.Services[i].Quarantined = quarantined
+
+			return nil
+		}
+	}
+
+	return servicemanager.ErrNotExist
+}
+
+func (storage *testServiceStorage) SetServicePrefetched(
+	serviceID string, aosVersion uint64, prefetched bool,
+) error {
+	for i, serviceInfo := range storage.Services {
+		if serviceInfo.ServiceID == serviceID && serviceInfo.AosVersion == aosVersion {
+			storage.Services[i].Prefetched = prefetched
+
+			return nil
+		}
+	}
+
+	return servicemanager.ErrNotExist
+}
+
+func (storage *testServiceStorage) AddBlob(blob blobstore.BlobInfo) error {
+	if storage.Blobs == nil {
+		storage.Blobs = make(map[string]blobstore.BlobInfo)
+	}
+
+	storage.Blobs[blob.Path] = blob
+
+	return nil
+}
+
+func (storage *testServiceStorage) GetBlob(path string) (blobstore.BlobInfo, error) {
+	blob, ok := storage.Blobs[path]
+	if !ok {
+		return blob, blobstore.ErrNotExist
+	}
+
+	return blob, nil
+}
+
+func (storage *testServiceStorage) GetBlobs() (blobs []blobstore.BlobInfo, err error) {
+	for _, blob := range storage.Blobs {
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, nil
+}
+
+func (storage *testServiceStorage) SetBlobRefCount(path string, refCount uint64) error {
+	blob, ok := storage.Blobs[path]
+	if !ok {
+		return blobstore.ErrNotExist
+	}
+
+	blob.RefCount = refCount
+	storage.Blobs[path] = blob
+
+	return nil
+}
+
+func (storage *testServiceStorage) RemoveBlob(path string) error {
+	delete(storage.Blobs, path)
+
+	return nil
+}
+
+/***********************************************************************************************************************
+* Private
+***********************************************************************************************************************/
+
+func setup() (err error) {
+	if tmpDir, err = ioutil.TempDir("", "aos_"); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	servicemanager.NewSpaceAllocator = newSpaceAllocator
+
+	return nil
+}
+
+func cleanup() {
+	os.RemoveAll(tmpDir)
+}
+
+func prepareService(
+	testContent, serviceID string, aosVersion uint64, servicelayerSize int64,
+) (serviceInfo aostypes.ServiceInfo, err error) {
+	return prepareServiceImage(serviceID, aosVersion, map[string]string{
+		filepath.Join("home", "service.py"): string(make([]byte, servicelayerSize)),
+	}, nil)
+}
+
+func prepareServiceImage(
+	serviceID string, aosVersion uint64, rootfsFiles map[string]string, annotations map[string]string,
+) (serviceInfo aostypes.ServiceInfo, err error) {
+	imageDir, err := ioutil.TempDir("", "aos_")
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	defer os.RemoveAll(imageDir)
+
+	rootFsPath := filepath.Join(imageDir, "rootfs")
+
+	for name, content := range rootfsFiles {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(rootFsPath, name)), 0o755); err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(rootFsPath, name), []byte(content), 0o644); err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+	}
+
+	serviceSize, err := fs.GetDirSize(rootFsPath)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	fsDigest, err := generateFsLayer(imageDir, rootFsPath)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	aosSrvConfigDigest, err := generateAndSaveDigest(filepath.Join(imageDir, blobsFolder), []byte("{}"))
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	imgSpecDigestDigest, err := generateAndSaveDigest(filepath.Join(imageDir, blobsFolder), []byte("{}"))
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	imgAosLayerDigest, err := generateAndSaveDigest(imageDir, []byte("{}"))
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err := genarateImageManfest(
+		imageDir, &imgSpecDigestDigest, &aosSrvConfigDigest, &fsDigest,
+		serviceSize, []digest.Digest{imgAosLayerDigest}, annotations); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	imageFile, err := ioutil.TempFile("", "aos_")
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	outputURL := imageFile.Name()
+	imageFile.Close()
+
+	if err = packImage(imageDir, outputURL); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	fileInfo, err := image.CreateFileInfo(context.Background(), outputURL)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	return aostypes.ServiceInfo{
+		VersionInfo: aostypes.VersionInfo{
 			AosVersion: aosVersion,
 		},
 		ID:     serviceID,
@@ -981,6 +2071,228 @@ func prepareService(
 	}, nil
 }
 
+func prepareMultiArchService(
+	serviceID string, aosVersion uint64, platforms []imagespec.Platform,
+) (serviceInfo aostypes.ServiceInfo, err error) {
+	imageDir, err := ioutil.TempDir("", "aos_")
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	defer os.RemoveAll(imageDir)
+
+	var index imagespec.Index
+	index.SchemaVersion = 2
+
+	for i := range platforms {
+		rootFsPath := filepath.Join(imageDir, "rootfs")
+
+		if err = os.MkdirAll(filepath.Join(rootFsPath, "home"), 0o755); err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(rootFsPath, "home", "platform"),
+			[]byte(platform.String(&platforms[i])), 0o644); err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		serviceSize, err := fs.GetDirSize(rootFsPath)
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		fsDigest, err := generateFsLayer(imageDir, rootFsPath)
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		configDigest, err := generateAndSaveDigest(filepath.Join(imageDir, blobsFolder),
+			[]byte(`{"architecture":"`+platforms[i].Architecture+`","os":"linux"}`))
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		aosSrvConfigDigest, err := generateAndSaveDigest(filepath.Join(imageDir, blobsFolder), []byte("{}"))
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		if err = genarateImageManfest(
+			imageDir, &configDigest, &aosSrvConfigDigest, &fsDigest, serviceSize, nil, nil); err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		manifestJSON, err := ioutil.ReadFile(filepath.Join(imageDir, "manifest.json"))
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		manifestDigest, err := generateAndSaveDigest(filepath.Join(imageDir, blobsFolder), manifestJSON)
+		if err != nil {
+			return serviceInfo, aoserrors.Wrap(err)
+		}
+
+		index.Manifests = append(index.Manifests, imagespec.Descriptor{
+			MediaType: imagespec.MediaTypeImageManifest,
+			Digest:    manifestDigest,
+			Size:      int64(len(manifestJSON)),
+			Platform:  &platforms[i],
+		})
+	}
+
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(imageDir, "manifest.json"), indexJSON, 0o644); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	imageFile, err := ioutil.TempFile("", "aos_")
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	outputURL := imageFile.Name()
+	imageFile.Close()
+
+	if err = packImage(imageDir, outputURL); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	fileInfo, err := image.CreateFileInfo(context.Background(), outputURL)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	return aostypes.ServiceInfo{
+		VersionInfo: aostypes.VersionInfo{AosVersion: aosVersion},
+		ID:          serviceID,
+		URL:         "file://" + outputURL,
+		Sha256:      fileInfo.Sha256,
+		Sha512:      fileInfo.Sha512,
+		Size:        fileInfo.Size,
+	}, nil
+}
+
+func getServiceRootFSDigest(t *testing.T, sm *servicemanager.ServiceManager, serviceID string) digest.Digest {
+	t.Helper()
+
+	service, err := sm.GetServiceInfo(serviceID)
+	if err != nil {
+		t.Fatalf("Can't get service info: %v", err)
+	}
+
+	manifestJSON, err := ioutil.ReadFile(filepath.Join(service.ImagePath, "manifest.json"))
+	if err != nil {
+		t.Fatalf("Can't read manifest: %v", err)
+	}
+
+	var manifest imagespec.Manifest
+
+	if err = json.Unmarshal(manifestJSON, &manifest); err != nil {
+		t.Fatalf("Can't parse manifest: %v", err)
+	}
+
+	return manifest.Layers[0].Digest
+}
+
+func createCertificate(
+	t *testing.T, parentKey *ecdsa.PrivateKey, parentCert *x509.Certificate, extKeyUsage ...x509.ExtKeyUsage,
+) (key *ecdsa.PrivateKey, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can't generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "Aos image signer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           extKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  parentCert == nil,
+	}
+
+	if parentCert == nil {
+		parentKey, parentCert = key, template
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Can't create certificate: %v", err)
+	}
+
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("Can't parse certificate: %v", err)
+	}
+
+	return key, cert
+}
+
+func signServicePackage(
+	serviceInfo aostypes.ServiceInfo, key *ecdsa.PrivateKey, cert *x509.Certificate,
+) (aostypes.ServiceInfo, error) {
+	imageDir, err := ioutil.TempDir("", "aos_")
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	defer os.RemoveAll(imageDir)
+
+	packageURL, err := url.Parse(serviceInfo.URL)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if output, err := exec.Command("tar", "xf", packageURL.Path, "-C", imageDir).CombinedOutput(); err != nil {
+		return serviceInfo, aoserrors.Errorf("tar error: %s, code: %s", string(output), err)
+	}
+
+	manifestJSON, err := ioutil.ReadFile(filepath.Join(imageDir, "manifest.json"))
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	hash := sha256.Sum256(manifestJSON)
+
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	signatureJSON, err := json.Marshal(map[string]interface{}{
+		"digest":       digest.FromBytes(manifestJSON),
+		"signature":    signature,
+		"certificates": string(cryptutils.CertToPEM(cert)),
+	})
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(imageDir, "signature.json"), signatureJSON, 0o600); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	if err = packImage(imageDir, packageURL.Path); err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	fileInfo, err := image.CreateFileInfo(context.Background(), packageURL.Path)
+	if err != nil {
+		return serviceInfo, aoserrors.Wrap(err)
+	}
+
+	serviceInfo.Sha256, serviceInfo.Sha512, serviceInfo.Size = fileInfo.Sha256, fileInfo.Sha512, fileInfo.Size
+
+	return serviceInfo, nil
+}
+
 func generateFsLayer(imgFolder, rootfs string) (digest digest.Digest, err error) {
 	blobsDir := filepath.Join(imgFolder, blobsFolder)
 	if err := os.MkdirAll(blobsDir, 0o755); err != nil {
@@ -1040,7 +2352,7 @@ func generateAndSaveDigest(folder string, data []byte) (retDigest digest.Digest,
 }
 
 func genarateImageManfest(folderPath string, imgConfig, aosSrvConfig, rootfsLayer *digest.Digest,
-	rootfsLayerSize int64, srvLayers []digest.Digest,
+	rootfsLayerSize int64, srvLayers []digest.Digest, annotations map[string]string,
 ) (err error) {
 	type serviceManifest struct {
 		imagespec.Manifest
@@ -1049,6 +2361,7 @@ func genarateImageManfest(folderPath string, imgConfig, aosSrvConfig, rootfsLaye
 
 	var manifest serviceManifest
 	manifest.SchemaVersion = 2
+	manifest.Annotations = annotations
 
 	manifest.Config = imagespec.Descriptor{
 		MediaType: "application/vnd.oci.image.config.v1+json",
@@ -1125,3 +2438,83 @@ func getDesiredServices(
 
 	return
 }
+
+func newTestRegistry() *testRegistry {
+	registry := &testRegistry{blobs: make(map[digest.Digest][]byte)}
+
+	registry.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.Lock()
+		defer registry.Unlock()
+
+		path := strings.Split(r.URL.Path, "/")
+
+		data, ok := registry.blobs[digest.Digest(path[len(path)-1])]
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		if path[len(path)-2] == "manifests" {
+			w.Header().Set("Content-Type", imagespec.MediaTypeImageManifest)
+		}
+
+		_, _ = w.Write(data)
+	}))
+
+	registry.host = strings.TrimPrefix(registry.server.URL, "http://")
+
+	return registry
+}
+
+// Pushes service package blobs and manifest with blob sizes set.
+func (registry *testRegistry) pushService(
+	service aostypes.ServiceInfo, repository string,
+) (manifestDigest digest.Digest, err error) {
+	imageDir, err := ioutil.TempDir("", "aos_")
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+	defer os.RemoveAll(imageDir)
+
+	if output, err := exec.Command("tar", "-C", imageDir, "-xf",
+		strings.TrimPrefix(service.URL, "file://")).CombinedOutput(); err != nil {
+		return "", aoserrors.Errorf("error: %s, code: %v", string(output), err)
+	}
+
+	manifestJSON, err := ioutil.ReadFile(filepath.Join(imageDir, "manifest.json"))
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	var manifest struct {
+		imagespec.Manifest
+		AosService *imagespec.Descriptor `json:"aosService,omitempty"`
+	}
+
+	if err = json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, descriptor := range []*imagespec.Descriptor{&manifest.Config, manifest.AosService, &manifest.Layers[0]} {
+		data, err := ioutil.ReadFile(filepath.Join(imageDir, blobsFolder, "sha256", descriptor.Digest.Hex()))
+		if err != nil {
+			return "", aoserrors.Wrap(err)
+		}
+
+		descriptor.Size = int64(len(data))
+		registry.blobs[descriptor.Digest] = data
+	}
+
+	if manifestJSON, err = json.Marshal(manifest); err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	manifestDigest = digest.FromBytes(manifestJSON)
+	registry.blobs[manifestDigest] = manifestJSON
+
+	return manifestDigest, nil
+}
@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicemanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/utils/cryptutils"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Image signature policies.
+const (
+	// SignaturePolicyNone image signature is not checked.
+	SignaturePolicyNone = ""
+	// SignaturePolicyVerify signed images are verified, unsigned images are accepted.
+	SignaturePolicyVerify = "verify"
+	// SignaturePolicyRequire only images with trusted signature are accepted.
+	SignaturePolicyRequire = "require"
+)
+
+// Detached signature of manifest.json. As manifest.json is modified on install, the signed manifest is kept
+// separately to verify the signature on launch.
+const (
+	signatureFileName      = "signature.json"
+	signedManifestFileName = "manifest.json.signed"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type imageSignature struct {
+	Digest       digest.Digest `json:"digest"`
+	Signature    []byte        `json:"signature"`
+	Certificates string        `json:"certificates"`
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func getCACertPool(caCert string) (caPool *x509.CertPool, err error) {
+	certs, err := cryptutils.LoadCertificateFromFile(caCert)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	caPool = x509.NewCertPool()
+
+	for _, cert := range certs {
+		caPool.AddCert(cert)
+	}
+
+	return caPool, nil
+}
+
+func (sm *ServiceManager) saveSignedManifest(installDir string) error {
+	if sm.signaturePolicy == SignaturePolicyNone {
+		return nil
+	}
+
+	if _, err := os.Stat(path.Join(installDir, signatureFileName)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	signedFileName := manifestFileName
+
+	// Image index is signed for multi-platform images
+	if _, err := os.Stat(path.Join(installDir, indexFileName)); err == nil {
+		signedFileName = indexFileName
+	}
+
+	manifestJSON, err := ioutil.ReadFile(path.Join(installDir, signedFileName))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = ioutil.WriteFile(path.Join(installDir, signedManifestFileName), manifestJSON, 0o644); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// Rootfs digest of installed manifest differs from the signed one, rootfs itself is checked by dirhash against
+// installed manifest which checksum is stored in DB.
+func (sm *ServiceManager) checkImageSignature(installDir string) error {
+	if sm.signaturePolicy == SignaturePolicyNone {
+		return nil
+	}
+
+	signatureJSON, err := ioutil.ReadFile(path.Join(installDir, signatureFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return aoserrors.Wrap(err)
+		}
+
+		if sm.signaturePolicy == SignaturePolicyRequire {
+			return aoserrors.Wrap(ErrNotSigned)
+		}
+
+		log.WithField("imagePath", installDir).Warn("Image is not signed")
+
+		return nil
+	}
+
+	var signature imageSignature
+
+	if err = json.Unmarshal(signatureJSON, &signature); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	signedManifestJSON, err := ioutil.ReadFile(path.Join(installDir, signedManifestFileName))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = verifySignature(signedManifestJSON, signature, sm.caPool); err != nil {
+		return err
+	}
+
+	if platform.IsIndex(signedManifestJSON) {
+		manifestDigest, err := selectPlatformManifest(installDir, signedManifestJSON, sm.platform)
+		if err != nil {
+			return err
+		}
+
+		if signedManifestJSON, err = ioutil.ReadFile(getBlobPath(installDir, manifestDigest)); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	var signedManifest serviceManifest
+
+	if err = json.Unmarshal(signedManifestJSON, &signedManifest); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	manifest, err := getImageManifest(installDir)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if !equalSignedDescriptors(manifest, &signedManifest) {
+		return aoserrors.Errorf("manifest doesn't match signed manifest: %w", ErrNotTrusted)
+	}
+
+	return nil
+}
+
+func verifySignature(data []byte, signature imageSignature, caPool *x509.CertPool) error {
+	if signature.Digest != digest.FromBytes(data) {
+		return aoserrors.Errorf("signed manifest digest mismatch: %w", ErrNotTrusted)
+	}
+
+	certs, err := cryptutils.PEMToX509Cert([]byte(signature.Certificates))
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if len(certs) == 0 {
+		return aoserrors.Errorf("no signer certificate: %w", ErrNotTrusted)
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// Certificate without extended key usage is valid for any usage, so code signing usage is checked explicitly
+	if !hasExtKeyUsage(certs[0], x509.ExtKeyUsageCodeSigning) {
+		return aoserrors.Errorf("signer certificate is not issued for code signing: %w", ErrNotTrusted)
+	}
+
+	if _, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         caPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return aoserrors.Errorf("%v: %w", err, ErrNotTrusted)
+	}
+
+	var algorithm x509.SignatureAlgorithm
+
+	switch certs[0].PublicKey.(type) {
+	case *rsa.PublicKey:
+		algorithm = x509.SHA256WithRSA
+
+	case *ecdsa.PublicKey:
+		algorithm = x509.ECDSAWithSHA256
+
+	case ed25519.PublicKey:
+		algorithm = x509.PureEd25519
+
+	default:
+		return aoserrors.Errorf("unsupported signer key: %w", ErrNotTrusted)
+	}
+
+	if err = certs[0].CheckSignature(algorithm, data, signature.Signature); err != nil {
+		return aoserrors.Errorf("%v: %w", err, ErrNotTrusted)
+	}
+
+	return nil
+}
+
+func equalSignedDescriptors(manifest, signedManifest *serviceManifest) bool {
+	if manifest.Config.Digest != signedManifest.Config.Digest ||
+		(manifest.AosService == nil) != (signedManifest.AosService == nil) ||
+		len(manifest.Layers) != len(signedManifest.Layers) || len(manifest.Layers) == 0 {
+		return false
+	}
+
+	if manifest.AosService != nil && manifest.AosService.Digest != signedManifest.AosService.Digest {
+		return false
+	}
+
+	return slices.EqualFunc(manifest.Layers[1:], signedManifest.Layers[1:],
+		func(layer, signedLayer imagespec.Descriptor) bool {
+			return layer.Digest == signedLayer.Digest
+		})
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, certUsage := range cert.ExtKeyUsage {
+		if certUsage == usage {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,311 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smclient
+
+import (
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/aostypes"
+	pb "github.com/aoscloud/aos_common/api/servicemanager/v3"
+	"github.com/aoscloud/aos_common/utils/pbconvert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/aoscloud/aos_servicemanager/launcher"
+	"github.com/aoscloud/aos_servicemanager/networkmanager"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
+)
+
+// Protocol extensions are described in extensions.proto.
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// SMIncomingMessages extension fields.
+const (
+	extGetTrafficHistory protowire.Number = 100
+)
+
+// SMOutgoingMessages extension fields.
+const (
+	extTrafficHistory protowire.Number = 100
+	extInstallStatus  protowire.Number = 101
+)
+
+// InstanceMonitoring extension fields.
+const (
+	extLimitsUsage protowire.Number = 100
+)
+
+// ServiceInfo extension fields.
+const (
+	extServicePrefetch protowire.Number = 100
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type trafficHistoryRequest struct {
+	requestID  string
+	instance   *aostypes.InstanceIdent
+	from, till time.Time
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func getExtMessage(message proto.Message, num protowire.Number) (payload []byte, ok bool, err error) {
+	if err = rangeExtFields(message.ProtoReflect().GetUnknown(),
+		func(fieldNum protowire.Number, fieldType protowire.Type, value []byte) error {
+			if fieldNum != num {
+				return nil
+			}
+
+			if payload, err = extBytes(fieldType, value); err != nil {
+				return err
+			}
+
+			ok = true
+
+			return nil
+		}); err != nil {
+		return nil, false, err
+	}
+
+	return payload, ok, nil
+}
+
+func getExtBool(message proto.Message, num protowire.Number) (value bool, ok bool, err error) {
+	if err = rangeExtFields(message.ProtoReflect().GetUnknown(),
+		func(fieldNum protowire.Number, fieldType protowire.Type, data []byte) error {
+			if fieldNum != num {
+				return nil
+			}
+
+			if fieldType != protowire.VarintType {
+				return aoserrors.Errorf("wrong extension field type: %v", fieldType)
+			}
+
+			varint, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return aoserrors.Wrap(protowire.ParseError(n))
+			}
+
+			value, ok = protowire.DecodeBool(varint), true
+
+			return nil
+		}); err != nil {
+		return false, false, err
+	}
+
+	return value, ok, nil
+}
+
+func setExtMessage(message proto.Message, num protowire.Number, payload []byte) {
+	message.ProtoReflect().SetUnknown(appendExtBytes(message.ProtoReflect().GetUnknown(), num, payload))
+}
+
+func rangeExtFields(
+	data []byte, handler func(num protowire.Number, fieldType protowire.Type, value []byte) error,
+) error {
+	for len(data) > 0 {
+		num, fieldType, tagLen := protowire.ConsumeTag(data)
+		if tagLen < 0 {
+			return aoserrors.Wrap(protowire.ParseError(tagLen))
+		}
+
+		data = data[tagLen:]
+
+		valueLen := protowire.ConsumeFieldValue(num, fieldType, data)
+		if valueLen < 0 {
+			return aoserrors.Wrap(protowire.ParseError(valueLen))
+		}
+
+		if err := handler(num, fieldType, data[:valueLen]); err != nil {
+			return err
+		}
+
+		data = data[valueLen:]
+	}
+
+	return nil
+}
+
+func extBytes(fieldType protowire.Type, value []byte) ([]byte, error) {
+	if fieldType != protowire.BytesType {
+		return nil, aoserrors.Errorf("wrong extension field type: %v", fieldType)
+	}
+
+	data, n := protowire.ConsumeBytes(value)
+	if n < 0 {
+		return nil, aoserrors.Wrap(protowire.ParseError(n))
+	}
+
+	return data, nil
+}
+
+func extProtoMessage(fieldType protowire.Type, value []byte, message proto.Message) error {
+	data, err := extBytes(fieldType, value)
+	if err != nil {
+		return err
+	}
+
+	return aoserrors.Wrap(proto.Unmarshal(data, message))
+}
+
+func appendExtBytes(data []byte, num protowire.Number, value []byte) []byte {
+	data = protowire.AppendTag(data, num, protowire.BytesType)
+
+	return protowire.AppendBytes(data, value)
+}
+
+func appendExtVarint(data []byte, num protowire.Number, value uint64) []byte {
+	data = protowire.AppendTag(data, num, protowire.VarintType)
+
+	return protowire.AppendVarint(data, value)
+}
+
+func appendExtProtoMessage(data []byte, num protowire.Number, message proto.Message) ([]byte, error) {
+	value, err := proto.Marshal(message)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return appendExtBytes(data, num, value), nil
+}
+
+func parseGetTrafficHistory(payload []byte) (request trafficHistoryRequest, err error) {
+	err = rangeExtFields(payload, func(num protowire.Number, fieldType protowire.Type, value []byte) error {
+		switch num {
+		case 1:
+			requestID, err := extBytes(fieldType, value)
+			if err != nil {
+				return err
+			}
+
+			request.requestID = string(requestID)
+
+		case 2:
+			var pbInstance pb.InstanceIdent
+
+			if err := extProtoMessage(fieldType, value, &pbInstance); err != nil {
+				return err
+			}
+
+			instance := pbconvert.NewInstanceIdentFromPB(&pbInstance)
+
+			request.instance = &instance
+
+		case 3, 4:
+			var timestamp timestamppb.Timestamp
+
+			if err := extProtoMessage(fieldType, value, &timestamp); err != nil {
+				return err
+			}
+
+			if num == 3 {
+				request.from = timestamp.AsTime()
+			} else {
+				request.till = timestamp.AsTime()
+			}
+		}
+
+		return nil
+	})
+
+	return request, err
+}
+
+func trafficHistoryToExt(
+	request trafficHistoryRequest, items []networkmanager.TrafficHistoryItem, historyErr error,
+) (payload []byte, err error) {
+	payload = appendExtBytes(payload, 1, []byte(request.requestID))
+
+	if request.instance != nil {
+		if payload, err = appendExtProtoMessage(payload, 2, pbconvert.InstanceIdentToPB(*request.instance)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, item := range items {
+		pbItem, err := appendExtProtoMessage(nil, 1, timestamppb.New(item.Timestamp))
+		if err != nil {
+			return nil, err
+		}
+
+		pbItem = appendExtVarint(pbItem, 2, item.InputTraffic)
+		pbItem = appendExtVarint(pbItem, 3, item.OutputTraffic)
+
+		payload = appendExtBytes(payload, 3, pbItem)
+	}
+
+	if historyErr != nil {
+		payload = appendExtBytes(payload, 4, []byte(historyErr.Error()))
+	}
+
+	return payload, nil
+}
+
+func installStatusToExt(event progress.Event) (payload []byte) {
+	payload = appendExtBytes(payload, 1, []byte(event.ItemType))
+	payload = appendExtBytes(payload, 2, []byte(event.ID))
+	payload = appendExtVarint(payload, 3, event.AosVersion)
+
+	if event.Digest != "" {
+		payload = appendExtBytes(payload, 4, []byte(event.Digest))
+	}
+
+	payload = appendExtBytes(payload, 5, []byte(event.State))
+
+	if event.State == progress.StateDownloading {
+		payload = appendExtVarint(payload, 6, event.Downloaded)
+		payload = appendExtVarint(payload, 7, event.Total)
+	}
+
+	if event.Error != "" {
+		payload = appendExtBytes(payload, 8, []byte(event.Error))
+	}
+
+	return payload
+}
+
+func limitsUsageToExt(usage launcher.LimitsUsage) (payload []byte) {
+	payload = appendExtVarint(payload, 1, usage.CPUWeight)
+	payload = appendExtVarint(payload, 2, usage.CPUs)
+	payload = appendExtVarint(payload, 3, usage.MemoryHighEvents)
+	payload = appendExtVarint(payload, 4, usage.Swap)
+
+	for _, ioUsage := range usage.IO {
+		var pbIO []byte
+
+		pbIO = appendExtBytes(pbIO, 1, []byte(ioUsage.Device))
+		pbIO = appendExtVarint(pbIO, 2, ioUsage.ReadBPS)
+		pbIO = appendExtVarint(pbIO, 3, ioUsage.WriteBPS)
+		pbIO = appendExtVarint(pbIO, 4, ioUsage.ReadIOPS)
+		pbIO = appendExtVarint(pbIO, 5, ioUsage.WriteIOPS)
+
+		payload = appendExtBytes(payload, 5, pbIO)
+	}
+
+	return payload
+}
@@ -41,6 +41,8 @@ import (
 
 	"github.com/aoscloud/aos_servicemanager/config"
 	"github.com/aoscloud/aos_servicemanager/launcher"
+	"github.com/aoscloud/aos_servicemanager/networkmanager"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
 )
 
 /***********************************************************************************************************************
@@ -70,13 +72,18 @@ type SMClient struct {
 	unitConfigProcessor  UnitConfigProcessor
 	monitoringProvider   MonitoringDataProvider
 	logsProvider         LogsProvider
+	trafficProvider      TrafficHistoryProvider
 	runtimeStatusChannel <-chan launcher.RuntimeStatus
 	alertChannel         <-chan cloudprotocol.AlertItem
 	monitoringChannel    <-chan cloudprotocol.NodeMonitoringData
 	logsChannel          <-chan cloudprotocol.PushLog
+	servicesProgress     <-chan progress.Event
+	layersProgress       <-chan progress.Event
 	nodeDescription      NodeDescription
 	nodeMonitoringData   cloudprotocol.NodeMonitoringData
 	runStatus            *launcher.InstancesStatus
+	cancelRunInstances   context.CancelFunc
+	runInstancesDone     chan struct{}
 }
 
 type NodeDescription struct {
@@ -99,12 +106,14 @@ type UnitConfigProcessor interface {
 
 // ServicesProcessor process desired services list.
 type ServicesProcessor interface {
-	ProcessDesiredServices(services []aostypes.ServiceInfo) error
+	ProcessDesiredServices(ctx context.Context, services, prefetchServices []aostypes.ServiceInfo) error
+	GetInstallProgressChannel() (channel <-chan progress.Event)
 }
 
 // LayersProcessor process desired layer list.
 type LayersProcessor interface {
-	ProcessDesiredLayers(layers []aostypes.LayerInfo) error
+	ProcessDesiredLayers(ctx context.Context, layers []aostypes.LayerInfo) error
+	GetInstallProgressChannel() (channel <-chan progress.Event)
 }
 
 // InstanceLauncher service instances launcher interface.
@@ -113,6 +122,7 @@ type InstanceLauncher interface {
 	RuntimeStatusChannel() <-chan launcher.RuntimeStatus
 	OverrideEnvVars(envVarsInfo []cloudprotocol.EnvVarsInstanceInfo) ([]cloudprotocol.EnvVarsInstanceStatus, error)
 	CloudConnection(connected bool) error
+	GetInstanceLimitsUsage(instanceIdent aostypes.InstanceIdent) (launcher.LimitsUsage, bool)
 }
 
 // AlertsProvider alert data provider interface.
@@ -133,6 +143,12 @@ type LogsProvider interface {
 	GetLogsDataChannel() (channel <-chan cloudprotocol.PushLog)
 }
 
+// TrafficHistoryProvider traffic history provider interface.
+type TrafficHistoryProvider interface {
+	GetTrafficHistory(
+		instanceIdent *aostypes.InstanceIdent, from, till time.Time) ([]networkmanager.TrafficHistoryItem, error)
+}
+
 /***********************************************************************************************************************
  * Vars
  **********************************************************************************************************************/
@@ -147,12 +163,14 @@ var errIncorrectAlertType = errors.New("incorrect alert type")
 func New(config *config.Config, nodeDescription NodeDescription, certificateProvider CertificateProvider,
 	servicesProcessor ServicesProcessor, layersProcessor LayersProcessor, launcher InstanceLauncher,
 	unitConfigProcessor UnitConfigProcessor, alertsProvider AlertsProvider, monitoringProvider MonitoringDataProvider,
-	logsProvider LogsProvider, cryptcoxontext *cryptutils.CryptoContext, insecure bool,
+	logsProvider LogsProvider, trafficProvider TrafficHistoryProvider, cryptcoxontext *cryptutils.CryptoContext,
+	insecure bool,
 ) (*SMClient, error) {
 	cmClient := &SMClient{
 		config: config, nodeDescription: nodeDescription, servicesProcessor: servicesProcessor,
 		layersProcessor: layersProcessor, launcher: launcher, unitConfigProcessor: unitConfigProcessor,
-		monitoringProvider: monitoringProvider, logsProvider: logsProvider, closeChannel: make(chan struct{}, 1),
+		monitoringProvider: monitoringProvider, logsProvider: logsProvider, trafficProvider: trafficProvider,
+		closeChannel: make(chan struct{}, 1),
 	}
 
 	if err := cmClient.createConnection(config, certificateProvider, cryptcoxontext, insecure); err != nil {
@@ -163,6 +181,14 @@ func New(config *config.Config, nodeDescription NodeDescription, certificateProv
 		cmClient.runtimeStatusChannel = launcher.RuntimeStatusChannel()
 	}
 
+	if servicesProcessor != nil {
+		cmClient.servicesProgress = servicesProcessor.GetInstallProgressChannel()
+	}
+
+	if layersProcessor != nil {
+		cmClient.layersProgress = layersProcessor.GetInstallProgressChannel()
+	}
+
 	if alertsProvider != nil {
 		cmClient.alertChannel = alertsProvider.GetAlertsChannel()
 	}
@@ -194,6 +220,14 @@ func (client *SMClient) Close() (err error) {
 		}
 	}
 
+	client.Lock()
+
+	if client.cancelRunInstances != nil {
+		client.cancelRunInstances()
+	}
+
+	client.Unlock()
+
 	close(client.closeChannel)
 
 	return aoserrors.Wrap(err)
@@ -339,7 +373,7 @@ func (client *SMClient) processMessages() (err error) {
 			client.processSetUnitConfig(data.SetUnitConfig)
 
 		case *pb.SMIncomingMessages_RunInstances:
-			client.processRunInstances(data.RunInstances)
+			client.startRunInstances(data.RunInstances)
 
 		case *pb.SMIncomingMessages_SystemLogRequest:
 			client.processGetSystemLogRequest(data.SystemLogRequest)
@@ -358,6 +392,9 @@ func (client *SMClient) processMessages() (err error) {
 
 		case *pb.SMIncomingMessages_ConnectionStatus:
 			client.processConnectionStatus(data.ConnectionStatus)
+
+		case nil:
+			client.processExtMessages(message)
 		}
 	}
 }
@@ -400,11 +437,41 @@ func (client *SMClient) processSetUnitConfig(config *pb.SetUnitConfig) {
 	}
 }
 
-func (client *SMClient) processRunInstances(runInstances *pb.RunInstances) {
-	services := make([]aostypes.ServiceInfo, len(runInstances.Services))
+// New desired state cancels processing of the previous one. Instances are run only after the previous processing is
+// finished.
+func (client *SMClient) startRunInstances(runInstances *pb.RunInstances) {
+	client.Lock()
+	defer client.Unlock()
+
+	if client.cancelRunInstances != nil {
+		client.cancelRunInstances()
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	prevDone, done := client.runInstancesDone, make(chan struct{})
+
+	client.cancelRunInstances, client.runInstancesDone = cancelFunc, done
+
+	go func() {
+		defer close(done)
+		defer cancelFunc()
+
+		if prevDone != nil {
+			<-prevDone
+		}
+
+		client.processRunInstances(ctx, runInstances)
+	}()
+}
+
+// Prefetched services are installed and kept inactive until an instance of them is requested. Services are prefetched
+// according to prefetch flag of the request, services without the flag are prefetched if they are not used by any
+// requested instance. Layers of prefetched services are kept as they are used by installed services.
+func (client *SMClient) processRunInstances(ctx context.Context, runInstances *pb.RunInstances) {
+	var services, prefetchServices []aostypes.ServiceInfo
 
-	for i, pbService := range runInstances.Services {
-		services[i] = aostypes.ServiceInfo{
+	for _, pbService := range runInstances.Services {
+		service := aostypes.ServiceInfo{
 			VersionInfo: aostypes.VersionInfo{
 				AosVersion:    pbService.VersionInfo.AosVersion,
 				VendorVersion: pbService.VersionInfo.VendorVersion, Description: pbService.VersionInfo.Description,
@@ -412,12 +479,35 @@ func (client *SMClient) processRunInstances(runInstances *pb.RunInstances) {
 			ID: pbService.ServiceId, ProviderID: pbService.ProviderId, URL: pbService.Url, GID: pbService.Gid,
 			Sha256: pbService.Sha256, Sha512: pbService.Sha512, Size: pbService.Size,
 		}
+
+		prefetch, ok, err := getExtBool(pbService, extServicePrefetch)
+		if err != nil {
+			log.WithField("serviceID", pbService.ServiceId).Errorf("Can't get service prefetch flag: %v", err)
+		}
+
+		if !ok {
+			prefetch = !isServiceUsed(pbService.ServiceId, runInstances.Instances)
+		}
+
+		if prefetch {
+			prefetchServices = append(prefetchServices, service)
+
+			continue
+		}
+
+		services = append(services, service)
 	}
 
-	if err := client.servicesProcessor.ProcessDesiredServices(services); err != nil {
+	if err := client.servicesProcessor.ProcessDesiredServices(ctx, services, prefetchServices); err != nil {
 		log.Errorf("Can't process desired services list %v", err)
 	}
 
+	if ctx.Err() != nil {
+		log.Warn("Desired services processing canceled")
+
+		return
+	}
+
 	layers := make([]aostypes.LayerInfo, len(runInstances.Layers))
 
 	for i, pbLayer := range runInstances.Layers {
@@ -431,10 +521,16 @@ func (client *SMClient) processRunInstances(runInstances *pb.RunInstances) {
 		}
 	}
 
-	if err := client.layersProcessor.ProcessDesiredLayers(layers); err != nil {
+	if err := client.layersProcessor.ProcessDesiredLayers(ctx, layers); err != nil {
 		log.Errorf("Can't process desired layer list %v", err)
 	}
 
+	if ctx.Err() != nil {
+		log.Warn("Desired layers processing canceled")
+
+		return
+	}
+
 	instances := make([]aostypes.InstanceInfo, len(runInstances.Instances))
 
 	for i, pbInstance := range runInstances.Instances {
@@ -533,7 +629,7 @@ func (client *SMClient) processNodeMonitoringData() {
 	if err := client.stream.Send(
 		&pb.SMOutgoingMessages{
 			SMOutgoingMessage: &pb.SMOutgoingMessages_NodeMonitoring{
-				NodeMonitoring: cloudprotocolMonitoringToPB(client.nodeMonitoringData),
+				NodeMonitoring: client.monitoringToPB(client.nodeMonitoringData),
 			},
 		}); err != nil {
 		log.Errorf("Can't send monitoring notification: %v ", err)
@@ -546,6 +642,55 @@ func (client *SMClient) processConnectionStatus(status *pb.ConnectionStatus) {
 	}
 }
 
+func (client *SMClient) processExtMessages(message *pb.SMIncomingMessages) {
+	payload, ok, err := getExtMessage(message, extGetTrafficHistory)
+	if err != nil {
+		log.Errorf("Can't parse extension message: %v", err)
+
+		return
+	}
+
+	if ok {
+		client.processGetTrafficHistory(payload)
+	}
+}
+
+func (client *SMClient) processGetTrafficHistory(payload []byte) {
+	request, err := parseGetTrafficHistory(payload)
+	if err != nil {
+		log.Errorf("Can't parse traffic history request: %v", err)
+
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"requestID": request.requestID, "from": request.from, "till": request.till,
+	}).Debug("Process traffic history request")
+
+	var items []networkmanager.TrafficHistoryItem
+
+	if client.trafficProvider != nil {
+		items, err = client.trafficProvider.GetTrafficHistory(request.instance, request.from, request.till)
+	} else {
+		err = aoserrors.New("traffic history is not supported")
+	}
+
+	payload, err = trafficHistoryToExt(request, items, err)
+	if err != nil {
+		log.Errorf("Can't convert traffic history: %v", err)
+
+		return
+	}
+
+	message := &pb.SMOutgoingMessages{}
+
+	setExtMessage(message, extTrafficHistory, payload)
+
+	if err := client.stream.Send(message); err != nil {
+		log.Errorf("Can't send traffic history: %v", err)
+	}
+}
+
 func (client *SMClient) handleChannels() {
 	for {
 		select {
@@ -582,7 +727,7 @@ func (client *SMClient) handleChannels() {
 			if err := client.stream.Send(
 				&pb.SMOutgoingMessages{
 					SMOutgoingMessage: &pb.SMOutgoingMessages_NodeMonitoring{
-						NodeMonitoring: cloudprotocolMonitoringToPB(monitoringData),
+						NodeMonitoring: client.monitoringToPB(monitoringData),
 					},
 				}); err != nil {
 				log.Errorf("Can't send monitoring notification: %v", err)
@@ -600,12 +745,51 @@ func (client *SMClient) handleChannels() {
 				return
 			}
 
+		case event := <-client.servicesProgress:
+			if err := client.sendInstallStatus(event); err != nil {
+				log.Errorf("Can't send service install status: %v", err)
+
+				return
+			}
+
+		case event := <-client.layersProgress:
+			if err := client.sendInstallStatus(event); err != nil {
+				log.Errorf("Can't send layer install status: %v", err)
+
+				return
+			}
+
 		case <-client.stream.Context().Done():
 			return
 		}
 	}
 }
 
+func (client *SMClient) sendInstallStatus(event progress.Event) error {
+	message := &pb.SMOutgoingMessages{}
+
+	setExtMessage(message, extInstallStatus, installStatusToExt(event))
+
+	return aoserrors.Wrap(client.stream.Send(message))
+}
+
+// Instance limits usage is sent as monitoring extension.
+func (client *SMClient) monitoringToPB(monitoring cloudprotocol.NodeMonitoringData) *pb.NodeMonitoring {
+	pbMonitoring := cloudprotocolMonitoringToPB(monitoring)
+
+	if client.launcher == nil {
+		return pbMonitoring
+	}
+
+	for i, instanceMonitoring := range monitoring.ServiceInstances {
+		if usage, ok := client.launcher.GetInstanceLimitsUsage(instanceMonitoring.InstanceIdent); ok {
+			setExtMessage(pbMonitoring.InstanceMonitoring[i], extLimitsUsage, limitsUsageToExt(usage))
+		}
+	}
+
+	return pbMonitoring
+}
+
 func (client *SMClient) sendRuntimeInstanceNotifications(runtimeStatus launcher.RuntimeStatus) error {
 	if runtimeStatus.RunStatus != nil {
 		runStatusNtf := &pb.SMOutgoingMessages_RunInstancesStatus{
@@ -630,6 +814,16 @@ func (client *SMClient) sendRuntimeInstanceNotifications(runtimeStatus launcher.
 	return nil
 }
 
+func isServiceUsed(serviceID string, instances []*pb.InstanceInfo) bool {
+	for _, instance := range instances {
+		if instance.GetInstance().GetServiceId() == serviceID {
+			return true
+		}
+	}
+
+	return false
+}
+
 func runInstanceStatusToPB(runStatus *launcher.InstancesStatus) *pb.RunInstancesStatus {
 	pbStatus := &pb.RunInstancesStatus{Instances: make([]*pb.InstanceStatus, len(runStatus.Instances))}
 
@@ -18,6 +18,7 @@
 package smclient_test
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
@@ -30,14 +31,18 @@ import (
 	"github.com/aoscloud/aos_common/aostypes"
 	"github.com/aoscloud/aos_common/api/cloudprotocol"
 	pb "github.com/aoscloud/aos_common/api/servicemanager/v3"
+	"github.com/aoscloud/aos_common/utils/pbconvert"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/aoscloud/aos_servicemanager/config"
 	"github.com/aoscloud/aos_servicemanager/launcher"
+	"github.com/aoscloud/aos_servicemanager/networkmanager"
 	"github.com/aoscloud/aos_servicemanager/smclient"
+	"github.com/aoscloud/aos_servicemanager/utils/progress"
 )
 
 /***********************************************************************************************************************
@@ -60,9 +65,24 @@ type testServer struct {
 	monitoringChannel chan *pb.SMOutgoingMessages_NodeMonitoring
 	logChannel        chan *pb.SMOutgoingMessages_Log
 	envVarsChannel    chan *pb.SMOutgoingMessages_OverrideEnvVarStatus
+	extChannel        chan []byte
 	pb.UnimplementedSMServiceServer
 }
 
+type testTrafficProvider struct {
+	instance   *aostypes.InstanceIdent
+	from, till time.Time
+	items      []networkmanager.TrafficHistoryItem
+	err        error
+}
+
+type testTrafficHistory struct {
+	requestID string
+	instance  *pb.InstanceIdent
+	items     []networkmanager.TrafficHistoryItem
+	err       string
+}
+
 type testMonitoringProvider struct {
 	monitoringChannel chan cloudprotocol.NodeMonitoringData
 }
@@ -83,11 +103,14 @@ type testAlertProvider struct {
 }
 
 type testServiceManager struct {
-	services []aostypes.ServiceInfo
+	services         []aostypes.ServiceInfo
+	prefetchServices []aostypes.ServiceInfo
+	progressChannel  chan progress.Event
 }
 
 type testLayerManager struct {
-	layers []aostypes.LayerInfo
+	layers          []aostypes.LayerInfo
+	progressChannel chan progress.Event
 }
 
 type testLauncher struct {
@@ -96,6 +119,8 @@ type testLauncher struct {
 	envVarsInfo   []cloudprotocol.EnvVarsInstanceInfo
 	envVarsStatus []cloudprotocol.EnvVarsInstanceStatus
 
+	limitsUsage map[aostypes.InstanceIdent]launcher.LimitsUsage
+
 	callChannel       chan struct{}
 	connectionChannel chan bool
 }
@@ -137,7 +162,7 @@ func TestSMRegistration(t *testing.T) {
 
 	client, err := smclient.New(&config.Config{CMServerURL: serverURL, RunnerFeatures: []string{"crun"}},
 		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: systemInfo},
-		nil, nil, nil, nil, nil, nil, testMonitoring, nil, nil, true)
+		nil, nil, nil, nil, nil, nil, testMonitoring, nil, nil, nil, true)
 	if err != nil {
 		t.Fatalf("Can't create UM client: %v", err)
 	}
@@ -171,10 +196,21 @@ func TestMonitoringNotifications(t *testing.T) {
 		Partitions: []cloudprotocol.PartitionInfo{{Name: "p1", Types: []string{"t1"}, TotalSize: 200}},
 	}
 
+	limitsUsage := launcher.LimitsUsage{
+		CPUWeight: 200, CPUs: 2, MemoryHighEvents: 3, Swap: 1024,
+		IO: []launcher.DeviceIOUsage{{Device: "8:0", ReadBPS: 100, WriteBPS: 200, ReadIOPS: 1, WriteIOPS: 2}},
+	}
+
+	testLauncher := newTestLauncher()
+
+	testLauncher.limitsUsage = map[aostypes.InstanceIdent]launcher.LimitsUsage{
+		{ServiceID: "service1", SubjectID: "s1", Instance: 1}: limitsUsage,
+	}
+
 	client, err := smclient.New(&config.Config{
 		CMServerURL: serverURL, RemoteNode: true, RunnerFeatures: []string{"crun"},
 	}, smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: systemInfo},
-		nil, nil, nil, nil, nil, nil, testMonitoring, nil, nil, true)
+		nil, nil, nil, testLauncher, nil, nil, testMonitoring, nil, nil, nil, true)
 	if err != nil {
 		t.Fatalf("Can't create UM client: %v", err)
 	}
@@ -195,6 +231,16 @@ func TestMonitoringNotifications(t *testing.T) {
 		expectedMonitoring pb.NodeMonitoring
 	}
 
+	instanceMonitoring := &pb.InstanceMonitoring{
+		Instance: &pb.InstanceIdent{ServiceId: "service1", SubjectId: "s1", Instance: 1},
+		MonitoringData: &pb.MonitoringData{
+			Ram: 10, Cpu: 20, InTraffic: 40, OutTraffic: 50,
+			Disk: []*pb.PartitionUsage{{Name: "ps1", UsedSize: 100}},
+		},
+	}
+
+	instanceMonitoring.ProtoReflect().SetUnknown(limitsUsageExt(limitsUsage))
+
 	testMonitoringData := []testMonitoringElement{
 		{
 			sendMonitoring: cloudprotocol.NodeMonitoringData{
@@ -239,13 +285,7 @@ func TestMonitoringNotifications(t *testing.T) {
 					Disk: []*pb.PartitionUsage{{Name: "p1", UsedSize: 100}},
 				},
 				InstanceMonitoring: []*pb.InstanceMonitoring{
-					{
-						Instance: &pb.InstanceIdent{ServiceId: "service1", SubjectId: "s1", Instance: 1},
-						MonitoringData: &pb.MonitoringData{
-							Ram: 10, Cpu: 20, InTraffic: 40, OutTraffic: 50,
-							Disk: []*pb.PartitionUsage{{Name: "ps1", UsedSize: 100}},
-						},
-					},
+					instanceMonitoring,
 					{
 						Instance: &pb.InstanceIdent{ServiceId: "service2", SubjectId: "s1", Instance: 1},
 						MonitoringData: &pb.MonitoringData{
@@ -285,7 +325,7 @@ func TestLogsNotification(t *testing.T) {
 
 	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
 		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
-		nil, nil, nil, nil, nil, nil, nil, &logProvider, nil, true)
+		nil, nil, nil, nil, nil, nil, nil, &logProvider, nil, nil, true)
 	if err != nil {
 		t.Fatalf("Can't create UM client: %v", err)
 	}
@@ -387,7 +427,7 @@ func TestAlertNotifications(t *testing.T) {
 
 	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
 		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
-		nil, nil, nil, nil, nil, testAlerts, nil, nil, nil, true)
+		nil, nil, nil, nil, nil, testAlerts, nil, nil, nil, nil, true)
 	if err != nil {
 		t.Fatalf("Can't create UM client: %v", err)
 	}
@@ -618,6 +658,16 @@ func TestRunInstances(t *testing.T) {
 					Sha512:      []byte("dsklddf"),
 					Size:        12900,
 				},
+				{
+					VersionInfo: &pb.VersionInfo{AosVersion: 1, VendorVersion: "3", Description: "this is service 3"},
+					Url:         "url876",
+					ServiceId:   "service3",
+					ProviderId:  "provider1",
+					Gid:         987,
+					Sha256:      []byte("lkjhasd"),
+					Sha512:      []byte("qwerwqe"),
+					Size:        4500,
+				},
 			},
 			Layers: []*pb.LayerInfo{
 				{
@@ -710,7 +760,7 @@ func TestRunInstances(t *testing.T) {
 
 	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
 		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
-		nil, serviceManager, layerManager, launcher, nil, nil, nil, nil, nil, true)
+		nil, serviceManager, layerManager, launcher, nil, nil, nil, nil, nil, nil, true)
 	if err != nil {
 		t.Fatalf("Can't create UM client: %v", err)
 	}
@@ -731,12 +781,16 @@ func TestRunInstances(t *testing.T) {
 			t.Fatalf("Error waiting call: %v", err)
 		}
 
-		services, layers, instances, forceRestart := convertRunInstancesReq(req)
+		services, prefetchServices, layers, instances, forceRestart := convertRunInstancesReq(req)
 
 		if !reflect.DeepEqual(serviceManager.services, services) {
 			t.Errorf("Wrong services: %v", serviceManager.services)
 		}
 
+		if !reflect.DeepEqual(serviceManager.prefetchServices, prefetchServices) {
+			t.Errorf("Wrong prefetch services: %v", serviceManager.prefetchServices)
+		}
+
 		if !reflect.DeepEqual(layerManager.layers, layers) {
 			t.Errorf("Wrong layers: %v", layerManager.layers)
 		}
@@ -751,6 +805,84 @@ func TestRunInstances(t *testing.T) {
 	}
 }
 
+func TestServicesPrefetch(t *testing.T) {
+	newService := func(serviceID string) *pb.ServiceInfo {
+		return &pb.ServiceInfo{VersionInfo: &pb.VersionInfo{AosVersion: 1}, ServiceId: serviceID}
+	}
+
+	newServiceWithPrefetch := func(serviceID string, prefetch bool) *pb.ServiceInfo {
+		service := newService(serviceID)
+
+		data := protowire.AppendTag(nil, 100, protowire.VarintType)
+		service.ProtoReflect().SetUnknown(protowire.AppendVarint(data, protowire.EncodeBool(prefetch)))
+
+		return service
+	}
+
+	req := &pb.RunInstances{
+		Services: []*pb.ServiceInfo{
+			newService("usedService"),
+			newService("unusedService"),
+			newServiceWithPrefetch("desiredService", false),
+			newServiceWithPrefetch("prefetchService", true),
+		},
+		Instances: []*pb.InstanceInfo{
+			{Instance: &pb.InstanceIdent{ServiceId: "usedService", SubjectId: "subject1"}},
+		},
+	}
+
+	server, err := newTestServer(serverURL)
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+
+	defer server.close()
+
+	serviceManager := &testServiceManager{}
+	launcher := newTestLauncher()
+
+	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
+		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
+		nil, serviceManager, &testLayerManager{}, launcher, nil, nil, nil, nil, nil, nil, true)
+	if err != nil {
+		t.Fatalf("Can't create SM client: %v", err)
+	}
+	defer client.Close()
+
+	if err := server.waitClientRegistered(&pb.NodeConfiguration{NodeId: "mainSM", NodeType: "model1"}); err != nil {
+		t.Fatalf("SM registration error: %v", err)
+	}
+
+	if err := server.stream.Send(&pb.SMIncomingMessages{
+		SMIncomingMessage: &pb.SMIncomingMessages_RunInstances{RunInstances: req},
+	}); err != nil {
+		t.Fatalf("Can't send request: %v", err)
+	}
+
+	if err := launcher.waitCall(); err != nil {
+		t.Fatalf("Error waiting call: %v", err)
+	}
+
+	getServiceIDs := func(services []aostypes.ServiceInfo) (serviceIDs []string) {
+		for _, service := range services {
+			serviceIDs = append(serviceIDs, service.ID)
+		}
+
+		return serviceIDs
+	}
+
+	// Desired service without instances is installed if it is not marked for prefetch
+	if serviceIDs := getServiceIDs(serviceManager.services); !reflect.DeepEqual(
+		serviceIDs, []string{"usedService", "desiredService"}) {
+		t.Errorf("Wrong services: %v", serviceIDs)
+	}
+
+	if serviceIDs := getServiceIDs(serviceManager.prefetchServices); !reflect.DeepEqual(
+		serviceIDs, []string{"unusedService", "prefetchService"}) {
+		t.Errorf("Wrong prefetch services: %v", serviceIDs)
+	}
+}
+
 func TestOverrideEnvVars(t *testing.T) {
 	type testData struct {
 		req    *pb.OverrideEnvVars
@@ -816,7 +948,7 @@ func TestOverrideEnvVars(t *testing.T) {
 
 	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
 		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
-		nil, nil, nil, launcher, nil, nil, nil, nil, nil, true)
+		nil, nil, nil, launcher, nil, nil, nil, nil, nil, nil, true)
 	if err != nil {
 		t.Fatalf("Can't create UM client: %v", err)
 	}
@@ -863,7 +995,7 @@ func TestCloudConnection(t *testing.T) {
 
 	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
 		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
-		nil, nil, nil, launcher, nil, nil, nil, nil, nil, true)
+		nil, nil, nil, launcher, nil, nil, nil, nil, nil, nil, true)
 	if err != nil {
 		t.Fatalf("Can't create UM client: %v", err)
 	}
@@ -911,6 +1043,169 @@ func TestCloudConnection(t *testing.T) {
 	}
 }
 
+func TestInstallStatus(t *testing.T) {
+	server, err := newTestServer(serverURL)
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+
+	defer server.close()
+
+	serviceManager := &testServiceManager{progressChannel: make(chan progress.Event, 10)}
+	layerManager := &testLayerManager{progressChannel: make(chan progress.Event, 10)}
+
+	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
+		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
+		nil, serviceManager, layerManager, nil, nil, nil, nil, nil, nil, nil, true)
+	if err != nil {
+		t.Fatalf("Can't create UM client: %v", err)
+	}
+	defer client.Close()
+
+	if err := server.waitClientRegistered(&pb.NodeConfiguration{NodeId: "mainSM", NodeType: "model1"}); err != nil {
+		t.Fatalf("SM registration error: %v", err)
+	}
+
+	data := []struct {
+		channel chan progress.Event
+		event   progress.Event
+	}{
+		{
+			channel: serviceManager.progressChannel,
+			event: progress.Event{
+				ItemType: progress.ItemTypeService, ID: "service0", AosVersion: 1, State: progress.StateStarted,
+			},
+		},
+		{
+			channel: serviceManager.progressChannel,
+			event: progress.Event{
+				ItemType: progress.ItemTypeService, ID: "service0", AosVersion: 1, State: progress.StateDownloading,
+				Downloaded: 1024, Total: 4096,
+			},
+		},
+		{
+			channel: serviceManager.progressChannel,
+			event: progress.Event{
+				ItemType: progress.ItemTypeService, ID: "service0", AosVersion: 1, State: progress.StateInstalled,
+			},
+		},
+		{
+			channel: layerManager.progressChannel,
+			event: progress.Event{
+				ItemType: progress.ItemTypeLayer, ID: "layer0", AosVersion: 2, Digest: "sha256:1234",
+				State: progress.StateFailed, Error: "checksum mismatch",
+			},
+		},
+	}
+
+	for _, item := range data {
+		item.channel <- item.event
+
+		status, err := server.waitInstallStatus()
+		if err != nil {
+			t.Fatalf("Wait install status error: %v", err)
+		}
+
+		if !reflect.DeepEqual(status, item.event) {
+			t.Errorf("Wrong install status: %v", status)
+		}
+	}
+}
+
+func TestTrafficHistory(t *testing.T) {
+	server, err := newTestServer(serverURL)
+	if err != nil {
+		t.Fatalf("Can't create test server: %v", err)
+	}
+
+	defer server.close()
+
+	trafficProvider := &testTrafficProvider{}
+
+	client, err := smclient.New(&config.Config{CMServerURL: serverURL},
+		smclient.NodeDescription{NodeID: "mainSM", NodeType: "model1", SystemInfo: cloudprotocol.SystemInfo{}},
+		nil, nil, nil, nil, nil, nil, nil, nil, trafficProvider, nil, true)
+	if err != nil {
+		t.Fatalf("Can't create UM client: %v", err)
+	}
+	defer client.Close()
+
+	if err := server.waitClientRegistered(&pb.NodeConfiguration{NodeId: "mainSM", NodeType: "model1"}); err != nil {
+		t.Fatalf("SM registration error: %v", err)
+	}
+
+	from := time.Now().Add(-time.Hour).Round(time.Second).UTC()
+	till := time.Now().Round(time.Second).UTC()
+
+	data := []struct {
+		requestID string
+		instance  *aostypes.InstanceIdent
+		items     []networkmanager.TrafficHistoryItem
+		err       error
+	}{
+		{
+			requestID: "system",
+			items: []networkmanager.TrafficHistoryItem{
+				{Timestamp: from, InputTraffic: 100, OutputTraffic: 200},
+				{Timestamp: till, InputTraffic: 300, OutputTraffic: 400},
+			},
+		},
+		{
+			requestID: "instance",
+			instance:  &aostypes.InstanceIdent{ServiceID: "service0", SubjectID: "subject0", Instance: 1},
+			items: []networkmanager.TrafficHistoryItem{
+				{Timestamp: till, InputTraffic: 1024, OutputTraffic: 2048},
+			},
+		},
+		{
+			requestID: "error",
+			instance:  &aostypes.InstanceIdent{ServiceID: "service1", SubjectID: "subject0", Instance: 0},
+			err:       aoserrors.New("instance not found"),
+		},
+	}
+
+	for _, item := range data {
+		trafficProvider.items, trafficProvider.err = item.items, item.err
+
+		request := &pb.SMIncomingMessages{}
+
+		request.ProtoReflect().SetUnknown(getTrafficHistoryRequest(t, item.requestID, item.instance, from, till))
+
+		if err := server.stream.Send(request); err != nil {
+			t.Fatalf("Can't send request: %v", err)
+		}
+
+		history, err := server.waitTrafficHistory()
+		if err != nil {
+			t.Fatalf("Wait traffic history error: %v", err)
+		}
+
+		if !reflect.DeepEqual(trafficProvider.instance, item.instance) {
+			t.Errorf("Wrong requested instance: %v", trafficProvider.instance)
+		}
+
+		if !trafficProvider.from.Equal(from) || !trafficProvider.till.Equal(till) {
+			t.Errorf("Wrong requested period: %v - %v", trafficProvider.from, trafficProvider.till)
+		}
+
+		if history.requestID != item.requestID {
+			t.Errorf("Wrong request ID: %s", history.requestID)
+		}
+
+		if item.instance != nil && !proto.Equal(history.instance, pbconvert.InstanceIdentToPB(*item.instance)) {
+			t.Errorf("Wrong instance: %v", history.instance)
+		}
+
+		if !reflect.DeepEqual(history.items, item.items) {
+			t.Errorf("Wrong traffic history: %v", history.items)
+		}
+
+		if item.err != nil && history.err != item.err.Error() {
+			t.Errorf("Wrong error: %s", history.err)
+		}
+	}
+}
+
 /***********************************************************************************************************************
  * Private
  **********************************************************************************************************************/
@@ -922,6 +1217,7 @@ func newTestServer(url string) (server *testServer, err error) {
 		monitoringChannel: make(chan *pb.SMOutgoingMessages_NodeMonitoring, 10),
 		logChannel:        make(chan *pb.SMOutgoingMessages_Log, 10),
 		envVarsChannel:    make(chan *pb.SMOutgoingMessages_OverrideEnvVarStatus, 10),
+		extChannel:        make(chan []byte, 10),
 	}
 
 	listener, err := net.Listen("tcp", url)
@@ -990,6 +1286,9 @@ func (server *testServer) RegisterSM(stream pb.SMService_RegisterSMServer) error
 
 		case *pb.SMOutgoingMessages_OverrideEnvVarStatus:
 			server.envVarsChannel <- data
+
+		case nil:
+			server.extChannel <- message.ProtoReflect().GetUnknown()
 		}
 	}
 }
@@ -1016,6 +1315,90 @@ func (server *testServer) waitAndCheckLogs(testLogs []testLogData) error {
 	}
 }
 
+func (server *testServer) waitExtMessage(expectedNum protowire.Number) (payload []byte, err error) {
+	select {
+	case data := <-server.extChannel:
+		num, _, n := protowire.ConsumeTag(data)
+		if n < 0 || num != expectedNum {
+			return nil, aoserrors.New("wrong extension message")
+		}
+
+		payload, n := protowire.ConsumeBytes(data[n:])
+		if n < 0 {
+			return nil, aoserrors.Wrap(protowire.ParseError(n))
+		}
+
+		return payload, nil
+
+	case <-time.After(5 * time.Second):
+		return nil, aoserrors.New("wait extension message timeout")
+	}
+}
+
+func (server *testServer) waitTrafficHistory() (history testTrafficHistory, err error) {
+	payload, err := server.waitExtMessage(100)
+	if err != nil {
+		return history, err
+	}
+
+	return parseTrafficHistory(payload)
+}
+
+func (server *testServer) waitInstallStatus() (event progress.Event, err error) {
+	payload, err := server.waitExtMessage(101)
+	if err != nil {
+		return event, err
+	}
+
+	for len(payload) > 0 {
+		num, fieldType, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return event, aoserrors.Wrap(protowire.ParseError(n))
+		}
+
+		payload = payload[n:]
+
+		var (
+			value    []byte
+			varint   uint64
+			valueLen int
+		)
+
+		if fieldType == protowire.BytesType {
+			value, valueLen = protowire.ConsumeBytes(payload)
+		} else {
+			varint, valueLen = protowire.ConsumeVarint(payload)
+		}
+
+		if valueLen < 0 {
+			return event, aoserrors.Wrap(protowire.ParseError(valueLen))
+		}
+
+		payload = payload[valueLen:]
+
+		switch num {
+		case 1:
+			event.ItemType = string(value)
+		case 2:
+			event.ID = string(value)
+		case 3:
+			event.AosVersion = varint
+		case 4:
+			event.Digest = string(value)
+		case 5:
+			event.State = string(value)
+		case 6:
+			event.Downloaded = varint
+		case 7:
+			event.Total = varint
+		case 8:
+			event.Error = string(value)
+		}
+	}
+
+	return event, nil
+}
+
 func (server *testServer) waitEnvVarsStatus(status []cloudprotocol.EnvVarsInstanceStatus) error {
 	select {
 	case data := <-server.envVarsChannel:
@@ -1045,12 +1428,11 @@ func (server *testServer) waitEnvVarsStatus(status []cloudprotocol.EnvVarsInstan
 }
 
 func convertRunInstancesReq(req *pb.RunInstances) (
-	services []aostypes.ServiceInfo, layers []aostypes.LayerInfo, instances []aostypes.InstanceInfo, forceRestart bool,
+	services, prefetchServices []aostypes.ServiceInfo, layers []aostypes.LayerInfo, instances []aostypes.InstanceInfo,
+	forceRestart bool,
 ) {
-	services = make([]aostypes.ServiceInfo, len(req.Services))
-
-	for i, service := range req.Services {
-		services[i] = aostypes.ServiceInfo{
+	for _, service := range req.Services {
+		serviceInfo := aostypes.ServiceInfo{
 			VersionInfo: aostypes.VersionInfo{
 				AosVersion:    service.VersionInfo.AosVersion,
 				VendorVersion: service.VersionInfo.VendorVersion,
@@ -1064,6 +1446,20 @@ func convertRunInstancesReq(req *pb.RunInstances) (
 			Sha512:     service.Sha512,
 			Size:       service.Size,
 		}
+
+		used := false
+
+		for _, instance := range req.Instances {
+			if instance.Instance.ServiceId == service.ServiceId {
+				used = true
+			}
+		}
+
+		if used {
+			services = append(services, serviceInfo)
+		} else {
+			prefetchServices = append(prefetchServices, serviceInfo)
+		}
 	}
 
 	layers = make([]aostypes.LayerInfo, len(req.Layers))
@@ -1102,7 +1498,7 @@ func convertRunInstancesReq(req *pb.RunInstances) (
 
 	forceRestart = req.ForceRestart
 
-	return services, layers, instances, forceRestart
+	return services, prefetchServices, layers, instances, forceRestart
 }
 
 func convertEnvVarsReq(req *pb.OverrideEnvVars) []cloudprotocol.EnvVarsInstanceInfo {
@@ -1133,6 +1529,163 @@ func convertEnvVarsReq(req *pb.OverrideEnvVars) []cloudprotocol.EnvVarsInstanceI
  * Interfaces
  **********************************************************************************************************************/
 
+func getTrafficHistoryRequest(
+	t *testing.T, requestID string, instance *aostypes.InstanceIdent, from, till time.Time,
+) []byte {
+	t.Helper()
+
+	var payload []byte
+
+	payload = protowire.AppendTag(payload, 1, protowire.BytesType)
+	payload = protowire.AppendString(payload, requestID)
+
+	if instance != nil {
+		payload = appendTestProtoMessage(t, payload, 2, pbconvert.InstanceIdentToPB(*instance))
+	}
+
+	payload = appendTestProtoMessage(t, payload, 3, timestamppb.New(from))
+	payload = appendTestProtoMessage(t, payload, 4, timestamppb.New(till))
+
+	data := protowire.AppendTag(nil, 100, protowire.BytesType)
+
+	return protowire.AppendBytes(data, payload)
+}
+
+func appendTestProtoMessage(t *testing.T, data []byte, num protowire.Number, message proto.Message) []byte {
+	t.Helper()
+
+	value, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatalf("Can't marshal message: %v", err)
+	}
+
+	data = protowire.AppendTag(data, num, protowire.BytesType)
+
+	return protowire.AppendBytes(data, value)
+}
+
+func parseTrafficHistory(data []byte) (history testTrafficHistory, err error) {
+	for len(data) > 0 {
+		num, fieldType, n := protowire.ConsumeTag(data)
+		if n < 0 || fieldType != protowire.BytesType {
+			return history, aoserrors.New("wrong traffic history field")
+		}
+
+		value, valueLen := protowire.ConsumeBytes(data[n:])
+		if valueLen < 0 {
+			return history, aoserrors.Wrap(protowire.ParseError(valueLen))
+		}
+
+		data = data[n+valueLen:]
+
+		switch num {
+		case 1:
+			history.requestID = string(value)
+
+		case 2:
+			history.instance = &pb.InstanceIdent{}
+
+			if err := proto.Unmarshal(value, history.instance); err != nil {
+				return history, aoserrors.Wrap(err)
+			}
+
+		case 3:
+			item, err := parseTrafficHistoryItem(value)
+			if err != nil {
+				return history, err
+			}
+
+			history.items = append(history.items, item)
+
+		case 4:
+			history.err = string(value)
+		}
+	}
+
+	return history, nil
+}
+
+func parseTrafficHistoryItem(data []byte) (item networkmanager.TrafficHistoryItem, err error) {
+	for len(data) > 0 {
+		num, fieldType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return item, aoserrors.Wrap(protowire.ParseError(n))
+		}
+
+		data = data[n:]
+
+		switch {
+		case num == 1 && fieldType == protowire.BytesType:
+			value, valueLen := protowire.ConsumeBytes(data)
+			if valueLen < 0 {
+				return item, aoserrors.Wrap(protowire.ParseError(valueLen))
+			}
+
+			var timestamp timestamppb.Timestamp
+
+			if err := proto.Unmarshal(value, &timestamp); err != nil {
+				return item, aoserrors.Wrap(err)
+			}
+
+			item.Timestamp = timestamp.AsTime()
+			data = data[valueLen:]
+
+		case (num == 2 || num == 3) && fieldType == protowire.VarintType:
+			value, valueLen := protowire.ConsumeVarint(data)
+			if valueLen < 0 {
+				return item, aoserrors.Wrap(protowire.ParseError(valueLen))
+			}
+
+			if num == 2 {
+				item.InputTraffic = value
+			} else {
+				item.OutputTraffic = value
+			}
+
+			data = data[valueLen:]
+
+		default:
+			return item, aoserrors.New("wrong traffic history item field")
+		}
+	}
+
+	return item, nil
+}
+
+func limitsUsageExt(usage launcher.LimitsUsage) []byte {
+	var payload []byte
+
+	for i, value := range []uint64{usage.CPUWeight, usage.CPUs, usage.MemoryHighEvents, usage.Swap} {
+		payload = protowire.AppendTag(payload, protowire.Number(i+1), protowire.VarintType)
+		payload = protowire.AppendVarint(payload, value)
+	}
+
+	for _, ioUsage := range usage.IO {
+		ioPayload := protowire.AppendTag(nil, 1, protowire.BytesType)
+		ioPayload = protowire.AppendString(ioPayload, ioUsage.Device)
+
+		for i, value := range []uint64{ioUsage.ReadBPS, ioUsage.WriteBPS, ioUsage.ReadIOPS, ioUsage.WriteIOPS} {
+			ioPayload = protowire.AppendTag(ioPayload, protowire.Number(i+2), protowire.VarintType)
+			ioPayload = protowire.AppendVarint(ioPayload, value)
+		}
+
+		payload = protowire.AppendTag(payload, 5, protowire.BytesType)
+		payload = protowire.AppendBytes(payload, ioPayload)
+	}
+
+	data := protowire.AppendTag(nil, 100, protowire.BytesType)
+
+	return protowire.AppendBytes(data, payload)
+}
+
+func (traffic *testTrafficProvider) GetTrafficHistory(
+	instanceIdent *aostypes.InstanceIdent, from, till time.Time,
+) ([]networkmanager.TrafficHistoryItem, error) {
+	traffic.instance, traffic.from, traffic.till = instanceIdent, from, till
+
+	return traffic.items, traffic.err
+}
+
 func (monitoring *testMonitoringProvider) GetMonitoringDataChannel() (
 	channel <-chan cloudprotocol.NodeMonitoringData,
 ) {
@@ -1167,18 +1720,29 @@ func (alerts *testAlertProvider) GetAlertsChannel() (channel <-chan cloudprotoco
 	return alerts.alertsChannel
 }
 
-func (processor *testServiceManager) ProcessDesiredServices(services []aostypes.ServiceInfo) error {
+func (processor *testServiceManager) ProcessDesiredServices(
+	ctx context.Context, services, prefetchServices []aostypes.ServiceInfo,
+) error {
 	processor.services = services
+	processor.prefetchServices = prefetchServices
 
 	return nil
 }
 
-func (processor *testLayerManager) ProcessDesiredLayers(layers []aostypes.LayerInfo) error {
+func (processor *testServiceManager) GetInstallProgressChannel() (channel <-chan progress.Event) {
+	return processor.progressChannel
+}
+
+func (processor *testLayerManager) ProcessDesiredLayers(ctx context.Context, layers []aostypes.LayerInfo) error {
 	processor.layers = layers
 
 	return nil
 }
 
+func (processor *testLayerManager) GetInstallProgressChannel() (channel <-chan progress.Event) {
+	return processor.progressChannel
+}
+
 func newTestLauncher() *testLauncher {
 	return &testLauncher{callChannel: make(chan struct{}, 1), connectionChannel: make(chan bool, 1)}
 }
@@ -1212,6 +1776,14 @@ func (launcher *testLauncher) CloudConnection(connected bool) error {
 	return nil
 }
 
+func (testLauncher *testLauncher) GetInstanceLimitsUsage(
+	instanceIdent aostypes.InstanceIdent,
+) (launcher.LimitsUsage, bool) {
+	usage, ok := testLauncher.limitsUsage[instanceIdent]
+
+	return usage, ok
+}
+
 func (launcher *testLauncher) waitCall() error {
 	select {
 	case <-launcher.callChannel:
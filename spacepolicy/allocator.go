@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spacepolicy
+
+import (
+	"sync"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/spaceallocator"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// ReservingAllocator space allocator which tracks size of allocations which are not accepted or released yet.
+type ReservingAllocator struct {
+	spaceallocator.Allocator
+
+	mutex        sync.Mutex
+	reservedSize uint64
+}
+
+type reservedSpace struct {
+	spaceallocator.Space
+
+	allocator *ReservingAllocator
+	size      uint64
+	once      sync.Once
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// NewReservingAllocator creates reserving allocator.
+func NewReservingAllocator(allocator spaceallocator.Allocator) *ReservingAllocator {
+	return &ReservingAllocator{Allocator: allocator}
+}
+
+// AllocateSpace allocates space and reserves it until the allocation is accepted or released.
+func (allocator *ReservingAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
+	space, err := allocator.Allocator.AllocateSpace(size)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	allocator.mutex.Lock()
+	allocator.reservedSize += size
+	allocator.mutex.Unlock()
+
+	return &reservedSpace{Space: space, allocator: allocator, size: size}, nil
+}
+
+// ReservedSize returns size of pending allocations.
+func (allocator *ReservingAllocator) ReservedSize() uint64 {
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+
+	return allocator.reservedSize
+}
+
+// Accept accepts allocated space.
+func (space *reservedSpace) Accept() error {
+	space.unreserve()
+
+	return aoserrors.Wrap(space.Space.Accept())
+}
+
+// Release releases allocated space.
+func (space *reservedSpace) Release() error {
+	space.unreserve()
+
+	return aoserrors.Wrap(space.Space.Release())
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (space *reservedSpace) unreserve() {
+	space.once.Do(func() {
+		space.allocator.mutex.Lock()
+		defer space.allocator.mutex.Unlock()
+
+		if space.size > space.allocator.reservedSize {
+			space.allocator.reservedSize = 0
+		} else {
+			space.allocator.reservedSize -= space.size
+		}
+	})
+}
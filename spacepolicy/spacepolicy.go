@@ -0,0 +1,403 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spacepolicy frees node space by evicting items according to node space policy
+package spacepolicy
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	"github.com/aoscloud/aos_common/utils/fs"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+
+	"github.com/aoscloud/aos_servicemanager/config"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Evicted item types.
+const (
+	ItemTypeLayer   = "layer"
+	ItemTypeService = "service"
+	ItemTypeStorage = "storage"
+)
+
+const coreComponent = "aos-servicemanager"
+
+const percents = 100
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Item evictable item. Items with lower priority are evicted first.
+type Item struct {
+	Type       string
+	ID         string
+	AosVersion uint64
+	Path       string
+	Size       uint64
+	Priority   uint64
+	Timestamp  time.Time
+}
+
+// Usage space used by items of the type. Path, part limit and reserved size describe the allocator of the items.
+type Usage struct {
+	Type         string
+	Size         uint64
+	Path         string
+	PartLimit    uint
+	ReservedSize uint64
+}
+
+// ItemProvider provides items which can be evicted.
+type ItemProvider interface {
+	GetSpaceUsage() (Usage, error)
+	GetEvictableItems() ([]Item, error)
+	EvictItem(item Item) error
+}
+
+// PriorityProvider provides priority of the instances which used the item last time.
+type PriorityProvider interface {
+	GetItemPriority(item Item) uint64
+}
+
+// AlertSender provides interface to send alerts.
+type AlertSender interface {
+	SendAlert(alert cloudprotocol.AlertItem)
+}
+
+// Policy node space policy instance.
+type Policy struct {
+	sync.Mutex
+
+	providers        []ItemProvider
+	priorityProvider PriorityProvider
+	alertSender      AlertSender
+	evictStorages    bool
+	maxEvictPriority uint64
+}
+
+type candidate struct {
+	Item
+	provider ItemProvider
+}
+
+type providerUsage struct {
+	Usage
+	provider ItemProvider
+}
+
+// Space required on the partition and in the allocator which failed to allocate.
+type deficit struct {
+	partSize      uint64
+	limitSize     uint64
+	limitProvider ItemProvider
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrNoSpace not enough evictable items to free required space.
+var ErrNoSpace = errors.New("not enough evictable space")
+
+// nolint:gochecknoglobals // used for unit test mock
+var (
+	// GetMountPoint returns mount point of the path.
+	GetMountPoint = fs.GetMountPoint
+	// GetAvailableSize returns available size of the path partition.
+	GetAvailableSize = fs.GetAvailableSize
+	// GetTotalSize returns total size of the path partition.
+	GetTotalSize = fs.GetTotalSize
+)
+
+// Items of types earlier in the list are evicted first: layers and services can be downloaded again.
+// nolint:gochecknoglobals // const
+var evictionOrder = []string{ItemTypeLayer, ItemTypeService, ItemTypeStorage}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// New creates new space policy instance. Priority provider provides priorities of items which owners don't track.
+func New(
+	cfg config.SpacePolicy, alertSender AlertSender, priorityProvider PriorityProvider, providers ...ItemProvider,
+) (policy *Policy) {
+	log.Debug("New space policy")
+
+	return &Policy{
+		providers:        providers,
+		priorityProvider: priorityProvider,
+		alertSender:      alertSender,
+		evictStorages:    cfg.EvictStorages,
+		maxEvictPriority: cfg.MaxEvictPriority,
+	}
+}
+
+// ReclaimSpace evicts items to allocate size bytes by the allocator of the path. Items to be evicted are reported
+// by core alerts before eviction. Space usage by item types is reported by system quota alerts.
+func (policy *Policy) ReclaimSpace(path string, size uint64) error {
+	policy.Lock()
+	defer policy.Unlock()
+
+	usages := policy.getUsages()
+
+	policy.sendUsageAlerts(usages)
+
+	candidates, err := policy.plan(path, size, usages)
+	if err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		log.WithFields(itemLogFields(candidate.Item)).Warn("Item will be evicted")
+
+		policy.sendEvictionAlert(candidate.Item)
+	}
+
+	for _, candidate := range candidates {
+		if err = candidate.provider.EvictItem(candidate.Item); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		log.WithFields(itemLogFields(candidate.Item)).Info("Item evicted")
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (policy *Policy) plan(path string, size uint64, usages []providerUsage) (candidates []candidate, err error) {
+	mountPoint, err := GetMountPoint(path)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	required, err := getDeficit(path, mountPoint, size, usages)
+	if err != nil {
+		return nil, err
+	}
+
+	if required.partSize == 0 && required.limitSize == 0 {
+		return nil, nil
+	}
+
+	if candidates, err = policy.getCandidates(mountPoint); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iOrder, jOrder := slices.Index(evictionOrder, candidates[i].Type), slices.Index(evictionOrder, candidates[j].Type)
+
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority < candidates[j].Priority
+		}
+
+		if iOrder != jOrder {
+			return iOrder < jOrder
+		}
+
+		return candidates[i].Timestamp.Before(candidates[j].Timestamp)
+	})
+
+	var (
+		evictCandidates          []candidate
+		freedPartSize, freedSize uint64
+	)
+
+	// Only items of the limited allocator reduce its deficit, any item of the partition reduces partition deficit
+	for _, candidate := range candidates {
+		if freedPartSize >= required.partSize && freedSize >= required.limitSize {
+			break
+		}
+
+		ownItem := candidate.provider == required.limitProvider
+
+		if freedPartSize >= required.partSize && !ownItem {
+			continue
+		}
+
+		evictCandidates = append(evictCandidates, candidate)
+		freedPartSize += candidate.Size
+
+		if ownItem {
+			freedSize += candidate.Size
+		}
+	}
+
+	if freedPartSize < required.partSize || freedSize < required.limitSize {
+		return nil, aoserrors.Errorf("%w: required partition %d, allocator %d, evictable %d",
+			ErrNoSpace, required.partSize, required.limitSize, freedPartSize)
+	}
+
+	return evictCandidates, nil
+}
+
+// Partition deficit includes pending reservations of all allocators of the partition. Allocator deficit is
+// calculated if the allocator of the path has part limit.
+func getDeficit(path, mountPoint string, size uint64, usages []providerUsage) (required deficit, err error) {
+	availableSize, err := GetAvailableSize(path)
+	if err != nil {
+		return required, aoserrors.Wrap(err)
+	}
+
+	requiredSize := size
+
+	for _, usage := range usages {
+		if usage.Path == "" || usage.ReservedSize == 0 {
+			continue
+		}
+
+		usageMountPoint, err := GetMountPoint(usage.Path)
+		if err != nil {
+			return required, aoserrors.Wrap(err)
+		}
+
+		if usageMountPoint == mountPoint {
+			requiredSize += usage.ReservedSize
+		}
+	}
+
+	if requiredSize > uint64(availableSize) {
+		required.partSize = requiredSize - uint64(availableSize)
+	}
+
+	for _, usage := range usages {
+		if usage.PartLimit == 0 || filepath.Clean(usage.Path) != filepath.Clean(path) {
+			continue
+		}
+
+		totalSize, err := GetTotalSize(path)
+		if err != nil {
+			return required, aoserrors.Wrap(err)
+		}
+
+		limitSize := uint64(totalSize) * uint64(usage.PartLimit) / percents
+
+		if usedSize := usage.Size + usage.ReservedSize + size; usedSize > limitSize {
+			required.limitSize = usedSize - limitSize
+			required.limitProvider = usage.provider
+		}
+
+		break
+	}
+
+	return required, nil
+}
+
+func (policy *Policy) getCandidates(mountPoint string) (candidates []candidate, err error) {
+	for _, provider := range policy.providers {
+		items, err := provider.GetEvictableItems()
+		if err != nil {
+			return nil, aoserrors.Wrap(err)
+		}
+
+		for _, item := range items {
+			if policy.priorityProvider != nil {
+				if priority := policy.priorityProvider.GetItemPriority(item); priority > item.Priority {
+					item.Priority = priority
+				}
+			}
+
+			if item.Priority > policy.maxEvictPriority || (item.Type == ItemTypeStorage && !policy.evictStorages) {
+				continue
+			}
+
+			// Only items on the same partition free required space
+			itemMountPoint, err := GetMountPoint(item.Path)
+			if err != nil {
+				log.WithFields(itemLogFields(item)).Warnf("Can't get item mount point: %v", err)
+
+				continue
+			}
+
+			if itemMountPoint == mountPoint {
+				candidates = append(candidates, candidate{Item: item, provider: provider})
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+func (policy *Policy) getUsages() (usages []providerUsage) {
+	for _, provider := range policy.providers {
+		usage, err := provider.GetSpaceUsage()
+		if err != nil {
+			log.Errorf("Can't get space usage: %v", err)
+
+			continue
+		}
+
+		usages = append(usages, providerUsage{Usage: usage, provider: provider})
+	}
+
+	return usages
+}
+
+func (policy *Policy) sendUsageAlerts(usages []providerUsage) {
+	if policy.alertSender == nil {
+		return
+	}
+
+	for _, usage := range usages {
+		policy.alertSender.SendAlert(cloudprotocol.AlertItem{
+			Timestamp: time.Now(),
+			Tag:       cloudprotocol.AlertTagSystemQuota,
+			Payload: cloudprotocol.SystemQuotaAlert{
+				Parameter: usage.Type + "Usage",
+				Value:     usage.Size,
+			},
+		})
+	}
+}
+
+func (policy *Policy) sendEvictionAlert(item Item) {
+	if policy.alertSender == nil {
+		return
+	}
+
+	policy.alertSender.SendAlert(cloudprotocol.AlertItem{
+		Timestamp: time.Now(),
+		Tag:       cloudprotocol.AlertTagAosCore,
+		Payload: cloudprotocol.CoreAlert{
+			CoreComponent: coreComponent,
+			Message: fmt.Sprintf("%s %s version %d will be evicted: size %d, priority %d",
+				item.Type, item.ID, item.AosVersion, item.Size, item.Priority),
+		},
+	})
+}
+
+func itemLogFields(item Item) log.Fields {
+	return log.Fields{
+		"type": item.Type, "id": item.ID, "aosVersion": item.AosVersion, "size": item.Size, "priority": item.Priority,
+	}
+}
@@ -0,0 +1,335 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spacepolicy_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aoscloud/aos_common/api/cloudprotocol"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/config"
+	"github.com/aoscloud/aos_servicemanager/spacepolicy"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	workingMountPoint = "/var/aos"
+	otherMountPoint   = "/home"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testProvider struct {
+	usage   spacepolicy.Usage
+	items   []spacepolicy.Item
+	evicted []spacepolicy.Item
+}
+
+type testPriorityProvider map[string]uint64
+
+type testAlertSender struct {
+	alerts []cloudprotocol.AlertItem
+}
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	spacepolicy.GetTotalSize = func(path string) (int64, error) {
+		return 2000, nil
+	}
+
+	spacepolicy.GetMountPoint = func(path string) (string, error) {
+		if path == otherMountPoint {
+			return otherMountPoint, nil
+		}
+
+		return workingMountPoint, nil
+	}
+
+	os.Exit(m.Run())
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestEvictionPlan(t *testing.T) {
+	now := time.Now()
+
+	type testData struct {
+		cfg           config.SpacePolicy
+		availableSize int64
+		requiredSize  uint64
+		layersUsage   spacepolicy.Usage
+		servicesUsage spacepolicy.Usage
+		priorities    map[string]uint64
+		expectedItems []string
+		expectedErr   error
+	}
+
+	data := []testData{
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 1000, requiredSize: 500,
+		},
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 0, requiredSize: 250,
+			expectedItems: []string{"layer1", "service2", "service1"},
+		},
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 0, requiredSize: 1000,
+			expectedErr: spacepolicy.ErrNoSpace,
+		},
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10, EvictStorages: true}, availableSize: 0, requiredSize: 1000,
+			expectedItems: []string{"layer1", "service2", "service1", "storage1"},
+		},
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 20}, availableSize: 100, requiredSize: 1100,
+			expectedItems: []string{"layer1", "service2", "service1", "service3"},
+		},
+		// Pending reservations of the partition increase the deficit
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 200, requiredSize: 100,
+			layersUsage: spacepolicy.Usage{
+				Type: spacepolicy.ItemTypeLayer, Path: "/var/aos/layers", ReservedSize: 150,
+			},
+			expectedItems: []string{"layer1"},
+		},
+		// Reservations on other partitions are not counted
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 200, requiredSize: 100,
+			layersUsage: spacepolicy.Usage{
+				Type: spacepolicy.ItemTypeLayer, Path: otherMountPoint, ReservedSize: 150,
+			},
+		},
+		// Part limit of the services allocator is reached: only services free its deficit
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 1000, requiredSize: 100,
+			servicesUsage: spacepolicy.Usage{
+				Type: spacepolicy.ItemTypeService, Path: "/var/aos/services", Size: 1050, PartLimit: 50,
+			},
+			expectedItems: []string{"service2", "service1"},
+		},
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 1000, requiredSize: 100,
+			servicesUsage: spacepolicy.Usage{
+				Type: spacepolicy.ItemTypeService, Path: "/var/aos/services", Size: 1050, ReservedSize: 100,
+				PartLimit: 50,
+			},
+			expectedErr: spacepolicy.ErrNoSpace,
+		},
+		// Priorities of stopped instances protect their items
+		{
+			cfg: config.SpacePolicy{MaxEvictPriority: 10}, availableSize: 0, requiredSize: 150,
+			priorities:    map[string]uint64{"layer1": 5, "service2": 20},
+			expectedItems: []string{"service1", "layer1"},
+		},
+	}
+
+	for i, item := range data {
+		layers := &testProvider{
+			usage: item.layersUsage,
+			items: []spacepolicy.Item{
+				{Type: spacepolicy.ItemTypeLayer, ID: "layer1", Path: "/var/aos/layers/1", Size: 100, Priority: 0},
+				{Type: spacepolicy.ItemTypeLayer, ID: "layer2", Path: otherMountPoint, Size: 1000, Priority: 0},
+			},
+		}
+		services := &testProvider{
+			usage: item.servicesUsage,
+			items: []spacepolicy.Item{
+				{
+					Type: spacepolicy.ItemTypeService, ID: "service1", Path: "/var/aos/services/1", Size: 100,
+					Priority: 0, Timestamp: now,
+				},
+				{
+					Type: spacepolicy.ItemTypeService, ID: "service2", Path: "/var/aos/services/2", Size: 100,
+					Priority: 0, Timestamp: now.Add(-time.Hour),
+				},
+				{
+					Type: spacepolicy.ItemTypeService, ID: "service3", Path: "/var/aos/services/3", Size: 1000,
+					Priority: 20,
+				},
+			},
+		}
+		storages := &testProvider{items: []spacepolicy.Item{
+			{Type: spacepolicy.ItemTypeStorage, ID: "storage1", Path: "/var/aos/storages/1", Size: 1000, Priority: 5},
+		}}
+
+		spacepolicy.GetAvailableSize = func(path string) (int64, error) {
+			return item.availableSize, nil
+		}
+
+		policy := spacepolicy.New(item.cfg, nil, testPriorityProvider(item.priorities), layers, services, storages)
+
+		requiredPath := "/var/aos/layers"
+		if item.servicesUsage.PartLimit != 0 {
+			requiredPath = item.servicesUsage.Path
+		}
+
+		if err := policy.ReclaimSpace(requiredPath, item.requiredSize); !errors.Is(err, item.expectedErr) {
+			t.Errorf("Case %d: wrong error: %v", i, err)
+		}
+
+		evicted := append(append(append([]spacepolicy.Item{}, layers.evicted...), services.evicted...),
+			storages.evicted...)
+
+		if ids := getItemIDs(evicted); !reflect.DeepEqual(sortIDs(ids), sortIDs(item.expectedItems)) {
+			t.Errorf("Case %d: wrong evicted items: %v", i, ids)
+		}
+	}
+}
+
+func TestReclaimSpace(t *testing.T) {
+	layers := &testProvider{
+		usage: spacepolicy.Usage{Type: spacepolicy.ItemTypeLayer, Size: 300},
+		items: []spacepolicy.Item{
+			{Type: spacepolicy.ItemTypeLayer, ID: "layer1", Path: "/var/aos/layers/1", Size: 100},
+		},
+	}
+	services := &testProvider{
+		usage: spacepolicy.Usage{Type: spacepolicy.ItemTypeService, Size: 500},
+		items: []spacepolicy.Item{
+			{Type: spacepolicy.ItemTypeService, ID: "service1", Path: "/var/aos/services/1", Size: 100},
+			{Type: spacepolicy.ItemTypeService, ID: "service2", Path: "/var/aos/services/2", Size: 100, Priority: 1},
+		},
+	}
+	alertSender := &testAlertSender{}
+
+	spacepolicy.GetAvailableSize = func(path string) (int64, error) {
+		return 0, nil
+	}
+
+	policy := spacepolicy.New(config.SpacePolicy{}, alertSender, nil, layers, services)
+
+	if err := policy.ReclaimSpace(workingMountPoint, 1000); !errors.Is(err, spacepolicy.ErrNoSpace) {
+		t.Errorf("Wrong error: %v", err)
+	}
+
+	if len(layers.evicted) != 0 || len(services.evicted) != 0 {
+		t.Error("Items should not be evicted when there is no enough space")
+	}
+
+	if err := policy.ReclaimSpace(workingMountPoint, 150); err != nil {
+		t.Fatalf("Can't reclaim space: %v", err)
+	}
+
+	if ids := getItemIDs(layers.evicted); !reflect.DeepEqual(ids, []string{"layer1"}) {
+		t.Errorf("Wrong evicted layers: %v", ids)
+	}
+
+	if ids := getItemIDs(services.evicted); !reflect.DeepEqual(ids, []string{"service1"}) {
+		t.Errorf("Wrong evicted services: %v", ids)
+	}
+
+	usageAlerts := []interface{}{
+		cloudprotocol.SystemQuotaAlert{Parameter: "layerUsage", Value: 300},
+		cloudprotocol.SystemQuotaAlert{Parameter: "serviceUsage", Value: 500},
+	}
+
+	// Usage is reported on each reclaim, evicted items are reported before eviction
+	expectedAlerts := append(append(usageAlerts, usageAlerts...),
+		cloudprotocol.CoreAlert{
+			CoreComponent: "aos-servicemanager",
+			Message:       "layer layer1 version 0 will be evicted: size 100, priority 0",
+		},
+		cloudprotocol.CoreAlert{
+			CoreComponent: "aos-servicemanager",
+			Message:       "service service1 version 0 will be evicted: size 100, priority 0",
+		})
+
+	if len(alertSender.alerts) != len(expectedAlerts) {
+		t.Fatalf("Wrong alerts count: %d", len(alertSender.alerts))
+	}
+
+	for i, alert := range alertSender.alerts {
+		if alert.Payload != expectedAlerts[i] {
+			t.Errorf("Wrong alert payload: %v", alert.Payload)
+		}
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func (provider *testProvider) GetSpaceUsage() (spacepolicy.Usage, error) {
+	return provider.usage, nil
+}
+
+func (provider *testProvider) GetEvictableItems() ([]spacepolicy.Item, error) {
+	return provider.items, nil
+}
+
+func (provider *testProvider) EvictItem(item spacepolicy.Item) error {
+	provider.evicted = append(provider.evicted, item)
+
+	return nil
+}
+
+func (provider testPriorityProvider) GetItemPriority(item spacepolicy.Item) uint64 {
+	return provider[item.ID]
+}
+
+func (sender *testAlertSender) SendAlert(alert cloudprotocol.AlertItem) {
+	sender.alerts = append(sender.alerts, alert)
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func getItemIDs(items []spacepolicy.Item) (ids []string) {
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+
+	return ids
+}
+
+func sortIDs(ids []string) []string {
+	sort.Strings(ids)
+
+	return ids
+}
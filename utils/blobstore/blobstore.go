@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobstore provides content-addressed storage of service and layer blobs
+package blobstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+* Types
+***********************************************************************************************************************/
+
+// BlobInfo blob information.
+type BlobInfo struct {
+	Path     string
+	Digest   string
+	Size     uint64
+	RefCount uint64
+}
+
+// Storage provides API to store blobs reference counters.
+type Storage interface {
+	AddBlob(blob BlobInfo) error
+	GetBlob(path string) (BlobInfo, error)
+	GetBlobs() ([]BlobInfo, error)
+	SetBlobRefCount(path string, refCount uint64) error
+	RemoveBlob(path string) error
+}
+
+// BlobStore stores files and directories by digest as storeDir/<algorithm>/<hex>. Each blob is stored once and
+// removed when the last reference to it is released.
+type BlobStore struct {
+	sync.Mutex
+
+	storeDir string
+	storage  Storage
+}
+
+/***********************************************************************************************************************
+* Vars
+***********************************************************************************************************************/
+
+// ErrNotExist blob does not exist in the store.
+var ErrNotExist = errors.New("blob does not exist")
+
+/***********************************************************************************************************************
+* Public
+***********************************************************************************************************************/
+
+// New creates blob store.
+func New(storeDir string, storage Storage) (store *BlobStore, err error) {
+	store = &BlobStore{storeDir: filepath.Clean(storeDir), storage: storage}
+
+	if err = os.MkdirAll(store.storeDir, 0o755); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if err = store.removeMissingBlobs(); err != nil {
+		log.Errorf("Can't remove missing blobs: %v", err)
+	}
+
+	return store, nil
+}
+
+// GetBlobPath returns store path of the blob.
+func (store *BlobStore) GetBlobPath(blobDigest digest.Digest) string {
+	return filepath.Join(store.storeDir, string(blobDigest.Algorithm()), blobDigest.Hex())
+}
+
+// AddBlob moves source file or directory to the store. If the blob is already stored, the source is removed and
+// shared is set to true. In both cases blob reference counter is incremented.
+func (store *BlobStore) AddBlob(
+	blobDigest digest.Digest, sourcePath string, size uint64,
+) (blobPath string, shared bool, err error) {
+	if err = blobDigest.Validate(); err != nil {
+		return "", false, aoserrors.Wrap(err)
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	blobPath = store.GetBlobPath(blobDigest)
+
+	blob, err := store.storage.GetBlob(blobPath)
+	if err == nil {
+		if sourcePath != blobPath {
+			if err = os.RemoveAll(sourcePath); err != nil {
+				return "", false, aoserrors.Wrap(err)
+			}
+		}
+
+		if err = store.storage.SetBlobRefCount(blobPath, blob.RefCount+1); err != nil {
+			return "", false, aoserrors.Wrap(err)
+		}
+
+		log.WithFields(log.Fields{"digest": blobDigest, "refCount": blob.RefCount + 1}).Debug("Blob shared")
+
+		return blobPath, true, nil
+	}
+
+	if !errors.Is(err, ErrNotExist) {
+		return "", false, aoserrors.Wrap(err)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(blobPath), 0o755); err != nil {
+		return "", false, aoserrors.Wrap(err)
+	}
+
+	// Blob is registered before moving, so the entry without file is cleaned up on next start if move fails
+	if err = store.storage.AddBlob(BlobInfo{
+		Path: blobPath, Digest: blobDigest.String(), Size: size, RefCount: 1,
+	}); err != nil {
+		return "", false, aoserrors.Wrap(err)
+	}
+
+	if sourcePath != blobPath {
+		// Remove blob left without storage entry
+		if err = os.RemoveAll(blobPath); err != nil {
+			log.Errorf("Can't remove blob: %v", err)
+		}
+
+		if err = os.Rename(sourcePath, blobPath); err != nil {
+			if removeErr := store.storage.RemoveBlob(blobPath); removeErr != nil {
+				log.Errorf("Can't remove blob: %v", removeErr)
+			}
+
+			return "", false, aoserrors.Wrap(err)
+		}
+	}
+
+	log.WithFields(log.Fields{"digest": blobDigest, "size": size}).Debug("Blob stored")
+
+	return blobPath, false, nil
+}
+
+// ReleaseBlob decrements blob reference counter and removes the blob if it is not used anymore.
+func (store *BlobStore) ReleaseBlob(blobDigest digest.Digest) (freedSize uint64, err error) {
+	store.Lock()
+	defer store.Unlock()
+
+	blobPath := store.GetBlobPath(blobDigest)
+
+	blob, err := store.storage.GetBlob(blobPath)
+	if err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if blob.RefCount > 1 {
+		if err = store.storage.SetBlobRefCount(blobPath, blob.RefCount-1); err != nil {
+			return 0, aoserrors.Wrap(err)
+		}
+
+		return 0, nil
+	}
+
+	if err = os.RemoveAll(blobPath); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	if err = store.storage.RemoveBlob(blobPath); err != nil {
+		return 0, aoserrors.Wrap(err)
+	}
+
+	log.WithFields(log.Fields{"digest": blobDigest, "size": blob.Size}).Debug("Blob removed")
+
+	return blob.Size, nil
+}
+
+/***********************************************************************************************************************
+* Private
+***********************************************************************************************************************/
+
+func (store *BlobStore) removeMissingBlobs() error {
+	blobs, err := store.storage.GetBlobs()
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, blob := range blobs {
+		if !strings.HasPrefix(blob.Path, store.storeDir+string(os.PathSeparator)) {
+			continue
+		}
+
+		if _, err := os.Stat(blob.Path); err == nil || !os.IsNotExist(err) {
+			continue
+		}
+
+		log.WithField("path", blob.Path).Warn("Blob missing")
+
+		if err := store.storage.RemoveBlob(blob.Path); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobstore_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/utils/blobstore"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testStorage struct {
+	blobs map[string]blobstore.BlobInfo
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var tmpDir string
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = ioutil.TempDir("", "aos_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	ret := m.Run()
+
+	if err := os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Can't remove tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestSharedBlob(t *testing.T) {
+	storage := &testStorage{blobs: make(map[string]blobstore.BlobInfo)}
+
+	store, err := blobstore.New(filepath.Join(tmpDir, "shared"), storage)
+	if err != nil {
+		t.Fatalf("Can't create blob store: %v", err)
+	}
+
+	content := []byte("blob content")
+	blobDigest := digest.FromBytes(content)
+
+	var blobPaths []string
+
+	for i := 0; i < 2; i++ {
+		sourcePath := filepath.Join(tmpDir, "source")
+
+		if err = ioutil.WriteFile(sourcePath, content, 0o600); err != nil {
+			t.Fatalf("Can't write source file: %v", err)
+		}
+
+		blobPath, shared, err := store.AddBlob(blobDigest, sourcePath, uint64(len(content)))
+		if err != nil {
+			t.Fatalf("Can't add blob: %v", err)
+		}
+
+		if shared != (i != 0) {
+			t.Errorf("Wrong shared value: %v", shared)
+		}
+
+		if _, err = os.Stat(sourcePath); !os.IsNotExist(err) {
+			t.Error("Source file should be removed")
+		}
+
+		blobPaths = append(blobPaths, blobPath)
+	}
+
+	if blobPaths[0] != blobPaths[1] || blobPaths[0] != store.GetBlobPath(blobDigest) {
+		t.Errorf("Wrong blob paths: %v", blobPaths)
+	}
+
+	if storage.blobs[blobPaths[0]].RefCount != 2 {
+		t.Errorf("Wrong ref count: %d", storage.blobs[blobPaths[0]].RefCount)
+	}
+
+	freedSize, err := store.ReleaseBlob(blobDigest)
+	if err != nil {
+		t.Fatalf("Can't release blob: %v", err)
+	}
+
+	if freedSize != 0 {
+		t.Errorf("Blob should not be freed: %d", freedSize)
+	}
+
+	if _, err = os.Stat(blobPaths[0]); err != nil {
+		t.Errorf("Blob should exist: %v", err)
+	}
+
+	if freedSize, err = store.ReleaseBlob(blobDigest); err != nil {
+		t.Fatalf("Can't release blob: %v", err)
+	}
+
+	if freedSize != uint64(len(content)) {
+		t.Errorf("Wrong freed size: %d", freedSize)
+	}
+
+	if _, err = os.Stat(blobPaths[0]); !os.IsNotExist(err) {
+		t.Error("Blob should be removed")
+	}
+
+	if _, err = store.ReleaseBlob(blobDigest); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("Wrong release error: %v", err)
+	}
+}
+
+func TestRemoveMissingBlobs(t *testing.T) {
+	storeDir := filepath.Join(tmpDir, "missing")
+
+	storage := &testStorage{blobs: map[string]blobstore.BlobInfo{
+		filepath.Join(storeDir, "sha256", "missing"): {Path: filepath.Join(storeDir, "sha256", "missing")},
+		filepath.Join(tmpDir, "other", "sha256", "missing"): {
+			Path: filepath.Join(tmpDir, "other", "sha256", "missing"),
+		},
+	}}
+
+	if _, err := blobstore.New(storeDir, storage); err != nil {
+		t.Fatalf("Can't create blob store: %v", err)
+	}
+
+	if _, ok := storage.blobs[filepath.Join(storeDir, "sha256", "missing")]; ok {
+		t.Error("Missing blob should be removed")
+	}
+
+	if _, ok := storage.blobs[filepath.Join(tmpDir, "other", "sha256", "missing")]; !ok {
+		t.Error("Blob of other store should not be removed")
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func (storage *testStorage) AddBlob(blob blobstore.BlobInfo) error {
+	storage.blobs[blob.Path] = blob
+
+	return nil
+}
+
+func (storage *testStorage) GetBlob(path string) (blobstore.BlobInfo, error) {
+	blob, ok := storage.blobs[path]
+	if !ok {
+		return blob, blobstore.ErrNotExist
+	}
+
+	return blob, nil
+}
+
+func (storage *testStorage) GetBlobs() (blobs []blobstore.BlobInfo, err error) {
+	for _, blob := range storage.blobs {
+		blobs = append(blobs, blob)
+	}
+
+	return blobs, nil
+}
+
+func (storage *testStorage) SetBlobRefCount(path string, refCount uint64) error {
+	blob, ok := storage.blobs[path]
+	if !ok {
+		return blobstore.ErrNotExist
+	}
+
+	blob.RefCount = refCount
+	storage.blobs[path] = blob
+
+	return nil
+}
+
+func (storage *testStorage) RemoveBlob(path string) error {
+	delete(storage.blobs, path)
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cpuset parses CPU lists in cpuset format, e.g. "0-2,4".
+package cpuset
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// Parse returns set of CPUs from cpuset list.
+func Parse(cpus string) (map[int]struct{}, error) {
+	cpuSet := make(map[int]struct{})
+
+	for _, item := range strings.Split(cpus, ",") {
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(item, "-")
+
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, aoserrors.Errorf("invalid cpuset %s", cpus)
+		}
+
+		end := start
+
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil {
+				return nil, aoserrors.Errorf("invalid cpuset %s", cpus)
+			}
+		}
+
+		if start < 0 || end < start {
+			return nil, aoserrors.Errorf("invalid cpuset %s", cpus)
+		}
+
+		for cpu := start; cpu <= end; cpu++ {
+			cpuSet[cpu] = struct{}{}
+		}
+	}
+
+	if len(cpuSet) == 0 {
+		return nil, aoserrors.Errorf("empty cpuset %s", cpus)
+	}
+
+	return cpuSet, nil
+}
+
+// IsSubset checks if all CPUs of cpus list are present in allowed list.
+func IsSubset(cpus, allowed string) (bool, error) {
+	cpuSet, err := Parse(cpus)
+	if err != nil {
+		return false, err
+	}
+
+	allowedSet, err := Parse(allowed)
+	if err != nil {
+		return false, err
+	}
+
+	for cpu := range cpuSet {
+		if _, ok := allowedSet[cpu]; !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cpuset_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aoscloud/aos_servicemanager/utils/cpuset"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestParse(t *testing.T) {
+	type testData struct {
+		cpus     string
+		expected map[int]struct{}
+		isValid  bool
+	}
+
+	data := []testData{
+		{cpus: "0", expected: map[int]struct{}{0: {}}, isValid: true},
+		{cpus: "0-2, 5", expected: map[int]struct{}{0: {}, 1: {}, 2: {}, 5: {}}, isValid: true},
+		{cpus: ""},
+		{cpus: "2-1"},
+		{cpus: "a-b"},
+		{cpus: "-1"},
+	}
+
+	for _, item := range data {
+		cpuSet, err := cpuset.Parse(item.cpus)
+		if (err == nil) != item.isValid {
+			t.Errorf("Wrong parse result for %s: %v", item.cpus, err)
+
+			continue
+		}
+
+		if item.isValid && !reflect.DeepEqual(cpuSet, item.expected) {
+			t.Errorf("Wrong cpuset for %s: %v", item.cpus, cpuSet)
+		}
+	}
+}
+
+func TestIsSubset(t *testing.T) {
+	type testData struct {
+		cpus     string
+		allowed  string
+		isSubset bool
+	}
+
+	data := []testData{
+		{cpus: "2-3", allowed: "2-3", isSubset: true},
+		{cpus: "3", allowed: "0,2-3", isSubset: true},
+		{cpus: "1-2", allowed: "2-3", isSubset: false},
+	}
+
+	for _, item := range data {
+		isSubset, err := cpuset.IsSubset(item.cpus, item.allowed)
+		if err != nil {
+			t.Fatalf("Can't check cpuset: %v", err)
+		}
+
+		if isSubset != item.isSubset {
+			t.Errorf("Wrong subset result for %s in %s: %v", item.cpus, item.allowed, isSubset)
+		}
+	}
+}
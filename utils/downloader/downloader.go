@@ -0,0 +1,370 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package downloader provides resumable download of service and layer packages
+package downloader
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/aoscloud/aos_common/image"
+	"github.com/aoscloud/aos_common/spaceallocator"
+	"github.com/cavaliergopher/grab/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+* Types
+***********************************************************************************************************************/
+
+// Notifier is called periodically while file is downloading.
+type Notifier func(downloaded, total uint64)
+
+// Downloader downloads files into download dir. Partially downloaded files are named by sha256 of the file and kept
+// between downloads to be resumed with HTTP range requests. Not used partial files are removed by space allocator
+// when space is required.
+type Downloader struct {
+	sync.Mutex
+
+	downloadDir string
+	allocator   spaceallocator.Allocator
+	downloads   map[string]spaceallocator.Space
+}
+
+/***********************************************************************************************************************
+* Vars
+***********************************************************************************************************************/
+
+// ErrAlreadyDownloading file with the same sha256 is being downloaded.
+var ErrAlreadyDownloading = errors.New("file is already downloading")
+
+// NewSpaceAllocator space allocator constructor.
+// nolint:gochecknoglobals // used for unit test mock
+var NewSpaceAllocator = spaceallocator.New
+
+// UpdatePeriod download progress update period.
+// nolint:gochecknoglobals // used for unit test mock
+var UpdatePeriod = 1 * time.Second
+
+/***********************************************************************************************************************
+* Public
+***********************************************************************************************************************/
+
+// New creates downloader.
+func New(downloadDir string) (downloader *Downloader, err error) {
+	downloader = &Downloader{
+		downloadDir: downloadDir,
+		downloads:   make(map[string]spaceallocator.Space),
+	}
+
+	if err = os.MkdirAll(downloader.downloadDir, 0o755); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if downloader.allocator, err = NewSpaceAllocator(downloader.downloadDir, 0, removePartialFile); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if err = downloader.setPartialFiles(); err != nil {
+		log.Errorf("Can't set partial downloads: %v", err)
+	}
+
+	return downloader, nil
+}
+
+// Close closes downloader.
+func (downloader *Downloader) Close() error {
+	if err := downloader.allocator.Close(); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// Download downloads file, resumes previous partial download if it exists and checks the result file.
+// Downloaded file should be released by Release when it is not needed anymore. If ctx is canceled, the partial file
+// is kept to be resumed later.
+func (downloader *Downloader) Download(
+	ctx context.Context, url string, fileInfo image.FileInfo, notifier Notifier,
+) (fileName string, err error) {
+	if len(fileInfo.Sha256) == 0 {
+		return "", aoserrors.New("file sha256 is not set")
+	}
+
+	fileName = filepath.Join(downloader.downloadDir, hex.EncodeToString(fileInfo.Sha256))
+
+	if err = downloader.startDownload(fileName); err != nil {
+		return "", err
+	}
+
+	partialSize, err := getFileSize(fileName)
+	if err != nil {
+		downloader.finishDownload(fileName, nil)
+
+		return "", err
+	}
+
+	if partialSize > fileInfo.Size {
+		if err = os.RemoveAll(fileName); err != nil {
+			downloader.finishDownload(fileName, nil)
+
+			return "", aoserrors.Wrap(err)
+		}
+
+		partialSize = 0
+	}
+
+	space, err := downloader.allocator.AllocateSpace(fileInfo.Size - partialSize)
+	if err != nil {
+		downloader.finishDownload(fileName, nil)
+
+		return "", aoserrors.Wrap(err)
+	}
+
+	if partialSize > 0 {
+		log.WithFields(log.Fields{"file": fileName, "size": partialSize}).Debug("Resume download")
+	}
+
+	if err = download(ctx, fileName, url, fileInfo.Size, notifier); err != nil {
+		downloader.keepPartialFile(fileName, space, fileInfo.Size, partialSize, errors.Is(err, grab.ErrBadLength))
+		downloader.finishDownload(fileName, nil)
+
+		return "", err
+	}
+
+	if err = image.CheckFileInfo(ctx, fileName, fileInfo); err != nil {
+		downloader.keepPartialFile(fileName, space, fileInfo.Size, partialSize, ctx.Err() == nil)
+		downloader.finishDownload(fileName, nil)
+
+		return "", aoserrors.Wrap(err)
+	}
+
+	downloader.finishDownload(fileName, space)
+
+	return fileName, nil
+}
+
+// Release removes downloaded file and releases its space.
+func (downloader *Downloader) Release(fileName string) {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	if err := os.RemoveAll(fileName); err != nil {
+		log.Errorf("Can't remove downloaded file: %v", err)
+	}
+
+	space, ok := downloader.downloads[fileName]
+	if !ok {
+		return
+	}
+
+	delete(downloader.downloads, fileName)
+
+	if space == nil {
+		return
+	}
+
+	if err := space.Release(); err != nil {
+		log.Errorf("Can't release memory: %v", err)
+	}
+}
+
+/***********************************************************************************************************************
+* Private
+***********************************************************************************************************************/
+
+func (downloader *Downloader) setPartialFiles() error {
+	files, err := ioutil.ReadDir(downloader.downloadDir)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	for _, file := range files {
+		fileName := filepath.Join(downloader.downloadDir, file.Name())
+
+		if _, err := hex.DecodeString(file.Name()); err != nil || file.IsDir() {
+			log.WithField("file", fileName).Warn("Remove unknown download file")
+
+			if err := os.RemoveAll(fileName); err != nil {
+				log.Errorf("Can't remove download file: %v", err)
+			}
+
+			continue
+		}
+
+		if err := downloader.allocator.AddOutdatedItem(
+			fileName, uint64(file.Size()), file.ModTime()); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (downloader *Downloader) startDownload(fileName string) error {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	if _, ok := downloader.downloads[fileName]; ok {
+		return aoserrors.Wrap(ErrAlreadyDownloading)
+	}
+
+	downloader.downloads[fileName] = nil
+
+	// Partial file should not be removed while it is downloading
+	downloader.allocator.RestoreOutdatedItem(fileName)
+
+	return nil
+}
+
+func (downloader *Downloader) finishDownload(fileName string, space spaceallocator.Space) {
+	downloader.Lock()
+	defer downloader.Unlock()
+
+	if space == nil {
+		delete(downloader.downloads, fileName)
+
+		return
+	}
+
+	downloader.downloads[fileName] = space
+}
+
+func (downloader *Downloader) keepPartialFile(
+	fileName string, space spaceallocator.Space, fileSize, resumedSize uint64, corrupted bool,
+) {
+	partialSize, err := getFileSize(fileName)
+	if err != nil {
+		log.Errorf("Can't get partial file size: %v", err)
+	}
+
+	if corrupted || partialSize == 0 {
+		if err := os.RemoveAll(fileName); err != nil {
+			log.Errorf("Can't remove download file: %v", err)
+		}
+
+		if err := space.Release(); err != nil {
+			log.Errorf("Can't release memory: %v", err)
+		}
+
+		return
+	}
+
+	// Space is allocated for the rest of the file, only the part downloaded by this attempt is occupied
+	allocatedSize, downloadedSize := fileSize-resumedSize, uint64(0)
+
+	if partialSize > resumedSize {
+		downloadedSize = partialSize - resumedSize
+	}
+
+	if downloadedSize < allocatedSize {
+		downloader.allocator.FreeSpace(allocatedSize - downloadedSize)
+	}
+
+	if err := space.Accept(); err != nil {
+		log.Errorf("Can't accept memory: %v", err)
+	}
+
+	if err := downloader.allocator.AddOutdatedItem(fileName, partialSize, time.Now()); err != nil {
+		log.Errorf("Can't add partial file to outdated items: %v", err)
+	}
+}
+
+func download(ctx context.Context, fileName, url string, size uint64, notifier Notifier) error {
+	log.WithField("url", url).Debug("Start downloading file")
+
+	req, err := grab.NewRequest(fileName, url)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	req.Size = int64(size)
+	// Local modification time is used to remove the oldest partial files first
+	req.IgnoreRemoteTime = true
+
+	resp := grab.NewClient().Do(req.WithContext(ctx))
+
+	ticker := time.NewTicker(UpdatePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			notifyProgress(notifier, resp)
+
+		case <-resp.Done:
+			if err := resp.Err(); err != nil {
+				return aoserrors.Wrap(err)
+			}
+
+			notifyProgress(notifier, resp)
+
+			log.WithFields(log.Fields{
+				"url": url, "file": resp.Filename, "resumed": resp.DidResume,
+			}).Debug("Download complete")
+
+			return nil
+		}
+	}
+}
+
+func notifyProgress(notifier Notifier, resp *grab.Response) {
+	log.WithFields(log.Fields{"complete": resp.BytesComplete(), "total": resp.Size()}).Debug("Download progress")
+
+	if notifier == nil {
+		return
+	}
+
+	var total uint64
+
+	if resp.Size() > 0 {
+		total = uint64(resp.Size())
+	}
+
+	notifier(uint64(resp.BytesComplete()), total)
+}
+
+func removePartialFile(fileName string) error {
+	log.WithField("file", fileName).Debug("Remove partial download")
+
+	if err := os.RemoveAll(fileName); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func getFileSize(fileName string) (size uint64, err error) {
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, aoserrors.Wrap(err)
+	}
+
+	return uint64(fileInfo.Size()), nil
+}
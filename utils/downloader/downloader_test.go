@@ -0,0 +1,426 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloader_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aoscloud/aos_common/image"
+	"github.com/aoscloud/aos_common/spaceallocator"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/utils/downloader"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const fileSize = 512 * 1024
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testAllocator struct {
+	sync.Mutex
+
+	remover       spaceallocator.ItemRemover
+	outdatedItems map[string]uint64
+	freedSize     uint64
+}
+
+type testSpace struct{}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+var (
+	tmpDir    string
+	allocator = &testAllocator{}
+)
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Main
+ **********************************************************************************************************************/
+
+func TestMain(m *testing.M) {
+	var err error
+
+	if tmpDir, err = ioutil.TempDir("", "aos_"); err != nil {
+		log.Fatalf("Error create temporary dir: %v", err)
+	}
+
+	downloader.NewSpaceAllocator = newSpaceAllocator
+	downloader.UpdatePeriod = 10 * time.Millisecond
+
+	ret := m.Run()
+
+	if err := os.RemoveAll(tmpDir); err != nil {
+		log.Errorf("Can't remove tmp dir: %v", err)
+	}
+
+	os.Exit(ret)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestResumeDownload(t *testing.T) {
+	downloadDir := filepath.Join(tmpDir, "resume")
+
+	serverDir, err := ioutil.TempDir(tmpDir, "server")
+	if err != nil {
+		t.Fatalf("Can't create server dir: %v", err)
+	}
+
+	content, fileInfo := createFile(t, filepath.Join(serverDir, "package"))
+
+	var (
+		interrupt   = true
+		rangeHeader string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			rangeHeader = r.Header.Get("Range")
+		}
+
+		if interrupt && r.Method == http.MethodGet {
+			// Send half of file and break connection
+			w.Header().Set("Content-Length", strconv.Itoa(fileSize))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(content[:fileSize/2])
+
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+
+			panic(http.ErrAbortHandler)
+		}
+
+		http.FileServer(http.Dir(serverDir)).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	fileDownloader, err := downloader.New(downloadDir)
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+
+	if _, err = fileDownloader.Download(context.Background(), server.URL+"/package", fileInfo, nil); err == nil {
+		t.Fatal("Download should fail")
+	}
+
+	partialFile := filepath.Join(downloadDir, hex.EncodeToString(fileInfo.Sha256))
+
+	if size := allocator.getOutdatedItem(partialFile); size != fileSize/2 {
+		t.Errorf("Wrong partial file size: %d", size)
+	}
+
+	if err = fileDownloader.Close(); err != nil {
+		t.Errorf("Can't close downloader: %v", err)
+	}
+
+	// Partial file is resumed after restart
+
+	interrupt = false
+
+	if fileDownloader, err = downloader.New(downloadDir); err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+	defer fileDownloader.Close()
+
+	var downloaded, total uint64
+
+	fileName, err := fileDownloader.Download(context.Background(), server.URL+"/package", fileInfo, func(d, t uint64) {
+		downloaded, total = d, t
+	})
+	if err != nil {
+		t.Fatalf("Can't download file: %v", err)
+	}
+
+	if fileName != partialFile {
+		t.Errorf("Wrong file name: %s", fileName)
+	}
+
+	if rangeHeader != "bytes="+strconv.Itoa(fileSize/2)+"-" {
+		t.Errorf("Wrong range header: %s", rangeHeader)
+	}
+
+	if downloaded != fileSize || total != fileSize {
+		t.Errorf("Wrong download progress: %d/%d", downloaded, total)
+	}
+
+	if size := allocator.getOutdatedItem(partialFile); size != 0 {
+		t.Error("Downloading file should not be outdated")
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Can't read file: %v", err)
+	}
+
+	if !bytes.Equal(data, content) {
+		t.Error("Wrong downloaded file content")
+	}
+
+	fileDownloader.Release(fileName)
+
+	if _, err = os.Stat(fileName); !os.IsNotExist(err) {
+		t.Error("Downloaded file should be removed")
+	}
+}
+
+func TestCorruptedPartialFile(t *testing.T) {
+	downloadDir := filepath.Join(tmpDir, "corrupted")
+
+	serverDir, err := ioutil.TempDir(tmpDir, "server")
+	if err != nil {
+		t.Fatalf("Can't create server dir: %v", err)
+	}
+
+	_, fileInfo := createFile(t, filepath.Join(serverDir, "package"))
+
+	server := httptest.NewServer(http.FileServer(http.Dir(serverDir)))
+	defer server.Close()
+
+	if err = os.MkdirAll(downloadDir, 0o755); err != nil {
+		t.Fatalf("Can't create download dir: %v", err)
+	}
+
+	partialFile := filepath.Join(downloadDir, hex.EncodeToString(fileInfo.Sha256))
+
+	if err = ioutil.WriteFile(partialFile, make([]byte, fileSize/2), 0o600); err != nil {
+		t.Fatalf("Can't create partial file: %v", err)
+	}
+
+	unknownFile := filepath.Join(downloadDir, "unknown")
+
+	if err = os.MkdirAll(unknownFile, 0o755); err != nil {
+		t.Fatalf("Can't create unknown file: %v", err)
+	}
+
+	fileDownloader, err := downloader.New(downloadDir)
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+	defer fileDownloader.Close()
+
+	if _, err = os.Stat(unknownFile); !os.IsNotExist(err) {
+		t.Error("Unknown file should be removed")
+	}
+
+	if size := allocator.getOutdatedItem(partialFile); size != fileSize/2 {
+		t.Errorf("Wrong partial file size: %d", size)
+	}
+
+	if _, err = fileDownloader.Download(context.Background(), server.URL+"/package", fileInfo, nil); err == nil {
+		t.Error("Download should fail")
+	}
+
+	if _, err = os.Stat(partialFile); !os.IsNotExist(err) {
+		t.Error("Corrupted file should be removed")
+	}
+
+	// Next download starts from scratch
+
+	fileName, err := fileDownloader.Download(context.Background(), server.URL+"/package", fileInfo, nil)
+	if err != nil {
+		t.Fatalf("Can't download file: %v", err)
+	}
+
+	fileDownloader.Release(fileName)
+}
+
+func TestCancelDownload(t *testing.T) {
+	downloadDir := filepath.Join(tmpDir, "cancel")
+
+	serverDir, err := ioutil.TempDir(tmpDir, "server")
+	if err != nil {
+		t.Fatalf("Can't create server dir: %v", err)
+	}
+
+	content, fileInfo := createFile(t, filepath.Join(serverDir, "package"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Send half of file and wait for client disconnect
+		w.Header().Set("Content-Length", strconv.Itoa(fileSize))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content[:fileSize/2])
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	fileDownloader, err := downloader.New(downloadDir)
+	if err != nil {
+		t.Fatalf("Can't create downloader: %v", err)
+	}
+	defer fileDownloader.Close()
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	if _, err = fileDownloader.Download(ctx, server.URL+"/package", fileInfo, func(downloaded, total uint64) {
+		if downloaded == fileSize/2 {
+			cancelFunc()
+		}
+	}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wrong download error: %v", err)
+	}
+
+	partialFile := filepath.Join(downloadDir, hex.EncodeToString(fileInfo.Sha256))
+
+	if size := allocator.getOutdatedItem(partialFile); size != fileSize/2 {
+		t.Errorf("Wrong partial file size: %d", size)
+	}
+
+	// Not downloaded part of allocated space should be freed
+	if size := allocator.getFreedSize(); size != fileSize/2 {
+		t.Errorf("Wrong freed size: %d", size)
+	}
+}
+
+/***********************************************************************************************************************
+ * Interfaces
+ **********************************************************************************************************************/
+
+func newSpaceAllocator(
+	path string, partLimit uint, remover spaceallocator.ItemRemover,
+) (spaceallocator.Allocator, error) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	allocator.remover = remover
+	allocator.outdatedItems = make(map[string]uint64)
+	allocator.freedSize = 0
+
+	return allocator, nil
+}
+
+func (allocator *testAllocator) AllocateSpace(size uint64) (spaceallocator.Space, error) {
+	return &testSpace{}, nil
+}
+
+func (allocator *testAllocator) FreeSpace(size uint64) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	allocator.freedSize += size
+}
+
+func (allocator *testAllocator) AddOutdatedItem(id string, size uint64, timestamp time.Time) error {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	allocator.outdatedItems[id] = size
+
+	return nil
+}
+
+func (allocator *testAllocator) RestoreOutdatedItem(id string) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	delete(allocator.outdatedItems, id)
+}
+
+func (allocator *testAllocator) Close() error {
+	return nil
+}
+
+func (space *testSpace) Accept() error {
+	return nil
+}
+
+func (space *testSpace) Release() error {
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (allocator *testAllocator) getOutdatedItem(id string) (size uint64) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	return allocator.outdatedItems[id]
+}
+
+func (allocator *testAllocator) getFreedSize() (size uint64) {
+	allocator.Lock()
+	defer allocator.Unlock()
+
+	return allocator.freedSize
+}
+
+func createFile(t *testing.T, fileName string) (content []byte, fileInfo image.FileInfo) {
+	t.Helper()
+
+	content = make([]byte, fileSize)
+
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("Can't generate file content: %v", err)
+	}
+
+	if err := ioutil.WriteFile(fileName, content, 0o600); err != nil {
+		t.Fatalf("Can't write file: %v", err)
+	}
+
+	fileInfo, err := image.CreateFileInfo(context.Background(), fileName)
+	if err != nil {
+		t.Fatalf("Can't create file info: %v", err)
+	}
+
+	return content, fileInfo
+}
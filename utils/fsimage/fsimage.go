@@ -0,0 +1,301 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fsimage packs directories into read-only filesystem images protected by dm-verity or fs-verity.
+package fsimage
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Image formats.
+const (
+	FormatSquashfs = "squashfs"
+	FormatErofs    = "erofs"
+)
+
+// Verity types.
+const (
+	VerityDM = "dm-verity"
+	VerityFS = "fs-verity"
+)
+
+const (
+	imageFileName    = "image"
+	hashTreeFileName = "hashtree"
+	devicePrefix     = "aos-"
+	deviceMapperDir  = "/dev/mapper"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Info packed image info. Empty format means content is not packed.
+type Info struct {
+	Format   string
+	Verity   string
+	RootHash string
+}
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrRootHashMismatch image root hash doesn't match expected one.
+var ErrRootHashMismatch = errors.New("root hash mismatch")
+
+// nolint:gochecknoglobals // used for unit test mock
+var runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// CheckFormat checks if image format and verity type are supported.
+func CheckFormat(format, verity string) error {
+	if format != FormatSquashfs && format != FormatErofs {
+		return aoserrors.Errorf("unsupported image format: %s", format)
+	}
+
+	if verity != VerityDM && verity != VerityFS {
+		return aoserrors.Errorf("unsupported verity type: %s", verity)
+	}
+
+	return nil
+}
+
+// Pack packs srcDir content into image stored in dstDir and enables verity protection.
+func Pack(ctx context.Context, srcDir, dstDir, format, verity string) (info Info, err error) {
+	log.WithFields(log.Fields{
+		"src": srcDir, "dst": dstDir, "format": format, "verity": verity,
+	}).Debug("Pack filesystem image")
+
+	if err = CheckFormat(format, verity); err != nil {
+		return info, err
+	}
+
+	if err = os.MkdirAll(dstDir, 0o755); err != nil {
+		return info, aoserrors.Wrap(err)
+	}
+
+	imagePath := filepath.Join(dstDir, imageFileName)
+
+	switch format {
+	case FormatSquashfs:
+		err = run(ctx, "mksquashfs", srcDir, imagePath, "-noappend", "-no-progress")
+
+	case FormatErofs:
+		err = run(ctx, "mkfs.erofs", imagePath, srcDir)
+	}
+
+	if err != nil {
+		return info, err
+	}
+
+	info = Info{Format: format, Verity: verity}
+
+	switch verity {
+	case VerityDM:
+		info.RootHash, err = formatDMVerity(ctx, imagePath, filepath.Join(dstDir, hashTreeFileName))
+
+	case VerityFS:
+		if err = run(ctx, "fsverity", "enable", imagePath); err != nil {
+			return info, err
+		}
+
+		info.RootHash, err = measureFSVerity(ctx, imagePath)
+	}
+
+	if err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+// IsPacked checks if dir contains packed image.
+func IsPacked(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, imageFileName))
+
+	return err == nil
+}
+
+// Verify reads whole image and checks it against root hash.
+func Verify(ctx context.Context, dir string, info Info) error {
+	imagePath := filepath.Join(dir, imageFileName)
+
+	switch info.Verity {
+	case VerityDM:
+		return run(ctx, "veritysetup", "verify", imagePath, filepath.Join(dir, hashTreeFileName), info.RootHash)
+
+	case VerityFS:
+		return checkFSVerity(ctx, imagePath, info.RootHash)
+
+	default:
+		return aoserrors.Errorf("unsupported verity type: %s", info.Verity)
+	}
+}
+
+// Mount mounts image read-only. Image blocks are verified by the kernel on read.
+func Mount(dir, mountPoint string, info Info) error {
+	log.WithFields(log.Fields{"dir": dir, "mountPoint": mountPoint}).Debug("Mount filesystem image")
+
+	ctx := context.Background()
+	imagePath := filepath.Join(dir, imageFileName)
+
+	if err := os.MkdirAll(mountPoint, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	switch info.Verity {
+	case VerityDM:
+		deviceName := getDeviceName(mountPoint)
+
+		if err := run(ctx, "veritysetup", "open", imagePath, deviceName, filepath.Join(dir, hashTreeFileName),
+			info.RootHash); err != nil {
+			return err
+		}
+
+		if err := run(ctx, "mount", "-t", info.Format, "-o", "ro",
+			filepath.Join(deviceMapperDir, deviceName), mountPoint); err != nil {
+			if closeErr := run(ctx, "veritysetup", "close", deviceName); closeErr != nil {
+				log.Errorf("Can't close verity device: %v", closeErr)
+			}
+
+			return err
+		}
+
+		return nil
+
+	case VerityFS:
+		if err := checkFSVerity(ctx, imagePath, info.RootHash); err != nil {
+			return err
+		}
+
+		return run(ctx, "mount", "-t", info.Format, "-o", "loop,ro", imagePath, mountPoint)
+
+	default:
+		return aoserrors.Errorf("unsupported verity type: %s", info.Verity)
+	}
+}
+
+// Umount unmounts image mounted by Mount and releases verity device.
+func Umount(mountPoint string) error {
+	log.WithField("mountPoint", mountPoint).Debug("Umount filesystem image")
+
+	ctx := context.Background()
+
+	if err := run(ctx, "umount", mountPoint); err != nil {
+		return err
+	}
+
+	deviceName := getDeviceName(mountPoint)
+
+	if _, err := os.Stat(filepath.Join(deviceMapperDir, deviceName)); err == nil {
+		if err = run(ctx, "veritysetup", "close", deviceName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func run(ctx context.Context, name string, args ...string) error {
+	if output, err := runCommand(ctx, name, args...); err != nil {
+		if ctx.Err() != nil {
+			return aoserrors.Wrap(ctx.Err())
+		}
+
+		return aoserrors.Errorf("%s failed: %v (%s)", name, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func formatDMVerity(ctx context.Context, imagePath, hashTreePath string) (rootHash string, err error) {
+	output, err := runCommand(ctx, "veritysetup", "format", imagePath, hashTreePath)
+	if err != nil {
+		return "", aoserrors.Errorf("veritysetup failed: %v (%s)", err, strings.TrimSpace(string(output)))
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+
+	for scanner.Scan() {
+		if name, value, ok := strings.Cut(scanner.Text(), ":"); ok && strings.TrimSpace(name) == "Root hash" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+
+	return "", aoserrors.New("root hash not found in veritysetup output")
+}
+
+func measureFSVerity(ctx context.Context, imagePath string) (rootHash string, err error) {
+	output, err := runCommand(ctx, "fsverity", "measure", imagePath)
+	if err != nil {
+		return "", aoserrors.Errorf("fsverity failed: %v (%s)", err, strings.TrimSpace(string(output)))
+	}
+
+	// Output format: <algorithm>:<digest> <file>
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", aoserrors.New("wrong fsverity output")
+	}
+
+	return fields[0], nil
+}
+
+func checkFSVerity(ctx context.Context, imagePath, rootHash string) error {
+	measuredHash, err := measureFSVerity(ctx, imagePath)
+	if err != nil {
+		return err
+	}
+
+	if measuredHash != rootHash {
+		return aoserrors.Errorf("%w: %s != %s", ErrRootHashMismatch, measuredHash, rootHash)
+	}
+
+	return nil
+}
+
+// Verity device name should be unique per mount point and fit device mapper name limit.
+func getDeviceName(mountPoint string) string {
+	hash := sha256.Sum256([]byte(filepath.Clean(mountPoint)))
+
+	return devicePrefix + hex.EncodeToString(hash[:16])
+}
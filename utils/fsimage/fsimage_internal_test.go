@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	testDMRootHash = "4392c5c3bd6ab2e0f0e6c35c5b4d0fa4e0c6ec8c7a1d3b0a8f6e2a5d4c3b2a19"
+	testFSRootHash = "sha256:0b7c4b0e7f8d9a6c5b4a3928171605f4e3d2c1b0a99887766554433221100ffe"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+type testCommandRunner struct {
+	commands []string
+	rootHash string
+}
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestPack(t *testing.T) {
+	type testData struct {
+		format           string
+		verity           string
+		expectedCommands []string
+		expectedInfo     Info
+		expectedErr      bool
+	}
+
+	data := []testData{
+		{
+			format: FormatSquashfs, verity: VerityDM,
+			expectedCommands: []string{
+				"mksquashfs src dst/image -noappend -no-progress",
+				"veritysetup format dst/image dst/hashtree",
+			},
+			expectedInfo: Info{Format: FormatSquashfs, Verity: VerityDM, RootHash: testDMRootHash},
+		},
+		{
+			format: FormatErofs, verity: VerityFS,
+			expectedCommands: []string{
+				"mkfs.erofs dst/image src",
+				"fsverity enable dst/image",
+				"fsverity measure dst/image",
+			},
+			expectedInfo: Info{Format: FormatErofs, Verity: VerityFS, RootHash: testFSRootHash},
+		},
+		{format: "ext4", verity: VerityDM, expectedErr: true},
+		{format: FormatSquashfs, verity: "", expectedErr: true},
+	}
+
+	for _, item := range data {
+		tmpDir := t.TempDir()
+		runner := newTestCommandRunner(tmpDir, testFSRootHash)
+
+		info, err := Pack(context.Background(), filepath.Join(tmpDir, "src"), filepath.Join(tmpDir, "dst"),
+			item.format, item.verity)
+		if (err != nil) != item.expectedErr {
+			t.Errorf("Unexpected pack error: %v", err)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if !reflect.DeepEqual(runner.commands, item.expectedCommands) {
+			t.Errorf("Wrong commands: %v", runner.commands)
+		}
+
+		if info != item.expectedInfo {
+			t.Errorf("Wrong image info: %v", info)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	tmpDir := t.TempDir()
+	mountPoint := filepath.Join(tmpDir, "mnt")
+	deviceName := getDeviceName(mountPoint)
+
+	runner := newTestCommandRunner(tmpDir, testFSRootHash)
+
+	if err := Mount(filepath.Join(tmpDir, "dst"), mountPoint, Info{
+		Format: FormatSquashfs, Verity: VerityDM, RootHash: testDMRootHash,
+	}); err != nil {
+		t.Fatalf("Can't mount image: %v", err)
+	}
+
+	if err := Mount(filepath.Join(tmpDir, "dst"), mountPoint, Info{
+		Format: FormatErofs, Verity: VerityFS, RootHash: testFSRootHash,
+	}); err != nil {
+		t.Fatalf("Can't mount image: %v", err)
+	}
+
+	expectedCommands := []string{
+		"veritysetup open dst/image " + deviceName + " dst/hashtree " + testDMRootHash,
+		"mount -t squashfs -o ro /dev/mapper/" + deviceName + " mnt",
+		"fsverity measure dst/image",
+		"mount -t erofs -o loop,ro dst/image mnt",
+	}
+
+	if !reflect.DeepEqual(runner.commands, expectedCommands) {
+		t.Errorf("Wrong commands: %v", runner.commands)
+	}
+
+	if err := Mount(filepath.Join(tmpDir, "dst"), mountPoint, Info{
+		Format: FormatErofs, Verity: VerityFS, RootHash: "sha256:1111",
+	}); !errors.Is(err, ErrRootHashMismatch) {
+		t.Errorf("Unexpected mount error: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	runner := newTestCommandRunner(tmpDir, testFSRootHash)
+
+	if err := Verify(context.Background(), filepath.Join(tmpDir, "dst"), Info{
+		Format: FormatSquashfs, Verity: VerityDM, RootHash: testDMRootHash,
+	}); err != nil {
+		t.Errorf("Can't verify image: %v", err)
+	}
+
+	if !reflect.DeepEqual(runner.commands, []string{
+		"veritysetup verify dst/image dst/hashtree " + testDMRootHash,
+	}) {
+		t.Errorf("Wrong commands: %v", runner.commands)
+	}
+
+	// Tampered image has different fs-verity digest
+	runner.rootHash = "sha256:1111"
+
+	if err := Verify(context.Background(), filepath.Join(tmpDir, "dst"), Info{
+		Format: FormatErofs, Verity: VerityFS, RootHash: testFSRootHash,
+	}); !errors.Is(err, ErrRootHashMismatch) {
+		t.Errorf("Unexpected verify error: %v", err)
+	}
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+// Commands are recorded with paths relative to tmpDir.
+func newTestCommandRunner(tmpDir, rootHash string) (runner *testCommandRunner) {
+	runner = &testCommandRunner{rootHash: rootHash}
+
+	runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		command := strings.ReplaceAll(strings.Join(append([]string{name}, args...), " "), tmpDir+"/", "")
+
+		runner.commands = append(runner.commands, command)
+
+		switch {
+		case strings.HasPrefix(command, "veritysetup format"):
+			return []byte("VERITY header information for image\nHash type:       \t1\nRoot hash:      \t" +
+				testDMRootHash + "\n"), nil
+
+		case strings.HasPrefix(command, "fsverity measure"):
+			return []byte(runner.rootHash + " " + args[len(args)-1] + "\n"), nil
+		}
+
+		return nil, nil
+	}
+
+	return runner
+}
@@ -0,0 +1,241 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package idmap creates idmapped mounts for instances running in user namespace.
+package idmap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const (
+	idField     = 2
+	minIDFields = 3
+	idSpaceSize = 1 << 32
+)
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// Host users and groups files.
+//
+//nolint:gochecknoglobals
+var (
+	PasswdFile = "/etc/passwd"
+	GroupFile  = "/etc/group"
+)
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Mapping maps range of container IDs to host IDs.
+type Mapping struct {
+	ContainerID uint32
+	HostID      uint32
+	Size        uint32
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// Mount clones source mount tree to target with IDs shifted by mapping: on-disk ID N is seen inside user namespace
+// with the same mapping as N.
+func Mount(source, target string, uidMapping, gidMapping Mapping) (err error) {
+	log.WithFields(log.Fields{
+		"source": source, "target": target, "hostUID": uidMapping.HostID, "hostGID": gidMapping.HostID,
+	}).Debug("Idmap mount")
+
+	usernsFd, err := openUserNS(uidMapping, gidMapping)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(usernsFd)
+
+	treeFd, err := unix.OpenTree(unix.AT_FDCWD, source, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+	defer unix.Close(treeFd)
+
+	if err = unix.MountSetattr(treeFd, "", unix.AT_EMPTY_PATH, &unix.MountAttr{
+		Attr_set:  unix.MOUNT_ATTR_IDMAP,
+		Userns_fd: uint64(usernsFd),
+	}); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err = unix.MoveMount(treeFd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// CheckRange checks that ID range fits into 32-bit ID space.
+func CheckRange(start, count uint32) error {
+	if uint64(start)+uint64(count) > idSpaceSize {
+		return aoserrors.Errorf("range %d-%d exceeds ID space", start, uint64(start)+uint64(count)-1)
+	}
+
+	return nil
+}
+
+// ValidateHostRange checks that ID range doesn't overlap IDs of host users and groups.
+func ValidateHostRange(start, count uint32) error {
+	if err := CheckRange(start, count); err != nil {
+		return err
+	}
+
+	for _, fileName := range []string{PasswdFile, GroupFile} {
+		ids, err := readIDs(fileName)
+		if err != nil {
+			return err
+		}
+
+		if err = CheckOverlap(start, count, ids); err != nil {
+			return aoserrors.Errorf("%s: %v", fileName, err)
+		}
+	}
+
+	return nil
+}
+
+// ParseIDs parses IDs of passwd or group file entries.
+func ParseIDs(reader io.Reader) (ids []uint32, err error) {
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) < minIDFields {
+			return nil, aoserrors.Errorf("invalid entry: %s", line)
+		}
+
+		id, err := strconv.ParseUint(fields[idField], 10, 32)
+		if err != nil {
+			return nil, aoserrors.Errorf("invalid entry ID: %s", line)
+		}
+
+		ids = append(ids, uint32(id))
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	return ids, nil
+}
+
+// CheckOverlap returns error if ID range contains any of IDs.
+func CheckOverlap(start, count uint32, ids []uint32) error {
+	end := uint64(start) + uint64(count)
+
+	for _, id := range ids {
+		if uint64(id) >= uint64(start) && uint64(id) < end {
+			return aoserrors.Errorf("ID %d is in range %d-%d", id, start, end-1)
+		}
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func readIDs(fileName string) ([]uint32, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, aoserrors.Wrap(err)
+	}
+	defer file.Close()
+
+	ids, err := ParseIDs(file)
+	if err != nil {
+		return nil, aoserrors.Errorf("%s: %v", fileName, err)
+	}
+
+	return ids, nil
+}
+
+// User namespace is kept by traced child process which is stopped before exec and killed after namespace is opened.
+func openUserNS(uidMapping, gidMapping Mapping) (int, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	proc, err := os.StartProcess("/proc/self/exe", []string{"idmap"}, &os.ProcAttr{
+		Sys: &syscall.SysProcAttr{
+			Cloneflags: unix.CLONE_NEWUSER,
+			UidMappings: []syscall.SysProcIDMap{
+				{ContainerID: int(uidMapping.ContainerID), HostID: int(uidMapping.HostID), Size: int(uidMapping.Size)},
+			},
+			GidMappings: []syscall.SysProcIDMap{
+				{ContainerID: int(gidMapping.ContainerID), HostID: int(gidMapping.HostID), Size: int(gidMapping.Size)},
+			},
+			Ptrace:    true,
+			Pdeathsig: unix.SIGKILL,
+		},
+	})
+	if err != nil {
+		return -1, aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if err := proc.Kill(); err != nil {
+			log.Errorf("Can't kill user namespace process: %v", err)
+		}
+
+		if _, err := proc.Wait(); err != nil {
+			log.Errorf("Can't wait user namespace process: %v", err)
+		}
+	}()
+
+	usernsFd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/user", proc.Pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, aoserrors.Wrap(err)
+	}
+
+	return usernsFd, nil
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package idmap_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aoscloud/aos_servicemanager/utils/idmap"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestParseIDs(t *testing.T) {
+	type testData struct {
+		content  string
+		expected []uint32
+		isValid  bool
+	}
+
+	data := []testData{
+		{
+			content:  "root:x:0:0:root:/root:/bin/sh\n\n# comment\nnobody:x:65534:65534::/:/sbin/nologin\n",
+			expected: []uint32{0, 65534},
+			isValid:  true,
+		},
+		{content: "root:x:0:\naos:x:1000:user1,user2\n", expected: []uint32{0, 1000}, isValid: true},
+		{content: "", isValid: true},
+		{content: "root:x\n"},
+		{content: "root:x:root:0::/root:/bin/sh\n"},
+		{content: "big:x:4294967296:0::/:/bin/sh\n"},
+	}
+
+	for _, item := range data {
+		ids, err := idmap.ParseIDs(strings.NewReader(item.content))
+		if (err == nil) != item.isValid {
+			t.Errorf("Wrong parse result for %q: %v", item.content, err)
+
+			continue
+		}
+
+		if item.isValid && !reflect.DeepEqual(ids, item.expected) {
+			t.Errorf("Wrong IDs for %q: %v", item.content, ids)
+		}
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	type testData struct {
+		start    uint32
+		count    uint32
+		overlaps bool
+	}
+
+	ids := []uint32{0, 1000, 65534}
+
+	data := []testData{
+		{start: 100000, count: 65536},
+		{start: 1001, count: 64533},
+		{start: 1, count: 999},
+		{start: 1000, count: 1, overlaps: true},
+		{start: 500, count: 1000, overlaps: true},
+		{start: 65534, count: 1, overlaps: true},
+		{start: 4294967295, count: 4294967295},
+	}
+
+	for _, item := range data {
+		if err := idmap.CheckOverlap(item.start, item.count, ids); (err != nil) != item.overlaps {
+			t.Errorf("Wrong overlap result for %d-%d: %v", item.start, item.count, err)
+		}
+	}
+}
+
+func TestValidateHostRange(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "idmap_")
+	if err != nil {
+		t.Fatalf("Can't create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	passwdFile, groupFile := idmap.PasswdFile, idmap.GroupFile
+
+	defer func() {
+		idmap.PasswdFile, idmap.GroupFile = passwdFile, groupFile
+	}()
+
+	idmap.PasswdFile = filepath.Join(tmpDir, "passwd")
+	idmap.GroupFile = filepath.Join(tmpDir, "group")
+
+	if err = os.WriteFile(idmap.PasswdFile, []byte("root:x:0:0:root:/root:/bin/sh\n"), 0o600); err != nil {
+		t.Fatalf("Can't write passwd file: %v", err)
+	}
+
+	if err = os.WriteFile(idmap.GroupFile, []byte("root:x:0:\naos:x:200000:\n"), 0o600); err != nil {
+		t.Fatalf("Can't write group file: %v", err)
+	}
+
+	if err = idmap.ValidateHostRange(100000, 65536); err != nil {
+		t.Errorf("Can't validate host range: %v", err)
+	}
+
+	if err = idmap.ValidateHostRange(100000, 200000); err == nil {
+		t.Error("Range overlapping host group should be rejected")
+	}
+
+	if err = idmap.ValidateHostRange(0xFFFF0000, 2*65536); err == nil {
+		t.Error("Range exceeding ID space should be rejected")
+	}
+
+	if err = os.Remove(idmap.GroupFile); err != nil {
+		t.Fatalf("Can't remove group file: %v", err)
+	}
+
+	if err = idmap.ValidateHostRange(100000, 200000); err != nil {
+		t.Errorf("Missing group file should be ignored: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package install provides helpers shared by service and layer installation.
+package install
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// WithTimeout returns context canceled after timeout. Zero timeout means no timeout.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// UnpackTarImage is same as image.UnpackTarImage but tar process is killed when ctx is canceled.
+func UnpackTarImage(ctx context.Context, source, destination string) error {
+	log.WithFields(log.Fields{"name": source, "destination": destination}).Debug("Unpack tar image")
+
+	if _, err := os.Stat(source); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := os.MkdirAll(destination, 0o755); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if output, err := exec.CommandContext(ctx, "tar", "xf", source, "-C", destination).CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return aoserrors.Wrap(ctx.Err())
+		}
+
+		log.Errorf("Failed to unpack archive: %s", string(output))
+
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2021 Renesas Electronics Corporation.
+// Copyright (C) 2021 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package install_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aoscloud/aos_servicemanager/utils/install"
+)
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestUnpackTarImage(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sourceDir := filepath.Join(tmpDir, "source")
+	archive := filepath.Join(tmpDir, "archive.tar")
+	destination := filepath.Join(tmpDir, "destination")
+
+	if err := os.MkdirAll(sourceDir, 0o755); err != nil {
+		t.Fatalf("Can't create source dir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(sourceDir, "file"), []byte("content"), 0o600); err != nil {
+		t.Fatalf("Can't create file: %v", err)
+	}
+
+	if output, err := exec.Command("tar", "cf", archive, "-C", sourceDir, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Can't create archive: %v, %s", err, string(output))
+	}
+
+	if err := install.UnpackTarImage(context.Background(), archive, destination); err != nil {
+		t.Fatalf("Can't unpack archive: %v", err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(destination, "file")); err != nil || string(content) != "content" {
+		t.Errorf("Wrong unpacked file: %s, err: %v", string(content), err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+
+	if err := install.UnpackTarImage(ctx, archive, destination); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wrong unpack error: %v", err)
+	}
+
+	if err := install.UnpackTarImage(context.Background(), filepath.Join(tmpDir, "absent.tar"),
+		destination); err == nil {
+		t.Error("Error expected")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancelFunc := install.WithTimeout(context.Background(), 0)
+	defer cancelFunc()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Context without timeout should not have deadline")
+	}
+
+	timeoutCtx, timeoutCancelFunc := install.WithTimeout(context.Background(), time.Millisecond)
+	defer timeoutCancelFunc()
+
+	<-timeoutCtx.Done()
+
+	if !errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Wrong context error: %v", timeoutCtx.Err())
+	}
+}
@@ -0,0 +1,341 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ociregistry pulls images from OCI distribution registries
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+// Scheme OCI registry reference URL scheme.
+const Scheme = "oci"
+
+const (
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+const maxManifestSize = 4 * 1024 * 1024
+
+const readChunkSize = 64 * 1024
+
+/***********************************************************************************************************************
+ * Types
+ **********************************************************************************************************************/
+
+// Reference registry image reference: host/repository[:tag][@digest].
+type Reference struct {
+	Host       string
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+}
+
+// Notifier is called while blob is downloading.
+type Notifier func(downloaded, total uint64)
+
+// Client OCI distribution registry client.
+type Client struct {
+	insecureRegistries []string
+	httpClient         *http.Client
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// IsReference checks if URL is a registry reference: oci:// URL or URL without scheme.
+func IsReference(rawURL string) bool {
+	if strings.HasPrefix(rawURL, Scheme+"://") {
+		return true
+	}
+
+	if strings.Contains(rawURL, "://") {
+		return false
+	}
+
+	_, err := ParseReference(rawURL)
+
+	return err == nil
+}
+
+// ParseReference parses registry reference.
+func ParseReference(rawURL string) (ref Reference, err error) {
+	name := strings.TrimPrefix(rawURL, Scheme+"://")
+
+	if index := strings.Index(name, "@"); index >= 0 {
+		if ref.Digest, err = digest.Parse(name[index+1:]); err != nil {
+			return ref, aoserrors.Wrap(err)
+		}
+
+		name = name[:index]
+	}
+
+	index := strings.Index(name, "/")
+	if index <= 0 {
+		return ref, aoserrors.Errorf("invalid registry reference: %s", rawURL)
+	}
+
+	ref.Host, ref.Repository = name[:index], name[index+1:]
+
+	// Registry host should be distinguishable from repository path
+	if !strings.ContainsAny(ref.Host, ".:") && ref.Host != "localhost" {
+		return ref, aoserrors.Errorf("invalid registry host: %s", ref.Host)
+	}
+
+	if index = strings.LastIndex(ref.Repository, ":"); index >= 0 {
+		ref.Repository, ref.Tag = ref.Repository[:index], ref.Repository[index+1:]
+	}
+
+	if ref.Repository == "" || (ref.Tag == "" && ref.Digest == "") {
+		return ref, aoserrors.Errorf("invalid registry reference: %s", rawURL)
+	}
+
+	return ref, nil
+}
+
+// String returns reference string.
+func (ref Reference) String() string {
+	name := ref.Host + "/" + ref.Repository
+
+	if ref.Tag != "" {
+		name += ":" + ref.Tag
+	}
+
+	if ref.Digest != "" {
+		name += "@" + string(ref.Digest)
+	}
+
+	return name
+}
+
+// New creates registry client. Insecure registries are accessed by plain HTTP.
+func New(insecureRegistries []string) *Client {
+	return &Client{insecureRegistries: insecureRegistries, httpClient: &http.Client{}}
+}
+
+// GetManifest gets raw image manifest. Manifest is verified against reference digest or expected digest if reference
+// is a tag.
+func (client *Client) GetManifest(
+	ctx context.Context, ref Reference, expectedDigest digest.Digest,
+) (manifest []byte, mediaType string, err error) {
+	if ref.Digest != "" {
+		expectedDigest = ref.Digest
+	}
+
+	if expectedDigest == "" {
+		return nil, "", aoserrors.Errorf("manifest digest is not specified for %s", ref)
+	}
+
+	reference := ref.Tag
+
+	if ref.Digest != "" {
+		reference = string(ref.Digest)
+	}
+
+	resp, err := client.get(ctx, ref, "manifests/"+reference,
+		strings.Join([]string{
+			imagespec.MediaTypeImageManifest, dockerManifestMediaType,
+			imagespec.MediaTypeImageIndex, dockerManifestListMediaType,
+		}, ", "))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if manifest, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1)); err != nil {
+		return nil, "", aoserrors.Wrap(err)
+	}
+
+	if len(manifest) > maxManifestSize {
+		return nil, "", aoserrors.Errorf("manifest %s is too big", ref)
+	}
+
+	if err = expectedDigest.Validate(); err != nil {
+		return nil, "", aoserrors.Wrap(err)
+	}
+
+	if expectedDigest.Algorithm().FromBytes(manifest) != expectedDigest {
+		return nil, "", aoserrors.Errorf("manifest %s digest mismatch", ref)
+	}
+
+	log.WithFields(log.Fields{"reference": ref, "digest": expectedDigest}).Debug("Manifest pulled")
+
+	return manifest, strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]), nil
+}
+
+// GetPlatformManifest gets image manifest. If reference points to an image index, manifest matching the node
+// platform is selected.
+func (client *Client) GetPlatformManifest(
+	ctx context.Context, ref Reference, expectedDigest digest.Digest, node imagespec.Platform,
+) (manifest []byte, err error) {
+	if manifest, _, err = client.GetManifest(ctx, ref, expectedDigest); err != nil {
+		return nil, err
+	}
+
+	if !platform.IsIndex(manifest) {
+		return manifest, nil
+	}
+
+	var index imagespec.Index
+
+	if err = json.Unmarshal(manifest, &index); err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	descriptor, err := platform.Select(index.Manifests, node)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	ref.Digest = descriptor.Digest
+
+	if manifest, _, err = client.GetManifest(ctx, ref, ""); err != nil {
+		return nil, err
+	}
+
+	if platform.IsIndex(manifest) {
+		return nil, aoserrors.Errorf("nested image index is not supported: %s", ref)
+	}
+
+	return manifest, nil
+}
+
+// GetBlob downloads blob into file and verifies its digest and size.
+func (client *Client) GetBlob(
+	ctx context.Context, ref Reference, descriptor imagespec.Descriptor, fileName string, notifier Notifier,
+) (err error) {
+	if err = descriptor.Digest.Validate(); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	resp, err := client.get(ctx, ref, "blobs/"+string(descriptor.Digest), "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = aoserrors.Wrap(closeErr)
+		}
+
+		if err != nil {
+			os.RemoveAll(fileName)
+		}
+	}()
+
+	verifier := descriptor.Digest.Verifier()
+	buffer := make([]byte, readChunkSize)
+
+	var downloaded uint64
+
+	for {
+		count, readErr := resp.Body.Read(buffer)
+
+		if downloaded += uint64(count); downloaded > uint64(descriptor.Size) {
+			return aoserrors.Errorf("blob %s size mismatch", descriptor.Digest)
+		}
+
+		if _, err = file.Write(buffer[:count]); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if _, err = verifier.Write(buffer[:count]); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if notifier != nil && count > 0 {
+			notifier(downloaded, uint64(descriptor.Size))
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+
+		if readErr != nil {
+			return aoserrors.Wrap(readErr)
+		}
+	}
+
+	if downloaded != uint64(descriptor.Size) || !verifier.Verified() {
+		return aoserrors.Errorf("blob %s hash mismatch", descriptor.Digest)
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func (client *Client) get(ctx context.Context, ref Reference, path, accept string) (*http.Response, error) {
+	scheme := "https"
+
+	if slices.Contains(client.insecureRegistries, ref.Host) {
+		scheme = "http"
+	}
+
+	url := fmt.Sprintf("%s://%s/v2/%s/%s", scheme, ref.Host, ref.Repository, path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, aoserrors.Wrap(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, aoserrors.Errorf("can't get %s: %s", url, resp.Status)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ociregistry_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/utils/ociregistry"
+)
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestParseReference(t *testing.T) {
+	const testDigest = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	cases := []struct {
+		url         string
+		isReference bool
+		reference   ociregistry.Reference
+	}{
+		{
+			url:         "oci://registry.local:5000/aos/service@" + testDigest,
+			isReference: true,
+			reference: ociregistry.Reference{
+				Host: "registry.local:5000", Repository: "aos/service", Digest: testDigest,
+			},
+		},
+		{
+			url:         "registry.local:5000/repo@" + testDigest,
+			isReference: true,
+			reference:   ociregistry.Reference{Host: "registry.local:5000", Repository: "repo", Digest: testDigest},
+		},
+		{
+			url:         "localhost/repo:v1",
+			isReference: true,
+			reference:   ociregistry.Reference{Host: "localhost", Repository: "repo", Tag: "v1"},
+		},
+		{url: "http://registry.local/repo:v1"},
+		{url: "file:///tmp/service.tar.gz"},
+		{url: "repo/service:v1"},
+		{url: "registry.local/repo"},
+	}
+
+	for _, tCase := range cases {
+		if isReference := ociregistry.IsReference(tCase.url); isReference != tCase.isReference {
+			t.Errorf("Wrong reference detection for %s: %v", tCase.url, isReference)
+		}
+
+		if !tCase.isReference {
+			continue
+		}
+
+		ref, err := ociregistry.ParseReference(tCase.url)
+		if err != nil {
+			t.Errorf("Can't parse reference %s: %v", tCase.url, err)
+
+			continue
+		}
+
+		if ref != tCase.reference {
+			t.Errorf("Wrong reference for %s: %v", tCase.url, ref)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	blob := []byte("layer content")
+	blobDescriptor := imagespec.Descriptor{
+		MediaType: imagespec.MediaTypeImageLayer, Digest: digest.FromBytes(blob), Size: int64(len(blob)),
+	}
+
+	manifest, err := json.Marshal(imagespec.Manifest{Layers: []imagespec.Descriptor{blobDescriptor}})
+	if err != nil {
+		t.Fatalf("Can't marshal manifest: %v", err)
+	}
+
+	manifestDigest := digest.FromBytes(manifest)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/aos/layer/manifests/" + string(manifestDigest), "/v2/aos/layer/manifests/v1":
+			w.Header().Set("Content-Type", imagespec.MediaTypeImageManifest)
+			_, _ = w.Write(manifest)
+
+		case "/v2/aos/layer/blobs/" + string(blobDescriptor.Digest):
+			_, _ = w.Write(blob)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	client := ociregistry.New([]string{host})
+
+	ref, err := ociregistry.ParseReference("oci://" + host + "/aos/layer@" + string(manifestDigest))
+	if err != nil {
+		t.Fatalf("Can't parse reference: %v", err)
+	}
+
+	pulledManifest, mediaType, err := client.GetManifest(context.Background(), ref, "")
+	if err != nil {
+		t.Fatalf("Can't get manifest: %v", err)
+	}
+
+	if string(pulledManifest) != string(manifest) || mediaType != imagespec.MediaTypeImageManifest {
+		t.Errorf("Wrong manifest: %s, %s", pulledManifest, mediaType)
+	}
+
+	tagRef := ref
+	tagRef.Tag, tagRef.Digest = "v1", ""
+
+	if _, _, err = client.GetManifest(context.Background(), tagRef, ""); err == nil {
+		t.Error("Tag reference without digest should fail")
+	}
+
+	if _, _, err = client.GetManifest(context.Background(), tagRef, digest.FromString("wrong")); err == nil {
+		t.Error("Manifest digest mismatch expected")
+	}
+
+	if _, _, err = client.GetManifest(context.Background(), tagRef, manifestDigest); err != nil {
+		t.Errorf("Can't get manifest by tag: %v", err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "ociregistry_")
+	if err != nil {
+		t.Fatalf("Can't create tmp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	blobFile := filepath.Join(tmpDir, "blob")
+
+	if err = client.GetBlob(context.Background(), ref, blobDescriptor, blobFile, nil); err != nil {
+		t.Fatalf("Can't get blob: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(blobFile)
+	if err != nil {
+		t.Fatalf("Can't read blob: %v", err)
+	}
+
+	if string(content) != string(blob) {
+		t.Errorf("Wrong blob content: %s", content)
+	}
+
+	wrongDescriptor := blobDescriptor
+	wrongDescriptor.Size--
+
+	if err = client.GetBlob(context.Background(), ref, wrongDescriptor, blobFile, nil); err == nil {
+		t.Error("Blob size mismatch expected")
+	}
+
+	if _, err = os.Stat(blobFile); !os.IsNotExist(err) {
+		t.Error("Failed blob should be removed")
+	}
+}
@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package platform provides node platform detection and image platform matching
+package platform
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/aoscloud/aos_common/aoserrors"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+/***********************************************************************************************************************
+ * Consts
+ **********************************************************************************************************************/
+
+const defaultOS = "linux"
+
+/***********************************************************************************************************************
+ * Vars
+ **********************************************************************************************************************/
+
+// ErrNoMatch no descriptor matches node platform.
+var ErrNoMatch = errors.New("no matching platform")
+
+// CPUInfoFile path to cpuinfo used to detect ARM variant.
+// nolint:gochecknoglobals // used for unit test mock
+var CPUInfoFile = "/proc/cpuinfo"
+
+// nolint:gochecknoglobals // architecture aliases
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"armhf":   "arm",
+	"armel":   "arm",
+}
+
+/***********************************************************************************************************************
+ * Public
+ **********************************************************************************************************************/
+
+// Node returns platform of the node.
+func Node() imagespec.Platform {
+	platform := imagespec.Platform{OS: defaultOS, Architecture: runtime.GOARCH}
+
+	switch platform.Architecture {
+	case "arm64":
+		platform.Variant = "v8"
+
+	case "arm":
+		platform.Variant = getARMVariant()
+	}
+
+	return platform
+}
+
+// Matches checks if image platform can run on the node. Empty image platform fields match any node.
+func Matches(platform *imagespec.Platform, node imagespec.Platform) bool {
+	if platform == nil {
+		return true
+	}
+
+	if platform.OS != "" && !strings.EqualFold(platform.OS, node.OS) {
+		return false
+	}
+
+	if platform.Architecture != "" && normalizeArch(platform.Architecture) != normalizeArch(node.Architecture) {
+		return false
+	}
+
+	if platform.Variant != "" && node.Variant != "" && platform.Variant != node.Variant {
+		return false
+	}
+
+	return true
+}
+
+// Select selects descriptor matching the node platform. Descriptor with exact variant is preferred.
+func Select(descriptors []imagespec.Descriptor, node imagespec.Platform) (imagespec.Descriptor, error) {
+	var (
+		selected  *imagespec.Descriptor
+		available []string
+	)
+
+	for i, descriptor := range descriptors {
+		available = append(available, String(descriptor.Platform))
+
+		if !Matches(descriptor.Platform, node) {
+			continue
+		}
+
+		if selected == nil || (selected.Platform == nil || selected.Platform.Variant == "") &&
+			descriptor.Platform != nil && descriptor.Platform.Variant != "" {
+			selected = &descriptors[i]
+		}
+	}
+
+	if selected == nil {
+		return imagespec.Descriptor{}, aoserrors.Errorf("%w: node %s, available %s", ErrNoMatch, String(&node),
+			strings.Join(available, ", "))
+	}
+
+	return *selected, nil
+}
+
+// IsIndex checks if JSON document is an image index.
+func IsIndex(data []byte) bool {
+	var document struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+
+	if err := json.Unmarshal(data, &document); err != nil {
+		return false
+	}
+
+	return document.MediaType == imagespec.MediaTypeImageIndex || document.Manifests != nil
+}
+
+// String returns platform string as os/arch/variant.
+func String(platform *imagespec.Platform) string {
+	if platform == nil {
+		return "any"
+	}
+
+	value := platform.OS + "/" + platform.Architecture
+
+	if platform.Variant != "" {
+		value += "/" + platform.Variant
+	}
+
+	return value
+}
+
+/***********************************************************************************************************************
+ * Private
+ **********************************************************************************************************************/
+
+func normalizeArch(arch string) string {
+	arch = strings.ToLower(arch)
+
+	if alias, ok := archAliases[arch]; ok {
+		return alias
+	}
+
+	return arch
+}
+
+func getARMVariant() string {
+	file, err := os.Open(CPUInfoFile)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2) // nolint:gomnd
+
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == "CPU architecture" { // nolint:gomnd
+			return "v" + strings.TrimSpace(fields[1])
+		}
+	}
+
+	return ""
+}
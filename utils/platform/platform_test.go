@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform_test
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/aoscloud/aos_servicemanager/utils/platform"
+)
+
+/***********************************************************************************************************************
+ * Init
+ **********************************************************************************************************************/
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: false,
+		TimestampFormat:  "2006-01-02 15:04:05.000",
+		FullTimestamp:    true,
+	})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(os.Stdout)
+}
+
+/***********************************************************************************************************************
+ * Tests
+ **********************************************************************************************************************/
+
+func TestNode(t *testing.T) {
+	node := platform.Node()
+
+	if node.OS != "linux" || node.Architecture != runtime.GOARCH {
+		t.Errorf("Wrong node platform: %s", platform.String(&node))
+	}
+}
+
+func TestMatches(t *testing.T) {
+	node := imagespec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+
+	cases := []struct {
+		platform *imagespec.Platform
+		matches  bool
+	}{
+		{platform: nil, matches: true},
+		{platform: &imagespec.Platform{}, matches: true},
+		{platform: &imagespec.Platform{OS: "linux", Architecture: "arm"}, matches: true},
+		{platform: &imagespec.Platform{OS: "linux", Architecture: "armhf", Variant: "v7"}, matches: true},
+		{platform: &imagespec.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, matches: false},
+		{platform: &imagespec.Platform{OS: "linux", Architecture: "arm64"}, matches: false},
+		{platform: &imagespec.Platform{OS: "windows", Architecture: "arm"}, matches: false},
+	}
+
+	for _, item := range cases {
+		if matches := platform.Matches(item.platform, node); matches != item.matches {
+			t.Errorf("Wrong match result for %s: %v", platform.String(item.platform), matches)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	descriptors := []imagespec.Descriptor{
+		{Digest: "sha256:amd64", Platform: &imagespec.Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:arm", Platform: &imagespec.Platform{OS: "linux", Architecture: "arm"}},
+		{Digest: "sha256:armv7", Platform: &imagespec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "sha256:arm64", Platform: &imagespec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+	}
+
+	cases := []struct {
+		node   imagespec.Platform
+		digest string
+	}{
+		{node: imagespec.Platform{OS: "linux", Architecture: "amd64"}, digest: "sha256:amd64"},
+		{node: imagespec.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, digest: "sha256:armv7"},
+		{node: imagespec.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, digest: "sha256:arm"},
+		{node: imagespec.Platform{OS: "linux", Architecture: "aarch64", Variant: "v8"}, digest: "sha256:arm64"},
+	}
+
+	for _, item := range cases {
+		descriptor, err := platform.Select(descriptors, item.node)
+		if err != nil {
+			t.Fatalf("Can't select descriptor: %v", err)
+		}
+
+		if string(descriptor.Digest) != item.digest {
+			t.Errorf("Wrong descriptor selected for %s: %s", platform.String(&item.node), descriptor.Digest)
+		}
+	}
+
+	if _, err := platform.Select(descriptors, imagespec.Platform{OS: "linux", Architecture: "riscv64"}); !errors.Is(
+		err, platform.ErrNoMatch) {
+		t.Errorf("No match error expected: %v", err)
+	}
+}
+
+func TestIsIndex(t *testing.T) {
+	if !platform.IsIndex([]byte(`{"schemaVersion":2,"manifests":[]}`)) {
+		t.Error("Index expected")
+	}
+
+	if platform.IsIndex([]byte(`{"schemaVersion":2,"config":{},"layers":[]}`)) {
+		t.Error("Manifest expected")
+	}
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+//
+// Copyright (C) 2022 Renesas Electronics Corporation.
+// Copyright (C) 2022 EPAM Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress provides install progress events
+package progress
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/***********************************************************************************************************************
+* Consts
+***********************************************************************************************************************/
+
+// Install item types.
+const (
+	ItemTypeService = "service"
+	ItemTypeLayer   = "layer"
+)
+
+// Install states.
+const (
+	StateStarted     = "started"
+	StateDownloading = "downloading"
+	StateValidating  = "validating"
+	StateExtracting  = "extracting"
+	StateInstalled   = "installed"
+	StateFailed      = "failed"
+)
+
+// EventChannelSize size of progress event channel.
+const EventChannelSize = 100
+
+/***********************************************************************************************************************
+* Types
+***********************************************************************************************************************/
+
+// Event install progress event.
+type Event struct {
+	ItemType   string
+	ID         string
+	AosVersion uint64
+	Digest     string
+	State      string
+	Downloaded uint64
+	Total      uint64
+	Error      string
+}
+
+// InstallError contains errors of failed items. Items are identified by ID for services and by digest for layers.
+type InstallError struct {
+	ItemType string
+	Total    int
+	Errors   map[string]error
+}
+
+/***********************************************************************************************************************
+* Vars
+***********************************************************************************************************************/
+
+// DownloadUpdatePeriod minimal period between downloading events of the same item.
+// nolint:gochecknoglobals // used for unit test mock
+var DownloadUpdatePeriod = 1 * time.Second
+
+/***********************************************************************************************************************
+* Public
+***********************************************************************************************************************/
+
+// Send sends event to the channel. The event is dropped if the channel is full.
+func Send(channel chan<- Event, event Event) {
+	select {
+	case channel <- event:
+
+	default:
+		log.WithFields(log.Fields{
+			"id": event.ID, "state": event.State,
+		}).Warn("Progress event channel is full, skip event")
+	}
+}
+
+// DownloadNotifier returns download notifier which sends downloading events based on the item event.
+// Intermediate events are sent not more often than DownloadUpdatePeriod.
+func DownloadNotifier(channel chan<- Event, item Event) func(downloaded, total uint64) {
+	var lastUpdate time.Time
+
+	return func(downloaded, total uint64) {
+		if downloaded < total && time.Since(lastUpdate) < DownloadUpdatePeriod {
+			return
+		}
+
+		lastUpdate = time.Now()
+
+		item.State, item.Downloaded, item.Total = StateDownloading, downloaded, total
+
+		Send(channel, item)
+	}
+}
+
+// Error returns error string.
+func (installErr *InstallError) Error() string {
+	return fmt.Sprintf("can't install %d of %d %ss", len(installErr.Errors), installErr.Total, installErr.ItemType)
+}
+
+// Is checks if any of item errors matches target.
+func (installErr *InstallError) Is(target error) bool {
+	for _, err := range installErr.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
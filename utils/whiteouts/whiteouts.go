@@ -23,6 +23,7 @@ import (
 	"strings"
 
 	"github.com/aoscloud/aos_common/aoserrors"
+	securejoin "github.com/cyphar/filepath-securejoin"
 	"golang.org/x/sys/unix"
 )
 
@@ -80,3 +81,147 @@ func OCIWhiteoutsToOverlay(path string, uid, gid int) error {
 
 	return nil
 }
+
+// ApplyOCILayer applies unpacked OCI layer to the directory: entries hidden by layer whiteouts are removed from the
+// directory, other layer entries are moved to it.
+func ApplyOCILayer(layerPath, path string) error {
+	if err := applyWhiteouts(layerPath, path); err != nil {
+		return err
+	}
+
+	if err := moveLayerEntries(layerPath, path); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/***********************************************************************************************************************
+* Private
+***********************************************************************************************************************/
+
+func applyWhiteouts(layerPath, path string) error {
+	if err := filepath.Walk(layerPath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		base := filepath.Base(name)
+
+		if info.IsDir() || !strings.HasPrefix(base, whiteoutPrefix) {
+			return nil
+		}
+
+		relDir, err := filepath.Rel(layerPath, filepath.Dir(name))
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		targetDir, err := securejoin.SecureJoin(path, relDir)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if base == whiteoutOpaqueDir {
+			return clearDir(targetDir)
+		}
+
+		if err := os.RemoveAll(filepath.Join(targetDir, strings.TrimPrefix(base, whiteoutPrefix))); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return nil
+	}); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func moveLayerEntries(layerPath, path string) error {
+	if err := filepath.Walk(layerPath, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		relPath, err := filepath.Rel(layerPath, name)
+		if err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if relPath == "." || strings.HasPrefix(filepath.Base(name), whiteoutPrefix) {
+			return nil
+		}
+
+		target, err := secureTarget(path, relPath)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return mergeDir(target, info.Mode().Perm())
+		}
+
+		if err := os.RemoveAll(target); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		if err := os.Rename(name, target); err != nil {
+			return aoserrors.Wrap(err)
+		}
+
+		return nil
+	}); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+// Base rootfs symlinks may point outside it (e.g. var/run -> /run), so target parent dir is resolved inside path.
+// The last element is not resolved: it is replaced by the layer entry.
+func secureTarget(path, relPath string) (string, error) {
+	targetDir, err := securejoin.SecureJoin(path, filepath.Dir(relPath))
+	if err != nil {
+		return "", aoserrors.Wrap(err)
+	}
+
+	return filepath.Join(targetDir, filepath.Base(relPath)), nil
+}
+
+func mergeDir(path string, perm os.FileMode) error {
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	if err := os.MkdirAll(path, perm); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	if err := os.Chmod(path, perm); err != nil {
+		return aoserrors.Wrap(err)
+	}
+
+	return nil
+}
+
+func clearDir(path string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return aoserrors.Wrap(err)
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(path, entry.Name())); err != nil {
+			return aoserrors.Wrap(err)
+		}
+	}
+
+	return nil
+}
@@ -151,3 +151,121 @@ func TestConvertWhiteouts(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyOCILayer(t *testing.T) {
+	rootfs := filepath.Join(tmpDir, "apply", "rootfs")
+	layer := filepath.Join(tmpDir, "apply", "layer")
+
+	for name, content := range map[string]string{
+		filepath.Join(rootfs, "etc", "removed.txt"):         "removed",
+		filepath.Join(rootfs, "etc", "updated.txt"):         "old",
+		filepath.Join(rootfs, "etc", "kept.txt"):            "kept",
+		filepath.Join(rootfs, "bin", "old"):                 "old",
+		filepath.Join(layer, "etc", ".wh.removed.txt"):      "",
+		filepath.Join(layer, "etc", "updated.txt"):          "new",
+		filepath.Join(layer, "bin", ".wh..wh..opq"):         "",
+		filepath.Join(layer, "bin", "new"):                  "new",
+		filepath.Join(layer, "usr", "lib", "library.so"):    "new",
+		filepath.Join(layer, "etc", ".wh.not_existing.txt"): "",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("Can't create dir: %v", err)
+		}
+
+		if err := ioutil.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatalf("Can't create file: %v", err)
+		}
+	}
+
+	if err := whiteouts.ApplyOCILayer(layer, rootfs); err != nil {
+		t.Fatalf("Can't apply OCI layer: %v", err)
+	}
+
+	for name, expectedContent := range map[string]string{
+		filepath.Join(rootfs, "etc", "updated.txt"):       "new",
+		filepath.Join(rootfs, "etc", "kept.txt"):          "kept",
+		filepath.Join(rootfs, "bin", "new"):               "new",
+		filepath.Join(rootfs, "usr", "lib", "library.so"): "new",
+	} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Errorf("Can't read file: %v", err)
+			continue
+		}
+
+		if string(content) != expectedContent {
+			t.Errorf("Wrong file %s content: %s", name, string(content))
+		}
+	}
+
+	for _, name := range []string{
+		filepath.Join(rootfs, "etc", "removed.txt"),
+		filepath.Join(rootfs, "etc", ".wh.removed.txt"),
+		filepath.Join(rootfs, "bin", "old"),
+		filepath.Join(rootfs, "bin", ".wh..wh..opq"),
+	} {
+		if _, err := os.Lstat(name); !os.IsNotExist(err) {
+			t.Errorf("File %s should not exist", name)
+		}
+	}
+}
+
+func TestApplyOCILayerSymlinks(t *testing.T) {
+	rootfs := filepath.Join(tmpDir, "symlinks", "rootfs")
+	layer := filepath.Join(tmpDir, "symlinks", "layer")
+	hostDir := filepath.Join(tmpDir, "symlinks", "host")
+
+	for name, content := range map[string]string{
+		filepath.Join(hostDir, "removed.txt"):                 "host",
+		filepath.Join(hostDir, "updated.txt"):                 "host",
+		filepath.Join(layer, "var", "run", ".wh.removed.txt"): "",
+		filepath.Join(layer, "etc", "updated.txt"):            "new",
+		filepath.Join(layer, "var", "run", "new.txt"):         "new",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatalf("Can't create dir: %v", err)
+		}
+
+		if err := ioutil.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatalf("Can't create file: %v", err)
+		}
+	}
+
+	// Absolute symlinks of base rootfs point to host
+	if err := os.MkdirAll(filepath.Join(rootfs, "var"), 0o755); err != nil {
+		t.Fatalf("Can't create dir: %v", err)
+	}
+
+	if err := os.Symlink(hostDir, filepath.Join(rootfs, "var", "run")); err != nil {
+		t.Fatalf("Can't create symlink: %v", err)
+	}
+
+	if err := os.Symlink(hostDir, filepath.Join(rootfs, "etc")); err != nil {
+		t.Fatalf("Can't create symlink: %v", err)
+	}
+
+	if err := whiteouts.ApplyOCILayer(layer, rootfs); err != nil {
+		t.Fatalf("Can't apply OCI layer: %v", err)
+	}
+
+	for name, expectedContent := range map[string]string{
+		filepath.Join(hostDir, "removed.txt"):          "host",
+		filepath.Join(hostDir, "updated.txt"):          "host",
+		filepath.Join(rootfs, "etc", "updated.txt"):    "new",
+		filepath.Join(rootfs, "var", "run", "new.txt"): "new",
+	} {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Errorf("Can't read file: %v", err)
+			continue
+		}
+
+		if string(content) != expectedContent {
+			t.Errorf("Wrong file %s content: %s", name, string(content))
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(hostDir, "new.txt")); !os.IsNotExist(err) {
+		t.Error("Layer entry should not be moved to host")
+	}
+}